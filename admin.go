@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The /admin routes manage the accounts and the storage, they go through requireAdmin so only admins and API keys can use them
+// the first admin is made with an API key, after that admins can make more themselves
+
+// compactor is a Store that can give back the space deleted data leaves behind when asked, bolt and redis look after that themselves
+type compactor interface {
+	Compact() error
+}
+
+// adminUserResponse is an account as an admin sees it, with the lists the user owns or has been shared and their role on each
+type adminUserResponse struct {
+	userResponse
+	Lists []listResponse `json:"lists"`
+}
+
+func newAdminUserResponse(user User) adminUserResponse {
+	lists := []listResponse{}
+	for _, list := range users.ListsFor(user.ID) {
+		lists = append(lists, newListResponse(list, list.access(user.ID)))
+	}
+	return adminUserResponse{userResponse: newUserResponse(user), Lists: lists}
+}
+
+// roleRequest is the body of PUT /admin/users/{username}/role
+type roleRequest struct {
+	Role Role `json:"role"`
+}
+
+// Handle Get request for every account
+func handleAdminGetUsers(w http.ResponseWriter, r *http.Request) {
+	accounts := []userResponse{}
+	for _, user := range users.Users() {
+		accounts = append(accounts, newUserResponse(user))
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+// Handle Get request for one account along with its lists
+func handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	user, ok := users.ByUsername(username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
+
+// Handle Put request to make a user an admin or take it away, list roles are changed by sharing the list
+func handleAdminPutRole(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Role != RoleAdmin && req.Role != RoleUser {
+		writeError(w, http.StatusBadRequest, "invalid_role", "role has to be admin or user, the owner, editor and viewer roles are given by sharing a list")
+		return
+	}
+
+	username := r.PathValue("username")
+	user, err := users.SetRole(username, req.Role)
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+	if errors.Is(err, ErrLastAdmin) {
+		writeError(w, http.StatusConflict, "last_admin", "this is the only admin, make someone else an admin first")
+		return
+	}
+	if err != nil {
+		slog.Error("Error changing role", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Changed role", "user", user.Username, "role", user.AccountRole(), "by", requestIdentity(r).Actor())
+	writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
+
+// passwordRequest is the body of POST /admin/users/{username}/password
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+// Handle Post request to set a user's password for them, e.g. when they have forgotten it, which logs them out everywhere
+func handleAdminSetPassword(w http.ResponseWriter, r *http.Request) {
+	var req passwordRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, "invalid_password", fmt.Sprintf("password has to be at least %d characters", minPasswordLength))
+		return
+	}
+
+	username := r.PathValue("username")
+	user, ok := users.ByUsername(username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+	err = users.SetPassword(user.ID, req.Password)
+	if err != nil {
+		slog.Error("Error setting password", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Reset password", "user", user.Username, "by", requestIdentity(r).Actor())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Delete request to delete an account along with the lists it owns and everything on them
+func handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	user, ok := users.ByUsername(username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := deleteAccount(user)
+	if errors.Is(err, ErrLastAdmin) {
+		writeError(w, http.StatusConflict, "last_admin", "this is the only admin, make someone else an admin first")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting user", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Deleted user", "user", user.Username, "by", requestIdentity(r).Actor())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inactiveReport is what POST /admin/delete-inactive deleted, or with ?dry_run=true what it would delete
+type inactiveReport struct {
+	DryRun bool `json:"dry_run"`
+	// Before is the cut off, accounts last seen before it are inactive
+	Before time.Time `json:"before"`
+	Users  []string  `json:"users"`
+}
+
+// Handle Post request to delete the accounts that haven't been seen for ?days=, admins are never deleted
+func handleAdminDeleteInactive(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_query", "days has to be a whole number of 1 or more")
+		return
+	}
+	report := inactiveReport{Before: time.Now().UTC().AddDate(0, 0, -days), Users: []string{}}
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		report.DryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "dry_run has to be true or false")
+			return
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, user := range users.Users() {
+		if user.AccountRole() == RoleAdmin || !user.LastSeenAt.Before(report.Before) {
+			continue
+		}
+		if !report.DryRun {
+			err = deleteAccount(user)
+			if err != nil {
+				slog.Error("Error deleting inactive user", "err", err)
+				writeInternalError(w)
+				return
+			}
+			slog.Info("Deleted inactive user", "user", user.Username, "last_seen", user.LastSeenAt, "by", requestIdentity(r).Actor())
+		}
+		report.Users = append(report.Users, user.Username)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Deletes the account and what was kept for the lists it owned, callers must hold mu
+// the entries go along with anything that would keep using a list once it's gone: its share link, guest tokens, recurring items, webhooks and digests
+// the rest of what was kept per list is left behind, list IDs are never reused so nothing can reach it
+func deleteAccount(user User) error {
+	owned, err := users.DeleteUser(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, listID := range owned {
+		err = store.Transaction(func(tx Store) error {
+			entries, err := tx.List(listID)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				err = tx.Delete(listID, entry.ID)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("deleting entries of list %d: %w", listID, err)
+		}
+		err = shareLinks.Delete(listID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		for _, t := range guestTokens.For(listID) {
+			err = guestTokens.Delete(listID, t.ID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, item := range recurring.For(listID) {
+			err = recurring.Delete(listID, item.ID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, hook := range webhooks.For(listID) {
+			err = webhooks.Delete(listID, hook.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// the user's own digests go too, including ones of lists shared with them that are still there
+	caller := identity{User: &user}.Caller()
+	changed := false
+	for key, sub := range digests.doc.Subscriptions {
+		if key == caller || slices.Contains(owned, sub.ListID) {
+			delete(digests.doc.Subscriptions, key)
+			changed = true
+		}
+	}
+	if changed {
+		return digests.save()
+	}
+	return nil
+}
+
+// storageStats is what GET /admin/storage reports
+type storageStats struct {
+	Storage string `json:"storage"`
+	// FileSize is the size in bytes of the data file, it is left out for postgres and redis which don't keep one on this server
+	FileSize int64 `json:"file_size,omitempty"`
+	Entries  int   `json:"entries"`
+	// Trashed is how many of the entries are in the trash
+	Trashed int `json:"trashed"`
+	// ListEntries is how many entries each list has, trash included, keyed by list ID
+	ListEntries map[int]int `json:"list_entries"`
+	Users       int         `json:"users"`
+	// Docs is the size in bytes of each saved document, e.g. users for the accounts
+	Docs map[string]int `json:"docs"`
+	// Compactable is whether POST /admin/compact does anything with this storage
+	Compactable bool `json:"compactable"`
+}
+
+// Returns the handler for GET /admin/storage, which needs to know the storage and data file the server was started with
+func handleAdminStorage(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := storageStats{Storage: cfg.Storage, ListEntries: make(map[int]int), Docs: make(map[string]int), Users: len(users.Users())}
+		if cfg.Storage != "postgres" && cfg.Storage != "redis" {
+			info, err := os.Stat(cfg.DataFile)
+			if err == nil {
+				stats.FileSize = info.Size()
+			}
+		}
+
+		mu.RLock()
+		defer mu.RUnlock()
+
+		_, stats.Compactable = store.(compactor)
+		entries, err := store.All()
+		if err != nil {
+			slog.Error("Error reading entries", "err", err)
+			writeInternalError(w)
+			return
+		}
+		for _, entry := range entries {
+			stats.Entries++
+			stats.ListEntries[entry.ListID]++
+			if entry.DeletedAt != nil {
+				stats.Trashed++
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(backupDocs)) {
+			var doc json.RawMessage
+			err := store.LoadDoc(name, &doc)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				slog.Error("Error reading document", "doc", name, "err", err)
+				writeInternalError(w)
+				return
+			}
+			stats.Docs[name] = len(doc)
+		}
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// Handle Post request to compact the storage now rather than when it gets round to it
+func handleAdminCompact(w http.ResponseWriter, r *http.Request) {
+	c, ok := store.(compactor)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not_supported", "this storage doesn't need compacting")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	start := time.Now()
+	err := c.Compact()
+	if err != nil {
+		slog.Error("Error compacting storage", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Compacted storage", "took", time.Since(start), "by", requestIdentity(r).Actor())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminBackupResponse is what POST /admin/backup wrote
+type adminBackupResponse struct {
+	Name string `json:"name"`
+}
+
+// Returns the handler for POST /admin/backup, which writes a scheduled backup now and rotates out all but the newest keep like the schedule does
+func handleAdminBackup(keep int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if backupStore == nil {
+			writeError(w, http.StatusNotFound, "backups_off", "scheduled backups are off, start the server with -backup-interval to turn them on or download one with GET /backup")
+			return
+		}
+		name, err := writeScheduledBackup(backupStore, keep)
+		if err != nil {
+			slog.Error("Error writing backup", "err", err)
+			writeInternalError(w)
+			return
+		}
+		slog.Info("Wrote backup", "name", name, "by", requestIdentity(r).Actor())
+		writeJSON(w, http.StatusCreated, adminBackupResponse{Name: name})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alexaMaxAge is how old a request's timestamp can be, Amazon rejects skills that take requests older than 150 seconds
+const alexaMaxAge = 150 * time.Second
+
+// alexaCertName is the name the certificate that signs Alexa requests has to be issued for
+const alexaCertName = "echo-api.amazon.com"
+
+// alexaSkill answers an Alexa custom skill with the intents AddItem, ReadList and CompleteItem, working on one list
+// Amazon POSTs every utterance to it, so instead of a token each request is checked against the skill ID and Amazon's signature
+// https://developer.amazon.com/en-US/docs/alexa/custom-skills/host-a-custom-skill-as-a-web-service.html
+type alexaSkill struct {
+	skillID string
+	listID  int
+	client  *http.Client
+
+	// certs are the signing certificates by URL, fetching one on every request would make Alexa wait on S3
+	certsMu sync.Mutex
+	certs   map[string]*x509.Certificate
+}
+
+var alexa *alexaSkill
+
+// Returns nil when no skill ID is configured
+func newAlexaSkill(cfg Config) *alexaSkill {
+	if cfg.AlexaSkillID == "" {
+		return nil
+	}
+	return &alexaSkill{
+		skillID: cfg.AlexaSkillID,
+		listID:  cfg.AlexaList,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		certs:   make(map[string]*x509.Certificate),
+	}
+}
+
+// alexaRequest is the part of an Alexa request envelope the skill uses
+type alexaRequest struct {
+	Version string `json:"version"`
+	Context struct {
+		System struct {
+			Application struct {
+				ApplicationID string `json:"applicationId"`
+			} `json:"application"`
+		} `json:"System"`
+	} `json:"context"`
+	Request struct {
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Intent    struct {
+			Name  string `json:"name"`
+			Slots map[string]struct {
+				Value string `json:"value"`
+			} `json:"slots"`
+		} `json:"intent"`
+	} `json:"request"`
+}
+
+// alexaResponse is what the skill says back
+type alexaResponse struct {
+	Version  string `json:"version"`
+	Response struct {
+		OutputSpeech *alexaSpeech `json:"outputSpeech,omitempty"`
+		// ShouldEndSession false keeps the microphone open for another command
+		ShouldEndSession bool `json:"shouldEndSession"`
+	} `json:"response"`
+}
+
+type alexaSpeech struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func newAlexaResponse(text string, end bool) alexaResponse {
+	resp := alexaResponse{Version: "1.0"}
+	if text != "" {
+		resp.Response.OutputSpeech = &alexaSpeech{Type: "PlainText", Text: text}
+	}
+	resp.Response.ShouldEndSession = end
+	return resp
+}
+
+const alexaHelp = "You can say add milk, what's on my list, or tick off milk."
+
+// Handle Post request from Alexa, checking it really came from Amazon for this skill before doing what it asks
+func handleAlexa(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	err = alexa.verifySignature(r, body)
+	if err != nil {
+		slog.Warn("Rejected Alexa request", "err", err)
+		writeError(w, http.StatusBadRequest, "invalid_signature", "the request isn't signed by Alexa")
+		return
+	}
+	var req alexaRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "request body isn't an Alexa request")
+		return
+	}
+	if req.Context.System.Application.ApplicationID != alexa.skillID {
+		writeError(w, http.StatusBadRequest, "wrong_skill", "the request is for a different skill")
+		return
+	}
+	age := time.Since(req.Request.Timestamp)
+	if age > alexaMaxAge || age < -alexaMaxAge {
+		writeError(w, http.StatusBadRequest, "stale_request", "the request's timestamp is too far from now")
+		return
+	}
+
+	var resp alexaResponse
+	switch req.Request.Type {
+	case "LaunchRequest":
+		resp = newAlexaResponse("Your shopping list is ready. "+alexaHelp, false)
+	case "IntentRequest":
+		resp = alexa.handleIntent(req)
+	default:
+		// SessionEndedRequest and anything newer can't be answered with speech
+		resp = newAlexaResponse("", true)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Runs an intent and returns what to say
+func (s *alexaSkill) handleIntent(req alexaRequest) alexaResponse {
+	slot := func(name string) string {
+		return strings.TrimSpace(req.Request.Intent.Slots[name].Value)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var text string
+	var err error
+	switch req.Request.Intent.Name {
+	case "AddItem":
+		text, err = s.add(slot("Item"), slot("Quantity"))
+	case "ReadList":
+		text, err = s.read()
+	case "CompleteItem":
+		text, err = s.complete(slot("Item"))
+	case "AMAZON.HelpIntent":
+		return newAlexaResponse(alexaHelp, false)
+	case "AMAZON.StopIntent", "AMAZON.CancelIntent":
+		return newAlexaResponse("Okay.", true)
+	default:
+		return newAlexaResponse("Sorry, I can't do that. "+alexaHelp, false)
+	}
+	if err != nil {
+		slog.Error("Error running Alexa intent", "intent", req.Request.Intent.Name, "err", err)
+		return newAlexaResponse("Sorry, something went wrong, try again later.", true)
+	}
+	return newAlexaResponse(text, true)
+}
+
+// Puts an item on the list, merged into one that is still on it the same way as POST /data?dedupe=merge
+func (s *alexaSkill) add(item string, quantity string) (string, error) {
+	entry := Entry{Item: item}
+	if q, err := strconv.ParseFloat(quantity, 64); err == nil && q > 0 {
+		entry.Quantity = q
+	}
+	if item == "" || entryProblem(entry) != "" {
+		return "Sorry, I didn't catch what to add.", nil
+	}
+	now := time.Now().UTC()
+	prepareNewEntry(&entry, now)
+
+	var updated, created []Entry
+	changes, err := recordChanges(func(tx Store) error {
+		var err error
+		_, updated, created, err = addMerging(tx, s.listID, []Entry{entry})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	auditChanges("alexa", 0, "add", now, changes)
+	publishEntries("alexa", EventUpdated, updated...)
+	publishEntries("alexa", EventCreated, created...)
+	if entry.Quantity != 1 {
+		return fmt.Sprintf("Added %s %s to your list.", formatQuantity(entry.Quantity), entry.Item), nil
+	}
+	return "Added " + entry.Item + " to your list.", nil
+}
+
+// Reads out what is still to get
+func (s *alexaSkill) read() (string, error) {
+	entries, err := store.List(s.listID)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, entry := range liveEntries(entries) {
+		if entry.Completed {
+			continue
+		}
+		item := entry.Item
+		if entry.Quantity != 1 || entry.Unit != "" {
+			item = strings.TrimSpace(formatQuantity(entry.Quantity)+" "+entry.Unit) + " " + item
+		}
+		items = append(items, item)
+	}
+	switch len(items) {
+	case 0:
+		return "Your shopping list is empty.", nil
+	case 1:
+		return "You need " + items[0] + ".", nil
+	}
+	return fmt.Sprintf("You need %d things: %s and %s.", len(items), strings.Join(items[:len(items)-1], ", "), items[len(items)-1]), nil
+}
+
+// Ticks off the first entry still to get whose name matches, matched with itemKey so "tick off tomato" finds "Tomatoes"
+func (s *alexaSkill) complete(item string) (string, error) {
+	if item == "" {
+		return "Sorry, I didn't catch what to tick off.", nil
+	}
+	entries, err := store.List(s.listID)
+	if err != nil {
+		return "", err
+	}
+	key := itemKey(s.listID, item)
+	for _, entry := range liveEntries(entries) {
+		if entry.Completed || itemKey(s.listID, entry.Item) != key {
+			continue
+		}
+		setCompleted(&entry, true)
+		entry.Revision++
+		now := time.Now().UTC()
+		changes, err := recordChanges(func(tx Store) error {
+			return tx.Update(entry)
+		})
+		if err != nil {
+			return "", err
+		}
+		auditChanges("alexa", 0, "complete", now, changes)
+		publishEntries("alexa", EventCompleted, entry)
+		return "Ticked off " + entry.Item + ".", nil
+	}
+	return item + " isn't on your list.", nil
+}
+
+// Checks the request body was signed by Amazon, with the certificate at the SignatureCertChainUrl header
+// and the base64 SHA-256 RSA signature in the Signature-256 header
+func (s *alexaSkill) verifySignature(r *http.Request, body []byte) error {
+	certURL := r.Header.Get("SignatureCertChainUrl")
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get("Signature-256"))
+	if err != nil || len(signature) == 0 {
+		return errors.New("missing or malformed Signature-256")
+	}
+	cert, err := s.certificate(certURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate doesn't have an RSA key")
+	}
+	digest := sha256.Sum256(body)
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+}
+
+// Returns the signing certificate at rawURL once its chain and name have been checked, from the cache while it is valid
+func (s *alexaSkill) certificate(rawURL string) (*x509.Certificate, error) {
+	err := checkAlexaCertURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.certsMu.Lock()
+	cert, ok := s.certs[rawURL]
+	s.certsMu.Unlock()
+	if ok && now.Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	resp, err := s.client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing certificate returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates at SignatureCertChainUrl")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	// Verify checks the dates and that the chain goes up to a root the system trusts, DNSName checks the subject alternative names
+	_, err = chain[0].Verify(x509.VerifyOptions{DNSName: alexaCertName, Intermediates: intermediates, CurrentTime: now})
+	if err != nil {
+		return nil, err
+	}
+	s.certsMu.Lock()
+	s.certs[rawURL] = chain[0]
+	s.certsMu.Unlock()
+	return chain[0], nil
+}
+
+// Only certificates Amazon publishes can sign requests, so the URL has to be https://s3.amazonaws.com/echo.api/... on port 443
+func checkAlexaCertURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return errors.New("missing or malformed SignatureCertChainUrl")
+	}
+	if !strings.EqualFold(u.Scheme, "https") || !strings.EqualFold(u.Hostname(), "s3.amazonaws.com") || (u.Port() != "" && u.Port() != "443") {
+		return errors.New("SignatureCertChainUrl isn't on https://s3.amazonaws.com")
+	}
+	// the path is cleaned first so /echo.api/../other/ can't get past the check
+	if !strings.HasPrefix(path.Clean(u.Path), "/echo.api/") {
+		return errors.New("SignatureCertChainUrl isn't under /echo.api/")
+	}
+	return nil
+}
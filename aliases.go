@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// singularizeItems is whether item names are matched without their plural, set from -singularize-items
+var singularizeItems = true
+
+// Returns the key items are matched on, two names with the same key are the same item e.g. for ?dedupe=merge, stats and autocomplete
+// it is the name lowercased with its spaces tidied, an alias is swapped for the item it stands for
+// and with -singularize-items the last word loses its plural, so "Tomatoes", "tomato" and "Tomatos" are all "tomato"
+// callers must hold mu since the aliases are read
+func itemKey(listID int, item string) string {
+	name := normalizeItemName(item)
+	if canonical, ok := aliases.Lookup(listID, name); ok {
+		name = normalizeItemName(canonical)
+	}
+	if singularizeItems {
+		name = singularize(name)
+	}
+	return name
+}
+
+// singularExceptions are plurals the suffix rules get wrong
+var singularExceptions = map[string]string{
+	"leaves":   "leaf",
+	"loaves":   "loaf",
+	"halves":   "half",
+	"knives":   "knife",
+	"potatoes": "potato",
+	"tomatoes": "tomato",
+	"mice":     "mouse",
+	"geese":    "goose",
+	"teeth":    "tooth",
+}
+
+// Takes the plural off the last word of a normalized name, e.g. "bin bags" is "bin bag" and "berries" is "berry"
+// it is only used to match names, never to show them, so a word it gets slightly wrong only has to be wrong the same way every time
+func singularize(name string) string {
+	start := strings.LastIndex(name, " ") + 1
+	word := name[start:]
+	if singular, ok := singularExceptions[word]; ok {
+		return name[:start] + singular
+	}
+	switch {
+	case len(word) <= 3:
+	case strings.HasSuffix(word, "ies"):
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "oes"), strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	// glass, hummus, asparagus and the like aren't plurals
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+	case strings.HasSuffix(word, "s"):
+		word = strings.TrimSuffix(word, "s")
+	}
+	return name[:start] + word
+}
+
+// aliasesDoc holds each list's aliases, from a normalized alias to the name of the item it stands for
+type aliasesDoc struct {
+	Lists map[int]map[string]string `json:"lists"`
+}
+
+// aliasesDocName is the Store document the aliases are saved under
+const aliasesDocName = "aliases"
+
+// aliasRegistry holds every list's aliases in memory and saves them to the Store on every change
+type aliasRegistry struct {
+	store Store
+	doc   aliasesDoc
+}
+
+var aliases *aliasRegistry
+
+func loadAliases(store Store) (*aliasRegistry, error) {
+	a := &aliasRegistry{store: store}
+	err := store.LoadDoc(aliasesDocName, &a.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if a.doc.Lists == nil {
+		a.doc.Lists = make(map[int]map[string]string)
+	}
+	return a, nil
+}
+
+// For returns the list's aliases
+func (a *aliasRegistry) For(listID int) map[string]string {
+	list := maps.Clone(a.doc.Lists[listID])
+	if list == nil {
+		list = map[string]string{}
+	}
+	return list
+}
+
+// Lookup returns the item a normalized name stands for, false if it isn't an alias
+// the name is tried as it is and without its plural, so an alias for "tomatos" also covers "tomato"
+func (a *aliasRegistry) Lookup(listID int, name string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	list := a.doc.Lists[listID]
+	if canonical, ok := list[name]; ok {
+		return canonical, true
+	}
+	canonical, ok := list[singularize(name)]
+	return canonical, ok
+}
+
+// Set makes alias stand for item on the list, or stops it being an alias when item is "", and saves them
+func (a *aliasRegistry) Set(listID int, alias string, item string) error {
+	list := a.doc.Lists[listID]
+	if item == "" {
+		delete(list, alias)
+		if len(list) == 0 {
+			delete(a.doc.Lists, listID)
+		}
+	} else {
+		if list == nil {
+			list = make(map[string]string)
+			a.doc.Lists[listID] = list
+		}
+		list[alias] = item
+	}
+	return a.store.SaveDoc(aliasesDocName, a.doc)
+}
+
+// Gives entries that are added under an alias the name of the item it stands for, callers must hold mu
+func canonicalItems(listID int, entries []Entry) {
+	for i := range entries {
+		if canonical, ok := aliases.Lookup(listID, normalizeItemName(entries[i].Item)); ok {
+			entries[i].Item = canonical
+		}
+	}
+}
+
+// Handle Get request for the list's aliases, e.g. {"tomatos": "tomato"}
+func handleGetAliases(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, aliases.For(requestIdentity(r).ListID))
+}
+
+// aliasRequest is the body of PUT /aliases/{alias}
+type aliasRequest struct {
+	Item string `json:"item"`
+}
+
+// Handle Put request to make a name an alias for an item, so entries added under it are added as the item
+// and it is matched as the item by ?dedupe=merge, GET /stats, GET /suggestions and GET /autocomplete
+func handlePutAlias(w http.ResponseWriter, r *http.Request) {
+	var body aliasRequest
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	alias := normalizeItemName(r.PathValue("alias"))
+	item := strings.Join(strings.Fields(body.Item), " ")
+	if errs := patchErrors(EntryPatch{Item: &item}); errs != nil {
+		writeFieldErrors(w, "invalid_alias", errs)
+		return
+	}
+	if alias == "" || len(alias) > maxItemLength {
+		writeError(w, http.StatusBadRequest, "invalid_alias", "aliases can't be empty or longer than "+strconv.Itoa(maxItemLength)+" characters")
+		return
+	}
+	if singularize(normalizeItemName(item)) == singularize(alias) {
+		writeError(w, http.StatusBadRequest, "invalid_alias", "an item can't be an alias for itself")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	// aliases only go one step, so an alias can't point at another alias or be pointed at by one
+	if canonical, ok := aliases.For(listID)[normalizeItemName(item)]; ok {
+		writeError(w, http.StatusConflict, "alias_chain", item+" is itself an alias for "+canonical+", use that instead")
+		return
+	}
+	for other, canonical := range aliases.For(listID) {
+		if normalizeItemName(canonical) == alias {
+			writeError(w, http.StatusConflict, "alias_chain", other+" is an alias for "+alias+", so it can't be an alias itself")
+			return
+		}
+	}
+	err = aliases.Set(listID, alias, item)
+	if err != nil {
+		slog.Error("Error saving aliases", "err", err)
+		writeInternalError(w)
+		return
+	}
+	rebuildItemIndex()
+	writeJSON(w, http.StatusOK, aliases.For(listID))
+}
+
+// Handle Delete request to stop a name being an alias, entries already added under it keep the item's name
+func handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	alias := normalizeItemName(r.PathValue("alias"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	if _, ok := aliases.For(listID)[alias]; !ok {
+		writeError(w, http.StatusNotFound, "alias_not_found", "alias not found")
+		return
+	}
+	err := aliases.Set(listID, alias, "")
+	if err != nil {
+		slog.Error("Error saving aliases", "err", err)
+		writeInternalError(w)
+		return
+	}
+	rebuildItemIndex()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Builds the autocomplete index again after the aliases change, since they decide which names are the same item
+// callers must hold mu, if it fails the old index is kept and is only out of date
+func rebuildItemIndex() {
+	index, err := buildItemIndex(store, trips)
+	if err != nil {
+		slog.Error("Error building the autocomplete index", "err", err)
+		return
+	}
+	itemNames = index
+}
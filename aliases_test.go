@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Every way an entry can be added gives an item added under an alias the name of the item it stands for
+func TestAddingUnderAnAlias(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"POST /data", "POST", "/data", `{"item": "Spuds"}`, http.StatusCreated},
+		{"POST /data with dedupe", "POST", "/data?dedupe=merge", `[{"item": "Spuds"}]`, http.StatusCreated},
+		{"POST /data/bulk", "POST", "/data/bulk", `[{"action": "add", "item": "Spuds"}]`, http.StatusOK},
+		{"POST /sync", "POST", "/sync", `{"mutations": [{"action": "add", "client_id": "c1", "item": "Spuds"}]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestServer(t)
+			err := aliases.Set(0, "spuds", "potato")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := serveTest(handler, tt.method, tt.target, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			assertItems(t, "potato")
+		})
+	}
+}
+
+func TestRecurringItemUnderAnAlias(t *testing.T) {
+	newTestServer(t)
+	err := aliases.Set(0, "spuds", "potato")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	recurring.doc.Items = append(recurring.doc.Items, RecurringItem{ID: 1, Item: "Spuds", Quantity: 1, EveryDays: 7, NextDue: now.Add(-time.Hour)})
+
+	_, err = recurring.addDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertItems(t, "potato")
+	// the entry it added is found under the alias too, so the next run doesn't add it again
+	recurring.doc.Items[0].NextDue = now.Add(-time.Hour)
+	_, err = recurring.addDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertItems(t, "potato")
+}
+
+// Fails the test unless the shared list has exactly these items, in the order they were added
+func assertItems(t *testing.T, want ...string) {
+	t.Helper()
+	entries, err := store.List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range liveEntries(entries) {
+		got = append(got, entry.Item)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("items = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditRecord is what one change did to one entry, the audit log is only ever added to
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Actor is the username, "api-key", "anonymous" for -no-auth, or "system" for changes the server makes itself
+	Actor  string `json:"actor"`
+	UserID int    `json:"user_id,omitempty"`
+	// Operation is the action that made the change e.g. "add", "update", "delete", "bulk", "clear", "undo" or "purge"
+	Operation string `json:"operation"`
+	ListID    int    `json:"list_id"`
+	EntryID   int    `json:"entry_id"`
+	// Before is nil when the entry was added and After is nil when it was removed for good
+	Before *Entry `json:"before"`
+	After  *Entry `json:"after"`
+}
+
+// auditQuery picks which records a Store returns from the audit log
+type auditQuery struct {
+	ListID int
+	// EntryID is 0 for every entry
+	EntryID int
+	// Since and Until are zero when there is no bound, Since is inclusive and Until isn't
+	Since time.Time
+	Until time.Time
+}
+
+// Matches reports whether the record is one the query asks for
+func (q auditQuery) Matches(record AuditRecord) bool {
+	if record.ListID != q.ListID {
+		return false
+	}
+	if q.EntryID != 0 && record.EntryID != q.EntryID {
+		return false
+	}
+	if !q.Since.IsZero() && record.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !record.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// systemActor is the actor for changes nobody asked for, like purging the trash
+const systemActor = "system"
+
+// Handle Get request for the list's audit log, newest first
+// ?entry= narrows it to one entry and ?item= to entries whose name contains the text before or after the change
+// ?since= and ?until= take RFC 3339 times and ?limit= keeps only the most recent records
+func handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := auditQuery{ListID: requestIdentity(r).ListID}
+	var err error
+	if entry := values.Get("entry"); entry != "" {
+		query.EntryID, err = strconv.Atoi(entry)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "entry has to be an entry ID")
+			return
+		}
+	}
+	query.Since, err = parseAuditTime("since", values.Get("since"))
+	if err == nil {
+		query.Until, err = parseAuditTime("until", values.Get("until"))
+	}
+	var limit int
+	if err == nil {
+		limit, err = parseNonNegative("limit", values.Get("limit"))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	item := normalizeItemName(values.Get("item"))
+
+	mu.RLock()
+	records, err := store.Audit(query)
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error reading audit log", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	matched := []AuditRecord{}
+	for _, record := range slices.Backward(records) {
+		if item != "" && !auditMentions(record.Before, item) && !auditMentions(record.After, item) {
+			continue
+		}
+		matched = append(matched, record)
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, matched)
+}
+
+func auditMentions(entry *Entry, item string) bool {
+	return entry != nil && strings.Contains(normalizeItemName(entry.Item), item)
+}
+
+func parseAuditTime(name string, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s has to be an RFC 3339 time e.g. 2024-05-01T09:00:00Z", errBadQuery, name)
+	}
+	return t, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads the API keys from -api-keys-file and -api-keys, both can be used at once
+// the file has one key per line, blank lines and lines starting with # are skipped
+func loadAPIKeys(cfg Config) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(cfg.APIKeys, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	if cfg.APIKeysFile != "" {
+		f, err := os.Open(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			keys = append(keys, line)
+		}
+		err = scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// identity is who a request was made by and which list it works on
+type identity struct {
+	// User is nil for requests made with an API key or with -no-auth
+	// it is built from the access token's claims so only ID, Username and ListID are filled in
+	User *User
+	// ListID is the user's own list, or list 0 (the list from before accounts existed) for API keys
+	ListID int
+	// Anonymous is set for requests -no-auth let through without a token
+	Anonymous bool
+}
+
+// Caller names who made the request for things kept per caller, "user:<id>" for a logged in user and "list:<id>" for an API key
+func (id identity) Caller() string {
+	if id.User != nil {
+		return "user:" + strconv.Itoa(id.User.ID)
+	}
+	return "list:" + strconv.Itoa(id.ListID)
+}
+
+// Actor names who made the request in the audit log, the username for a logged in user
+func (id identity) Actor() string {
+	switch {
+	case id.User != nil:
+		return id.User.Username
+	case id.Anonymous:
+		return "anonymous"
+	default:
+		return "api-key"
+	}
+}
+
+// UserID is the logged in user's ID or 0 when there isn't one
+func (id identity) UserID() int {
+	if id.User == nil {
+		return 0
+	}
+	return id.User.ID
+}
+
+// ctxKey is the type for values this package puts in a request context, so they can't clash with anyone else's
+type ctxKey int
+
+const identityKey ctxKey = 0
+
+// Returns the identity requireAuth attached to the request
+func requestIdentity(r *http.Request) identity {
+	id, _ := r.Context().Value(identityKey).(identity)
+	return id
+}
+
+// Returns middleware that only lets a request through with "Authorization: Bearer <token>"
+// the token can be one of the API keys, which works on list 0, or an access token from POST /login, which works on that user's list
+// with noAuth set a request without a token is let through to list 0, which is only meant for running on localhost
+func requireAuth(keys []string, noAuth bool, tokens *tokenIssuer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id identity
+			token, ok := bearerToken(r)
+			switch {
+			case ok && validAPIKey(keys, token):
+				id = identity{ListID: 0}
+			case ok:
+				// everything needed is in the signed claims so no session lookup is needed
+				c, err := tokens.Verify(token)
+				if err != nil {
+					unauthorized(w)
+					return
+				}
+				user := User{ID: c.UserID, Username: c.Username, ListID: c.ListID}
+				id = identity{User: &user, ListID: c.ListID}
+			case noAuth:
+				id = identity{ListID: 0, Anonymous: true}
+			default:
+				unauthorized(w)
+				return
+			}
+			ctx := context.WithValue(r.Context(), identityKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	// WWW-Authenticate tells the client what kind of credentials to send
+	w.Header().Set("WWW-Authenticate", `Bearer realm="shoppinglist"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized", "a valid API key or access token is required")
+}
+
+// Pulls the token out of an "Authorization: Bearer <token>" header
+// browsers can't set headers when opening a WebSocket or an EventSource so for those the token can be sent as ?access_token= instead
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	streaming := headerContains(r.Header, "Upgrade", "websocket") || headerContains(r.Header, "Accept", "text/event-stream")
+	if header == "" && streaming {
+		token := r.URL.Query().Get("access_token")
+		return token, token != ""
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// Compares in constant time so the response time doesn't give away how much of a key was right
+// every key is checked even after a match for the same reason
+func validAPIKey(keys []string, key string) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(k), []byte(key))
+	}
+	return valid == 1
+}
+
+// Returns middleware for feeds that apps subscribe to by URL, like GET /calendar.ics, which can only send a username and password
+// "Authorization: Basic" is checked against the user accounts, or with any username against the API keys, anything else goes through auth as usual
+// a request without credentials is asked for Basic ones so the app prompts for them, unless noAuth lets it through
+func requireBasicAuth(keys []string, noAuth bool, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withToken := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				if r.Header.Get("Authorization") == "" && !noAuth {
+					w.Header().Set("WWW-Authenticate", `Basic realm="shoppinglist", charset="UTF-8"`)
+					writeError(w, http.StatusUnauthorized, "unauthorized", "a username and password or API key is required")
+					return
+				}
+				withToken.ServeHTTP(w, r)
+				return
+			}
+			var id identity
+			if validAPIKey(keys, password) {
+				id = identity{ListID: 0}
+			} else {
+				// a password sent here can be guessed at as well as one sent to POST /login, so it counts towards the same lockout
+				ip := remoteIP(r)
+				if wait := logins.Check(username, ip, time.Now()); wait > 0 {
+					writeLoginLocked(w, wait)
+					return
+				}
+				user, err := users.Authenticate(username, password)
+				if err != nil {
+					if errors.Is(err, ErrBadCredentials) {
+						logins.Failed(username, ip, time.Now())
+					}
+					w.Header().Set("WWW-Authenticate", `Basic realm="shoppinglist", charset="UTF-8"`)
+					writeError(w, http.StatusUnauthorized, "invalid_credentials", "wrong username or password")
+					return
+				}
+				logins.Succeeded(username, ip)
+				id = identity{User: &User{ID: user.ID, Username: user.Username, ListID: user.ListID}, ListID: user.ListID}
+			}
+			ctx := context.WithValue(r.Context(), identityKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultCompletions is how many names GET /autocomplete returns when ?limit= isn't given
+const defaultCompletions = 10
+
+// completion is an item name that has been used on a list, with how it is usually added
+type completion struct {
+	Item string `json:"item"`
+	// Count is how many times it has been added
+	Count    int     `json:"count"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	// Category is the category it is most often given, "" if it has never had one
+	Category string `json:"category"`
+}
+
+// itemAmount is a quantity with its unit, they are counted together since 2 and 2 kg aren't the same amount
+type itemAmount struct {
+	quantity float64
+	unit     string
+}
+
+// itemUsage is everything the index knows about one item name
+type itemUsage struct {
+	// name is the spelling it was last added with
+	name       string
+	count      int
+	amounts    map[itemAmount]int
+	categories map[string]int
+}
+
+// itemIndex holds the item names used on each list, keyed by list and then by itemKey
+// it lives only in memory, it is built from the stored entries and trips at startup and kept up to date by recordChanges
+// so every method is called with mu held
+type itemIndex struct {
+	lists map[int]map[string]*itemUsage
+}
+
+var itemNames *itemIndex
+
+// Builds the index from every stored entry, trash included, and the entries on closed trips that have since been purged
+func buildItemIndex(store Store, tripList *tripsRegistry) (*itemIndex, error) {
+	entries, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+	x := &itemIndex{lists: make(map[int]map[string]*itemUsage)}
+	type entryKey struct{ listID, id int }
+	stored := make(map[entryKey]bool)
+	for _, entry := range entries {
+		stored[entryKey{entry.ListID, entry.ID}] = true
+		x.note(entry)
+	}
+	for _, trip := range tripList.doc.Trips {
+		for _, entry := range trip.Entries {
+			if !stored[entryKey{trip.ListID, entry.ID}] {
+				entry.ListID = trip.ListID
+				x.note(entry)
+			}
+		}
+	}
+	return x, nil
+}
+
+// Counts an entry's item name as used once more
+func (x *itemIndex) note(entry Entry) {
+	key := itemKey(entry.ListID, entry.Item)
+	if key == "" {
+		return
+	}
+	names := x.lists[entry.ListID]
+	if names == nil {
+		names = make(map[string]*itemUsage)
+		x.lists[entry.ListID] = names
+	}
+	usage := names[key]
+	if usage == nil {
+		usage = &itemUsage{amounts: make(map[itemAmount]int), categories: make(map[string]int)}
+		names[key] = usage
+	}
+	usage.name = strings.Join(strings.Fields(entry.Item), " ")
+	usage.count++
+	usage.amounts[itemAmount{entry.Quantity, entry.Unit}]++
+	if entry.Category != "" {
+		usage.categories[entry.Category]++
+	}
+}
+
+// Notes the names of entries that were added or renamed by a change
+// schema migrations make changes at startup before the index is built, it is built from what they leave so they are skipped
+func (x *itemIndex) Note(changes []entryChange) {
+	if x == nil {
+		return
+	}
+	for _, change := range changes {
+		if change.After == nil {
+			continue
+		}
+		if change.Before == nil || normalizeItemName(change.Before.Item) != normalizeItemName(change.After.Item) {
+			x.note(*change.After)
+		}
+	}
+}
+
+// Complete returns the list's item names that start with prefix, or have a word that does, the most used first
+// names that start with it come before names that only have a word starting with it
+func (x *itemIndex) Complete(listID int, prefix string) []completion {
+	prefix = normalizeItemName(prefix)
+	type match struct {
+		completion
+		wordOnly bool
+	}
+	var matches []match
+	for key, usage := range x.lists[listID] {
+		// the key may have lost a plural the name being typed still has, so the name is tried too
+		name := normalizeItemName(usage.name)
+		wordOnly := false
+		if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(name, prefix) {
+			if !strings.Contains(key, " "+prefix) && !strings.Contains(name, " "+prefix) {
+				continue
+			}
+			wordOnly = true
+		}
+		matches = append(matches, match{usage.completion(), wordOnly})
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		if a.wordOnly != b.wordOnly {
+			if a.wordOnly {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(strings.ToLower(a.Item), strings.ToLower(b.Item)))
+	})
+	completions := make([]completion, len(matches))
+	for i, m := range matches {
+		completions[i] = m.completion
+	}
+	return completions
+}
+
+// Returns the name with the amount and category it is most often added with
+func (u *itemUsage) completion() completion {
+	c := completion{Item: u.name, Count: u.count, Quantity: 1}
+	best := 0
+	for amount, n := range u.amounts {
+		// ties go to the smaller amount so the answer doesn't change from one request to the next
+		if n > best || n == best && (amount.quantity < c.Quantity || amount.quantity == c.Quantity && amount.unit < c.Unit) {
+			best, c.Quantity, c.Unit = n, amount.quantity, amount.unit
+		}
+	}
+	best = 0
+	for category, n := range u.categories {
+		if n > best || n == best && category < c.Category {
+			best, c.Category = n, category
+		}
+	}
+	return c
+}
+
+// Handle Get request for item names used on the list before that start with ?q=, for suggesting them while an item is typed
+// ?limit= is how many to return, 10 when it isn't given
+func handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	limit, err := parseNonNegative("limit", values.Get("limit"))
+	if err == nil && strings.TrimSpace(values.Get("q")) == "" {
+		err = errors.New("q is required")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if values.Get("limit") == "" {
+		limit = defaultCompletions
+	}
+
+	mu.RLock()
+	completions := itemNames.Complete(requestIdentity(r).ListID, values.Get("q"))
+	mu.RUnlock()
+	writeJSON(w, http.StatusOK, completions[:min(limit, len(completions))])
+}
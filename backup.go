@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// backupVersion is the format GET /backup writes, POST /restore refuses anything newer than it understands
+const backupVersion = 1
+
+// Backup is a snapshot of everything the server keeps, every list's entries and every saved document
+type Backup struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   []Entry   `json:"entries"`
+	// Docs are the saved documents by name e.g. "users", each in the same JSON the subsystem saves it as
+	Docs map[string]json.RawMessage `json:"docs"`
+}
+
+// backupDocs are the documents a backup holds, with the type each one decodes into so a restore can check them first
+// the token signing secret is left out because it is a secret and only signs short lived tokens, and the undo history
+// is left out because it describes changes to the entries the restore replaces
+var backupDocs = map[string]func() any{
+	accountsDocName:    func() any { return &accountsDoc{} },
+	aliasesDocName:     func() any { return &aliasesDoc{} },
+	budgetsDocName:     func() any { return &budgetsDoc{} },
+	conflictsDocName:   func() any { return &conflictsDoc{} },
+	guestTokensDocName: func() any { return &guestTokensDoc{} },
+	digestsDocName:     func() any { return &digestsDoc{} },
+	categoriesDocName:  func() any { return &categoriesDoc{} },
+	shopsDocName:       func() any { return &shopsDoc{} },
+	shareLinksDocName:  func() any { return &shareLinksDoc{} },
+	mealPlanDocName:    func() any { return &mealPlanDoc{} },
+	recipesDocName:     func() any { return &recipesDoc{} },
+	recurringDocName:   func() any { return &recurringDoc{} },
+	remindersDocName:   func() any { return &remindersDoc{} },
+	tripsDocName:       func() any { return &tripsDoc{} },
+	unitsDocName:       func() any { return &unitsDoc{} },
+	webhooksDocName:    func() any { return &webhooksDoc{} },
+}
+
+// restoreReport says what a restore changed, or with ?dry_run=true what it would change
+type restoreReport struct {
+	DryRun bool `json:"dry_run"`
+	// Added, Removed and Changed count entries by ID compared with what is stored now
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	// Docs are the names of the documents that are different in the backup
+	Docs []string `json:"docs"`
+}
+
+// Reads everything into a Backup, callers must hold mu
+func takeBackup(s Store) (Backup, error) {
+	backup := Backup{Version: backupVersion, CreatedAt: time.Now().UTC(), Docs: make(map[string]json.RawMessage)}
+	var err error
+	backup.Entries, err = s.All()
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(backupDocs)) {
+		var doc json.RawMessage
+		err := s.LoadDoc(name, &doc)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Backup{}, fmt.Errorf("reading %s: %w", name, err)
+		}
+		backup.Docs[name] = doc
+	}
+	return backup, nil
+}
+
+// Returns what is wrong with a backup, or "" if it can be restored
+func (b Backup) problem() string {
+	if b.Version < 1 || b.Version > backupVersion {
+		return fmt.Sprintf("version %d isn't supported, this server reads up to version %d", b.Version, backupVersion)
+	}
+	seen := make(map[int]bool)
+	for i, entry := range b.Entries {
+		if entry.ID < 1 || seen[entry.ID] {
+			return fmt.Sprintf("entry %d: every entry needs its own ID of 1 or more", i)
+		}
+		seen[entry.ID] = true
+		if entry.Item == "" {
+			return fmt.Sprintf("entry %d: item is required", entry.ID)
+		}
+		if problem := entryProblem(entry); problem != "" {
+			return fmt.Sprintf("entry %d: %s", entry.ID, problem)
+		}
+	}
+	for name, data := range b.Docs {
+		newDoc, ok := backupDocs[name]
+		if !ok {
+			return fmt.Sprintf("unknown document %q", name)
+		}
+		err := json.Unmarshal(data, newDoc())
+		if err != nil {
+			return fmt.Sprintf("document %q: %v", name, err)
+		}
+	}
+	return ""
+}
+
+// Compares a backup with what is stored now, callers must hold mu
+func compareBackup(s Store, b Backup) (restoreReport, error) {
+	report := restoreReport{Docs: []string{}}
+	current, err := s.All()
+	if err != nil {
+		return report, err
+	}
+	byID := make(map[int]Entry, len(current))
+	for _, entry := range current {
+		byID[entry.ID] = entry
+	}
+	for _, entry := range b.Entries {
+		old, ok := byID[entry.ID]
+		switch {
+		case !ok:
+			report.Added++
+		case reflect.DeepEqual(old, entry):
+			report.Unchanged++
+		default:
+			report.Changed++
+		}
+		delete(byID, entry.ID)
+	}
+	report.Removed = len(byID)
+
+	for _, name := range slices.Sorted(maps.Keys(backupDocs)) {
+		var old, restored any
+		err := s.LoadDoc(name, &old)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return report, err
+		}
+		if data, ok := b.Docs[name]; ok {
+			json.Unmarshal(data, &restored)
+		}
+		if !reflect.DeepEqual(old, restored) {
+			report.Docs = append(report.Docs, name)
+		}
+	}
+	return report, nil
+}
+
+// Replaces everything with the backup and reloads the subsystems that keep their documents in memory, callers must hold mu
+// a document that isn't in the backup is reset to empty, the undo history is cleared
+func restoreBackup(s Store, b Backup) error {
+	for i := range b.Entries {
+		normalizeEntry(&b.Entries[i])
+	}
+	// with SQLite the documents are written in the same transaction as the entries
+	err := s.Transaction(func(tx Store) error {
+		err := tx.Replace(b.Entries)
+		if err != nil {
+			return err
+		}
+		for name, newDoc := range backupDocs {
+			var doc any = newDoc()
+			if data, ok := b.Docs[name]; ok {
+				doc = data
+			}
+			err := tx.SaveDoc(name, doc)
+			if err != nil {
+				return fmt.Errorf("writing %s: %w", name, err)
+			}
+		}
+		return tx.SaveDoc(undoDocName, undoDoc{})
+	})
+	if err != nil {
+		return err
+	}
+	return reloadDocs(s)
+}
+
+// Loads every document again after a restore
+// the users, webhooks and reminders are swapped in place because their goroutines hold on to the registry itself
+func reloadDocs(s Store) error {
+	loadedUsers, err := loadUsers(s, users.sessionTTL)
+	if err != nil {
+		return err
+	}
+	users.mu.Lock()
+	users.doc = loadedUsers.doc
+	users.mu.Unlock()
+
+	loadedWebhooks, err := loadWebhooks(s)
+	if err != nil {
+		return err
+	}
+	webhooks.mu.Lock()
+	webhooks.doc = loadedWebhooks.doc
+	webhooks.mu.Unlock()
+
+	loadedReminders, err := loadReminders(s, reminders.actions)
+	if err != nil {
+		return err
+	}
+	reminders.doc = loadedReminders.doc
+
+	loadedDigests, err := loadDigests(s, nil, "")
+	if err != nil {
+		return err
+	}
+	digests.doc = loadedDigests.doc
+
+	budgets, err = loadBudgets(s)
+	if err != nil {
+		return err
+	}
+	conflicts, err = loadConflicts(s)
+	if err != nil {
+		return err
+	}
+	categories, err = loadCategories(s)
+	if err != nil {
+		return err
+	}
+	shops, err = loadShops(s)
+	if err != nil {
+		return err
+	}
+	recurring, err = loadRecurring(s)
+	if err != nil {
+		return err
+	}
+	trips, err = loadTrips(s)
+	if err != nil {
+		return err
+	}
+	recipes, err = loadRecipes(s)
+	if err != nil {
+		return err
+	}
+	mealPlan, err = loadMealPlan(s)
+	if err != nil {
+		return err
+	}
+	// the public URL is a setting rather than part of the backup so it is kept
+	shareLinks, err = loadShareLinks(s, shareLinks.publicURL)
+	if err != nil {
+		return err
+	}
+	guestTokens, err = loadGuestTokens(s, guestTokens.publicURL)
+	if err != nil {
+		return err
+	}
+	undos, err = loadUndoLog(s)
+	if err != nil {
+		return err
+	}
+	aliases, err = loadAliases(s)
+	if err != nil {
+		return err
+	}
+	units, err = loadUnits(s)
+	if err != nil {
+		return err
+	}
+	itemNames, err = buildItemIndex(s, trips)
+	return err
+}
+
+// Handle Get request for a backup of everything on the server
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	backup, err := takeBackup(store)
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error taking backup", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="shoppinglist-backup-`+backup.CreatedAt.Format("2006-01-02T150405Z")+`.json"`)
+	writeJSON(w, http.StatusOK, backup)
+}
+
+// Handle Post request to replace everything on the server with a backup from GET /backup
+// the whole backup is checked before anything is written, ?dry_run=true stops there and reports what would change
+// live clients are sent a reset event so they fetch their list again
+func handleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	var backup Backup
+	// not decodeStrict, a backup from a newer version with fields this one doesn't know yet still restores what it can
+	err := json.NewDecoder(r.Body).Decode(&backup)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "dry_run has to be true or false")
+			return
+		}
+	}
+	if problem := backup.problem(); problem != "" {
+		writeError(w, http.StatusBadRequest, "invalid_backup", problem)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := store.All()
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	report, err := compareBackup(store, backup)
+	if err != nil {
+		slog.Error("Error comparing backup", "err", err)
+		writeInternalError(w)
+		return
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	err = restoreBackup(store, backup)
+	if err != nil {
+		slog.Error("Error restoring backup", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Restored backup", "actor", requestIdentity(r).Actor(), "created_at", backup.CreatedAt, "entries", len(backup.Entries))
+	for _, listID := range listIDs(slices.Concat(current, backup.Entries)) {
+		hub.Publish(Event{Type: EventReset, ListID: listID, Actor: requestIdentity(r).Actor()})
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Returns the IDs of the lists the entries are on, in order
+func listIDs(entries []Entry) []int {
+	var ids []int
+	for _, entry := range entries {
+		if !slices.Contains(ids, entry.ListID) {
+			ids = append(ids, entry.ListID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupPrefix starts the name of every scheduled backup, anything else in the directory or bucket is left alone
+const backupPrefix = "shoppinglist-backup-"
+
+// storedBackup is one scheduled backup in a GET /backups response
+type storedBackup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupTarget is somewhere scheduled backups are written to
+type backupTarget interface {
+	Put(name string, data []byte) error
+	// List returns the backups, oldest first
+	List() ([]storedBackup, error)
+	Delete(name string) error
+}
+
+// backupStore is nil when scheduled backups are off
+var backupStore backupTarget
+
+// Picks the target from the config, S3 when -backup-s3 is set and the -backup-dir directory otherwise
+func newBackupTarget(cfg Config) (backupTarget, error) {
+	if cfg.BackupS3 == "" {
+		return dirTarget{dir: cfg.BackupDir}, nil
+	}
+	u, err := url.Parse(cfg.BackupS3)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("-backup-s3 has to be an http or https URL ending in the bucket, e.g. https://s3.example.com/my-bucket")
+	}
+	if cfg.BackupS3AccessKey == "" || cfg.BackupS3SecretKey == "" {
+		return nil, errors.New("-backup-s3-key-id and -backup-s3-secret have to be set with -backup-s3")
+	}
+	u.Path = "/" + strings.Trim(u.Path, "/")
+	return &s3Target{
+		client:    &http.Client{Timeout: time.Minute},
+		bucket:    u,
+		region:    cfg.BackupS3Region,
+		accessKey: cfg.BackupS3AccessKey,
+		secretKey: cfg.BackupS3SecretKey,
+	}, nil
+}
+
+// Writes a backup every interval and then deletes all but the newest keep, until ctx is cancelled
+func runBackups(ctx context.Context, target backupTarget, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		name, err := writeScheduledBackup(target, keep)
+		if err != nil {
+			slog.Error("Error writing scheduled backup", "err", err)
+			continue
+		}
+		slog.Info("Wrote scheduled backup", "name", name)
+	}
+}
+
+func writeScheduledBackup(target backupTarget, keep int) (string, error) {
+	mu.RLock()
+	backup, err := takeBackup(store)
+	mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return "", err
+	}
+	// the timestamp sorts the same way as a string, so the names are in age order
+	name := backupPrefix + backup.CreatedAt.Format("20060102T150405Z") + ".json"
+	err = target.Put(name, data)
+	if err != nil {
+		return "", err
+	}
+
+	files, err := target.List()
+	if err != nil {
+		return name, fmt.Errorf("listing backups to rotate: %w", err)
+	}
+	for len(files) > keep {
+		err := target.Delete(files[0].Name)
+		if err != nil {
+			return name, fmt.Errorf("deleting old backup %s: %w", files[0].Name, err)
+		}
+		files = files[1:]
+	}
+	return name, nil
+}
+
+// Handle Get request for the scheduled backups that are being kept, newest first
+func handleGetBackups(w http.ResponseWriter, r *http.Request) {
+	if backupStore == nil {
+		writeError(w, http.StatusNotFound, "backups_off", "scheduled backups are off, start the server with -backup-interval to turn them on")
+		return
+	}
+	files, err := backupStore.List()
+	if err != nil {
+		slog.Error("Error listing backups", "err", err)
+		writeInternalError(w)
+		return
+	}
+	if files == nil {
+		files = []storedBackup{}
+	}
+	slices.Reverse(files)
+	writeJSON(w, http.StatusOK, files)
+}
+
+// dirTarget keeps backups as files in a local directory
+type dirTarget struct {
+	dir string
+}
+
+func (d dirTarget) Put(name string, data []byte) error {
+	// backups hold password hashes so only the server's own user can read them
+	err := os.MkdirAll(d.dir, 0700)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.dir, name)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d dirTarget) List() ([]storedBackup, error) {
+	dirEntries, err := os.ReadDir(d.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []storedBackup
+	// ReadDir sorts by name, which is also oldest first
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, storedBackup{Name: name, Size: info.Size(), CreatedAt: info.ModTime().UTC()})
+	}
+	return files, nil
+}
+
+func (d dirTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(d.dir, name))
+}
+
+// s3Target keeps backups as objects in an S3 compatible bucket, addressed by path e.g. https://s3.example.com/bucket/name
+// requests are signed with AWS Signature Version 4, which MinIO, R2, B2 and the rest all accept
+type s3Target struct {
+	client    *http.Client
+	bucket    *url.URL
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func (s *s3Target) Put(name string, data []byte) error {
+	_, err := s.do(http.MethodPut, "/"+name, nil, data)
+	return err
+}
+
+func (s *s3Target) Delete(name string) error {
+	_, err := s.do(http.MethodDelete, "/"+name, nil, nil)
+	return err
+}
+
+// Only the first 1000 objects are listed, which is plenty for one prefix that gets rotated
+func (s *s3Target) List() ([]storedBackup, error) {
+	body, err := s.do(http.MethodGet, "", url.Values{"list-type": {"2"}, "prefix": {backupPrefix}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+	}
+	err = xml.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	var files []storedBackup
+	for _, object := range result.Contents {
+		files = append(files, storedBackup{Name: object.Key, Size: object.Size, CreatedAt: object.LastModified.UTC()})
+	}
+	slices.SortFunc(files, func(a, b storedBackup) int { return strings.Compare(a.Name, b.Name) })
+	return files, nil
+}
+
+// Sends a signed request for a key in the bucket and returns the response body
+func (s *s3Target) do(method string, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *s.bucket
+	u.Path += key
+	u.RawQuery = s3Query(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: bucket returned %s", method, key, resp.Status)
+	}
+	return respBody, nil
+}
+
+// Adds the AWS Signature Version 4 headers, https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Encodes a query the way Signature Version 4 wants it, sorted by key with spaces as %20 rather than +
+func s3Query(query url.Values) string {
+	var parts []string
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// how long a product that was found is kept before it is looked up again
+	barcodeCacheTTL = 30 * 24 * time.Hour
+	// how long a barcode that wasn't found is remembered, products are added to Open Food Facts all the time
+	barcodeMissTTL = 24 * time.Hour
+)
+
+// barcodeProduct is what a barcode was looked up as, Name is "" for a barcode the product database doesn't have
+type barcodeProduct struct {
+	Name      string    `json:"name"`
+	Category  string    `json:"category"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// barcodesDoc is the cache of barcodes that have been looked up, shared by every list
+type barcodesDoc struct {
+	Products map[string]barcodeProduct `json:"products"`
+}
+
+// barcodesDocName is the Store document the barcode cache is saved under, it isn't backed up since it can always be fetched again
+const barcodesDocName = "barcodes"
+
+// barcodeLookup looks products up by barcode in an Open Food Facts compatible database and caches what it finds in the Store
+// the cache is read and written with mu held but the lookup itself isn't, so a slow database doesn't hold up everything else
+type barcodeLookup struct {
+	api    string
+	client *http.Client
+	store  Store
+	doc    barcodesDoc
+}
+
+// barcodes is nil when -barcode-api is empty
+var barcodes *barcodeLookup
+
+func loadBarcodes(store Store, api string) (*barcodeLookup, error) {
+	b := &barcodeLookup{api: strings.TrimRight(api, "/"), client: &http.Client{Timeout: 10 * time.Second}, store: store}
+	err := store.LoadDoc(barcodesDocName, &b.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if b.doc.Products == nil {
+		b.doc.Products = make(map[string]barcodeProduct)
+	}
+	return b, nil
+}
+
+// Reports whether code is an EAN-8, UPC-A or EAN-13 barcode with the right check digit
+func validBarcode(code string) bool {
+	if len(code) != 8 && len(code) != 12 && len(code) != 13 {
+		return false
+	}
+	// the check digit makes the weighted sum a multiple of 10, the digits are weighted 3 and 1 alternately from the right
+	sum := 0
+	for i := range len(code) {
+		c := code[len(code)-1-i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// Returns the cached product for code, false if it isn't cached or has been for too long, callers must hold mu
+func (b *barcodeLookup) cached(code string, now time.Time) (barcodeProduct, bool) {
+	product, ok := b.doc.Products[code]
+	ttl := barcodeCacheTTL
+	if product.Name == "" {
+		ttl = barcodeMissTTL
+	}
+	return product, ok && now.Sub(product.FetchedAt) < ttl
+}
+
+// Saves a product to the cache, callers must hold mu
+func (b *barcodeLookup) remember(code string, product barcodeProduct) error {
+	b.doc.Products[code] = product
+	return b.store.SaveDoc(barcodesDocName, b.doc)
+}
+
+// offResponse is the part of an Open Food Facts product response that is used
+type offResponse struct {
+	Status  int `json:"status"`
+	Product struct {
+		ProductName    string   `json:"product_name"`
+		GenericName    string   `json:"generic_name"`
+		Brands         string   `json:"brands"`
+		CategoriesTags []string `json:"categories_tags"`
+	} `json:"product"`
+}
+
+// Looks a barcode up in the product database, a product it doesn't have comes back with no name
+func (b *barcodeLookup) fetch(ctx context.Context, code string) (barcodeProduct, error) {
+	target := b.api + "/api/v2/product/" + url.PathEscape(code) + ".json?fields=product_name,generic_name,brands,categories_tags"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return barcodeProduct{}, err
+	}
+	// Open Food Facts asks apps to say who they are
+	req.Header.Set("User-Agent", "shoppinglist/1.0")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return barcodeProduct{}, err
+	}
+	defer resp.Body.Close()
+
+	product := barcodeProduct{FetchedAt: time.Now().UTC()}
+	if resp.StatusCode == http.StatusNotFound {
+		return product, nil
+	}
+	if resp.StatusCode >= 300 {
+		return barcodeProduct{}, fmt.Errorf("product database returned %s", resp.Status)
+	}
+	var off offResponse
+	err = json.NewDecoder(resp.Body).Decode(&off)
+	if err != nil {
+		return barcodeProduct{}, err
+	}
+	if off.Status != 1 {
+		return product, nil
+	}
+	product.Name = strings.Join(strings.Fields(off.Product.ProductName), " ")
+	if product.Name == "" {
+		product.Name = strings.Join(strings.Fields(off.Product.GenericName), " ")
+	}
+	if product.Name == "" {
+		// some products only have a brand, which is still better than nothing to go on
+		brand, _, _ := strings.Cut(off.Product.Brands, ",")
+		product.Name = strings.TrimSpace(brand)
+	}
+	product.Category = aisleForTags(off.Product.CategoriesTags)
+	return product, nil
+}
+
+// offAisles maps Open Food Facts category tags to the aisle the product is found in, in the order they are tried
+// the tags go from general to specific, so e.g. plant based milks are tagged both en:beverages and en:milk-substitutes
+// and the more useful aisle has to come first here
+var offAisles = []struct{ tag, aisle string }{
+	{"en:frozen-foods", "frozen"},
+	{"en:dairies", "dairy"},
+	{"en:milk-substitutes", "dairy"},
+	{"en:cheeses", "dairy"},
+	{"en:eggs", "dairy"},
+	{"en:meats", "meat"},
+	{"en:seafood", "fish"},
+	{"en:fishes", "fish"},
+	{"en:breads", "bakery"},
+	{"en:pastries", "bakery"},
+	{"en:fruits", "produce"},
+	{"en:vegetables", "produce"},
+	{"en:fresh-foods", "produce"},
+	{"en:breakfast-cereals", "cereal"},
+	{"en:snacks", "snacks"},
+	{"en:sweets", "snacks"},
+	{"en:alcoholic-beverages", "drinks"},
+	{"en:beverages", "drinks"},
+	{"en:condiments", "pantry"},
+	{"en:canned-foods", "pantry"},
+	{"en:pastas", "pantry"},
+	{"en:cereals-and-potatoes", "pantry"},
+}
+
+// Picks the aisle for a product from its Open Food Facts category tags, "" if none of them are known
+func aisleForTags(tags []string) string {
+	for _, a := range offAisles {
+		for _, tag := range tags {
+			if tag == a.tag {
+				return a.aisle
+			}
+		}
+	}
+	return ""
+}
+
+// scanRequest is the body of POST /scan
+type scanRequest struct {
+	Barcode string `json:"barcode"`
+	// Quantity is how many to add, 1 when it isn't given
+	Quantity float64 `json:"quantity"`
+}
+
+// Handle Post request to add the product with a barcode to the list, e.g. from a phone's camera
+// the product is looked up in the -barcode-api database, or its cache, and added under its name and aisle
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	var req scanRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	code := strings.TrimSpace(req.Barcode)
+	if !validBarcode(code) {
+		writeFieldErrors(w, "invalid_barcode", []fieldError{{Field: "barcode", Message: "barcode has to be an EAN-8, UPC-A or EAN-13 code with the right check digit"}})
+		return
+	}
+
+	now := time.Now().UTC()
+	mu.RLock()
+	product, ok := barcodes.cached(code, now)
+	mu.RUnlock()
+	if !ok {
+		product, err = barcodes.fetch(r.Context(), code)
+		if err != nil {
+			slog.Error("Error looking up barcode", "barcode", code, "err", err)
+			writeError(w, http.StatusBadGateway, "barcode_lookup_failed", "the product database couldn't be reached, try again later")
+			return
+		}
+		mu.Lock()
+		err = barcodes.remember(code, product)
+		mu.Unlock()
+		if err != nil {
+			// the product was still found, it just has to be looked up again next time
+			slog.Error("Error caching barcode", "barcode", code, "err", err)
+		}
+	}
+	if product.Name == "" {
+		writeError(w, http.StatusNotFound, "product_not_found", "no product with barcode "+code+" was found, add it by name instead")
+		return
+	}
+
+	entries := []Entry{{Item: product.Name, Category: product.Category, Quantity: req.Quantity}}
+	if errs := prepareNewEntries(entries, now); errs != nil {
+		writeFieldErrors(w, "invalid_entry", errs)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	listID := requestIdentity(r).ListID
+	created, err := addEntries(r, listID, entries)
+	if err != nil {
+		slog.Error("Error adding entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Location", entryLocation(r, created[0].ID))
+	writeJSON(w, http.StatusCreated, created[0])
+}
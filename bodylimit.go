@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Returns middleware that caps how much of a request body a handler can read
+// a Content-Length over the limit is turned away with 413 before anything is read, a chunked body that goes over fails part way through
+// reading and the handler answers 413 itself, see writeBodyError
+func withBodyLimit(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body is too large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
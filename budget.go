@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Budget is how much a list is meant to cost
+type Budget struct {
+	Amount float64 `json:"amount"`
+	// Currency is what the amount is in, entries without a currency are taken to be in it too
+	Currency string `json:"currency"`
+}
+
+// budgetsDoc holds the budget of every list that has one
+type budgetsDoc struct {
+	Lists map[int]Budget `json:"lists"`
+}
+
+// budgetsDocName is the Store document the budgets are saved under
+const budgetsDocName = "budgets"
+
+// budgetRegistry holds the budgets in memory and saves them to the Store on every change
+type budgetRegistry struct {
+	store Store
+	doc   budgetsDoc
+}
+
+var budgets *budgetRegistry
+
+func loadBudgets(store Store) (*budgetRegistry, error) {
+	b := &budgetRegistry{store: store}
+	err := store.LoadDoc(budgetsDocName, &b.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if b.doc.Lists == nil {
+		b.doc.Lists = make(map[int]Budget)
+	}
+	return b, nil
+}
+
+// For returns the list's budget, false if it doesn't have one
+func (b *budgetRegistry) For(listID int) (Budget, bool) {
+	budget, ok := b.doc.Lists[listID]
+	return budget, ok
+}
+
+// Set gives the list a budget, or takes it away when budget is nil, and saves it
+func (b *budgetRegistry) Set(listID int, budget *Budget) error {
+	if budget == nil {
+		delete(b.doc.Lists, listID)
+	} else {
+		b.doc.Lists[listID] = *budget
+	}
+	return b.store.SaveDoc(budgetsDocName, b.doc)
+}
+
+// Reports whether s is empty or three letters, which is all that is needed to keep totals in different currencies apart
+func validCurrency(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return true
+	}
+	if len(s) != 3 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// spending is the cost of a set of entries
+type spending struct {
+	// EstimatedTotal is what every priced entry on the list costs together
+	EstimatedTotal float64 `json:"estimated_total"`
+	// Spent is what the completed ones cost
+	Spent float64 `json:"spent"`
+}
+
+func (s *spending) add(entry Entry) {
+	cost := entry.Price * entry.Quantity
+	s.EstimatedTotal += cost
+	if entry.Completed {
+		s.Spent += cost
+	}
+}
+
+// listSummary is the GET /lists/{id}/summary response
+type listSummary struct {
+	ListID   int    `json:"list_id"`
+	Currency string `json:"currency"`
+	spending
+	// Budget and Remaining are null when the list has no budget, Remaining is the budget less what has been spent
+	Budget    *float64 `json:"budget"`
+	Remaining *float64 `json:"remaining"`
+	// Unpriced is how many entries have no price and so aren't in the totals
+	Unpriced int `json:"unpriced"`
+	// OtherCurrencies are the totals of entries priced in a currency other than the budget's, they are kept apart rather than converted
+	OtherCurrencies map[string]*spending `json:"other_currencies,omitempty"`
+}
+
+// Handle Get request for what the list is expected to cost, what has been spent so far and what is left of the budget
+// entries in the trash aren't counted
+func handleListSummary(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	listID := requestIdentity(r).ListID
+	entries, err := store.List(listID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	summary := listSummary{ListID: listID}
+	budget, hasBudget := budgets.For(listID)
+	if hasBudget {
+		summary.Currency = budget.Currency
+	}
+	for _, entry := range liveEntries(entries) {
+		if entry.Price == 0 {
+			summary.Unpriced++
+			continue
+		}
+		if entry.Currency == "" || entry.Currency == summary.Currency {
+			summary.add(entry)
+			continue
+		}
+		if summary.OtherCurrencies == nil {
+			summary.OtherCurrencies = make(map[string]*spending)
+		}
+		if summary.OtherCurrencies[entry.Currency] == nil {
+			summary.OtherCurrencies[entry.Currency] = &spending{}
+		}
+		summary.OtherCurrencies[entry.Currency].add(entry)
+	}
+
+	summary.EstimatedTotal = roundMoney(summary.EstimatedTotal)
+	summary.Spent = roundMoney(summary.Spent)
+	for _, other := range summary.OtherCurrencies {
+		other.EstimatedTotal = roundMoney(other.EstimatedTotal)
+		other.Spent = roundMoney(other.Spent)
+	}
+	if hasBudget {
+		remaining := roundMoney(budget.Amount - summary.Spent)
+		summary.Budget = &budget.Amount
+		summary.Remaining = &remaining
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// Rounds to cents so adding up prices doesn't show floating point noise like 3.3000000000000003
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Handle Put request to set the list's budget
+func handlePutBudget(w http.ResponseWriter, r *http.Request) {
+	var budget Budget
+	err := decodeStrict(r.Body, &budget)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if budget.Amount < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_budget", "amount can't be negative")
+		return
+	}
+	if !validCurrency(budget.Currency) {
+		writeError(w, http.StatusBadRequest, "invalid_budget", "currency has to be a three letter code like EUR")
+		return
+	}
+	budget.Currency = strings.ToUpper(strings.TrimSpace(budget.Currency))
+
+	mu.Lock()
+	defer mu.Unlock()
+	err = budgets.Set(requestIdentity(r).ListID, &budget)
+	if err != nil {
+		slog.Error("Error saving budget", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// Handle Delete request to take the list's budget away
+func handleDeleteBudget(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	if _, ok := budgets.For(listID); !ok {
+		writeError(w, http.StatusNotFound, "budget_not_found", "the list doesn't have a budget")
+		return
+	}
+	err := budgets.Set(listID, nil)
+	if err != nil {
+		slog.Error("Error saving budget", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// bulkOperation is one change in a POST /data/bulk request
+// the entry fields sit next to the action e.g. {"action": "update", "id": 3, "quantity": 2}
+type bulkOperation struct {
+	// Action is "add", "update", "delete", "complete" or "uncomplete"
+	Action string `json:"action"`
+	// ID is the entry to change, add ignores it
+	ID int `json:"id"`
+	EntryPatch
+}
+
+// bulkResult says what happened to one operation, in the same order as the request
+type bulkResult struct {
+	Action string `json:"action"`
+	ID     int    `json:"id"`
+	// Status is the status code the same change would have got on its own endpoint
+	Status int    `json:"status"`
+	Entry  *Entry `json:"entry,omitempty"`
+}
+
+// bulkError is an operation that stopped the whole batch
+type bulkError struct {
+	index int
+	op    bulkOperation
+	err   error
+}
+
+func (e *bulkError) Error() string {
+	return fmt.Sprintf("operation %d (%s %d): %v", e.index, e.op.Action, e.op.ID, e.err)
+}
+
+func (e *bulkError) Unwrap() error {
+	return e.err
+}
+
+// Handle Post request with a list of changes that are applied together, for clients catching up after being offline
+// either every operation is applied or none are, and it all goes to storage in one write
+// the events for the changes are only sent once everything has been stored
+// a body without any operations, e.g. null or [], is a 400 rather than an empty success
+func handleBulk(w http.ResponseWriter, r *http.Request) {
+	var ops []bulkOperation
+	err := decodeStrict(r.Body, &ops)
+	if emptyBody(len(ops), err) {
+		writeError(w, http.StatusBadRequest, "invalid_operation", "request body has to be an array of operations")
+		return
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	// everything that can be checked without the store is checked first so a bad request doesn't start a transaction
+	for i, op := range ops {
+		message := ""
+		switch op.Action {
+		case "add":
+			if op.Item == nil {
+				message = "item is required to add an entry"
+			}
+		case "update", "delete", "complete", "uncomplete":
+		default:
+			message = "action has to be add, update, delete, complete or uncomplete"
+		}
+		if problem := patchProblem(op.EntryPatch); problem != "" {
+			message = problem
+		}
+		if message != "" {
+			writeError(w, http.StatusBadRequest, "invalid_operation", fmt.Sprintf("operation %d: %s", i, message))
+			return
+		}
+	}
+
+	listID := requestIdentity(r).ListID
+	results := make([]bulkResult, 0, len(ops))
+	// the events are kept back until the transaction has been committed
+	type pendingEvent struct {
+		eventType string
+		entry     Entry
+	}
+	var events []pendingEvent
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	err = trackChange(r, "bulk", func(tx Store) error {
+		for i, op := range ops {
+			result, eventType, err := applyBulkOperation(tx, listID, op, now)
+			if err != nil {
+				return &bulkError{index: i, op: op, err: err}
+			}
+			results = append(results, result)
+			if eventType != "" {
+				events = append(events, pendingEvent{eventType, *result.Entry})
+			}
+		}
+		return nil
+	})
+	var opErr *bulkError
+	if errors.As(err, &opErr) && errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("operation %d: entry %d not found, nothing was changed", opErr.index, opErr.op.ID))
+		return
+	}
+	if errors.As(err, &opErr) && errors.Is(err, ErrRevisionConflict) {
+		writeError(w, http.StatusConflict, "revision_conflict", fmt.Sprintf("operation %d: %v, nothing was changed", opErr.index, opErr.err))
+		return
+	}
+	if err != nil {
+		slog.Error("Error applying bulk operations", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	for _, e := range events {
+		publishEntries(requestIdentity(r).Actor(), e.eventType, e.entry)
+	}
+	// deleted entries were only kept in the results for their events
+	for i := range results {
+		if results[i].Status == http.StatusNoContent {
+			results[i].Entry = nil
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Applies one operation inside the bulk transaction and returns its result and the event it should send, if any
+func applyBulkOperation(tx Store, listID int, op bulkOperation, now time.Time) (bulkResult, string, error) {
+	result := bulkResult{Action: op.Action, ID: op.ID}
+
+	if op.Action == "add" {
+		entry := Entry{}
+		applyPatch(&entry, op.EntryPatch)
+		prepareNewEntry(&entry, now)
+		entries := []Entry{entry}
+		canonicalItems(listID, entries)
+		created, err := tx.Add(listID, entries)
+		if err != nil {
+			return result, "", err
+		}
+		result.ID = created[0].ID
+		result.Status = http.StatusCreated
+		result.Entry = &created[0]
+		return result, EventCreated, nil
+	}
+
+	if op.Action == "delete" {
+		_, err := softDelete(tx, listID, op.ID, now)
+		if err != nil {
+			return result, "", err
+		}
+		result.Status = http.StatusNoContent
+		result.Entry = &Entry{ID: op.ID, ListID: listID}
+		return result, EventDeleted, nil
+	}
+
+	entry, err := getEntry(tx, listID, op.ID)
+	if err != nil {
+		return result, "", err
+	}
+	// a revision in the operation is checked the same as on PATCH, so an offline client finds out what it would overwrite
+	if op.Revision != nil {
+		err := checkRevision(entry, *op.Revision)
+		if err != nil {
+			return result, "", err
+		}
+	}
+	wasCompleted := entry.Completed
+	switch op.Action {
+	case "update":
+		applyPatch(&entry, op.EntryPatch)
+	case "complete":
+		setCompleted(&entry, true)
+	case "uncomplete":
+		setCompleted(&entry, false)
+	}
+	entry.Revision++
+	err = tx.Update(entry)
+	if err != nil {
+		return result, "", err
+	}
+	result.Status = http.StatusOK
+	result.Entry = &entry
+	if entry.Completed && !wasCompleted {
+		return result, EventCompleted, nil
+	}
+	return result, EventUpdated, nil
+}
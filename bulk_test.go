@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBulkRejectsEmptyBody(t *testing.T) {
+	handler := newTestServer(t)
+	for _, body := range []string{"", " ", "null", "[]", "5", `"add"`} {
+		t.Run(body, func(t *testing.T) {
+			w := serveTest(handler, "POST", "/data/bulk", body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			var resp errorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != "invalid_operation" || resp.Error.Message != "request body has to be an array of operations" {
+				t.Errorf("error = %+v, want invalid_operation saying what the body has to be", resp.Error)
+			}
+		})
+	}
+
+	w := serveTest(handler, "POST", "/data/bulk", `[{"action": "add", "item": "milk"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	assertItems(t, "milk")
+}
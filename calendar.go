@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icalTimeLayout is how iCalendar writes a time in UTC
+const icalTimeLayout = "20060102T150405Z"
+
+// Handle Get request for the list as an iCalendar feed, so a phone calendar subscribed to it shows
+// every entry still to get that has a due date, at that time, and every planned meal as an all-day event
+// it is a read-only subscription rather than CalDAV, calendar apps poll it and can log in with Basic auth, see requireBasicAuth
+func handleCalendar(w http.ResponseWriter, r *http.Request) {
+	listID := requestIdentity(r).ListID
+
+	mu.RLock()
+	entries, err := store.List(listID)
+	meals := mealPlan.Between(listID, "", "9999-12-31")
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	now := time.Now().UTC().Format(icalTimeLayout)
+	var buf bytes.Buffer
+	writeICalLine(&buf, "BEGIN:VCALENDAR")
+	writeICalLine(&buf, "VERSION:2.0")
+	writeICalLine(&buf, "PRODID:-//shoppinglist//shoppinglist//EN")
+	writeICalLine(&buf, "CALSCALE:GREGORIAN")
+	writeICalLine(&buf, "X-WR-CALNAME:Shopping list")
+	for _, entry := range liveEntries(entries) {
+		if entry.DueBy == nil || entry.Completed {
+			continue
+		}
+		summary := entry.Item
+		if entry.Quantity != 1 || entry.Unit != "" {
+			summary = strings.TrimSpace(formatQuantity(entry.Quantity)+" "+entry.Unit) + " " + entry.Item
+		}
+		writeICalLine(&buf, "BEGIN:VEVENT")
+		writeICalLine(&buf, "UID:entry-"+strconv.Itoa(entry.ID)+"@shoppinglist")
+		writeICalLine(&buf, "DTSTAMP:"+now)
+		writeICalLine(&buf, "DTSTART:"+entry.DueBy.UTC().Format(icalTimeLayout))
+		writeICalLine(&buf, "DURATION:PT30M")
+		writeICalLine(&buf, "SUMMARY:"+escapeICalText("Buy "+summary))
+		if entry.Notes != "" {
+			writeICalLine(&buf, "DESCRIPTION:"+escapeICalText(entry.Notes))
+		}
+		if entry.Store != "" {
+			writeICalLine(&buf, "LOCATION:"+escapeICalText(entry.Store))
+		}
+		writeICalLine(&buf, "END:VEVENT")
+	}
+	for _, meal := range meals {
+		day, err := time.Parse(dateLayout, meal.Date)
+		if err != nil {
+			continue
+		}
+		summary := meal.Name
+		if meal.Meal != "" {
+			summary = meal.Meal + ": " + meal.Name
+		}
+		writeICalLine(&buf, "BEGIN:VEVENT")
+		writeICalLine(&buf, "UID:meal-"+strconv.Itoa(meal.ID)+"@shoppinglist")
+		writeICalLine(&buf, "DTSTAMP:"+now)
+		writeICalLine(&buf, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+		writeICalLine(&buf, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+		writeICalLine(&buf, "SUMMARY:"+escapeICalText(summary))
+		writeICalLine(&buf, "TRANSP:TRANSPARENT")
+		writeICalLine(&buf, "END:VEVENT")
+	}
+	writeICalLine(&buf, "END:VCALENDAR")
+	writeBody(w, http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+}
+
+// Escapes the characters iCalendar gives a meaning to in text values
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// Writes a content line ending in CRLF, folded so no line is longer than the 75 octets iCalendar allows
+// a fold is a CRLF and a space, and never splits a UTF-8 character
+func writeICalLine(buf *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		// the space at the start of the next line counts towards its 75
+		limit = 74
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// defaultCategories is the aisle order a list has until its owner sets their own
+var defaultCategories = []string{"produce", "bakery", "meat", "dairy", "frozen", "pantry", "drinks", "household"}
+
+const maxCategoryLength = 50
+
+// categoriesDoc holds each list's categories in the order the aisles come in, lists that are missing use defaultCategories
+type categoriesDoc struct {
+	Lists map[int][]string `json:"lists"`
+}
+
+// categoriesDocName is the Store document the categories are saved under
+const categoriesDocName = "categories"
+
+// categoryRegistry holds every list's categories in memory and saves them to the Store on every change
+type categoryRegistry struct {
+	store Store
+	doc   categoriesDoc
+}
+
+var categories *categoryRegistry
+
+func loadCategories(store Store) (*categoryRegistry, error) {
+	c := &categoryRegistry{store: store}
+	err := store.LoadDoc(categoriesDocName, &c.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if c.doc.Lists == nil {
+		c.doc.Lists = make(map[int][]string)
+	}
+	return c, nil
+}
+
+// For returns the list's categories in aisle order
+func (c *categoryRegistry) For(listID int) []string {
+	names, ok := c.doc.Lists[listID]
+	if !ok {
+		return slices.Clone(defaultCategories)
+	}
+	return slices.Clone(names)
+}
+
+// Set replaces the list's categories and saves them
+func (c *categoryRegistry) Set(listID int, names []string) error {
+	c.doc.Lists[listID] = names
+	return c.store.SaveDoc(categoriesDocName, c.doc)
+}
+
+// Categories are compared ignoring case and extra spaces, " Frozen  Food" and "frozen food" are the same aisle
+func normalizeCategory(name string) string {
+	return normalizeItemName(name)
+}
+
+// Checks a category name that is about to be saved and returns it normalized
+func validCategory(name string) (string, bool) {
+	name = normalizeCategory(name)
+	return name, name != "" && len(name) <= maxCategoryLength
+}
+
+// categoryGroup is the entries from one aisle in a GET /data?groupBy=category response
+type categoryGroup struct {
+	Category string  `json:"category"`
+	Entries  []Entry `json:"entries"`
+}
+
+// Splits entries into groups, one per category in the list's order, keeping the entries' order within each
+// categories that aren't on the list come after it in alphabetical order, then the entries without one
+// empty groups are left out
+func groupByCategory(entries []Entry, order []string) []categoryGroup {
+	byCategory := make(map[string][]Entry)
+	for _, entry := range entries {
+		byCategory[entry.Category] = append(byCategory[entry.Category], entry)
+	}
+
+	var unlisted []string
+	for category := range byCategory {
+		if category != "" && !slices.Contains(order, category) {
+			unlisted = append(unlisted, category)
+		}
+	}
+	slices.Sort(unlisted)
+
+	groups := []categoryGroup{}
+	for _, category := range slices.Concat(order, unlisted, []string{""}) {
+		if len(byCategory[category]) > 0 {
+			groups = append(groups, categoryGroup{Category: category, Entries: byCategory[category]})
+		}
+	}
+	return groups
+}
+
+// Handle Get request for the list's categories in aisle order
+func handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, categories.For(requestIdentity(r).ListID))
+}
+
+// Handle Put request to replace the list's categories, which is also how they are reordered
+// entries keep their category even if it is no longer on the list
+func handlePutCategories(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	err := decodeStrict(r.Body, &names)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	for i := range names {
+		name, ok := validCategory(names[i])
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_category", "category names can't be empty or longer than 50 characters")
+			return
+		}
+		if slices.Contains(names[:i], name) {
+			writeError(w, http.StatusBadRequest, "invalid_category", "category "+name+" is on the list twice")
+			return
+		}
+		names[i] = name
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	err = categories.Set(requestIdentity(r).ListID, names)
+	if err != nil {
+		slog.Error("Error saving categories", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// Handle Post request to add a category to the list, at the end or at the position given in the body
+func handlePostCategory(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+		// Position is where the category goes counting from 0, nil puts it at the end
+		Position *int `json:"position"`
+	}
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	name, ok := validCategory(body.Name)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_category", "category names can't be empty or longer than 50 characters")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	names := categories.For(listID)
+	if slices.Contains(names, name) {
+		writeError(w, http.StatusConflict, "category_exists", "category "+name+" is already on the list")
+		return
+	}
+	position := len(names)
+	if body.Position != nil {
+		position = min(max(*body.Position, 0), len(names))
+	}
+	names = slices.Insert(names, position, name)
+	err = categories.Set(listID, names)
+	if err != nil {
+		slog.Error("Error saving categories", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, names)
+}
+
+// Handle Delete request to take a category off the list, entries in it are left as they are and group after the listed ones
+func handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	name := normalizeCategory(r.PathValue("name"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	names := categories.For(listID)
+	i := slices.Index(names, name)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "category_not_found", "category not found")
+		return
+	}
+	err := categories.Set(listID, slices.Delete(names, i, i+1))
+	if err != nil {
+		slog.Error("Error saving categories", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
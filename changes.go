@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// entryChange is what happened to one entry in a change, Before is nil for an added entry and After is nil for one removed for good
+type entryChange struct {
+	Before *Entry
+	After  *Entry
+}
+
+// changeRecorder is a Store that notes what each entry looked like before and after the changes made through it
+type changeRecorder struct {
+	Store
+	changes []entryChange
+	// index is where each entry's change is in changes, an entry changed twice only gets one
+	index map[int]int
+}
+
+func (s *changeRecorder) Add(listID int, entries []Entry) ([]Entry, error) {
+	created, err := s.Store.Add(listID, entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range created {
+		s.index[entry.ID] = len(s.changes)
+		s.changes = append(s.changes, entryChange{After: &entry})
+	}
+	return created, nil
+}
+
+func (s *changeRecorder) Update(entry Entry) error {
+	i, err := s.remember(entry.ListID, entry.ID)
+	if err != nil {
+		return err
+	}
+	err = s.Store.Update(entry)
+	if err != nil {
+		return err
+	}
+	s.changes[i].After = &entry
+	return nil
+}
+
+func (s *changeRecorder) Delete(listID int, id int) error {
+	i, err := s.remember(listID, id)
+	if err != nil {
+		return err
+	}
+	err = s.Store.Delete(listID, id)
+	if err != nil {
+		return err
+	}
+	s.changes[i].After = nil
+	return nil
+}
+
+// Returns where the entry's change is kept, noting the entry as it is now the first time it is changed
+func (s *changeRecorder) remember(listID int, id int) (int, error) {
+	if i, ok := s.index[id]; ok {
+		return i, nil
+	}
+	entry, err := s.Store.Get(listID, id)
+	if err != nil {
+		return 0, err
+	}
+	s.index[id] = len(s.changes)
+	s.changes = append(s.changes, entryChange{Before: &entry, After: &entry})
+	return s.index[id], nil
+}
+
+// Runs fn in a transaction and returns what it changed once that has been committed, callers must hold mu
+// the item names of added and renamed entries go into the autocomplete index
+func recordChanges(fn func(tx Store) error) ([]entryChange, error) {
+	var rec *changeRecorder
+	err := store.Transaction(func(tx Store) error {
+		rec = &changeRecorder{Store: tx, index: make(map[int]int)}
+		return fn(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	itemNames.Note(rec.changes)
+	return rec.changes, nil
+}
+
+// Runs fn in a transaction and once it has been committed adds what it changed to the audit log and the caller's undo history
+// fn has to make its changes through the Store it is given, callers must hold mu
+func trackChange(r *http.Request, action string, fn func(tx Store) error) error {
+	changes, err := recordChanges(fn)
+	if err != nil {
+		return err
+	}
+	id := requestIdentity(r)
+	now := time.Now().UTC()
+	undos.Record(id.Caller(), newUndoOperation(action, id.ListID, now, changes))
+	auditChanges(id.Actor(), id.UserID(), action, now, changes)
+	return nil
+}
+
+// Writes a change to the audit log, it has already been stored by now so a failure is logged rather than returned
+func auditChanges(actor string, userID int, operation string, now time.Time, changes []entryChange) {
+	if len(changes) == 0 {
+		return
+	}
+	records := make([]AuditRecord, 0, len(changes))
+	for _, change := range changes {
+		record := AuditRecord{
+			Time:      now,
+			Actor:     actor,
+			UserID:    userID,
+			Operation: operation,
+			Before:    change.Before,
+			After:     change.After,
+		}
+		entry := change.After
+		if entry == nil {
+			entry = change.Before
+		}
+		record.ListID = entry.ListID
+		record.EntryID = entry.ID
+		records = append(records, record)
+	}
+	err := store.AppendAudit(records)
+	if err != nil {
+		slog.Error("Error writing audit log", "err", err)
+	}
+}
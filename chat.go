@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chatListID is -chat-list, the list the Slack, Discord and Matrix integrations work on
+var chatListID int
+
+// Returns the commands chat integrations take, prefix is how they are typed e.g. "/" on Telegram or "/shop " on Slack
+func chatHelp(prefix string) string {
+	return prefix + "add <item> [quantity] - put an item on the list, e.g. " + prefix + "add milk 2\n" +
+		prefix + "list - show what is still to get\n" +
+		prefix + "done <id or item> - tick an item off"
+}
+
+// Runs a chat command on the list and returns the reply, actor is who sent it as it goes in the audit log
+// it is shared by the Telegram bot and the Slack, Discord and Matrix integrations so they all understand the same commands
+func runChatCommand(listID int, actor string, prefix string, command string, args string) string {
+	args = strings.TrimSpace(args)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var reply string
+	var err error
+	switch strings.ToLower(command) {
+	case "add":
+		reply, err = chatAdd(listID, actor, prefix, args)
+	case "list":
+		reply, err = chatList(listID)
+	case "done":
+		reply, err = chatDone(listID, actor, prefix, args)
+	default:
+		return chatHelp(prefix)
+	}
+	if err != nil {
+		slog.Error("Error running chat command", "command", command, "actor", actor, "err", err)
+		return "Something went wrong, try again later"
+	}
+	return reply
+}
+
+// "add kitchen roll 2" adds 2 kitchen roll, a trailing number is the quantity
+// the item is merged into one that is still on the list the same way as POST /data?dedupe=merge
+func chatAdd(listID int, actor string, prefix string, args string) (string, error) {
+	words := strings.Fields(args)
+	if len(words) == 0 {
+		return "Usage: " + prefix + "add <item> [quantity]", nil
+	}
+	entry := Entry{}
+	if len(words) > 1 {
+		quantity, err := strconv.ParseFloat(words[len(words)-1], 64)
+		if err == nil && quantity > 0 {
+			entry.Quantity = quantity
+			words = words[:len(words)-1]
+		}
+	}
+	entry.Item = strings.Join(words, " ")
+	if problem := entryProblem(entry); problem != "" {
+		return problem, nil
+	}
+	now := time.Now().UTC()
+	prepareNewEntry(&entry, now)
+
+	var results, updated, created []Entry
+	changes, err := recordChanges(func(tx Store) error {
+		var err error
+		results, updated, created, err = addMerging(tx, listID, []Entry{entry})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	auditChanges(actor, 0, "add", now, changes)
+	publishEntries(actor, EventUpdated, updated...)
+	publishEntries(actor, EventCreated, created...)
+
+	result := results[0]
+	return fmt.Sprintf("%d. %s x%s", result.ID, result.Item, formatQuantity(result.Quantity)), nil
+}
+
+// Lists the entries that haven't been ticked off with the IDs done takes
+func chatList(listID int) (string, error) {
+	entries, err := store.List(listID)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, entry := range liveEntries(entries) {
+		if entry.Completed {
+			continue
+		}
+		line := fmt.Sprintf("%d. %s x%s", entry.ID, entry.Item, formatQuantity(entry.Quantity))
+		if entry.Unit != "" {
+			line += " " + entry.Unit
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "Nothing left to get", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Ticks off the entry with the ID, or the first one still to get whose name matches with itemKey
+func chatDone(listID int, actor string, prefix string, args string) (string, error) {
+	if args == "" {
+		return "Usage: " + prefix + "done <id or item>, the IDs are shown by " + prefix + "list", nil
+	}
+	var entry Entry
+	if id, err := strconv.Atoi(args); err == nil {
+		entry, err = getEntry(store, listID, id)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Sprintf("There is no item %d", id), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	} else {
+		entries, err := store.List(listID)
+		if err != nil {
+			return "", err
+		}
+		key := itemKey(listID, args)
+		found := false
+		for _, e := range liveEntries(entries) {
+			if !e.Completed && itemKey(listID, e.Item) == key {
+				entry, found = e, true
+				break
+			}
+		}
+		if !found {
+			return args + " isn't on the list", nil
+		}
+	}
+	if entry.Completed {
+		return entry.Item + " is already ticked off", nil
+	}
+	setCompleted(&entry, true)
+	entry.Revision++
+
+	now := time.Now().UTC()
+	changes, err := recordChanges(func(tx Store) error {
+		return tx.Update(entry)
+	})
+	if err != nil {
+		return "", err
+	}
+	auditChanges(actor, 0, "complete", now, changes)
+	publishEntries(actor, EventCompleted, entry)
+	return "Ticked off " + entry.Item, nil
+}
+
+func formatQuantity(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
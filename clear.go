@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Handle Post request to move every ticked off entry to the trash, for sweeping the list after shopping
+// the response is the entries that were moved, POST /undo or POST /data/{id}/restore brings them back
+func handleClearCompleted(w http.ResponseWriter, r *http.Request) {
+	clearEntries(w, r, "clear-completed", func(entry Entry) bool { return entry.Completed })
+}
+
+// Handle Delete request to move every entry on the list to the trash
+// it needs ?confirm=true so a DELETE sent to /data by mistake, e.g. with the entry ID left off, doesn't empty the list
+func handleClearAll(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		writeError(w, http.StatusBadRequest, "confirmation_required", "add ?confirm=true to move every entry on the list to the trash")
+		return
+	}
+	clearEntries(w, r, "clear", func(Entry) bool { return true })
+}
+
+// Moves the list's entries that match to the trash as one change, so a single undo puts them all back
+func clearEntries(w http.ResponseWriter, r *http.Request, action string, match func(Entry) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	now := time.Now().UTC()
+	cleared := []Entry{}
+	err := trackChange(r, action, func(tx Store) error {
+		entries, err := tx.List(listID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range liveEntries(entries) {
+			if !match(entry) {
+				continue
+			}
+			entry, err = softDelete(tx, listID, entry.ID, now)
+			if err != nil {
+				return err
+			}
+			cleared = append(cleared, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error clearing entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	publishEntries(requestIdentity(r).Actor(), EventDeleted, cleared...)
+	writeJSON(w, http.StatusOK, cleared)
+}
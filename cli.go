@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliRun runs a client subcommand with the arguments left once the flags are parsed
+type cliRun func(c *apiClient, args []string, w io.Writer) error
+
+// cliCommand is a client subcommand, it talks to a running server over the JSON API like any other client
+type cliCommand struct {
+	usage string
+	// flags declares the command's own flags, the server, token and list flags are added for every command
+	flags func(fs *flag.FlagSet) cliRun
+}
+
+// cliCommands are the subcommands that make the binary a client, e.g. shoppinglist add milk -qty 2
+var cliCommands = map[string]cliCommand{
+	"add":    {usage: `add <item>... [-qty n] [-unit u] [-category c] [-store s] [-tag t]`, flags: cliAdd},
+	"ls":     {usage: `ls [-pending] [-done] [-q text] [-tag t] [-store s]`, flags: cliList},
+	"done":   {usage: `done <id>...`, flags: noFlags(cliCompleted(true))},
+	"undone": {usage: `undone <id>...`, flags: noFlags(cliCompleted(false))},
+	"rm":     {usage: `rm <id>...`, flags: noFlags(cliRemove)},
+	"tui":    {usage: `tui`, flags: noFlags(cliTUI)},
+}
+
+// errUsage is returned by a command that was given the wrong arguments, its usage line is printed
+var errUsage = errors.New("usage")
+
+// Runs a client subcommand and returns the exit code, 2 for bad arguments and 1 when the server turned the request down
+func runClient(name string, args []string, w io.Writer) int {
+	command := cliCommands[name]
+	env := envReader{}
+	client := &apiClient{http: &http.Client{Timeout: 30 * time.Second}}
+	fs := flag.NewFlagSet("shoppinglist "+name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(&client.server, "server", env.String("SERVER", "http://localhost:8080"), "URL of the server to talk to ($SHOPPINGLIST_SERVER)")
+	fs.StringVar(&client.token, "token", env.String("TOKEN", ""), "API key or access token to send, prefer the env var so it doesn't show up in ps ($SHOPPINGLIST_TOKEN)")
+	fs.IntVar(&client.list, "list", env.Int("LIST", 0), "ID of the list to use, 0 for the token's own list ($SHOPPINGLIST_LIST)")
+	fs.Usage = func() {
+		fmt.Fprintln(w, "Usage: shoppinglist", command.usage)
+		fs.PrintDefaults()
+	}
+	run := command.flags(fs)
+	if env.err != nil {
+		fmt.Fprintln(w, "Error reading configuration: ", env.err)
+		return 2
+	}
+
+	args, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	err = run(client, args, w)
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(w, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// Parses flags wherever they are among the arguments, the flag package stops at the first argument that isn't one
+// so "add milk -qty 2" would otherwise leave -qty 2 unparsed, everything after "--" is an argument
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// apiClient makes requests to the server the CLI was pointed at
+type apiClient struct {
+	server string
+	token  string
+	list   int
+	http   *http.Client
+}
+
+// Sends a request and decodes a JSON response into out, a response with an error body comes back as an error with its message
+func (c *apiClient) do(method string, path string, query url.Values, body any, out any) error {
+	req, err := c.request(context.Background(), method, path, query, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var apiErr errorResponse
+		if json.NewDecoder(res.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			return errors.New(apiErr.Error.Message)
+		}
+		return fmt.Errorf("the server answered %s", res.Status)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent || res.ContentLength == 0 {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Builds a request to the server with the list, the token and body as JSON
+func (c *apiClient) request(ctx context.Context, method string, path string, query url.Values, body any) (*http.Request, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.list != 0 {
+		query.Set("list", strconv.Itoa(c.list))
+	}
+	target := strings.TrimRight(c.server, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// Adds each argument as an item, quote an item with spaces in it e.g. "oat milk"
+func cliAdd(fs *flag.FlagSet) cliRun {
+	qty := fs.Float64("qty", 0, "how many of each item, 1 when it isn't given")
+	unit := fs.String("unit", "", "unit the quantity is in e.g. kg")
+	category := fs.String("category", "", "aisle the items are in e.g. dairy")
+	store := fs.String("store", "", "shop to buy the items at")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "tag to give the items, repeat it for several")
+	return func(c *apiClient, args []string, w io.Writer) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+		entries := make([]Entry, len(args))
+		for i, item := range args {
+			entries[i] = Entry{Item: item, Quantity: *qty, Unit: *unit, Category: *category, Store: *store, Tags: tags}
+		}
+		var created []Entry
+		err := c.do(http.MethodPost, "/data", nil, entries, &created)
+		if err != nil {
+			return err
+		}
+		for _, entry := range created {
+			fmt.Fprintf(w, "Added %s as %d\n", entry.Item, entry.ID)
+		}
+		return nil
+	}
+}
+
+// Prints the list, one entry a line with its ID so it can be passed to done and rm
+func cliList(fs *flag.FlagSet) cliRun {
+	pending := fs.Bool("pending", false, "only show items that haven't been ticked off")
+	done := fs.Bool("done", false, "only show items that have been ticked off")
+	search := fs.String("q", "", "only show items with this in their name")
+	store := fs.String("store", "", "leave out items for other shops")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "only show items with this tag, repeat it to need several")
+	return func(c *apiClient, args []string, w io.Writer) error {
+		if len(args) > 0 || *pending && *done {
+			return errUsage
+		}
+		query := url.Values{"tag": tags}
+		if *search != "" {
+			query.Set("q", *search)
+		}
+		if *store != "" {
+			query.Set("store", *store)
+		}
+		switch {
+		case *pending:
+			query.Set("completed", "false")
+		case *done:
+			query.Set("completed", "true")
+		}
+		var entries []Entry
+		err := c.do(http.MethodGet, "/data", query, nil, &entries)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fmt.Fprintln(w, formatEntryLine(entry))
+		}
+		return nil
+	}
+}
+
+// Formats an entry as e.g. "   3 [x] milk  2 l"
+func formatEntryLine(entry Entry) string {
+	check := " "
+	if entry.Completed {
+		check = "x"
+	}
+	line := fmt.Sprintf("%4d [%s] %s", entry.ID, check, entry.Item)
+	if entry.Quantity != 1 || entry.Unit != "" {
+		line += "  " + strings.TrimSpace(strconv.FormatFloat(entry.Quantity, 'f', -1, 64)+" "+entry.Unit)
+	}
+	return line
+}
+
+// For commands that only take the flags every command has
+func noFlags(run cliRun) func(fs *flag.FlagSet) cliRun {
+	return func(*flag.FlagSet) cliRun { return run }
+}
+
+// Ticks entries off, or back on again for undone
+func cliCompleted(completed bool) cliRun {
+	action, message := "/complete", "Ticked off"
+	if !completed {
+		action, message = "/uncomplete", "Unticked"
+	}
+	return func(c *apiClient, args []string, w io.Writer) error {
+		return eachEntryID(args, func(id int) error {
+			var entry Entry
+			err := c.do(http.MethodPost, "/data/"+strconv.Itoa(id)+action, nil, nil, &entry)
+			if err == nil {
+				fmt.Fprintln(w, message, entry.Item)
+			}
+			return err
+		})
+	}
+}
+
+// Moves entries to the trash
+func cliRemove(c *apiClient, args []string, w io.Writer) error {
+	return eachEntryID(args, func(id int) error {
+		err := c.do(http.MethodDelete, "/data/"+strconv.Itoa(id), nil, nil, nil)
+		if err == nil {
+			fmt.Fprintln(w, "Removed", id)
+		}
+		return err
+	})
+}
+
+// Calls fn with each argument as an entry ID, stopping at the first that fails
+func eachEntryID(args []string, fn func(id int) error) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("%q isn't an entry ID, ls shows them", arg)
+		}
+		ids[i] = id
+	}
+	for _, id := range ids {
+		err := fn(id)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// stringsFlag is a flag that can be given more than once
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
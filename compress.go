@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Bodies smaller than this aren't worth compressing, the gzip header and CPU time cost more than they save
+const gzipMinSize = 1024
+
+// Reports whether the client said it can take a gzip response, "gzip;q=0" means it can't
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, "gzip") && coding != "*" {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
+
+// Writes body to the response like writeBody, gzipped when it is big enough and the client accepts it
+func writeMaybeGzipped(w http.ResponseWriter, r *http.Request, status int, contentType string, body []byte) {
+	// the response depends on Accept-Encoding whether or not this one is compressed, caches need to know that
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < gzipMinSize || !acceptsGzip(r) {
+		writeBody(w, status, contentType, body)
+		return
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(body)
+	gz.Close()
+	w.Header().Set("Content-Encoding", "gzip")
+	writeBody(w, status, contentType, buf.Bytes())
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Conflict is a change from POST /sync that was kept to one side rather than applied, under ResolutionKeepBoth
+// the stored entry is left as it was until someone picks which version to keep with POST /conflicts/{id}/resolve
+type Conflict struct {
+	ID      int       `json:"id"`
+	ListID  int       `json:"list_id"`
+	EntryID int       `json:"entry_id"`
+	Time    time.Time `json:"time"`
+	// Actor is who made the change that conflicted, the same as in the audit log
+	Actor string `json:"actor"`
+	// Action is the mutation's action, "update" or "delete"
+	Action string `json:"action"`
+	// Fields are the fields the two versions disagree on, empty for a delete
+	Fields []string `json:"fields"`
+	// Client is the entry as the client wanted it, null when it wanted it deleted
+	Client *Entry `json:"client"`
+}
+
+// conflictView is a conflict with the entry as it is stored now, which is what keeping the server's version leaves
+type conflictView struct {
+	Conflict
+	// Server is null when the entry has since been removed for good
+	Server *Entry `json:"server"`
+}
+
+// conflictsDoc is everything the conflicts subsystem saves
+type conflictsDoc struct {
+	// Strategies are the resolutions lists have picked for POST /sync, by list ID, lists that haven't picked aren't in it
+	Strategies map[int]string `json:"strategies"`
+	Conflicts  []Conflict     `json:"conflicts"`
+	NextID     int            `json:"next_id"`
+}
+
+// conflictsDocName is the Store document the conflicts are saved under
+const conflictsDocName = "conflicts"
+
+// conflictRegistry holds each list's conflict strategy and its unresolved conflicts in memory and saves them to the Store on every change
+type conflictRegistry struct {
+	store Store
+	doc   conflictsDoc
+}
+
+var conflicts *conflictRegistry
+
+func loadConflicts(store Store) (*conflictRegistry, error) {
+	c := &conflictRegistry{store: store}
+	err := store.LoadDoc(conflictsDocName, &c.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if c.doc.Strategies == nil {
+		c.doc.Strategies = make(map[int]string)
+	}
+	if c.doc.Conflicts == nil {
+		c.doc.Conflicts = []Conflict{}
+	}
+	c.doc.NextID = max(c.doc.NextID, 1)
+	return c, nil
+}
+
+func (c *conflictRegistry) save() error {
+	return c.store.SaveDoc(conflictsDocName, c.doc)
+}
+
+// Strategy returns the resolution the list has picked, "" if it hasn't
+func (c *conflictRegistry) Strategy(listID int) string {
+	return c.doc.Strategies[listID]
+}
+
+// SetStrategy sets the list's resolution, "" goes back to letting each sync pick, and saves it
+func (c *conflictRegistry) SetStrategy(listID int, strategy string) error {
+	if strategy == "" {
+		delete(c.doc.Strategies, listID)
+	} else {
+		c.doc.Strategies[listID] = strategy
+	}
+	return c.save()
+}
+
+// For returns the list's unresolved conflicts, oldest first
+func (c *conflictRegistry) For(listID int) []Conflict {
+	found := []Conflict{}
+	for _, conflict := range c.doc.Conflicts {
+		if conflict.ListID == listID {
+			found = append(found, conflict)
+		}
+	}
+	return found
+}
+
+// Get returns the conflict with the ID on the list, or ErrNotFound
+func (c *conflictRegistry) Get(listID int, id int) (Conflict, error) {
+	i := c.index(listID, id)
+	if i < 0 {
+		return Conflict{}, ErrNotFound
+	}
+	return c.doc.Conflicts[i], nil
+}
+
+// Add gives each conflict an ID, saves them and returns them with their IDs
+func (c *conflictRegistry) Add(added []Conflict) ([]Conflict, error) {
+	if len(added) == 0 {
+		return added, nil
+	}
+	for i := range added {
+		added[i].ID = c.doc.NextID
+		c.doc.NextID++
+	}
+	c.doc.Conflicts = append(c.doc.Conflicts, added...)
+	return added, c.save()
+}
+
+// Delete removes the conflict once it has been resolved
+func (c *conflictRegistry) Delete(listID int, id int) error {
+	i := c.index(listID, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	c.doc.Conflicts = slices.Delete(c.doc.Conflicts, i, i+1)
+	return c.save()
+}
+
+func (c *conflictRegistry) index(listID int, id int) int {
+	return slices.IndexFunc(c.doc.Conflicts, func(conflict Conflict) bool {
+		return conflict.ID == id && conflict.ListID == listID
+	})
+}
+
+// validResolution reports whether s is one of the resolutions POST /sync knows
+func validResolution(s string) bool {
+	return s == ResolutionLastWriterWins || s == ResolutionMerge || s == ResolutionKeepBoth
+}
+
+// conflictStrategy is the GET and PUT /lists/{id}/conflict-strategy body
+type conflictStrategy struct {
+	// Strategy is "" when the list hasn't picked one, each sync's resolution is used then
+	Strategy string `json:"strategy"`
+}
+
+// Handle Get request for how the list settles sync conflicts
+func handleGetConflictStrategy(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, conflictStrategy{Strategy: conflicts.Strategy(requestIdentity(r).ListID)})
+}
+
+// Handle Put request to set how the list settles sync conflicts, it takes the place of the resolution each sync sends
+func handlePutConflictStrategy(w http.ResponseWriter, r *http.Request) {
+	var body conflictStrategy
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if body.Strategy != "" && !validResolution(body.Strategy) {
+		writeError(w, http.StatusBadRequest, "invalid_strategy", "strategy has to be "+ResolutionLastWriterWins+", "+ResolutionMerge+", "+ResolutionKeepBoth+" or empty")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	err = conflicts.SetStrategy(requestIdentity(r).ListID, body.Strategy)
+	if err != nil {
+		slog.Error("Error saving conflicts", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Handle Get request for the list's unresolved conflicts, oldest first
+func handleGetConflicts(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	listID := requestIdentity(r).ListID
+	views := []conflictView{}
+	for _, conflict := range conflicts.For(listID) {
+		view := conflictView{Conflict: conflict}
+		entry, err := store.Get(listID, conflict.EntryID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Error("Error reading entry", "err", err)
+			writeInternalError(w)
+			return
+		}
+		if err == nil {
+			view.Server = &entry
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// resolveRequest is the POST /conflicts/{id}/resolve body
+type resolveRequest struct {
+	// Keep is "server" to leave the entry as it is stored or "client" to make the client's change after all
+	Keep string `json:"keep"`
+}
+
+// Handle Post request to settle a conflict by keeping one of its versions, the conflict is removed either way
+// keeping the client's version overwrites the entry even if it has changed again since
+func handleResolveConflict(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "conflict ID has to be a number")
+		return
+	}
+	var body resolveRequest
+	err = decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if body.Keep != "server" && body.Keep != "client" {
+		writeError(w, http.StatusBadRequest, "invalid_resolution", "keep has to be server or client")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	conflict, err := conflicts.Get(listID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "conflict_not_found", "conflict not found")
+		return
+	}
+	entry, err := store.Get(listID, conflict.EntryID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// there is nothing left to keep once the entry has been purged
+	if errors.Is(err, ErrNotFound) {
+		err = conflicts.Delete(listID, id)
+		if err != nil {
+			slog.Error("Error saving conflicts", "err", err)
+			writeInternalError(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, "not_found", "the entry has been removed for good, the conflict has been dropped")
+		return
+	}
+
+	eventType := ""
+	if body.Keep == "client" {
+		before := entry
+		err = trackChange(r, "resolve", func(tx Store) error {
+			if conflict.Client == nil {
+				if entry.DeletedAt != nil {
+					return nil
+				}
+				entry, err = softDelete(tx, listID, entry.ID, time.Now().UTC())
+				eventType = EventDeleted
+				return err
+			}
+			entry = *conflict.Client
+			entry.Revision = before.Revision + 1
+			eventType = updateEvent(before, entry)
+			return tx.Update(entry)
+		})
+		if err != nil {
+			slog.Error("Error resolving conflict", "err", err)
+			writeInternalError(w)
+			return
+		}
+	}
+	err = conflicts.Delete(listID, id)
+	if err != nil {
+		slog.Error("Error saving conflicts", "err", err)
+		writeInternalError(w)
+		return
+	}
+	if eventType != "" {
+		publishEntries(requestIdentity(r).Actor(), eventType, entry)
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
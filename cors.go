@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What a browser is told it may send cross-origin, covering everything the API reads
+const (
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type, If-Match, If-None-Match, Last-Event-ID"
+	// response headers scripts can only read if they are listed
+	corsExposeHeaders = "ETag, Link, X-Total-Count"
+	// how long a browser can cache a preflight answer
+	corsMaxAge = 10 * time.Minute
+)
+
+// Returns middleware that lets browser pages on the allowed origins call the API
+// origins is the comma separated -cors-origins setting, "*" allows any origin and an empty one turns CORS off
+// tokens are sent in the Authorization header rather than cookies, so credentials are never allowed
+func withCORS(origins string, next http.Handler) http.Handler {
+	allowed := []string{}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	if len(allowed) == 0 {
+		return next
+	}
+	allowAny := slices.Contains(allowed, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		// requests that aren't from a browser on another origin don't need anything
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the answer depends on Origin so caches mustn't give one origin's answer to another
+		w.Header().Add("Vary", "Origin")
+		ok := allowAny || slices.Contains(allowed, origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !ok {
+			if preflight {
+				writeError(w, http.StatusForbidden, "origin_not_allowed", "requests from this origin aren't allowed")
+				return
+			}
+			// without the headers the browser won't let the page see the response
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowAny {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
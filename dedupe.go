@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+)
+
+// Returns the form of an item name used to spot duplicates, "  Whole  MILK " and "whole milk" are the same item
+func normalizeItemName(item string) string {
+	return strings.ToLower(strings.Join(strings.Fields(item), " "))
+}
+
+// Two entries are the same thing to buy when their names and units match, 2 l of milk and 1 bottle of milk stay separate
+// names are matched with itemKey, so aliases and plurals count as the same name, and units with unitKey, so 2 l and 500 ml match
+func dedupeKey(listID int, entry Entry) string {
+	return itemKey(listID, entry.Item) + "\x00" + unitKey(listID, entry.Unit)
+}
+
+// Stores new entries for ?dedupe=merge, any that match an entry still to get on the list have their quantity added to it instead of being added
+// duplicates within newEntries are folded together the same way, quantities in different units are converted by mergeQuantity
+// it returns what each new entry ended up as, in order, along with the entries that were changed and the ones that were added
+func addMerging(tx Store, listID int, newEntries []Entry) (results []Entry, updated []Entry, created []Entry, err error) {
+	canonicalItems(listID, newEntries)
+	existing, err := tx.List(listID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// only entries that haven't been ticked off are merged into, buying milk again after getting it is a new entry
+	open := make(map[string]int)
+	for i, entry := range existing {
+		if !entry.Completed && entry.DeletedAt == nil {
+			if _, ok := open[dedupeKey(listID, entry)]; !ok {
+				open[dedupeKey(listID, entry)] = i
+			}
+		}
+	}
+
+	// ref says where each new entry went, either into existing[index] or toAdd[index]
+	type ref struct {
+		merged bool
+		index  int
+	}
+	refs := make([]ref, len(newEntries))
+	changed := make(map[int]bool)
+	var toAdd []Entry
+	adding := make(map[string]int)
+	for n, entry := range newEntries {
+		key := dedupeKey(listID, entry)
+		if i, ok := open[key]; ok {
+			mergeQuantity(listID, &existing[i], entry)
+			changed[i] = true
+			refs[n] = ref{merged: true, index: i}
+			continue
+		}
+		if i, ok := adding[key]; ok {
+			mergeQuantity(listID, &toAdd[i], entry)
+			refs[n] = ref{index: i}
+			continue
+		}
+		adding[key] = len(toAdd)
+		refs[n] = ref{index: len(toAdd)}
+		toAdd = append(toAdd, entry)
+	}
+
+	for i := range existing {
+		if !changed[i] {
+			continue
+		}
+		existing[i].Revision++
+		err = tx.Update(existing[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		updated = append(updated, existing[i])
+	}
+	created = []Entry{}
+	if len(toAdd) > 0 {
+		created, err = tx.Add(listID, toAdd)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	results = make([]Entry, len(newEntries))
+	for n, r := range refs {
+		if r.merged {
+			results[n] = existing[r.index]
+		} else {
+			results[n] = created[r.index]
+		}
+	}
+	return results, updated, created, nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How often the digest scheduler looks for digests that are due
+const digestCheckInterval = time.Minute
+
+// maxDigestRecipients is the most addresses one digest can go to
+const maxDigestRecipients = 10
+
+// digestSettings is when and where a caller's weekly email of the list goes, the body of PUT /digest
+type digestSettings struct {
+	Enabled bool `json:"enabled"`
+	// To are the addresses it is sent to, e.g. everyone in the household
+	To []string `json:"to"`
+	// Weekday is the day it is sent on e.g. "saturday", Hour is the hour of that day from 0 to 23 in TimeZone
+	Weekday  string `json:"weekday"`
+	Hour     int    `json:"hour"`
+	TimeZone string `json:"time_zone"`
+}
+
+// digestSubscription is a caller's digest as it is saved
+type digestSubscription struct {
+	digestSettings
+	// ListID is the list the digest is of, the one ?list= picked when it was set up
+	ListID int `json:"list_id"`
+	// Token is the secret in the unsubscribe link, so the link works without logging in
+	Token string `json:"token"`
+	// LastSent is when the digest last went out, so it is sent once a week however often the scheduler checks
+	LastSent *time.Time `json:"last_sent"`
+}
+
+// digestResponse is what GET and PUT /digest return
+type digestResponse struct {
+	digestSettings
+	ListID   int        `json:"list_id"`
+	LastSent *time.Time `json:"last_sent"`
+}
+
+// digestsDoc holds every caller's digest, keyed by identity.Caller
+type digestsDoc struct {
+	Subscriptions map[string]digestSubscription `json:"subscriptions"`
+}
+
+// digestsDocName is the Store document the digests are saved under
+const digestsDocName = "digests"
+
+// digestRegistry holds the digests in memory and sends the ones that are due
+// its state is only touched with mu held, emails are sent after it has been released
+type digestRegistry struct {
+	store Store
+	doc   digestsDoc
+	mail  *mailer
+	// publicURL is -public-url, the unsubscribe link is left out of the email when it is ""
+	publicURL string
+}
+
+var digests *digestRegistry
+
+func loadDigests(store Store, mail *mailer, publicURL string) (*digestRegistry, error) {
+	d := &digestRegistry{store: store, mail: mail, publicURL: strings.TrimSuffix(publicURL, "/")}
+	err := store.LoadDoc(digestsDocName, &d.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if d.doc.Subscriptions == nil {
+		d.doc.Subscriptions = make(map[string]digestSubscription)
+	}
+	return d, nil
+}
+
+func (d *digestRegistry) save() error {
+	return d.store.SaveDoc(digestsDocName, d.doc)
+}
+
+// Checks the settings and fills in the defaults, saturday at 8 in UTC, returning what is wrong with them
+func (s *digestSettings) normalize() []fieldError {
+	var errs []fieldError
+	to := []string{}
+	for _, address := range s.To {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if !validEmailAddress(address) {
+			errs = append(errs, fieldError{Field: "to", Message: address + " isn't an email address"})
+		}
+		to = append(to, address)
+	}
+	s.To = to
+	if s.Enabled && len(s.To) == 0 {
+		errs = append(errs, fieldError{Field: "to", Message: "a digest needs at least one address to go to"})
+	}
+	if len(s.To) > maxDigestRecipients {
+		errs = append(errs, fieldError{Field: "to", Message: fmt.Sprintf("a digest can go to at most %d addresses", maxDigestRecipients)})
+	}
+	s.Weekday = strings.ToLower(strings.TrimSpace(s.Weekday))
+	if s.Weekday == "" {
+		s.Weekday = "saturday"
+	}
+	if _, ok := parseWeekday(s.Weekday); !ok {
+		errs = append(errs, fieldError{Field: "weekday", Message: "weekday has to be a day of the week like saturday"})
+	}
+	if s.Hour < 0 || s.Hour > 23 {
+		errs = append(errs, fieldError{Field: "hour", Message: "hour has to be from 0 to 23"})
+	}
+	s.TimeZone = strings.TrimSpace(s.TimeZone)
+	if s.TimeZone == "" {
+		s.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		errs = append(errs, fieldError{Field: "time_zone", Message: "time_zone has to be an IANA time zone like Europe/London"})
+	}
+	return errs
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// Reports whether the digest should go out at now, which is once its hour has come on its weekday and it hasn't been sent that day
+func (s digestSubscription) isDue(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return false
+	}
+	day, _ := parseWeekday(s.Weekday)
+	local := now.In(loc)
+	if local.Weekday() != day || local.Hour() < s.Hour {
+		return false
+	}
+	return s.LastSent == nil || s.LastSent.In(loc).Format(dateLayout) != local.Format(dateLayout)
+}
+
+// digestEmail is a digest ready to send
+type digestEmail struct {
+	to      []string
+	subject string
+	body    string
+}
+
+// Returns the digests that should go out now and marks them as sent, callers must hold mu
+// they are saved as sent first so a crash can lose a digest but never send one twice
+func (d *digestRegistry) due(now time.Time) ([]digestEmail, error) {
+	var emails []digestEmail
+	for caller, sub := range d.doc.Subscriptions {
+		if !sub.isDue(now) {
+			continue
+		}
+		email, err := d.compose(sub)
+		if err != nil {
+			return nil, err
+		}
+		sub.LastSent = &now
+		d.doc.Subscriptions[caller] = sub
+		emails = append(emails, email)
+	}
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	return emails, d.save()
+}
+
+// Writes the email for a digest, the list's open entries grouped by aisle, callers must hold mu
+func (d *digestRegistry) compose(sub digestSubscription) (digestEmail, error) {
+	entries, err := d.store.List(sub.ListID)
+	if err != nil {
+		return digestEmail{}, err
+	}
+	var open []Entry
+	for _, entry := range liveEntries(entries) {
+		if !entry.Completed {
+			open = append(open, entry)
+		}
+	}
+
+	var body strings.Builder
+	if len(open) == 0 {
+		body.WriteString("There is nothing on your shopping list.\n")
+	} else {
+		fmt.Fprintf(&body, "There are %d things on your shopping list.\n", len(open))
+	}
+	for _, group := range groupByCategory(open, categories.For(sub.ListID)) {
+		title := group.Category
+		if title == "" {
+			title = "other"
+		}
+		fmt.Fprintf(&body, "\n%s\n", strings.ToUpper(title))
+		for _, entry := range group.Entries {
+			body.WriteString(digestLine(entry))
+		}
+	}
+	if d.publicURL != "" {
+		fmt.Fprintf(&body, "\n--\nTo stop these emails go to %s/digest/unsubscribe?token=%s\n", d.publicURL, url.QueryEscape(sub.Token))
+	}
+	subject := fmt.Sprintf("Your shopping list: %d to get", len(open))
+	return digestEmail{to: sub.To, subject: subject, body: body.String()}, nil
+}
+
+// Writes an entry as a line of the digest e.g. "- milk (2 l) @ Aldi"
+func digestLine(entry Entry) string {
+	line := "- " + entry.Item
+	amount := formatQuantity(entry.Quantity)
+	if entry.Unit != "" {
+		amount += " " + entry.Unit
+	}
+	if amount != "1" {
+		line += " (" + amount + ")"
+	}
+	if entry.Store != "" {
+		line += " @ " + entry.Store
+	}
+	if entry.Notes != "" {
+		line += " - " + strings.Join(strings.Fields(entry.Notes), " ")
+	}
+	return line + "\n"
+}
+
+// Sends the digests that are due every interval until ctx is cancelled
+func (d *digestRegistry) run(ctx context.Context, interval time.Duration) {
+	if d.mail == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		mu.Lock()
+		emails, err := d.due(time.Now().UTC())
+		mu.Unlock()
+		if err != nil {
+			slog.Error("Error checking digests", "err", err)
+		}
+		for _, email := range emails {
+			err := d.mail.Send(email.to, email.subject, email.body)
+			if err != nil {
+				slog.Error("Error sending digest", "to", email.to, "err", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newDigestResponse(sub digestSubscription) digestResponse {
+	return digestResponse{digestSettings: sub.digestSettings, ListID: sub.ListID, LastSent: sub.LastSent}
+}
+
+// Handle Get request for the caller's digest settings, a digest that was never set up is off
+func handleGetDigest(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	id := requestIdentity(r)
+	sub, ok := digests.doc.Subscriptions[id.Caller()]
+	if !ok {
+		sub = digestSubscription{digestSettings: digestSettings{To: []string{}}, ListID: id.ListID}
+		sub.normalize()
+	}
+	writeJSON(w, http.StatusOK, newDigestResponse(sub))
+}
+
+// Handle Put request to set up or change the caller's weekly digest of the list picked with ?list=
+func handlePutDigest(w http.ResponseWriter, r *http.Request) {
+	var settings digestSettings
+	err := decodeStrict(r.Body, &settings)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if errs := settings.normalize(); errs != nil {
+		writeFieldErrors(w, "invalid_digest", errs)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := requestIdentity(r)
+	sub := digests.doc.Subscriptions[id.Caller()]
+	if sub.Token == "" {
+		sub.Token, err = newToken()
+		if err != nil {
+			slog.Error("Error making unsubscribe token", "err", err)
+			writeInternalError(w)
+			return
+		}
+	}
+	sub.digestSettings = settings
+	sub.ListID = id.ListID
+	digests.doc.Subscriptions[id.Caller()] = sub
+	err = digests.save()
+	if err != nil {
+		slog.Error("Error saving digests", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, newDigestResponse(sub))
+}
+
+// Handle Delete request to stop the caller's digest and forget its settings
+func handleDeleteDigest(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	caller := requestIdentity(r).Caller()
+	if _, ok := digests.doc.Subscriptions[caller]; !ok {
+		writeError(w, http.StatusNotFound, "not_found", "no digest is set up")
+		return
+	}
+	delete(digests.doc.Subscriptions, caller)
+	err := digests.save()
+	if err != nil {
+		slog.Error("Error saving digests", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsubscribePage is what the unsubscribe link in a digest opens, like resetPage it has no scripts or style sheets
+// opening the link only asks, so a mail scanner following it doesn't turn the digest off, the button sends the POST that does
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Unsubscribe from the weekly shopping list</title>
+</head>
+<body>
+<h1>Unsubscribe from the weekly shopping list</h1>
+{{if .Done}}<p>You won't get the weekly shopping list email any more.</p>
+{{else if .Problem}}<p><strong>{{.Problem}}</strong></p>
+{{else}}<form method="post" action="/digest/unsubscribe">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Stop getting the weekly shopping list email? It can be turned back on from the app.</p>
+<p><button type="submit">Unsubscribe</button></p>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type unsubscribePageData struct {
+	Token   string
+	Problem string
+	Done    bool
+}
+
+// Handle Get request from the unsubscribe link in a digest, which asks whether to turn it off, the token is only checked when the form is sent
+func handleUnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{Token: r.URL.Query().Get("token")})
+}
+
+func writeUnsubscribePage(w http.ResponseWriter, status int, data unsubscribePageData) {
+	var buf strings.Builder
+	err := unsubscribePage.Execute(&buf, data)
+	if err != nil {
+		slog.Error("Error rendering HTML", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// the token is in the page, so it mustn't leak to other sites through the Referer or be kept by any cache
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, status, "text/html; charset=utf-8", []byte(buf.String()))
+}
+
+// Handle Post request to turn a digest off without logging in, from the unsubscribe page's form or a mail client's one-click unsubscribe
+// the token is in the form or the link's query, it is the only thing that says whose digest it is
+// the settings are kept so PUT /digest can turn it back on
+func handleUnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	token := r.Form.Get("token")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for caller, sub := range digests.doc.Subscriptions {
+		if token == "" || !validAPIKey([]string{sub.Token}, token) {
+			continue
+		}
+		sub.Enabled = false
+		digests.doc.Subscriptions[caller] = sub
+		err := digests.save()
+		if err != nil {
+			slog.Error("Error saving digests", "err", err)
+			writeInternalError(w)
+			return
+		}
+		writeUnsubscribePage(w, http.StatusOK, unsubscribePageData{Done: true})
+		return
+	}
+	writeUnsubscribePage(w, http.StatusNotFound, unsubscribePageData{Problem: "This unsubscribe link isn't valid."})
+}
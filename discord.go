@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// discordMaxContent is the longest message Discord accepts
+const discordMaxContent = 2000
+
+// discordKey is -discord-public-key, POST /integrations/discord is only served when it is set
+var discordKey ed25519.PublicKey
+
+// Discord interaction and response types, https://discord.com/developers/docs/interactions/receiving-and-responding
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordChannelMessage     = 4
+	discordSubCommand         = 1
+)
+
+// discordInteraction is the part of an interaction the handler uses
+type discordInteraction struct {
+	Type int `json:"type"`
+	Data struct {
+		Name    string          `json:"name"`
+		Options []discordOption `json:"options"`
+	} `json:"data"`
+	// Member is set for commands in a server and User for ones in a DM
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+}
+
+type discordUser struct {
+	Username string `json:"username"`
+}
+
+// discordOption is an argument to a command, a sub-command has its own options
+type discordOption struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   any             `json:"value"`
+	Options []discordOption `json:"options"`
+}
+
+type discordResponse struct {
+	Type int `json:"type"`
+	Data *struct {
+		Content string `json:"content"`
+	} `json:"data,omitempty"`
+}
+
+// Handle Post request from Discord for a slash command, working on -chat-list
+// the command can have sub-commands add, list and done, e.g. /shop add item:milk, or one text option e.g. /shop text:add milk
+// Discord signs every request with the application's Ed25519 key instead of sending a token
+func handleDiscord(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if err != nil || len(signature) != ed25519.SignatureSize || !ed25519.Verify(discordKey, message, signature) {
+		slog.Warn("Rejected Discord request with a bad signature")
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "the request isn't signed by Discord")
+		return
+	}
+	var interaction discordInteraction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "request body isn't a Discord interaction")
+		return
+	}
+
+	switch interaction.Type {
+	case discordPing:
+		// Discord pings the endpoint when it is set up and checks it answers
+		writeJSON(w, http.StatusOK, discordResponse{Type: discordPong})
+		return
+	case discordApplicationCommand:
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_interaction", "only slash commands are supported")
+		return
+	}
+
+	var command, args string
+	options := interaction.Data.Options
+	if len(options) > 0 && options[0].Type == discordSubCommand {
+		command, options = options[0].Name, options[0].Options
+	}
+	var values []string
+	for _, option := range options {
+		switch value := option.Value.(type) {
+		case nil:
+		case string:
+			values = append(values, strings.TrimSpace(value))
+		default:
+			values = append(values, jsonText(value))
+		}
+	}
+	args = strings.Join(values, " ")
+	if command == "" {
+		command, args, _ = strings.Cut(args, " ")
+	}
+	actor := "discord"
+	if interaction.Member != nil {
+		actor += ":" + interaction.Member.User.Username
+	} else if interaction.User != nil {
+		actor += ":" + interaction.User.Username
+	}
+
+	reply := runChatCommand(chatListID, actor, "/"+interaction.Data.Name+" ", command, args)
+	if len(reply) > discordMaxContent {
+		reply = reply[:discordMaxContent-3] + "..."
+	}
+	resp := discordResponse{Type: discordChannelMessage}
+	resp.Data = &struct {
+		Content string `json:"content"`
+	}{Content: reply}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Writes an option value as text, numbers like the quantity come as JSON numbers
+func jsonText(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errorResponse is the body of every error so clients have something to show the user
+// e.g. {"error": {"code": "not_found", "message": "entry not found"}}
+// code is a fixed string clients can switch on, message is for people and may change
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields is every field of the request body that was wrong, only for errors about the body's fields
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// Writes a 400 listing every field that was wrong, the message is the first one so clients that only show that still say what to fix
+func writeFieldErrors(w http.ResponseWriter, code string, errs []fieldError) {
+	writeJSON(w, http.StatusBadRequest, errorResponse{Error: apiError{Code: code, Message: errs[0].Message, Fields: errs}})
+}
+
+// Used when something went wrong on our side, the details are logged rather than sent to the client
+func writeInternalError(w http.ResponseWriter) {
+	writeError(w, http.StatusInternalServerError, "internal_error", "something went wrong on the server, try again later")
+}
+
+// Used when a request body couldn't be read or parsed, it is 413 if it was over -max-body-size and 400 for anything else
+// a field sent as the wrong type or one that isn't known is reported against that field
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body is too large")
+		return
+	}
+	if errs, ok := decodeFieldErrors(err); ok {
+		writeFieldErrors(w, "invalid_field", errs)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_json", "request body isn't valid JSON: "+err.Error())
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"time"
+)
+
+// Event is a change to a list that is pushed to live clients
+type Event struct {
+	// Seq goes up by one for every event the server publishes, SSE clients send the last one they saw to catch up after reconnecting
+	Seq uint64 `json:"seq"`
+	// Type is "created", "updated", "completed", "deleted" or "reset"
+	Type   string `json:"type"`
+	ListID int    `json:"list_id"`
+	ID     int    `json:"id"`
+	Entry  *Entry `json:"entry,omitempty"`
+	// Actor is who made the change, as in the audit log
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+const (
+	EventCreated   = "created"
+	EventUpdated   = "updated"
+	EventCompleted = "completed"
+	EventDeleted   = "deleted"
+	// EventReset tells a client that events it asked to replay are no longer kept and it should fetch the whole list again
+	EventReset = "reset"
+)
+
+// subscriber is one live client listening to one list
+type subscriber struct {
+	listID int
+	// all is set for subscribers that get the events from every list, like the webhook dispatcher
+	all bool
+	// replay is set when the client wants the events after since before any new ones
+	replay bool
+	since  uint64
+	// send is closed by the hub when the subscriber is dropped or the hub shuts down
+	send chan Event
+}
+
+// eventHub passes events to every subscriber of the list they happened on
+// all of its state is owned by the run goroutine and everything else talks to it over channels, so it needs no locks
+type eventHub struct {
+	register   chan *subscriber
+	unregister chan *subscriber
+	broadcast  chan Event
+	quit       chan struct{}
+}
+
+const (
+	// How many events a slow subscriber can fall behind by before it is dropped
+	subscriberBuffer = 64
+	// How many recent events are kept for replay
+	eventHistorySize = 256
+)
+
+var hub = newEventHub()
+
+func newEventHub() *eventHub {
+	h := &eventHub{
+		register:   make(chan *subscriber),
+		unregister: make(chan *subscriber),
+		broadcast:  make(chan Event, 256),
+		quit:       make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *eventHub) run() {
+	subscribers := make(map[*subscriber]bool)
+	// history is a ring buffer of the last eventHistorySize events, next is where the next one goes
+	history := make([]Event, 0, eventHistorySize)
+	next := 0
+	var seq uint64
+
+	for {
+		select {
+		case s := <-h.register:
+			if s.replay {
+				replayEvents(s, history, next, seq)
+			}
+			subscribers[s] = true
+		case s := <-h.unregister:
+			if subscribers[s] {
+				delete(subscribers, s)
+				close(s.send)
+			}
+		case e := <-h.broadcast:
+			seq++
+			e.Seq = seq
+			if len(history) < eventHistorySize {
+				history = append(history, e)
+			} else {
+				history[next] = e
+			}
+			next = (next + 1) % eventHistorySize
+
+			for s := range subscribers {
+				if !s.all && s.listID != e.ListID {
+					continue
+				}
+				// a subscriber that isn't keeping up is dropped rather than holding everyone else up
+				select {
+				case s.send <- e:
+				default:
+					delete(subscribers, s)
+					close(s.send)
+				}
+			}
+		case <-h.quit:
+			for s := range subscribers {
+				close(s.send)
+			}
+			return
+		}
+	}
+}
+
+// Sends a new subscriber the events on its list that came after s.since, oldest first
+// if some of those have already fallen out of history (or the server has restarted since) a reset event is sent instead
+func replayEvents(s *subscriber, history []Event, next int, seq uint64) {
+	if s.since >= seq {
+		if s.since > seq {
+			s.send <- Event{Type: EventReset, ListID: s.listID, Seq: seq, Time: time.Now().UTC()}
+		}
+		return
+	}
+	// once the ring is full the oldest event is the one about to be overwritten
+	start := 0
+	if len(history) == eventHistorySize {
+		start = next
+	}
+	oldest := history[start].Seq
+	if s.since+1 < oldest {
+		s.send <- Event{Type: EventReset, ListID: s.listID, Seq: seq, Time: time.Now().UTC()}
+		return
+	}
+	for i := 0; i < len(history); i++ {
+		e := history[(start+i)%len(history)]
+		if e.Seq > s.since && e.ListID == s.listID {
+			s.send <- e
+		}
+	}
+}
+
+// Publish queues an event for everyone listening to its list
+// it never blocks a request, if the hub is that far behind the event is dropped
+func (h *eventHub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	select {
+	case h.broadcast <- e:
+	default:
+	}
+}
+
+// Subscribe starts sending the list's events to the returned subscriber until Unsubscribe is called
+func (h *eventHub) Subscribe(listID int) *subscriber {
+	return h.subscribe(&subscriber{listID: listID, send: make(chan Event, subscriberBuffer)})
+}
+
+// SubscribeSince is Subscribe for a client that has seen every event up to since and wants the ones it missed first
+func (h *eventHub) SubscribeSince(listID int, since uint64) *subscriber {
+	// room for the whole history on top of the normal buffer so the replay can't get the subscriber dropped
+	send := make(chan Event, subscriberBuffer+eventHistorySize)
+	return h.subscribe(&subscriber{listID: listID, replay: true, since: since, send: send})
+}
+
+// SubscribeAll is Subscribe for every list, it is for the server's own listeners rather than clients
+func (h *eventHub) SubscribeAll(buffer int) *subscriber {
+	return h.subscribe(&subscriber{all: true, send: make(chan Event, buffer)})
+}
+
+func (h *eventHub) subscribe(s *subscriber) *subscriber {
+	select {
+	case h.register <- s:
+	case <-h.quit:
+		close(s.send)
+	}
+	return s
+}
+
+func (h *eventHub) Unsubscribe(s *subscriber) {
+	select {
+	case h.unregister <- s:
+	case <-h.quit:
+	}
+}
+
+// Close disconnects every subscriber, it is called once on shutdown
+func (h *eventHub) Close() {
+	close(h.quit)
+}
+
+// Publishes an event for each entry, used after a change made by actor has been stored
+func publishEntries(actor string, eventType string, entries ...Entry) {
+	for i := range entries {
+		entry := entries[i]
+		e := Event{Type: eventType, ListID: entry.ListID, ID: entry.ID, Actor: actor}
+		if eventType != EventDeleted {
+			e.Entry = &entry
+		}
+		hub.Publish(e)
+	}
+}
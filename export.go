@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader is the first row of a CSV export, the columns are in the same order as exportRow writes them
+var csvHeader = []string{"id", "item", "quantity", "unit", "category", "tags", "priority", "store", "price", "currency", "notes", "due_by", "completed", "created_at", "completed_at"}
+
+// Handle Get request to download the list as a CSV file, it takes the same query parameters as GET /data
+func handleExportCSV(w http.ResponseWriter, r *http.Request) {
+	entries, ok := exportEntries(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(csvHeader)
+	for _, entry := range entries {
+		cw.Write(exportRow(entry))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.Error("Error writing CSV", "err", err)
+		writeInternalError(w)
+		return
+	}
+	setAttachment(w, "csv")
+	writeBody(w, http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// Handle Get request to download the list as Markdown, a checklist grouped by aisle that prints well
+// it takes the same query parameters as GET /data
+func handleExportMarkdown(w http.ResponseWriter, r *http.Request) {
+	entries, ok := exportEntries(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Shopping list\n\n_Exported %s_\n", time.Now().UTC().Format("2 January 2006 15:04 MST"))
+	mu.RLock()
+	groups := groupByCategory(entries, categories.For(requestIdentity(r).ListID))
+	mu.RUnlock()
+	for _, group := range groups {
+		title := group.Category
+		if title == "" {
+			title = "other"
+		}
+		fmt.Fprintf(&buf, "\n## %s\n\n", escapeMarkdown(title))
+		for _, entry := range group.Entries {
+			buf.WriteString(markdownLine(entry))
+		}
+	}
+	setAttachment(w, "md")
+	writeBody(w, http.StatusOK, "text/markdown; charset=utf-8", buf.Bytes())
+}
+
+// Reads and filters the caller's entries for an export, writing the error response itself when it can't
+func exportEntries(w http.ResponseWriter, r *http.Request) ([]Entry, bool) {
+	query, err := parseEntryQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return nil, false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries, err := store.List(requestIdentity(r).ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return nil, false
+	}
+	page, _ := query.Apply(liveEntries(entries))
+	return page, true
+}
+
+// Sets Content-Disposition so browsers save the export as a dated file rather than showing it
+func setAttachment(w http.ResponseWriter, extension string) {
+	name := "shopping-list-" + time.Now().UTC().Format("2006-01-02") + "." + extension
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+}
+
+func exportRow(entry Entry) []string {
+	return []string{
+		strconv.Itoa(entry.ID),
+		csvCell(entry.Item),
+		formatQuantity(entry.Quantity),
+		csvCell(entry.Unit),
+		csvCell(entry.Category),
+		csvCell(strings.Join(entry.Tags, ";")),
+		entry.Priority,
+		csvCell(entry.Store),
+		formatPrice(entry.Price),
+		entry.Currency,
+		csvCell(entry.Notes),
+		formatExportTime(entry.DueBy),
+		strconv.FormatBool(entry.Completed),
+		formatExportTime(entry.CreatedAt),
+		formatExportTime(entry.CompletedAt),
+	}
+}
+
+// Spreadsheets run cells that start with = + - or @ as formulas, so those get a ' in front to be shown as text
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatPrice(price float64) string {
+	if price == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(price, 'f', 2, 64)
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Writes an entry as a checklist item e.g. "- [ ] milk (2 l) @ Aldi"
+func markdownLine(entry Entry) string {
+	check := " "
+	if entry.Completed {
+		check = "x"
+	}
+	line := "- [" + check + "] " + escapeMarkdown(entry.Item)
+	amount := formatQuantity(entry.Quantity)
+	if entry.Unit != "" {
+		amount += " " + entry.Unit
+	}
+	if amount != "1" {
+		line += " (" + escapeMarkdown(amount) + ")"
+	}
+	if entry.Store != "" {
+		line += " @ " + escapeMarkdown(entry.Store)
+	}
+	if entry.Notes != "" {
+		// notes can have line breaks, which would end the list item
+		line += " - " + escapeMarkdown(strings.Join(strings.Fields(entry.Notes), " "))
+	}
+	return line + "\n"
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "#", `\#`, "<", `\<`)
+
+// Escapes the characters that would otherwise turn user text into Markdown formatting
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
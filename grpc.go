@@ -0,0 +1,540 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/rachvm/shoppingList --go-grpc_out=. --go-grpc_opt=module=github.com/rachvm/shoppingList shoppinglist.proto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rachvm/shoppingList/shoppinglistpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The gRPC API is the service in shoppinglist.proto, served by grpc-go with the code generated from it into shoppinglistpb
+// each call is run through the HTTP handler for the same operation and its JSON response turned into the generated messages,
+// so the two APIs share validation, the audit log and events and can't drift apart
+
+// The largest request message the server reads, -max-body-size still applies on top
+const maxGRPCMessageSize = 4 << 20
+
+// grpcServer implements the service, the calls it doesn't have get Unimplemented from the embedded type
+type grpcServer struct {
+	shoppinglistpb.UnimplementedShoppingListServer
+}
+
+// Returns the handler gRPC calls go to, it is mounted in newRouter behind auth like the HTTP routes
+func newGRPCHandler() http.Handler {
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxGRPCMessageSize))
+	shoppinglistpb.RegisterShoppingListServer(server, grpcServer{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if r.URL.Path == shoppinglistpb.ShoppingList_WatchChanges_FullMethodName {
+			// the stream stays open for as long as the client wants, so the server's write timeout is turned off for it
+			err := rc.SetWriteDeadline(time.Time{})
+			if err != nil {
+				slog.Error("Error starting change stream", "err", err)
+				writeGRPCStatus(w, codes.Internal, "")
+				return
+			}
+		}
+		server.ServeHTTP(flushWriter{ResponseWriter: w, rc: rc}, r)
+	})
+}
+
+// flushWriter lets grpc-go flush through the middleware's response writers, which only offer it through Unwrap
+type flushWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (fw flushWriter) Flush() {
+	fw.rc.Flush()
+}
+
+// Returns the gRPC status code that means the same as the HTTP status an error was written with
+func grpcCodeFor(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	if status >= 500 {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// Returns the message of an error written as JSON, or the status text if it isn't one
+func errorMessage(status int, body []byte) string {
+	var resp errorResponse
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return http.StatusText(status)
+}
+
+// Returns middleware that turns the JSON errors auth and the rate limits write before a call reaches grpc-go into gRPC statuses
+// something that isn't a gRPC client gets them as they are, it wouldn't understand a status in a header
+func withGRPCErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isGRPC(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &grpcErrorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status != 0 {
+			// the headers the JSON error set don't describe a gRPC response
+			w.Header().Del("Content-Length")
+			w.Header().Del("Content-Encoding")
+			writeGRPCStatus(w, grpcCodeFor(ew.status), errorMessage(ew.status, ew.body.Bytes()))
+		}
+	})
+}
+
+// grpcErrorWriter holds back an error response so it can be sent as a gRPC status instead, anything else goes straight through
+type grpcErrorWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ew *grpcErrorWriter) WriteHeader(status int) {
+	if status >= 300 {
+		ew.status = status
+		return
+	}
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *grpcErrorWriter) Write(b []byte) (int, error) {
+	if ew.status != 0 {
+		return ew.body.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *grpcErrorWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// Ends a call grpc-go never saw as a trailers-only response, which is just a status
+func writeGRPCStatus(w http.ResponseWriter, code codes.Code, message string) {
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcPercentEncode(message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpc-message is percent-encoded so it can carry any text in a header
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Reports whether the request is a gRPC call the server can answer, only the protobuf encoding is supported
+func isGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return r.ProtoMajor == 2 && (contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+proto"))
+}
+
+// grpcRecorder keeps the response of an HTTP handler a call is run through
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *grpcRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *grpcRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *grpcRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// err returns the handler's error response as a gRPC status, nil if it succeeded
+func (rec *grpcRecorder) err() error {
+	if rec.status < 300 {
+		return nil
+	}
+	return status.Error(grpcCodeFor(rec.status), errorMessage(rec.status, rec.body.Bytes()))
+}
+
+// Builds the request a call is run through the HTTP handlers with, ctx carries who is calling from auth
+// query is the query string, with list set when the call had a list_id, and id is the {id} path value, 0 for none
+func newHandlerRequest(ctx context.Context, method string, query url.Values, id int, body any) (*http.Request, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, "/?"+query.Encode(), reader)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if id != 0 {
+		r.SetPathValue("id", strconv.Itoa(id))
+	}
+	return r, nil
+}
+
+// Runs a call through the HTTP handler for the same operation and decodes the JSON it responded with into resp
+// if the handler failed its error is returned as the call's status
+func callHandler(ctx context.Context, method string, query url.Values, id int, body any, need Permission, handler http.HandlerFunc, resp any) error {
+	r, err := newHandlerRequest(ctx, method, query, id, body)
+	if err != nil {
+		slog.Error("Error building request", "err", err)
+		return status.Error(codes.Internal, "")
+	}
+	rec := &grpcRecorder{header: make(http.Header)}
+	withList(need, handler).ServeHTTP(rec, r)
+	err = rec.err()
+	if err != nil || resp == nil {
+		return err
+	}
+	err = json.Unmarshal(rec.body.Bytes(), resp)
+	if err != nil {
+		slog.Error("Error parsing JSON", "err", err)
+		return status.Error(codes.Internal, "")
+	}
+	return nil
+}
+
+// Returns the query with list= set when a request has its optional list_id
+func listQuery(listID *int64) url.Values {
+	query := url.Values{}
+	if listID != nil {
+		query.Set("list", strconv.FormatInt(*listID, 10))
+	}
+	return query
+}
+
+func (grpcServer) ListItems(ctx context.Context, req *shoppinglistpb.ListItemsRequest) (*shoppinglistpb.ListItemsResponse, error) {
+	query := listQuery(req.ListId)
+	if req.Completed != nil {
+		query.Set("completed", strconv.FormatBool(*req.Completed))
+	}
+	if req.Query != "" {
+		query.Set("q", req.Query)
+	}
+	for _, tag := range req.Tags {
+		query.Add("tag", tag)
+	}
+	if req.Store != "" {
+		query.Set("store", req.Store)
+	}
+	var entries []Entry
+	err := callHandler(ctx, http.MethodGet, query, 0, nil, PermissionRead, handleGet, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return &shoppinglistpb.ListItemsResponse{Items: itemsFromEntries(entries)}, nil
+}
+
+func (grpcServer) AddItems(ctx context.Context, req *shoppinglistpb.AddItemsRequest) (*shoppinglistpb.AddItemsResponse, error) {
+	entries := make([]Entry, 0, len(req.Items))
+	for _, item := range req.Items {
+		entry, err := entryFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	var created []Entry
+	err := callHandler(ctx, http.MethodPost, listQuery(req.ListId), 0, nil, PermissionWrite, addItemsHandler(entries), &created)
+	if err != nil {
+		return nil, err
+	}
+	return &shoppinglistpb.AddItemsResponse{Items: itemsFromEntries(created)}, nil
+}
+
+// POST /data decodes the entries from its body and these come from the call, so this does the rest of what it does
+func addItemsHandler(entries []Entry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if errs := prepareNewEntries(entries, time.Now().UTC()); errs != nil {
+			writeFieldErrors(w, "invalid_entry", errs)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		created, err := addEntries(r, requestIdentity(r).ListID, entries)
+		if err != nil {
+			slog.Error("Error adding entries", "err", err)
+			writeInternalError(w)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	}
+}
+
+func (grpcServer) UpdateItem(ctx context.Context, req *shoppinglistpb.UpdateItemRequest) (*shoppinglistpb.Item, error) {
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	patch, err := updatePatch(req)
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	err = callHandler(ctx, http.MethodPatch, listQuery(req.ListId), int(req.Id), patch, PermissionWrite, handleUpdate, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return itemFromEntry(entry), nil
+}
+
+// Returns the JSON body of the PATCH an UpdateItemRequest stands for, only the fields that were set are in it
+func updatePatch(req *shoppinglistpb.UpdateItemRequest) (map[string]any, error) {
+	patch := make(map[string]any)
+	if req.Item != nil {
+		patch["item"] = *req.Item
+	}
+	if req.Completed != nil {
+		patch["completed"] = *req.Completed
+	}
+	if req.Quantity != nil {
+		patch["quantity"] = *req.Quantity
+	}
+	if req.Unit != nil {
+		patch["unit"] = *req.Unit
+	}
+	if req.Category != nil {
+		patch["category"] = *req.Category
+	}
+	if req.Tags != nil {
+		// an empty Tags is sent as an empty list so it takes every tag away rather than leaving them
+		patch["tags"] = append([]string{}, req.Tags.Tags...)
+	}
+	if req.Priority != nil {
+		patch["priority"] = *req.Priority
+	}
+	if req.Notes != nil {
+		patch["notes"] = *req.Notes
+	}
+	if req.Price != nil {
+		patch["price"] = *req.Price
+	}
+	if req.Currency != nil {
+		patch["currency"] = *req.Currency
+	}
+	if req.Store != nil {
+		patch["store"] = *req.Store
+	}
+	if req.DueBy != nil {
+		due, err := timeFromTimestamp(req.DueBy)
+		if err != nil {
+			return nil, err
+		}
+		patch["due_by"] = due
+	}
+	if req.ClearDueBy {
+		patch["due_by"] = nil
+	}
+	if req.Revision != nil {
+		patch["revision"] = *req.Revision
+	}
+	return patch, nil
+}
+
+func (grpcServer) DeleteItem(ctx context.Context, req *shoppinglistpb.DeleteItemRequest) (*shoppinglistpb.DeleteItemResponse, error) {
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	err := callHandler(ctx, http.MethodDelete, listQuery(req.ListId), int(req.Id), nil, PermissionWrite, handleDelete, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &shoppinglistpb.DeleteItemResponse{}, nil
+}
+
+// Streams the list's events as Change messages for as long as the client keeps the call open
+func (grpcServer) WatchChanges(req *shoppinglistpb.WatchChangesRequest, stream grpc.ServerStreamingServer[shoppinglistpb.Change]) error {
+	r, err := newHandlerRequest(stream.Context(), http.MethodGet, listQuery(req.ListId), 0, nil)
+	if err != nil {
+		slog.Error("Error building request", "err", err)
+		return status.Error(codes.Internal, "")
+	}
+	// withList checks the caller can read the list the same as for the other calls
+	var streamErr error
+	rec := &grpcRecorder{header: make(http.Header)}
+	withList(PermissionRead, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		streamErr = streamChanges(r, stream, req.SinceSeq)
+	})).ServeHTTP(rec, r)
+	err = rec.err()
+	if err != nil {
+		return err
+	}
+	return streamErr
+}
+
+func streamChanges(r *http.Request, stream grpc.ServerStreamingServer[shoppinglistpb.Change], since uint64) error {
+	listID := requestIdentity(r).ListID
+	var sub *subscriber
+	if since > 0 {
+		sub = hub.SubscribeSince(listID, since)
+	} else {
+		sub = hub.Subscribe(listID)
+	}
+	defer hub.Unsubscribe(sub)
+
+	// the headers go straight away so the client knows it is watching before the first change
+	err := stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case e, ok := <-sub.send:
+			if !ok {
+				// the hub dropped us or is shutting down, the client reconnects with since_seq and catches up
+				return status.Error(codes.Unavailable, "the change stream was closed, watch again with since_seq to catch up")
+			}
+			err = stream.Send(changeFromEvent(e))
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Returns the time a google.protobuf.Timestamp holds, in UTC like the times the HTTP API takes
+func timeFromTimestamp(ts *timestamppb.Timestamp) (time.Time, error) {
+	err := ts.CheckValid()
+	if err != nil {
+		return time.Time{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return ts.AsTime(), nil
+}
+
+// Returns a Timestamp for an optional time, nil for nil
+func timestampFromTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func itemFromEntry(entry Entry) *shoppinglistpb.Item {
+	return &shoppinglistpb.Item{
+		Id:          int64(entry.ID),
+		Item:        entry.Item,
+		Completed:   entry.Completed,
+		Quantity:    entry.Quantity,
+		Unit:        entry.Unit,
+		Category:    entry.Category,
+		Tags:        entry.Tags,
+		Priority:    entry.Priority,
+		Notes:       entry.Notes,
+		Price:       entry.Price,
+		Currency:    entry.Currency,
+		Store:       entry.Store,
+		DueBy:       timestampFromTime(entry.DueBy),
+		ListId:      int64(entry.ListID),
+		CreatedAt:   timestampFromTime(entry.CreatedAt),
+		CompletedAt: timestampFromTime(entry.CompletedAt),
+		Revision:    int64(entry.Revision),
+	}
+}
+
+func itemsFromEntries(entries []Entry) []*shoppinglistpb.Item {
+	items := make([]*shoppinglistpb.Item, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, itemFromEntry(entry))
+	}
+	return items
+}
+
+// Returns the entry an Item being added stands for, the fields the server sets are left out as they would be ignored anyway
+func entryFromItem(item *shoppinglistpb.Item) (Entry, error) {
+	entry := Entry{
+		Item:      item.Item,
+		Completed: item.Completed,
+		Quantity:  item.Quantity,
+		Unit:      item.Unit,
+		Category:  item.Category,
+		Tags:      item.Tags,
+		Priority:  item.Priority,
+		Notes:     item.Notes,
+		Price:     item.Price,
+		Currency:  item.Currency,
+		Store:     item.Store,
+	}
+	if item.DueBy != nil {
+		due, err := timeFromTimestamp(item.DueBy)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.DueBy = &due
+	}
+	return entry, nil
+}
+
+func changeFromEvent(e Event) *shoppinglistpb.Change {
+	change := &shoppinglistpb.Change{
+		Seq:    e.Seq,
+		Type:   e.Type,
+		ListId: int64(e.ListID),
+		Id:     int64(e.ID),
+		Actor:  e.Actor,
+		Time:   timestamppb.New(e.Time),
+	}
+	if e.Entry != nil {
+		change.Item = itemFromEntry(*e.Entry)
+	}
+	return change
+}
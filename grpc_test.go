@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rachvm/shoppingList/shoppinglistpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// wireField is one field of an encoded message, as protowire reads it without the generated code
+type wireField struct {
+	Num  protowire.Number
+	Type protowire.Type
+}
+
+// Returns the number and wire type of every field in an encoded message, in the order they were sent
+func wireFields(t *testing.T, data []byte) []wireField {
+	t.Helper()
+	var fields []wireField
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+		fields = append(fields, wireField{num, typ})
+	}
+	return fields
+}
+
+func testEntry() Entry {
+	due := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	completed := time.Date(2026, 3, 2, 18, 15, 0, 0, time.UTC)
+	return Entry{
+		ID: 42, Item: "Oat milk", Completed: true, Quantity: 2.5, Unit: "l", Category: "Dairy",
+		Tags: []string{"breakfast", "vegan"}, Priority: "high", Notes: "the barista one", Price: 1.99, Currency: "GBP",
+		Store: "Café Nord", DueBy: &due, ListID: 7, CreatedAt: &created, CompletedAt: &completed, Revision: 3,
+	}
+}
+
+// Every field of an Item has to go on the wire with the number and type shoppinglist.proto gives it
+func TestItemWireFormat(t *testing.T) {
+	data, err := proto.Marshal(itemFromEntry(testEntry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []wireField{
+		{1, protowire.VarintType},  // int64 id
+		{2, protowire.BytesType},   // string item
+		{3, protowire.VarintType},  // bool completed
+		{4, protowire.Fixed64Type}, // double quantity
+		{5, protowire.BytesType},   // string unit
+		{6, protowire.BytesType},   // string category
+		{7, protowire.BytesType},   // repeated string tags
+		{7, protowire.BytesType},
+		{8, protowire.BytesType},    // string priority
+		{9, protowire.BytesType},    // string notes
+		{10, protowire.Fixed64Type}, // double price
+		{11, protowire.BytesType},   // string currency
+		{12, protowire.BytesType},   // string store
+		{13, protowire.BytesType},   // Timestamp due_by
+		{14, protowire.VarintType},  // int64 list_id
+		{15, protowire.BytesType},   // Timestamp created_at
+		{16, protowire.BytesType},   // Timestamp completed_at
+		{17, protowire.VarintType},  // int64 revision
+	}
+	got := wireFields(t, data)
+	if !slices.Equal(got, want) {
+		t.Errorf("fields = %v\nwant %v", got, want)
+	}
+}
+
+// An Item a client adds comes back as the same entry, less the fields the server sets
+func TestItemRoundTrip(t *testing.T) {
+	entry := testEntry()
+	data, err := proto.Marshal(itemFromEntry(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var item shoppinglistpb.Item
+	err = proto.Unmarshal(data, &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Id != 42 || item.ListId != 7 || item.Revision != 3 {
+		t.Errorf("id, list_id, revision = %d, %d, %d, want 42, 7, 3", item.Id, item.ListId, item.Revision)
+	}
+	if !item.CreatedAt.AsTime().Equal(*entry.CreatedAt) || !item.CompletedAt.AsTime().Equal(*entry.CompletedAt) {
+		t.Errorf("created_at, completed_at = %v, %v, want %v, %v", item.CreatedAt.AsTime(), item.CompletedAt.AsTime(), entry.CreatedAt, entry.CompletedAt)
+	}
+
+	got, err := entryFromItem(&item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := entry
+	want.ID, want.ListID, want.Revision, want.CreatedAt, want.CompletedAt = 0, 0, 0, nil, nil
+	if got.Item != want.Item || got.Completed != want.Completed || got.Quantity != want.Quantity || got.Unit != want.Unit ||
+		got.Category != want.Category || !slices.Equal(got.Tags, want.Tags) || got.Priority != want.Priority ||
+		got.Notes != want.Notes || got.Price != want.Price || got.Currency != want.Currency || got.Store != want.Store {
+		t.Errorf("entry = %+v\nwant %+v", got, want)
+	}
+	if got.DueBy == nil || !got.DueBy.Equal(*want.DueBy) || got.DueBy.Location() != time.UTC {
+		t.Errorf("due_by = %v, want %v in UTC", got.DueBy, want.DueBy)
+	}
+	if got.ID != 0 || got.ListID != 0 || got.Revision != 0 || got.CreatedAt != nil || got.CompletedAt != nil {
+		t.Errorf("server set fields came through: %+v", got)
+	}
+}
+
+// An entry without the optional times or anything else set goes on the wire as proto3 leaves out zero values
+func TestEmptyItemWireFormat(t *testing.T) {
+	data, err := proto.Marshal(itemFromEntry(Entry{Item: "eggs"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := wireFields(t, data)
+	want := []wireField{{2, protowire.BytesType}}
+	if !slices.Equal(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+}
+
+// An UpdateItemRequest built by hand from shoppinglist.proto's field numbers decodes to a PATCH of only the fields that were sent
+func TestUpdateItemRequestWireFormat(t *testing.T) {
+	due := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+	dueBytes, err := proto.Marshal(timestamppb.New(due))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []byte
+	tags = protowire.AppendTag(tags, 1, protowire.BytesType)
+	tags = protowire.AppendString(tags, "weekly")
+
+	tests := []struct {
+		name string
+		wire func(b []byte) []byte
+		want map[string]any
+	}{
+		{"nothing set", func(b []byte) []byte { return b }, map[string]any{}},
+		{"item", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 3, protowire.BytesType)
+			return protowire.AppendString(b, "bread")
+		}, map[string]any{"item": "bread"}},
+		{"completed false is still set", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 4, protowire.VarintType)
+			return protowire.AppendVarint(b, 0)
+		}, map[string]any{"completed": false}},
+		{"quantity", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+			return protowire.AppendFixed64(b, math.Float64bits(3))
+		}, map[string]any{"quantity": 3.0}},
+		{"strings", func(b []byte) []byte {
+			for num, s := range map[protowire.Number]string{6: "kg", 7: "Bakery", 9: "low", 10: "sliced", 12: "EUR", 13: "Lidl"} {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendString(b, s)
+			}
+			return b
+		}, map[string]any{"unit": "kg", "category": "Bakery", "priority": "low", "notes": "sliced", "currency": "EUR", "store": "Lidl"}},
+		{"tags", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 8, protowire.BytesType)
+			return protowire.AppendBytes(b, tags)
+		}, map[string]any{"tags": []string{"weekly"}}},
+		{"empty tags take them all away", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 8, protowire.BytesType)
+			return protowire.AppendBytes(b, nil)
+		}, map[string]any{"tags": []string{}}},
+		{"price", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 11, protowire.Fixed64Type)
+			return protowire.AppendFixed64(b, math.Float64bits(0.5))
+		}, map[string]any{"price": 0.5}},
+		{"due_by", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 14, protowire.BytesType)
+			return protowire.AppendBytes(b, dueBytes)
+		}, map[string]any{"due_by": due}},
+		{"clear_due_by", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 15, protowire.VarintType)
+			return protowire.AppendVarint(b, 1)
+		}, map[string]any{"due_by": nil}},
+		{"revision", func(b []byte) []byte {
+			b = protowire.AppendTag(b, 16, protowire.VarintType)
+			return protowire.AppendVarint(b, 9)
+		}, map[string]any{"revision": int64(9)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b []byte
+			b = protowire.AppendTag(b, 1, protowire.VarintType)
+			b = protowire.AppendVarint(b, 5)
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, 12)
+			var req shoppinglistpb.UpdateItemRequest
+			err := proto.Unmarshal(tt.wire(b), &req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.GetListId() != 5 || req.Id != 12 {
+				t.Errorf("list_id, id = %d, %d, want 5, 12", req.GetListId(), req.Id)
+			}
+			patch, err := updatePatch(&req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(patch) != len(tt.want) {
+				t.Fatalf("patch = %v, want %v", patch, tt.want)
+			}
+			for key, want := range tt.want {
+				got, ok := patch[key]
+				if !ok {
+					t.Errorf("patch has no %s, want %v", key, want)
+					continue
+				}
+				switch want := want.(type) {
+				case []string:
+					if !slices.Equal(got.([]string), want) || got.([]string) == nil {
+						t.Errorf("%s = %#v, want %#v", key, got, want)
+					}
+				case time.Time:
+					if !got.(time.Time).Equal(want) {
+						t.Errorf("%s = %v, want %v", key, got, want)
+					}
+				default:
+					if got != want {
+						t.Errorf("%s = %#v, want %#v", key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// A Change has the numbers and types shoppinglist.proto gives it and carries the entry for everything but deletes and resets
+func TestChangeWireFormat(t *testing.T) {
+	entry := Entry{ID: 4, Item: "tea", ListID: 2}
+	tests := []struct {
+		name  string
+		event Event
+		want  []wireField
+	}{
+		{"created", Event{Seq: 10, Type: EventCreated, ListID: 2, ID: 4, Entry: &entry, Actor: "alice", Time: time.Now()}, []wireField{
+			{1, protowire.VarintType}, // uint64 seq
+			{2, protowire.BytesType},  // string type
+			{3, protowire.VarintType}, // int64 list_id
+			{4, protowire.VarintType}, // int64 id
+			{5, protowire.BytesType},  // Item item
+			{6, protowire.BytesType},  // string actor
+			{7, protowire.BytesType},  // Timestamp time
+		}},
+		{"deleted", Event{Seq: 11, Type: EventDeleted, ListID: 2, ID: 4, Actor: "alice", Time: time.Now()}, []wireField{
+			{1, protowire.VarintType},
+			{2, protowire.BytesType},
+			{3, protowire.VarintType},
+			{4, protowire.VarintType},
+			{6, protowire.BytesType},
+			{7, protowire.BytesType},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(changeFromEvent(tt.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := wireFields(t, data)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fields = %v\nwant %v", got, tt.want)
+			}
+			var change shoppinglistpb.Change
+			err = proto.Unmarshal(data, &change)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if change.Seq != tt.event.Seq || change.Type != tt.event.Type || !change.Time.AsTime().Equal(tt.event.Time) {
+				t.Errorf("change = %v, want it to match %+v", &change, tt.event)
+			}
+		})
+	}
+}
+
+func TestInvalidTimestampIsInvalidArgument(t *testing.T) {
+	_, err := entryFromItem(&shoppinglistpb.Item{Item: "eggs", DueBy: &timestamppb.Timestamp{Nanos: -1}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("entryFromItem error = %v, want InvalidArgument", err)
+	}
+	_, err = updatePatch(&shoppinglistpb.UpdateItemRequest{Id: 1, DueBy: &timestamppb.Timestamp{Seconds: math.MaxInt64}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("updatePatch error = %v, want InvalidArgument", err)
+	}
+}
+
+// Returns a client for the gRPC API served the way the server does it, by newRouter over h2c with the API key "test-key"
+// only calls that fail before reaching the storage, or watch for changes, can be made as nothing else is loaded
+func newTestGRPCClient(t *testing.T) shoppinglistpb.ShoppingListClient {
+	t.Helper()
+	if digests == nil {
+		digests = &digestRegistry{}
+	}
+	server := httptest.NewUnstartedServer(newRouter(Config{GRPC: true}, requireAuth([]string{"test-key"}, false, nil), nil))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return shoppinglistpb.NewShoppingListClient(conn)
+}
+
+func withKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer test-key")
+}
+
+func TestGRPCErrors(t *testing.T) {
+	client := newTestGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		call    func() error
+		code    codes.Code
+		message string
+	}{
+		{"no credentials", func() error {
+			_, err := client.ListItems(ctx, &shoppinglistpb.ListItemsRequest{})
+			return err
+		}, codes.Unauthenticated, "a valid API key or access token is required"},
+		{"wrong key", func() error {
+			_, err := client.DeleteItem(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer nope"), &shoppinglistpb.DeleteItemRequest{Id: 1})
+			return err
+		}, codes.Unauthenticated, "a valid API key or access token is required"},
+		{"missing id", func() error {
+			_, err := client.DeleteItem(withKey(ctx), &shoppinglistpb.DeleteItemRequest{})
+			return err
+		}, codes.InvalidArgument, "id is required"},
+		{"list an API key can't use", func() error {
+			_, err := client.UpdateItem(withKey(ctx), &shoppinglistpb.UpdateItemRequest{ListId: proto.Int64(7), Id: 1, Item: proto.String("bread")})
+			return err
+		}, codes.NotFound, "list not found"},
+		{"bad due_by", func() error {
+			_, err := client.UpdateItem(withKey(ctx), &shoppinglistpb.UpdateItemRequest{Id: 1, DueBy: &timestamppb.Timestamp{Nanos: 2e9}})
+			return err
+		}, codes.InvalidArgument, ""},
+		{"watching a list an API key can't use", func() error {
+			stream, err := client.WatchChanges(withKey(ctx), &shoppinglistpb.WatchChangesRequest{ListId: proto.Int64(7)})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.NotFound, "list not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			s, _ := status.FromError(err)
+			if s.Code() != tt.code {
+				t.Fatalf("error = %v, want %v", err, tt.code)
+			}
+			if tt.message != "" && s.Message() != tt.message {
+				t.Errorf("message = %q, want %q", s.Message(), tt.message)
+			}
+		})
+	}
+}
+
+func TestGRPCWatchChanges(t *testing.T) {
+	client := newTestGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchChanges(withKey(ctx), &shoppinglistpb.WatchChangesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the headers come once the server has subscribed, so nothing published after them is missed
+	_, err = stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{ID: 8, Item: "coffee", Tags: []string{}}
+	hub.Publish(Event{Type: EventCreated, ListID: 0, ID: 8, Entry: &entry, Actor: "api-key", Time: time.Now()})
+
+	change, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Type != EventCreated || change.Id != 8 || change.GetItem().GetItem() != "coffee" || change.Actor != "api-key" || change.Seq == 0 {
+		t.Errorf("change = %v, want the coffee that was created", change)
+	}
+}
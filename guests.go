@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A guest token lets a visitor put items on a list and do nothing else, they can't read it, tick things off or delete anything
+// it is made by the list's owner and handed out as a link to a page with an add form, or used by a script with POST /guest/add
+// only a hash of the token is kept, like sessions, so it is shown once when it is made
+
+// guestToken is one of a list's guest tokens, Name says who it was given to and is what the audit log shows as guest:<name>
+type guestToken struct {
+	ID        int        `json:"id"`
+	ListID    int        `json:"list_id"`
+	Name      string     `json:"name"`
+	TokenHash string     `json:"token_hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Added counts the items added with the token, so the owner can see whether it is used
+	Added int `json:"added"`
+}
+
+// guestTokenRequest is the body of POST /lists/{id}/guest-tokens
+type guestTokenRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// guestTokenResponse is a guest token as the owner sees it, Token and URL are only filled in when it is made
+type guestTokenResponse struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Added     int        `json:"added"`
+	Token     string     `json:"token,omitempty"`
+	URL       string     `json:"url,omitempty"`
+}
+
+// guestAddRequest is a JSON body of POST /guest/add, a guest can only set what an item is and how many
+type guestAddRequest struct {
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Notes    string  `json:"notes"`
+}
+
+// guestTokensDoc is what the guest tokens subsystem saves
+type guestTokensDoc struct {
+	NextID int          `json:"next_id"`
+	Tokens []guestToken `json:"tokens"`
+}
+
+const guestTokensDocName = "guesttokens"
+
+// maxGuestNameLength keeps the name short enough to read in the audit log
+const maxGuestNameLength = 50
+
+// guestTokenRegistry holds the guest tokens in memory and saves them to the Store on every change
+type guestTokenRegistry struct {
+	store Store
+	doc   guestTokensDoc
+	// publicURL is -public-url, without it links are made from the Host of the request that asks for them
+	publicURL string
+}
+
+var guestTokens *guestTokenRegistry
+
+func loadGuestTokens(store Store, publicURL string) (*guestTokenRegistry, error) {
+	g := &guestTokenRegistry{store: store, publicURL: strings.TrimSuffix(publicURL, "/")}
+	err := store.LoadDoc(guestTokensDocName, &g.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if g.doc.Tokens == nil {
+		g.doc.Tokens = []guestToken{}
+	}
+	g.doc.NextID = max(g.doc.NextID, 1)
+	return g, nil
+}
+
+// Saves doc and only then makes it the current state, like userRegistry.save, so a failed write can't leave
+// a revoked token working again after a restart or a new one working only until then
+func (g *guestTokenRegistry) save(doc guestTokensDoc) error {
+	err := g.store.SaveDoc(guestTokensDocName, doc)
+	if err != nil {
+		return err
+	}
+	g.doc = doc
+	return nil
+}
+
+// For returns the list's guest tokens
+func (g *guestTokenRegistry) For(listID int) []guestToken {
+	tokens := []guestToken{}
+	for _, t := range g.doc.Tokens {
+		if t.ListID == listID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Add stores a new token for the list, giving it an ID
+func (g *guestTokenRegistry) Add(t guestToken) (guestToken, error) {
+	t.ID = g.doc.NextID
+	doc := g.doc
+	doc.NextID++
+	doc.Tokens = append(slices.Clone(g.doc.Tokens), t)
+	err := g.save(doc)
+	if err != nil {
+		return guestToken{}, err
+	}
+	return t, nil
+}
+
+// Delete takes the token away, ErrNotFound if the list doesn't have it
+func (g *guestTokenRegistry) Delete(listID int, id int) error {
+	doc := g.doc
+	doc.Tokens = slices.DeleteFunc(slices.Clone(g.doc.Tokens), func(t guestToken) bool {
+		return t.ListID == listID && t.ID == id
+	})
+	if len(doc.Tokens) == len(g.doc.Tokens) {
+		return ErrNotFound
+	}
+	return g.save(doc)
+}
+
+// Lookup returns the token that hashes to the same as token, false when there isn't one or it has expired
+func (g *guestTokenRegistry) Lookup(token string, now time.Time) (guestToken, bool) {
+	if token == "" {
+		return guestToken{}, false
+	}
+	hash := hashToken(token)
+	for _, t := range g.doc.Tokens {
+		if t.TokenHash == hash {
+			return t, t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+		}
+	}
+	return guestToken{}, false
+}
+
+// CountAdded adds n to the number of items added with the token
+func (g *guestTokenRegistry) CountAdded(id int, n int) error {
+	i := slices.IndexFunc(g.doc.Tokens, func(t guestToken) bool { return t.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	doc := g.doc
+	doc.Tokens = slices.Clone(g.doc.Tokens)
+	doc.Tokens[i].Added += n
+	return g.save(doc)
+}
+
+// URL is the page a guest opens to add items with the token
+func (g *guestTokenRegistry) URL(r *http.Request, token string) string {
+	base := g.publicURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/guest?token=" + url.QueryEscape(token)
+}
+
+func guestTokenToResponse(t guestToken) guestTokenResponse {
+	return guestTokenResponse{ID: t.ID, Name: t.Name, CreatedAt: t.CreatedAt, CreatedBy: t.CreatedBy, ExpiresAt: t.ExpiresAt, Added: t.Added}
+}
+
+// Handle Get request for the list's guest tokens, the tokens themselves can't be shown again
+func handleGetGuestTokens(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	resp := []guestTokenResponse{}
+	for _, t := range guestTokens.For(requestIdentity(r).ListID) {
+		resp = append(resp, guestTokenToResponse(t))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Handle Post request to make a guest token for the list, the response is the only time the token and its link are shown
+func handlePostGuestToken(w http.ResponseWriter, r *http.Request) {
+	var req guestTokenRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	req.Name = strings.TrimSpace(req.Name)
+	var errs []fieldError
+	if req.Name == "" || len(req.Name) > maxGuestNameLength {
+		errs = append(errs, fieldError{Field: "name", Message: "name is required and can be at most " + strconv.Itoa(maxGuestNameLength) + " characters"})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		errs = append(errs, fieldError{Field: "expires_at", Message: "expires_at has to be in the future"})
+	}
+	if errs != nil {
+		writeFieldErrors(w, "invalid_guest_token", errs)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		slog.Error("Error making guest token", "err", err)
+		writeInternalError(w)
+		return
+	}
+	id := requestIdentity(r)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	t, err := guestTokens.Add(guestToken{
+		ListID:    id.ListID,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		CreatedBy: id.Actor(),
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		slog.Error("Error saving guest token", "err", err)
+		writeInternalError(w)
+		return
+	}
+	resp := guestTokenToResponse(t)
+	resp.Token = token
+	resp.URL = guestTokens.URL(r, token)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// Handle Delete request to stop a guest token working
+func handleDeleteGuestToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.Atoi(r.PathValue("tokenId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", "guest token not found")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = guestTokens.Delete(requestIdentity(r).ListID, tokenID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "guest token not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting guest token", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// guestPage is the add form a guest link opens, like listPage it has no scripts or style sheets
+// it never shows what is on the list, only what the guest has just added
+var guestPage = template.Must(template.New("guest").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Add to the shopping list</title>
+</head>
+<body>
+<h1>Add to the shopping list</h1>
+{{if .Added}}<p>Thanks, {{.Added}} is on the list.</p>
+{{end}}{{if .Problem}}<p><strong>{{.Problem}}</strong></p>
+{{end}}<form method="post" action="/guest/add">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>Item <input name="item" required maxlength="{{.MaxItem}}" autofocus></label></p>
+<p><label>How many <input name="quantity" type="number" min="0" step="any"></label></p>
+<p><button type="submit">Add</button></p>
+</form>
+</body>
+</html>
+`))
+
+type guestPageData struct {
+	Token   string
+	Added   string
+	Problem string
+	MaxItem int
+}
+
+// Handle Get request for the add form of a guest link
+func handleGuestPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	mu.RLock()
+	_, ok := guestTokens.Lookup(token, time.Now())
+	mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "this guest link doesn't exist or has expired")
+		return
+	}
+	writeGuestPage(w, http.StatusOK, guestPageData{Token: token})
+}
+
+func writeGuestPage(w http.ResponseWriter, status int, data guestPageData) {
+	data.MaxItem = maxItemLength
+	var buf strings.Builder
+	err := guestPage.Execute(&buf, data)
+	if err != nil {
+		slog.Error("Error rendering HTML", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// the token is in the page, so it mustn't leak to other sites through the Referer or be kept by shared caches
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, status, "text/html; charset=utf-8", []byte(buf.String()))
+}
+
+// Handle Post request from a guest to put an item on the list, merged into one still on it the same way as POST /data?dedupe=merge
+// the token is a bearer token or a token form field, the item comes as JSON or from the guest page's form, which gets the page back
+// the response only echoes what was sent so a guest can't learn what else is on the list
+func handleGuestAdd(w http.ResponseWriter, r *http.Request) {
+	// the page never sends a bearer token, so with one the body is JSON even if a tool like curl labelled it a form
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	fromForm := mediaType == "application/x-www-form-urlencoded" && r.Header.Get("Authorization") == ""
+
+	var req guestAddRequest
+	var token string
+	if fromForm {
+		err := r.ParseForm()
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		token = r.PostForm.Get("token")
+		req.Item = r.PostForm.Get("item")
+		if s := r.PostForm.Get("quantity"); s != "" {
+			req.Quantity, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				writeGuestPage(w, http.StatusBadRequest, guestPageData{Token: token, Problem: "How many has to be a number"})
+				return
+			}
+		}
+	} else {
+		token, _ = bearerToken(r)
+		err := decodeStrict(r.Body, &req)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	entry := Entry{Item: strings.TrimSpace(req.Item), Quantity: req.Quantity, Unit: strings.TrimSpace(req.Unit), Notes: req.Notes}
+	if errs := entryErrors(entry); errs != nil {
+		if fromForm {
+			writeGuestPage(w, http.StatusBadRequest, guestPageData{Token: token, Problem: errs[0].Message})
+			return
+		}
+		writeFieldErrors(w, "invalid_entry", errs)
+		return
+	}
+	prepareNewEntry(&entry, now)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	guest, ok := guestTokens.Lookup(token, now)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="shoppinglist"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized", "a valid guest token is required")
+		return
+	}
+	actor := "guest:" + guest.Name
+	var updated, created []Entry
+	changes, err := recordChanges(func(tx Store) error {
+		var err error
+		_, updated, created, err = addMerging(tx, guest.ListID, []Entry{entry})
+		return err
+	})
+	if err != nil {
+		slog.Error("Error adding entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	auditChanges(actor, 0, "add", now, changes)
+	publishEntries(actor, EventUpdated, updated...)
+	publishEntries(actor, EventCreated, created...)
+	err = guestTokens.CountAdded(guest.ID, 1)
+	if err != nil {
+		// the item is on the list, only the count is behind
+		slog.Error("Error saving guest token", "err", err)
+	}
+
+	if fromForm {
+		writeGuestPage(w, http.StatusOK, guestPageData{Token: token, Added: entry.Item})
+		return
+	}
+	writeJSON(w, http.StatusCreated, guestAddRequest{Item: entry.Item, Quantity: entry.Quantity, Unit: entry.Unit, Notes: entry.Notes})
+}
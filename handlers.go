@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handle Get request to retrieve the entries on a list
+// ?completed=, ?q=, ?limit= and ?offset= narrow down what is returned and ?sort= with ?order= orders it
+// ?store= only returns entries to buy at that shop along with the ones that can be bought anywhere
+// ?tag= only returns entries with that tag, and can be given more than once to need every one of them
+// ?groupBy=category returns the entries in groups in the list's aisle order instead of as one array
+// X-Total-Count says how many entries matched before paging
+// the response has an ETag so clients that poll can send If-None-Match and get a 304 when nothing has changed
+// it is JSON unless the Accept header asks for text/plain, a checklist for curl, or text/html, a table for simple browsers
+func handleGet(w http.ResponseWriter, r *http.Request) {
+	query, err := parseEntryQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	// JSON comes first so clients that send */* or no Accept at all get what they always have
+	format := negotiate(r.Header.Get("Accept"), "application/json", "text/plain", "text/html")
+	if format == "" {
+		writeError(w, http.StatusNotAcceptable, "not_acceptable", "the list can be had as application/json, text/plain or text/html")
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	// Reads the entries on the caller's list and if it can't it will send a HTTP response to the client
+	entries, err := store.List(requestIdentity(r).ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	page, total := query.Apply(liveEntries(entries))
+	query.setPageHeaders(w, r, total)
+	var groups []categoryGroup
+	if query.GroupBy == "category" {
+		groups = groupByCategory(page, categories.For(requestIdentity(r).ListID))
+	}
+	switch format {
+	case "application/json":
+		if groups != nil {
+			writeJSONWithETag(w, r, groups)
+			return
+		}
+		writeJSONWithETag(w, r, page)
+		return
+	}
+
+	// the text and HTML lists are flat, grouping just puts the entries in aisle order
+	if groups != nil {
+		page = nil
+		for _, group := range groups {
+			page = append(page, group.Entries...)
+		}
+	}
+	if format == "text/plain" {
+		writeBodyTagged(w, r, "text/plain; charset=utf-8", renderPlainList(page), "")
+		return
+	}
+	body, err := renderHTMLList(page)
+	if err != nil {
+		slog.Error("Error rendering HTML", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeBodyTagged(w, r, "text/html; charset=utf-8", body, "")
+}
+
+// Handle Get request for a single entry, its ETag is its revision so it can be sent back in If-Match with a PUT or PATCH
+func handleGetEntry(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entry, err := getEntry(store, requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "entry not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSONTagged(w, r, entry, entryETag(entry))
+}
+
+// Handle Post request to append data to the store
+// the body is a single entry or an array of at least one, anything else e.g. null or [] is a 400, and the response is what they were added as with their IDs
+// in the same form, adding one entry also sets Location to where it can be fetched
+// with ?dedupe=merge an item that is already on the list and not ticked off has its quantity increased instead of being added again,
+// the response is then the entries each posted item ended up as, 200 if anything was merged and 201 if everything was new
+func handlePost(w http.ResponseWriter, r *http.Request) {
+	dedupe := r.URL.Query().Get("dedupe")
+	if dedupe != "" && dedupe != "merge" {
+		writeError(w, http.StatusBadRequest, "invalid_query", "dedupe has to be merge")
+		return
+	}
+
+	// io.ReadAll reads until the end of the body, net/http has already dealt with Content-Length and chunked bodies for us
+	// and withBodyLimit stops it once the body goes over -max-body-size
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("Error reading POST body", "err", err)
+		writeBodyError(w, err)
+		return
+	}
+
+	slog.Debug("Received POST body", "body", string(body))
+
+	var newEntries []Entry
+	// a body starting with { is one entry rather than an array of them
+	single := bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+	// json.Unmarshal converts json to go
+	// by passing a pointer this allows the function to modify the original. Using pointers is memory efficent so you aren't passing large data structures
+	// & is for memory address and * is used for accessing of modigying the value
+	if single {
+		var entry Entry
+		err = decodeStrict(bytes.NewReader(body), &entry)
+		newEntries = []Entry{entry}
+	} else {
+		err = decodeStrict(bytes.NewReader(body), &newEntries)
+	}
+	if emptyBody(len(newEntries), err) {
+		writeError(w, http.StatusBadRequest, "invalid_entry", entriesBodyMessage)
+		return
+	}
+	if err != nil {
+		slog.Error("Error parsing JSON", "err", err)
+		writeBodyError(w, err)
+		return
+	}
+	// checked on its own first so the fields are named as they were sent, "item" rather than "[0].item"
+	if single {
+		if errs := entryErrors(newEntries[0]); errs != nil {
+			writeFieldErrors(w, "invalid_entry", errs)
+			return
+		}
+	}
+	if errs := prepareNewEntries(newEntries, time.Now().UTC()); errs != nil {
+		writeFieldErrors(w, "invalid_entry", errs)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	if dedupe == "merge" {
+		var results, updated, created []Entry
+		err = trackChange(r, "add", func(tx Store) error {
+			results, updated, created, err = addMerging(tx, listID, newEntries)
+			return err
+		})
+		if err != nil {
+			slog.Error("Error adding entries", "err", err)
+			writeInternalError(w)
+			return
+		}
+		publishEntries(requestIdentity(r).Actor(), EventUpdated, updated...)
+		publishEntries(requestIdentity(r).Actor(), EventCreated, created...)
+		status := http.StatusCreated
+		if len(updated) > 0 {
+			status = http.StatusOK
+		}
+		if len(created) == 1 && len(results) == 1 {
+			w.Header().Set("Location", entryLocation(r, created[0].ID))
+		}
+		if single {
+			writeJSON(w, status, results[0])
+			return
+		}
+		writeJSON(w, status, results)
+		return
+	}
+
+	created, err := addEntries(r, listID, newEntries)
+	if err != nil {
+		slog.Error("Error adding entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	if len(created) == 1 {
+		w.Header().Set("Location", entryLocation(r, created[0].ID))
+	}
+	if single {
+		writeJSON(w, http.StatusCreated, created[0])
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// Returns the URL of an entry that r added, keeping ?list= so it points at the same list
+func entryLocation(r *http.Request, id int) string {
+	location := "/data/" + strconv.Itoa(id)
+	if list := r.URL.Query().Get("list"); list != "" {
+		location += "?list=" + url.QueryEscape(list)
+	}
+	return location
+}
+
+// Checks and prepares entries a client sent to be added, returning everything that is wrong with them or nil
+// nothing is prepared unless every entry is fine
+func prepareNewEntries(entries []Entry, now time.Time) []fieldError {
+	var errs []fieldError
+	for i := range entries {
+		for _, e := range entryErrors(entries[i]) {
+			errs = append(errs, fieldError{Field: "[" + strconv.Itoa(i) + "]." + e.Field, Message: "entry " + strconv.Itoa(i) + ": " + e.Message})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	for i := range entries {
+		prepareNewEntry(&entries[i], now)
+	}
+	return nil
+}
+
+// Adds prepared entries to the list and returns them with their IDs, callers must hold mu
+func addEntries(r *http.Request, listID int, entries []Entry) ([]Entry, error) {
+	canonicalItems(listID, entries)
+	var created []Entry
+	err := trackChange(r, "add", func(tx Store) error {
+		var err error
+		created, err = tx.Add(listID, entries)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	publishEntries(requestIdentity(r).Actor(), EventCreated, created...)
+	return created, nil
+}
+
+// Handle Put and Patch requests to change an existing entry in the store
+// PUT replaces every field with whatever is in the body, PATCH only changes the fields that were sent
+func handleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+
+	var body entryBody
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		slog.Error("Error parsing JSON", "err", err)
+		writeBodyError(w, err)
+		return
+	}
+	patch := body.EntryPatch
+	if errs := patchErrors(patch); errs != nil {
+		writeFieldErrors(w, "invalid_entry", errs)
+		return
+	}
+	revision, checkRev, err := expectedRevision(r, patch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_if_match", err.Error())
+		return
+	}
+	// PUT is a full replacement so a missing item is an error and any other missing field goes back to its default
+	if r.Method == http.MethodPut {
+		if patch.Item == nil {
+			writeError(w, http.StatusBadRequest, "invalid_entry", "item is required when replacing an entry with PUT")
+			return
+		}
+		if patch.Completed == nil {
+			completed := false
+			patch.Completed = &completed
+		}
+		if patch.Quantity == nil {
+			quantity := 0.0
+			patch.Quantity = &quantity
+		}
+		if patch.Unit == nil {
+			unit := ""
+			patch.Unit = &unit
+		}
+		if patch.Category == nil {
+			category := ""
+			patch.Category = &category
+		}
+		if patch.Tags == nil {
+			patch.Tags = &[]string{}
+		}
+		if patch.Priority == nil {
+			priority := PriorityNormal
+			patch.Priority = &priority
+		}
+		if patch.Notes == nil {
+			notes := ""
+			patch.Notes = &notes
+		}
+		if patch.Price == nil {
+			price := 0.0
+			patch.Price = &price
+		}
+		if patch.Currency == nil {
+			currency := ""
+			patch.Currency = &currency
+		}
+		if patch.Store == nil {
+			shop := ""
+			patch.Store = &shop
+		}
+		if !patch.DueBy.Set {
+			patch.DueBy = optionalTime{Set: true}
+		}
+	}
+
+	// held from the Get to the Update so nobody else can change the entry in between
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, err := getEntry(store, requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "entry not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	if checkRev {
+		if err := checkRevision(entry, revision); err != nil {
+			writeError(w, http.StatusConflict, "revision_conflict", err.Error())
+			return
+		}
+	}
+
+	// Merge the sent fields into the stored entry
+	wasCompleted := entry.Completed
+	applyPatch(&entry, patch)
+	entry.Revision++
+
+	err = trackChange(r, "update", func(tx Store) error {
+		return tx.Update(entry)
+	})
+	if err != nil {
+		slog.Error("Error updating entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// ticking an item off gets its own event type so clients can show it differently
+	if entry.Completed && !wasCompleted {
+		publishEntries(requestIdentity(r).Actor(), EventCompleted, entry)
+	} else {
+		publishEntries(requestIdentity(r).Actor(), EventUpdated, entry)
+	}
+
+	// Send the updated entry back so the client can see the result of the merge
+	w.Header().Set("ETag", entryETag(entry))
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// Handle Post request to tick an entry off
+func handleComplete(w http.ResponseWriter, r *http.Request) {
+	changeCompleted(w, r, true)
+}
+
+// Handle Post request to put a ticked off entry back on the list
+func handleUncomplete(w http.ResponseWriter, r *http.Request) {
+	changeCompleted(w, r, false)
+}
+
+func changeCompleted(w http.ResponseWriter, r *http.Request, completed bool) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, err := getEntry(store, requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "entry not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	// nothing to store if it is already in the state asked for
+	if entry.Completed != completed {
+		setCompleted(&entry, completed)
+		entry.Revision++
+		action := "uncomplete"
+		if completed {
+			action = "complete"
+		}
+		err = trackChange(r, action, func(tx Store) error {
+			return tx.Update(entry)
+		})
+		if err != nil {
+			slog.Error("Error updating entry", "err", err)
+			writeInternalError(w)
+			return
+		}
+		if completed {
+			publishEntries(requestIdentity(r).Actor(), EventCompleted, entry)
+		} else {
+			publishEntries(requestIdentity(r).Actor(), EventUpdated, entry)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// Handle Delete request to move the entry with the given ID to the trash, POST /data/{id}/restore brings it back
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	err := trackChange(r, "delete", func(tx Store) error {
+		_, err := softDelete(tx, listID, id, time.Now().UTC())
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "entry not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	publishEntries(requestIdentity(r).Actor(), EventDeleted, Entry{ID: id, ListID: listID})
+
+	// 204 No Content because there is nothing to send back once the entry is gone
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Extracts the entry ID from the {id} part of a /data/{id} route
+// returns false if the ID isn't a number e.g. "/data/3" gives 3 but "/data/abc" is rejected
+func parseEntryID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// Encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error marshalling JSON", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeBody(w, status, "application/json", body)
+}
+
+// Writes a whole response body in one go with its Content-Type and Content-Length
+// without the length net/http falls back to a chunked response once a body is over a couple of KB, which some strict clients and proxies don't like
+// net/http adds the Date header to every response itself, and bodyless responses get Content-Length: 0
+// the event streams are the only responses that can't have a length because they never end
+func writeBody(w http.ResponseWriter, status int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Writes v as a 200 response with an ETag made from a hash of the body, gzipped if the client accepts it
+// if the client already has that version (If-None-Match) it gets 304 Not Modified and no body
+// the tag comes from the content, so any change to the list changes it and it stays valid across restarts
+// it is a weak tag because the gzipped and plain bodies share it, they are the same JSON but not the same bytes
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONTagged(w, r, v, "")
+}
+
+// Does the same as writeJSONWithETag with the given ETag, or the hash of the body if etag is ""
+func writeJSONTagged(w http.ResponseWriter, r *http.Request, v any, etag string) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error marshalling JSON", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeBodyTagged(w, r, "application/json", body, etag)
+}
+
+// Does the same as writeJSONTagged for a body that is already written out
+func writeBodyTagged(w http.ResponseWriter, r *http.Request, contentType string, body []byte, etag string) {
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	w.Header().Set("ETag", etag)
+	// ETags differ per query and per user so caches have to check with the server every time
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeMaybeGzipped(w, r, http.StatusOK, contentType, body)
+}
+
+// Reports whether an If-None-Match header lists etag, using the weak comparison the RFC asks for so W/ prefixes are ignored
+func etagMatches(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
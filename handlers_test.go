@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Loads a registry from the test store or stops the test
+func mustLoad[T any](t *testing.T, load func(Store) (T, error)) T {
+	t.Helper()
+	registry, err := load(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// Returns the router as the server runs it, over a new JSON store in a temporary directory, with the API key "test-key"
+// the globals are set up the way main does it for everything the entry routes use
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	var err error
+	store, err = openStore(Config{Storage: "json", DataFile: filepath.Join(t.TempDir(), "data.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	err = migrateData(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users = mustLoad(t, func(s Store) (*userRegistry, error) { return loadUsers(s, time.Hour) })
+	budgets = mustLoad(t, loadBudgets)
+	webhooks = mustLoad(t, loadWebhooks)
+	conflicts = mustLoad(t, loadConflicts)
+	reminders = mustLoad(t, func(s Store) (*reminderEngine, error) { return loadReminders(s, nil) })
+	digests = mustLoad(t, func(s Store) (*digestRegistry, error) { return loadDigests(s, nil, "") })
+	recurring = mustLoad(t, loadRecurring)
+	shops = mustLoad(t, loadShops)
+	trips = mustLoad(t, loadTrips)
+	recipes = mustLoad(t, loadRecipes)
+	mealPlan = mustLoad(t, loadMealPlan)
+	shareLinks = mustLoad(t, func(s Store) (*shareLinkRegistry, error) { return loadShareLinks(s, "") })
+	guestTokens = mustLoad(t, func(s Store) (*guestTokenRegistry, error) { return loadGuestTokens(s, "") })
+	categories = mustLoad(t, loadCategories)
+	undos = mustLoad(t, loadUndoLog)
+	aliases = mustLoad(t, loadAliases)
+	units = mustLoad(t, loadUnits)
+	itemNames = mustLoad(t, func(s Store) (*itemIndex, error) { return buildItemIndex(s, trips) })
+	return withValidQuery(newRouter(Config{}, requireAuth([]string{"test-key"}, false, nil), nil))
+}
+
+// Sends a request with the test API key and returns the response
+func serveTest(handler http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer test-key")
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestPostRejectsEmptyBody(t *testing.T) {
+	handler := newTestServer(t)
+	for _, body := range []string{"", " ", "null", "[]", "5", `"milk"`} {
+		t.Run(body, func(t *testing.T) {
+			w := serveTest(handler, "POST", "/data", body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			var resp errorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != "invalid_entry" || resp.Error.Message != entriesBodyMessage {
+				t.Errorf("error = %+v, want invalid_entry with %q", resp.Error, entriesBodyMessage)
+			}
+		})
+	}
+	assertItems(t)
+}
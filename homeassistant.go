@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Home Assistant has no MQTT to-do platform, so with -mqtt-discovery the bridge announces the list as two entities it does have:
+// a sensor whose state is how many items are still to get, with the items as its attributes, and a text entity that adds what is typed into it
+// they are announced with retained config messages under the discovery prefix, so they show up in Home Assistant without any YAML
+// https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery
+
+// haDevice groups the entities under one device in Home Assistant
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// haEntityConfig is a discovery config, fields a platform doesn't use are left out
+type haEntityConfig struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	Icon                string   `json:"icon"`
+	AvailabilityTopic   string   `json:"availability_topic"`
+	PayloadAvailable    string   `json:"payload_available"`
+	PayloadNotAvailable string   `json:"payload_not_available"`
+	StateTopic          string   `json:"state_topic,omitempty"`
+	ValueTemplate       string   `json:"value_template,omitempty"`
+	JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	UnitOfMeasurement   string   `json:"unit_of_measurement,omitempty"`
+	CommandTopic        string   `json:"command_topic,omitempty"`
+	Max                 int      `json:"max,omitempty"`
+	Device              haDevice `json:"device"`
+}
+
+// haListState is what is published to <prefix>/lists/<id>/state, the sensor's state is Open and the rest are its attributes
+type haListState struct {
+	Open  int           `json:"open"`
+	Items []haStateItem `json:"items"`
+}
+
+type haStateItem struct {
+	ID       int     `json:"id"`
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+}
+
+// The topic the state of the bridge's list is published on
+func (b *mqttBridge) stateTopic() string {
+	return b.prefix + "/lists/" + strconv.Itoa(b.listID) + "/state"
+}
+
+// Publishes the discovery configs for the bridge's list, retained so Home Assistant finds them whenever it starts
+func (b *mqttBridge) publishDiscovery() error {
+	node := "shoppinglist_" + strconv.Itoa(b.listID)
+	device := haDevice{Identifiers: []string{node}, Name: "Shopping list " + strconv.Itoa(b.listID), Manufacturer: "shoppinglist"}
+	configs := map[string]haEntityConfig{
+		"sensor/" + node + "/open/config": {
+			Name:                "Shopping list",
+			UniqueID:            node + "_open",
+			Icon:                "mdi:cart",
+			StateTopic:          b.stateTopic(),
+			ValueTemplate:       "{{ value_json.open }}",
+			JSONAttributesTopic: b.stateTopic(),
+			UnitOfMeasurement:   "items",
+		},
+		"text/" + node + "/add/config": {
+			Name:         "Add to shopping list",
+			UniqueID:     node + "_add",
+			Icon:         "mdi:cart-plus",
+			CommandTopic: b.prefix + "/command",
+			Max:          maxItemLength,
+		},
+	}
+	for topic, config := range configs {
+		config.AvailabilityTopic = b.prefix + "/status"
+		config.PayloadAvailable = "online"
+		config.PayloadNotAvailable = "offline"
+		config.Device = device
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		err = b.write(mqttPublishPacket(b.discovery+"/"+topic, payload, true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publishes what is still to get on the bridge's list, retained so the sensor has a state as soon as Home Assistant subscribes
+func (b *mqttBridge) publishState() error {
+	mu.RLock()
+	entries, err := store.List(b.listID)
+	mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	state := haListState{Items: []haStateItem{}}
+	for _, entry := range liveEntries(entries) {
+		if !entry.Completed {
+			state.Items = append(state.Items, haStateItem{ID: entry.ID, Item: entry.Item, Quantity: entry.Quantity, Unit: entry.Unit})
+		}
+	}
+	state.Open = len(state.Items)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return b.write(mqttPublishPacket(b.stateTopic(), payload, true))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The most a client can put in an Idempotency-Key, UUIDs are the usual choice and are far shorter
+const maxIdempotencyKeyLength = 255
+
+// idempotencyCache remembers the responses to requests sent with an Idempotency-Key header
+// a client that retries a POST after losing the response gets the first response again instead of the change being made twice
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	responses map[string]*storedResponse
+	lastSweep time.Time
+}
+
+type storedResponse struct {
+	// bodyHash catches a key being reused for a different request
+	bodyHash [sha256.Size]byte
+	// done is closed once the first request has finished, until then the rest of the fields aren't set
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, responses: make(map[string]*storedResponse), lastSweep: time.Now()}
+}
+
+// Returns middleware that makes a handler idempotent for requests with an Idempotency-Key header, requests without one are passed straight through
+// keys are per caller and per route so two users picking the same key can't see each other's responses
+// it goes inside the auth middleware because it needs to know who the caller is
+func (c *idempotencyCache) Wrap(next http.Handler) http.Handler {
+	if c.ttl <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key can be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+			return
+		}
+
+		// the body is read up front so it can be compared with the first request's, then put back for the handler
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		cacheKey := requestIdentity(r).Caller() + " " + r.Method + " " + r.URL.RequestURI() + " " + key
+
+		bodyHash := sha256.Sum256(body)
+		stored, first := c.start(cacheKey, bodyHash)
+		if !first {
+			if stored.bodyHash != bodyHash {
+				writeError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "this Idempotency-Key was already used for a different request")
+				return
+			}
+			select {
+			case <-stored.done:
+			default:
+				writeError(w, http.StatusConflict, "request_in_progress", "a request with this Idempotency-Key is still being handled, retry shortly")
+				return
+			}
+			maps.Copy(w.Header(), stored.header)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w}
+		// deferred so the key is let go of even when the handler panics, net/http recovers from it
+		// but the key would otherwise be in progress for ever and the sweep would never remove it
+		panicked := true
+		defer func() {
+			if panicked {
+				// counted as a server error so the key is forgotten and a retry gets another go
+				capture.status = http.StatusInternalServerError
+			}
+			c.finish(cacheKey, stored, capture)
+		}()
+		next.ServeHTTP(capture, r)
+		panicked = false
+	})
+}
+
+// Looks the key up and if it is new claims it for this request, returning true
+func (c *idempotencyCache) start(key string, bodyHash [sha256.Size]byte) (*storedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, stored := range c.responses {
+			if !stored.expires.IsZero() && now.After(stored.expires) {
+				delete(c.responses, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	stored, ok := c.responses[key]
+	if ok && (stored.expires.IsZero() || now.Before(stored.expires)) {
+		return stored, false
+	}
+	stored = &storedResponse{bodyHash: bodyHash, done: make(chan struct{})}
+	c.responses[key] = stored
+	return stored, true
+}
+
+// Keeps the response to replay it, server errors are forgotten so a retry gets another go
+func (c *idempotencyCache) finish(key string, stored *storedResponse, capture *responseCapture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := capture.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= 500 {
+		delete(c.responses, key)
+	} else {
+		stored.status = status
+		stored.header = capture.Header().Clone()
+		stored.body = capture.body.Bytes()
+		stored.expires = time.Now().Add(c.ttl)
+	}
+	close(stored.done)
+}
+
+// responseCapture passes a response through to the client while keeping a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	if rc.status == 0 {
+		rc.status = status
+	}
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
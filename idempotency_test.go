@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A handler that panics lets go of its Idempotency-Key, so a retry is handled rather than told it is in progress for ever
+func TestIdempotencyKeyAfterPanic(t *testing.T) {
+	calls := 0
+	handler := newIdempotencyCache(time.Hour).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/data", strings.NewReader(`{"item": "milk"}`))
+		r.Header.Set("Idempotency-Key", "retry-me")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the handler's panic didn't reach net/http")
+			}
+		}()
+		send()
+	}()
+
+	w := send()
+	if w.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("retry status = %d after %d calls, want %d from a second call", w.Code, calls, http.StatusCreated)
+	}
+	w = send()
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" || calls != 2 {
+		t.Errorf("second retry status = %d replayed %q after %d calls, want the second call's response replayed", w.Code, w.Header().Get("Idempotent-Replayed"), calls)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// claims is the payload of the access tokens handed out on login
+// the user's ID, name and list are in the token itself so the auth middleware doesn't have to look anything up per request
+type claims struct {
+	Subject   string `json:"sub"`
+	UserID    int    `json:"uid"`
+	Username  string `json:"name"`
+	ListID    int    `json:"lid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// tokenIssuer signs and checks access tokens, they are JWTs signed with HMAC-SHA256 (HS256)
+type tokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+var tokens *tokenIssuer
+
+// jwtHeader never changes because only HS256 is used, so it is encoded once
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// jwtSecretDocName is the Store document a generated signing secret is saved under
+const jwtSecretDocName = "jwt"
+
+// Sets up the token issuer with the configured secret
+// when no secret is configured one is generated on first start and saved, so tokens stay valid across restarts
+func newTokenIssuer(store Store, secret string, ttl time.Duration) (*tokenIssuer, error) {
+	if secret != "" {
+		return &tokenIssuer{secret: []byte(secret), ttl: ttl}, nil
+	}
+
+	var doc struct {
+		Secret []byte `json:"secret"`
+	}
+	err := store.LoadDoc(jwtSecretDocName, &doc)
+	if errors.Is(err, ErrNotFound) {
+		doc.Secret = make([]byte, 32)
+		rand.Read(doc.Secret)
+		err = store.SaveDoc(jwtSecretDocName, doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tokenIssuer{secret: doc.Secret, ttl: ttl}, nil
+}
+
+// Issue returns a signed access token for user and when it expires
+func (t *tokenIssuer) Issue(user User) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(t.ttl)
+	payload, err := json.Marshal(claims{
+		Subject:   strconv.Itoa(user.ID),
+		UserID:    user.ID,
+		Username:  user.Username,
+		ListID:    user.ListID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + t.sign(unsigned), expires, nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims
+func (t *tokenIssuer) Verify(token string) (claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims{}, ErrInvalidToken
+	}
+	// the header has to be exactly ours, which rules out tricks like "alg":"none"
+	if parts[0] != jwtHeader {
+		return claims{}, ErrInvalidToken
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(unsigned))) {
+		return claims{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims{}, ErrInvalidToken
+	}
+	var c claims
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return claims{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return claims{}, ErrExpiredToken
+	}
+	return c, nil
+}
+
+func (t *tokenIssuer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
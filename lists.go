@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Permission is how much a user can do with a list
+type Permission string
+
+const (
+	PermissionNone  Permission = ""
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	// PermissionOwner can do everything including sharing, it can't be given away with a share
+	PermissionOwner Permission = "owner"
+)
+
+// Returns how permissions compare so "at least write" checks are a number comparison
+func (p Permission) rank() int {
+	switch p {
+	case PermissionRead:
+		return 1
+	case PermissionWrite:
+		return 2
+	case PermissionOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Allows reports whether p is at least need
+func (p Permission) Allows(need Permission) bool {
+	return p.rank() >= need.rank()
+}
+
+// Share gives another user access to a list
+type Share struct {
+	UserID     int        `json:"user_id"`
+	Permission Permission `json:"permission"`
+}
+
+var ErrUserNotFound = errors.New("user not found")
+
+// ListAccess returns what user can do with the list, PermissionNone if the list doesn't exist or isn't shared with them
+// an admin can do everything with every list, as if they owned it
+func (u *userRegistry) ListAccess(userID int, listID int) Permission {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	list, ok := u.listByID(listID)
+	if !ok {
+		return PermissionNone
+	}
+	if user, ok := u.userByID(userID); ok && user.actsAsAdmin() {
+		return PermissionOwner
+	}
+	return list.access(userID)
+}
+
+// ListsFor returns every list user owns or has been shared
+func (u *userRegistry) ListsFor(userID int) []List {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	lists := []List{}
+	for _, list := range u.doc.Lists {
+		if list.access(userID) != PermissionNone {
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+// ShareList gives username permission on the list, replacing any share they already had
+// PermissionNone removes their share
+func (u *userRegistry) ShareList(listID int, username string, permission Permission) (List, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	index := -1
+	for i := range u.doc.Lists {
+		if u.doc.Lists[i].ID == listID {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return List{}, ErrNotFound
+	}
+	userID := 0
+	for _, user := range u.doc.Users {
+		if strings.EqualFold(user.Username, username) {
+			userID = user.ID
+			break
+		}
+	}
+	if userID == 0 {
+		return List{}, ErrUserNotFound
+	}
+
+	// a copy of the list so the stored one only changes once the save has worked
+	list := u.doc.Lists[index]
+	shares := []Share{}
+	for _, share := range list.Shares {
+		if share.UserID != userID {
+			shares = append(shares, share)
+		}
+	}
+	if permission != PermissionNone && userID != list.OwnerID {
+		shares = append(shares, Share{UserID: userID, Permission: permission})
+	}
+	list.Shares = shares
+
+	doc := u.doc
+	doc.Lists = append([]List{}, u.doc.Lists...)
+	doc.Lists[index] = list
+	err := u.save(doc)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// callers must hold u.mu
+func (u *userRegistry) listByID(id int) (List, bool) {
+	for _, list := range u.doc.Lists {
+		if list.ID == id {
+			return list, true
+		}
+	}
+	return List{}, false
+}
+
+// Returns what userID can do with the list
+func (l List) access(userID int) Permission {
+	if l.OwnerID == userID {
+		return PermissionOwner
+	}
+	for _, share := range l.Shares {
+		if share.UserID == userID {
+			return share.Permission
+		}
+	}
+	return PermissionNone
+}
+
+// Returns middleware that picks the list a request works on and checks the caller is allowed to do need with it
+// the list is the caller's own unless ?list=<id> names another one, and the one picked replaces identity.ListID for the handler
+// a list the caller can't see at all gets 404 rather than 403 so list IDs can't be probed
+func withList(need Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listParam := r.URL.Query().Get("list")
+		if listParam == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		listID, err := strconv.Atoi(listParam)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "list has to be a list ID")
+			return
+		}
+		serveWithList(w, r, listID, need, next)
+	})
+}
+
+// withPathList is withList for /lists/{id}/... routes where the list is part of the path
+func withPathList(need Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeListNotFound(w)
+			return
+		}
+		serveWithList(w, r, listID, need, next)
+	})
+}
+
+// Checks the caller can do need with the list and if so passes the request on with identity.ListID set to it
+func serveWithList(w http.ResponseWriter, r *http.Request, listID int, need Permission, next http.Handler) {
+	id := requestIdentity(r)
+	// API keys and -no-auth only ever work on list 0
+	if id.User == nil {
+		if listID != 0 {
+			writeListNotFound(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	permission := users.ListAccess(id.User.ID, listID)
+	if permission == PermissionNone {
+		writeListNotFound(w)
+		return
+	}
+	if !permission.Allows(need) {
+		writeError(w, http.StatusForbidden, "forbidden", "this list has only been shared with you as "+string(permission.Role())+", this needs "+string(need.Role()))
+		return
+	}
+	id.ListID = listID
+	ctx := context.WithValue(r.Context(), identityKey, id)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// The same answer for a list that doesn't exist and one the caller can't see
+func writeListNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "list_not_found", "list not found")
+}
+
+// listResponse is a list along with what the caller can do with it, as both a permission and the role it gives
+type listResponse struct {
+	List
+	Permission Permission `json:"permission"`
+	Role       Role       `json:"role"`
+}
+
+func newListResponse(list List, permission Permission) listResponse {
+	return listResponse{List: list, Permission: permission, Role: permission.Role()}
+}
+
+// Handle Get request for every list the caller owns or has been shared
+func handleGetLists(w http.ResponseWriter, r *http.Request) {
+	user := requestIdentity(r).User
+	if user == nil {
+		writeError(w, http.StatusNotFound, "no_account", "lists belong to user accounts, log in to see yours")
+		return
+	}
+	lists := []listResponse{}
+	for _, list := range users.ListsFor(user.ID) {
+		lists = append(lists, newListResponse(list, list.access(user.ID)))
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+// shareRequest is the body of POST /lists/{id}/share
+// permission is the role to give, viewer or editor, read and write from before roles still work
+type shareRequest struct {
+	Username   string `json:"username"`
+	Permission string `json:"permission"`
+}
+
+// Handle Post request to share a list with another user, only the owner or an admin can do this
+// sending "permission": "none" or DELETE /lists/{id}/share/{username} takes a share away
+func handleShareList(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Username == "" {
+		writeError(w, http.StatusBadRequest, "invalid_share", "username is required")
+		return
+	}
+	permission, ok := parseSharePermission(req.Permission)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_share", "permission has to be viewer, editor or none")
+		return
+	}
+	changeShare(w, r, req.Username, permission)
+}
+
+// Handle Delete request to take a user's access to a list away
+func handleUnshareList(w http.ResponseWriter, r *http.Request) {
+	changeShare(w, r, r.PathValue("username"), PermissionNone)
+}
+
+func changeShare(w http.ResponseWriter, r *http.Request, username string, permission Permission) {
+	user := requestIdentity(r).User
+	listID, err := strconv.Atoi(r.PathValue("id"))
+	if user == nil || err != nil {
+		writeListNotFound(w)
+		return
+	}
+	access := users.ListAccess(user.ID, listID)
+	if access == PermissionNone {
+		writeListNotFound(w)
+		return
+	}
+	if access != PermissionOwner {
+		writeError(w, http.StatusForbidden, "forbidden", "only the owner of a list or an admin can share it")
+		return
+	}
+
+	list, err := users.ShareList(listID, username, permission)
+	if errors.Is(err, ErrNotFound) {
+		writeListNotFound(w)
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+	if err != nil {
+		slog.Error("Error sharing list", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, newListResponse(list, access))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginThrottle counts failed logins per username and per client IP and locks either out once it has too many
+// every failure past the limit doubles the lockout up to maxLockout, so guessing passwords gets slower the longer it goes on
+// usernames that don't exist are counted the same as real ones so a lockout doesn't give away which accounts exist
+// someone can lock a real user out by failing on purpose, which is why the lockout is short to start with and ends by itself
+type loginThrottle struct {
+	// accountLimit and ipLimit are how many failures in a row lock a username or IP out, 0 turns that lockout off
+	accountLimit int
+	ipLimit      int
+	lockout      time.Duration
+	maxLockout   time.Duration
+
+	mu        sync.Mutex
+	accounts  map[string]*loginFailures
+	ips       map[string]*loginFailures
+	lastSweep time.Time
+}
+
+// loginFailures is the failed logins of one username or IP
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+var logins *loginThrottle
+
+func newLoginThrottle(cfg Config) *loginThrottle {
+	return &loginThrottle{
+		accountLimit: cfg.LoginFailures,
+		ipLimit:      cfg.LoginIPFailures,
+		lockout:      cfg.LoginLockout,
+		maxLockout:   cfg.LoginMaxLockout,
+		accounts:     make(map[string]*loginFailures),
+		ips:          make(map[string]*loginFailures),
+		lastSweep:    time.Now(),
+	}
+}
+
+// Check returns how long until username can try to log in again from ip, 0 if it can now
+func (t *loginThrottle) Check(username string, ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wait := time.Duration(0)
+	if f := t.current(t.accounts, strings.ToLower(username), now); f != nil {
+		wait = max(wait, f.lockedUntil.Sub(now))
+	}
+	if f := t.current(t.ips, ip, now); f != nil {
+		wait = max(wait, f.lockedUntil.Sub(now))
+	}
+	if wait > 0 {
+		slog.Warn("Security event", "event", "login_blocked", "user", username, "remote", ip, "retry_in", wait.Round(time.Second))
+	}
+	return wait
+}
+
+// Failed records a failed login and locks the username or IP out if that was one too many
+func (t *loginThrottle) Failed(username string, ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) > rateLimitSweepInterval {
+		t.sweep(now)
+	}
+	accountLock := t.fail(t.accounts, strings.ToLower(username), t.accountLimit, now)
+	ipLock := t.fail(t.ips, ip, t.ipLimit, now)
+
+	slog.Warn("Security event", "event", "login_failed", "user", username, "remote", ip,
+		"user_failures", t.accounts[strings.ToLower(username)].count, "remote_failures", t.ips[ip].count)
+	if accountLock > 0 {
+		slog.Warn("Security event", "event", "account_locked", "user", username, "remote", ip, "for", accountLock)
+	}
+	if ipLock > 0 {
+		slog.Warn("Security event", "event", "remote_locked", "user", username, "remote", ip, "for", ipLock)
+	}
+}
+
+// Succeeded forgets the username's failures, the IP's are kept so one account that works can't be used to reset them between guesses at others
+func (t *loginThrottle) Succeeded(username string, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := strings.ToLower(username)
+	if f, ok := t.accounts[key]; ok {
+		slog.Info("Security event", "event", "login_succeeded_after_failures", "user", username, "remote", ip, "failures", f.count)
+		delete(t.accounts, key)
+	}
+}
+
+// Returns the failures for key, nil when there aren't any or they are old enough to have been forgotten, callers must hold t.mu
+// failures are forgotten once there hasn't been one for maxLockout, by then any lockout they caused is over
+func (t *loginThrottle) current(failures map[string]*loginFailures, key string, now time.Time) *loginFailures {
+	f, ok := failures[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(f.last) > t.maxLockout {
+		delete(failures, key)
+		return nil
+	}
+	return f
+}
+
+// Counts a failure for key and returns how long it is now locked out for, 0 when it isn't, callers must hold t.mu
+func (t *loginThrottle) fail(failures map[string]*loginFailures, key string, limit int, now time.Time) time.Duration {
+	f := t.current(failures, key, now)
+	if f == nil {
+		f = &loginFailures{}
+		failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if limit == 0 || f.count < limit {
+		return 0
+	}
+	// the shift is capped so a long run of failures can't overflow, maxLockout is reached long before then anyway
+	lockout := t.lockout << min(f.count-limit, 30)
+	if lockout <= 0 || lockout > t.maxLockout {
+		lockout = t.maxLockout
+	}
+	f.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Drops failures old enough to have been forgotten so usernames that were only tried once don't pile up, callers must hold t.mu
+func (t *loginThrottle) sweep(now time.Time) {
+	for _, failures := range []map[string]*loginFailures{t.accounts, t.ips} {
+		for key, f := range failures {
+			if now.Sub(f.last) > t.maxLockout {
+				delete(failures, key)
+			}
+		}
+	}
+	t.lastSweep = now
+}
+
+// The IP a login came from, the same one withRateLimit goes by
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Answers a login that came while its username or IP was locked out
+// it is 429 rather than 401 so a client can tell waiting will help, Retry-After is rounded up to whole seconds like withRateLimit
+func writeLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "login_locked", "too many failed logins, try again in "+wait.Round(time.Second).String())
+}
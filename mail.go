@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailer sends plain text emails through the SMTP server set with -smtp-addr
+type mailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+// Returns nil when no SMTP server is configured, anything that sends email has to check for that
+func newMailer(cfg Config) *mailer {
+	if cfg.SMTPAddr == "" {
+		return nil
+	}
+	return &mailer{addr: cfg.SMTPAddr, from: cfg.SMTPFrom, username: cfg.SMTPUsername, password: cfg.SMTPPassword}
+}
+
+// Reports whether address can be sent to, anything that could start another header in the To line is refused
+func validEmailAddress(address string) bool {
+	return strings.Contains(address, "@") && !strings.ContainsAny(address, "\r\n,<> ")
+}
+
+// Send emails body to every address in to
+// smtp.SendMail upgrades to TLS with STARTTLS when the server offers it, and PLAIN auth is only used over TLS or to localhost
+func (m *mailer) Send(to []string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", "").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.addr, auth, m.from, to, []byte(msg.String()))
+}
@@ -1,254 +1,709 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rachvm/shoppingList/shoppinglistpb"
 )
 
 type Entry struct {
-	ID    int    `json:"id"`
-	Item  string `json:"item"`
-	Completed bool `json:"completed"`
+	ID        int     `json:"id"`
+	Item      string  `json:"item"`
+	Completed bool    `json:"completed"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+	// Category is where the item is found in the shop e.g. produce or dairy, "" when it hasn't been given one
+	Category string `json:"category"`
+	// Tags are free-form labels e.g. "urgent" or "birthday-party", always lowercase and never repeated
+	Tags []string `json:"tags"`
+	// Priority is "low", "normal", "high" or "urgent"
+	Priority string `json:"priority"`
+	// Notes is anything else worth knowing e.g. "the lactose-free one, blue carton"
+	Notes string `json:"notes"`
+	// Price is what one of Unit costs, 0 when it isn't known, so the entry costs Price * Quantity
+	Price float64 `json:"price"`
+	// Currency is a three letter code like EUR, "" means the list's budget currency
+	Currency string `json:"currency"`
+	// Store is the shop the item is to be bought at e.g. "Aldi", "" when any will do
+	Store string `json:"store"`
+	// DueBy is when the item is needed by, a reminder goes out once it passes, null when there is no rush
+	DueBy *time.Time `json:"due_by"`
+	// ListID is the list the entry is on, entries from before lists existed are on list 0
+	ListID int `json:"list_id"`
+	// CreatedAt is when the entry was added, entries added before it was recorded were given the time the server was upgraded
+	CreatedAt *time.Time `json:"created_at"`
+	// CompletedAt is when the entry was ticked off, null while it isn't
+	CompletedAt *time.Time `json:"completed_at"`
+	// DeletedAt is when the entry was moved to the trash, it is only ever set on entries from GET /trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Revision goes up by one every time the stored entry changes, starting at 1, so every Update has to increment it
+	// a PUT or PATCH that sends the revision it was made to gets 409 if someone else has changed the entry since
+	Revision int `json:"revision"`
+	// ClientID is the ID a client gave the entry when it added it offline, "" for entries added any other way
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// EntryPatch is used by PATCH to tell apart fields that were left out of the body from fields set to their zero value
+// e.g. {"completed": false} has to un-tick an item rather than be ignored, so every field is a pointer and nil means "not sent"
+type EntryPatch struct {
+	Item      *string   `json:"item"`
+	Completed *bool     `json:"completed"`
+	Quantity  *float64  `json:"quantity"`
+	Unit      *string   `json:"unit"`
+	Category  *string   `json:"category"`
+	Tags      *[]string `json:"tags"`
+	Priority  *string   `json:"priority"`
+	Notes     *string   `json:"notes"`
+	Price     *float64  `json:"price"`
+	Currency  *string   `json:"currency"`
+	Store     *string   `json:"store"`
+	// DueBy is an optionalTime rather than a pointer so that "due_by": null can clear it
+	DueBy optionalTime `json:"due_by"`
+	// Revision is the revision the change was made to, it is checked rather than applied, If-Match does the same
+	Revision *int `json:"revision"`
+}
+
+// optionalTime is a time in a PATCH body that can be left out, set, or set to null
+type optionalTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (t *optionalTime) UnmarshalJSON(data []byte) error {
+	t.Set = true
+	return json.Unmarshal(data, &t.Time)
+}
+
+// Fills in defaults for fields that older data.json files don't have
+// an entry saved before quantities existed unmarshals with Quantity 0, which really meant "one of them"
+func normalizeEntry(entry *Entry) {
+	if entry.Quantity == 0 {
+		entry.Quantity = 1
+	}
+	entry.Category = normalizeCategory(entry.Category)
+	entry.Tags = normalizeTags(entry.Tags)
+	entry.Priority = strings.ToLower(strings.TrimSpace(entry.Priority))
+	if entry.Priority == "" {
+		entry.Priority = PriorityNormal
+	}
+	entry.Notes = strings.TrimSpace(entry.Notes)
+	entry.Currency = strings.ToUpper(strings.TrimSpace(entry.Currency))
+	entry.Store = strings.Join(strings.Fields(entry.Store), " ")
+	if entry.DueBy != nil {
+		due := entry.DueBy.UTC()
+		entry.DueBy = &due
+	}
+}
+
+// maxNotesLength is the most characters an entry's notes can have, set from -max-notes-length
+var maxNotesLength = 500
+
+// Ticks an entry off or back on, keeping CompletedAt in step with Completed
+// ticking off an entry that is already completed keeps its original time
+func setCompleted(entry *Entry, completed bool) {
+	if completed && !entry.Completed {
+		now := time.Now().UTC()
+		entry.CompletedAt = &now
+	}
+	if !completed {
+		entry.CompletedAt = nil
+	}
+	entry.Completed = completed
+}
+
+// Fills in what the server decides about a new entry before it is stored
+// a new entry that is already ticked off was completed now, whatever CompletedAt the client sent
+func prepareNewEntry(entry *Entry, now time.Time) {
+	normalizeEntry(entry)
+	entry.CreatedAt = &now
+	entry.DeletedAt = nil
+	entry.Revision = 1
+	completed := entry.Completed
+	entry.Completed = false
+	entry.CompletedAt = nil
+	setCompleted(entry, completed)
+}
+
+// Merges the fields that were sent into an entry
+func applyPatch(entry *Entry, patch EntryPatch) {
+	if patch.Item != nil {
+		entry.Item = *patch.Item
+	}
+	if patch.Completed != nil {
+		setCompleted(entry, *patch.Completed)
+	}
+	if patch.Quantity != nil {
+		entry.Quantity = *patch.Quantity
+	}
+	if patch.Unit != nil {
+		entry.Unit = *patch.Unit
+	}
+	if patch.Category != nil {
+		entry.Category = *patch.Category
+	}
+	if patch.Tags != nil {
+		entry.Tags = *patch.Tags
+	}
+	if patch.Priority != nil {
+		entry.Priority = *patch.Priority
+	}
+	if patch.Notes != nil {
+		entry.Notes = *patch.Notes
+	}
+	if patch.Price != nil {
+		entry.Price = *patch.Price
+	}
+	if patch.Currency != nil {
+		entry.Currency = *patch.Currency
+	}
+	if patch.Store != nil {
+		entry.Store = *patch.Store
+	}
+	if patch.DueBy.Set {
+		entry.DueBy = patch.DueBy.Time
+	}
+	normalizeEntry(entry)
 }
 
-// Using var here to allow it to be accessible throughout the package
-var dataFile = "data.json"
-// Mutex prevents concurrent write access to the file
-var mu sync.Mutex
+// store is where the entries and every registry's document are kept, it is opened once at startup
+var store Store
+
+// Mutex prevents concurrent write access to the store
+// it is a RWMutex so any number of GETs can read at the same time while changes still happen one at a time
+//
+// The rest of the package's state is in globals declared next to their types, each is set once in main before the server starts.
+// mu also guards these, so their methods don't take a lock of their own:
+// aliases, budgets, categories, conflicts, digests, guestTokens, itemNames, mealPlan, recipes, recurring, reminders,
+// shareLinks, shops, trips, undos, units and the cache in barcodes
+// These are also used without mu held, so they have their own lock:
+// users (users.mu), logins (logins.mu), webhooks (webhooks.mu), metrics (metrics.mu), alexa's certificate cache (alexa.certsMu)
+// and matrix's transactions (matrix.txnMu)
+// hub is only changed by its own goroutine, everything else talks to it over channels
+// The settings are only read after startup so need no lock: tokens, resets, backupStore, slackSecret, discordKey, chatListID,
+// requireAdmin2FA, singularizeItems and maxNotesLength
+var mu sync.RWMutex
 
 func main() {
-	l, err := net.Listen("tcp", ":8080")
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(args[1:], os.Stdout))
+	}
+	if len(args) > 0 {
+		if _, ok := cliCommands[args[0]]; ok {
+			os.Exit(runClient(args[0], args[1:], os.Stdout))
+		}
+	}
+	// serve is what running with no command does, it can be given so scripts can say which they mean
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fmt.Println("Unknown command", args[0]+", expected serve, migrate, add, ls, done, undone, rm or tui")
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(args)
 	if err != nil {
-		fmt.Println("Error starting server: ", err)
-		return
+		fmt.Println("Error reading configuration: ", err)
+		os.Exit(2)
 	}
-	defer l.Close()
-	fmt.Println("Server listening on portt 8080")
+	setupLogging(cfg)
+	cfg.Print(os.Stdout)
+	maxNotesLength = cfg.MaxNotesLength
+	singularizeItems = cfg.SingularizeItems
+	requireAdmin2FA = cfg.RequireAdmin2FA
 
-	// In simpleServer I am using Dial because I was simply setting up a connection here I will be using multiple connections and need to be listening out so use accept
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection: ", err)
-			continue
-		}
-		go handleConnection(conn)
+	store, err = openStore(cfg)
+	if err != nil {
+		slog.Error("Error opening storage", "err", err)
+		os.Exit(1)
 	}
-}
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
+	err = migrateData(store)
+	if err != nil {
+		slog.Error("Error migrating data", "err", err)
+		os.Exit(1)
+	}
 
-	reader := bufio.NewReader(conn)
-	req, err := reader.ReadString('\n')
+	users, err = loadUsers(store, cfg.SessionTTL)
 	if err != nil {
-		fmt.Println("Error reading request: ", err)
-		return
+		slog.Error("Error loading user accounts", "err", err)
+		os.Exit(1)
 	}
 
-	// parseRequeset(req) takes in req which should be a HTTP request line e.g. "POST /data HTTP/1.1\n" this method will parse it to find the HTTP method and the return method Post and path /data HTTP/1.1\n
-	method, path := parseRequest(req)
-	// Checks if the HTTP method or path extracted from the request line is empty so invalid requests exits the handleConnection function
-	if method == "" || path == "" {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+	logins = newLoginThrottle(cfg)
+	resets = newPasswordResetter(cfg)
+
+	budgets, err = loadBudgets(store)
+	if err != nil {
+		slog.Error("Error loading budgets", "err", err)
+		os.Exit(1)
 	}
 
-	// reads and parses HTTP headers from the request
-	// Initialises a map to store the header fields and their values
-	headers := make(map[string]string)
-	for {
-		// reads a line from the connection
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading headers:", err)
-			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-			return
-		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			break
-		}
-		// splits the line into key and value
-		parts := strings.SplitN(line, ": ", 2)
-		// checks that the line was successfully split int key and value
-		if len(parts) == 2 {
-			headers[parts[0]] = parts[1]
-		}
+	webhooks, err = loadWebhooks(store)
+	if err != nil {
+		slog.Error("Error loading webhooks", "err", err)
+		os.Exit(1)
 	}
 
-	// Decides which handler function to call based on the HTTP methos and path
-	if method == "GET" && path == "/data" {
-		handleGet(conn)
-		return
+	conflicts, err = loadConflicts(store)
+	if err != nil {
+		slog.Error("Error loading conflicts", "err", err)
+		os.Exit(1)
 	}
 
-	switch {
-	case method == "GET" && path == "/data":
-		handleGet(conn)
-	case method == "POST" && path == "/data":
-		contentLength := 0
-		if lengthStr, ok := headers["Content-Length"]; ok {
-			fmt.Sscanf(lengthStr, "%d", &contentLength)
-		}
-		handlePost(conn, reader, contentLength)
-	case method == "DELETE" && strings.HasPrefix(path, "/data/"):
-		handleDelete(conn, path)
-	default:
-		conn.Write([]byte("HTTP/1.1 404 Not Found\r\n\r\n"))
+	notifiers, err := loadNotifiers(cfg.NotifiersFile)
+	if err != nil {
+		slog.Error("Error loading notifiers", "err", err)
+		os.Exit(1)
 	}
-}
 
-// This is required to split the Get or post request from the data
-// req is the parameter of type sting which represents HTTP request line (e.g. "GET /data HTTP/1.1")
-// this function returns two values type string this will be the HTTP methood and the path
-func parseRequest(req string) (string, string) {
-	// this splits the req string into a slice of substrings seperated by a whitespace req = "GET /data HTTP/1.1" and parts = ["GET", "/data", "HTTP/1.1"]
-	parts := strings.Fields(req)
-	// This is a check to makes sure that parts has fewer than 2 elements
-	if len(parts) < 2 {
-		return "", ""
-	}
-	// For example - parts[0] wil be GET and parts [/data]
-	return parts[0], parts[1]
-}
+	bot, err := newTelegramBot(cfg)
+	if err != nil {
+		slog.Error("Error setting up the Telegram bot", "err", err)
+		os.Exit(1)
+	}
 
-// Handle Get request to retrieve all data from the JSON file
-func handleGet(conn net.Conn) {
-	mu.Lock()
-	defer mu.Unlock()
+	alexa = newAlexaSkill(cfg)
 
-	// Reads the json file and if it can't it will send a HTTP response to the client
-	file, err := os.ReadFile(dataFile)
+	slackSecret = []byte(cfg.SlackSecret)
+	// the key was checked by loadConfig
+	discordKey, _ = hex.DecodeString(cfg.DiscordPublicKey)
+	chatListID = cfg.ChatList
+	matrix, err = newMatrixAppService(cfg)
 	if err != nil {
-		fmt.Println("Error reading file: ", err)
-		// converted to byte slice because it is required by conn.Write
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error setting up the Matrix application service", "err", err)
+		os.Exit(1)
 	}
 
-	conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n"))
-	conn.Write(file)
-}
+	bridge, err := newMQTTBridge(cfg)
+	if err != nil {
+		slog.Error("Error setting up the MQTT bridge", "err", err)
+		os.Exit(1)
+	}
 
-// Handle Post request to append data to the JSON file
-func handlePost(conn net.Conn, reader *bufio.Reader, contentLength int) {
-	// allocates the memory to the correct size
-	body := make([]byte, contentLength)
-	_, err := io.ReadFull(reader, body)
+	if cfg.BackupInterval > 0 {
+		backupStore, err = newBackupTarget(cfg)
+		if err != nil {
+			slog.Error("Error setting up scheduled backups", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	reminderActions, err := parseReminderActions(cfg.Reminders, newMailer(cfg))
+	if err != nil {
+		slog.Error("Error setting up reminders", "err", err)
+		os.Exit(1)
+	}
+	reminders, err = loadReminders(store, reminderActions)
 	if err != nil {
-		fmt.Println("Error reading POST body:", err)
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+		slog.Error("Error loading reminders", "err", err)
+		os.Exit(1)
 	}
 
-	fmt.Println("Received POST body:", string(body))
+	digests, err = loadDigests(store, newMailer(cfg), cfg.PublicURL)
+	if err != nil {
+		slog.Error("Error loading digests", "err", err)
+		os.Exit(1)
+	}
 
-	var newEntries []Entry
-	// json.Unmarshal converts json to go
-	// by passing a pointer this allows the function to modify the original. Using pointers is memory efficent so you aren't passing large data structures
-	// & is for memory address and * is used for accessing of modigying the value
-	err = json.Unmarshal(body, &newEntries)
+	recurring, err = loadRecurring(store)
 	if err != nil {
-		fmt.Println("Error parsing JSON:", err)
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+		slog.Error("Error loading recurring items", "err", err)
+		os.Exit(1)
 	}
 
-	var entries []Entry
-	mu.Lock()
-	defer mu.Unlock()
+	shops, err = loadShops(store)
+	if err != nil {
+		slog.Error("Error loading stores", "err", err)
+		os.Exit(1)
+	}
 
-	file, err := os.ReadFile(dataFile)
-	if err == nil {
-		_ = json.Unmarshal(file, &entries)
+	trips, err = loadTrips(store)
+	if err != nil {
+		slog.Error("Error loading trips", "err", err)
+		os.Exit(1)
 	}
 
-	// Assign IDs to new entries and append to existing entries
-	for i := range newEntries {
-		newEntries[i].ID = len(entries) + i + 1
+	recipes, err = loadRecipes(store)
+	if err != nil {
+		slog.Error("Error loading recipes", "err", err)
+		os.Exit(1)
 	}
-	entries = append(entries, newEntries...)
 
-	// MarshallIndent does the same as marshall but just gets everything in the right format
-	file, err = json.MarshalIndent(entries, "", "  ")
+	mealPlan, err = loadMealPlan(store)
 	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error loading the meal plan", "err", err)
+		os.Exit(1)
 	}
-	err = os.WriteFile(dataFile, file, 0644)
+
+	shareLinks, err = loadShareLinks(store, cfg.PublicURL)
 	if err != nil {
-		fmt.Println("Error writing file:", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error loading share links", "err", err)
+		os.Exit(1)
 	}
 
-	conn.Write([]byte("HTTP/1.1 201 Created\r\n\r\n"))
-}
+	guestTokens, err = loadGuestTokens(store, cfg.PublicURL)
+	if err != nil {
+		slog.Error("Error loading guest tokens", "err", err)
+		os.Exit(1)
+	}
 
-func handleDelete(conn net.Conn, path string) {
-	mu.Lock()
-	defer mu.Unlock()
+	categories, err = loadCategories(store)
+	if err != nil {
+		slog.Error("Error loading categories", "err", err)
+		os.Exit(1)
+	}
 
-	// Extract ID from the path
-	parts := strings.Split(path, "/")
-	if len(parts) < 3 {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+	undos, err = loadUndoLog(store)
+	if err != nil {
+		slog.Error("Error loading undo history", "err", err)
+		os.Exit(1)
 	}
-	idStr := parts[2]
 
-	// Convert ID to integer
-	id, err := strconv.Atoi(idStr)
+	aliases, err = loadAliases(store)
 	if err != nil {
-		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
-		return
+		slog.Error("Error loading aliases", "err", err)
+		os.Exit(1)
 	}
-	// Read existing entries
-	file, err := os.ReadFile(dataFile)
+
+	units, err = loadUnits(store)
 	if err != nil {
-		fmt.Println("Error reading file: ", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error loading units", "err", err)
+		os.Exit(1)
 	}
 
-	var entries []Entry
-	err = json.Unmarshal(file, &entries)
+	itemNames, err = buildItemIndex(store, trips)
 	if err != nil {
-		fmt.Println("Error parsing JSON: ", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error building the autocomplete index", "err", err)
+		os.Exit(1)
 	}
 
-	// Filter out the entry with the given ID
-	var newEntries []Entry
-	for _, entry := range entries {
-		if entry.ID != id {
-			newEntries = append(newEntries, entry)
+	if cfg.BarcodeAPI != "" {
+		barcodes, err = loadBarcodes(store, cfg.BarcodeAPI)
+		if err != nil {
+			slog.Error("Error loading the barcode cache", "err", err)
+			os.Exit(1)
 		}
 	}
 
-	// Save the updated entries back to the file
-	file, err = json.MarshalIndent(newEntries, "", "  ")
+	tokens, err = newTokenIssuer(store, cfg.JWTSecret, cfg.TokenTTL)
 	if err != nil {
-		fmt.Println("Error marshalling JSON: ", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error setting up access tokens", "err", err)
+		os.Exit(1)
 	}
-	err = os.WriteFile(dataFile, file, 0644)
+
+	apiKeys, err := loadAPIKeys(cfg)
 	if err != nil {
-		fmt.Println("Error writing file: ", err)
-		conn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return
+		slog.Error("Error loading API keys", "err", err)
+		os.Exit(1)
+	}
+
+	// each wrapper goes around the ones before it so the last one runs first, CORS is outside the rate limit so a browser page can still read a 429
+	var handler http.Handler = withOptions(newRouter(cfg, requireAuth(apiKeys, cfg.NoAuth, tokens), apiKeys))
+	handler = withValidQuery(handler)
+	handler = withBodyLimit(cfg.MaxBodySize, handler)
+	handler = withRateLimit(cfg.RateLimit, cfg.RateBurst, handler)
+	handler = withCORS(cfg.CORSOrigins, handler)
+	handler = withMetrics(handler)
+	handler = withRequestLogging(handler)
+
+	server := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		// a client that connects and never finishes its headers is cut off well before ReadTimeout
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	// net/http keeps connections open between requests by default, honouring Connection: close and HTTP/1.0 clients,
+	// and reads pipelined requests one after another using Content-Length or chunked framing
+	server.SetKeepAlivesEnabled(cfg.KeepAlive)
+	// gRPC needs HTTP/2, over TLS it is negotiated anyway but plain HTTP only gets it with h2c turned on
+	if cfg.GRPC {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	// ctx is cancelled on Ctrl+C or when the process is asked to stop (e.g. docker stop or systemctl stop send SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.TLSEnabled() {
+		certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			slog.Error("Error loading TLS certificate", "err", err)
+			os.Exit(1)
+		}
+		go certs.watch(ctx, certCheckInterval)
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
 	}
 
-	conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
-}
\ No newline at end of file
+	go runTrashPurge(ctx, cfg.TrashRetention, trashPurgeInterval)
+	go runRecurring(ctx, recurringCheckInterval)
+	go reminders.run(ctx, reminderCheckInterval)
+	go digests.run(ctx, digestCheckInterval)
+	go webhooks.run(ctx)
+	go runNotifiers(ctx, notifiers)
+	if bot != nil {
+		go bot.run(ctx)
+	}
+	if bridge != nil {
+		go bridge.run(ctx)
+	}
+	if backupStore != nil {
+		go runBackups(ctx, backupStore, cfg.BackupInterval, cfg.BackupKeep)
+	}
+
+	// The server runs on its own goroutine so main can wait for a signal, a failure to start is sent back on serverErr
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server listening", "addr", cfg.Addr, "tls", cfg.TLSEnabled())
+		// ListenAndServe accepts connections and runs each one on its own goroutine, so the accept loop we used to write by hand is no longer needed
+		if cfg.TLSEnabled() {
+			// the file names are empty because the certificate comes from TLSConfig.GetCertificate
+			serverErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serverErr:
+		slog.Error("Error starting server", "err", err)
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+		// closing the hub ends the event streams, otherwise Shutdown would wait the whole timeout for them
+		// it also tells WebSockets to finish, Shutdown doesn't wait for those because net/http has handed those connections over
+		hub.Close()
+		shutdown(server, cfg.ShutdownTimeout)
+	}
+
+	// Anything the background writer hasn't saved yet is written before the process exits
+	err = store.Close()
+	if err != nil {
+		slog.Error("Error closing storage", "err", err)
+		os.Exit(1)
+	}
+}
+
+// Stops accepting new connections and waits up to timeout for in-flight requests to finish
+func shutdown(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("Timed out waiting for requests to finish")
+		// Taking the lock waits for any handler still in the middle of a change so the store isn't closed underneath it
+		// it is never released because the process is about to exit and nothing else should change the store now
+		mu.Lock()
+	} else if err != nil {
+		slog.Error("Error shutting down", "err", err)
+	}
+}
+
+// newRouter decides which handler function to call based on the HTTP method and path
+// ServeMux patterns take care of the method matching, percent-decoding the path and pulling out {id}, which parseRequest used to do by hand
+// e.g. DELETE /stores/Caf%C3%A9%20Nord gets {name} "Café Nord" and an escaped slash stays inside its segment, withValidQuery checks the query
+// auth wraps every route that needs to know who is asking so only authenticated clients can read or change a list
+// apiKeys are for the feeds calendar apps subscribe to, which can also be sent as a Basic auth password
+func newRouter(cfg Config, auth func(http.Handler) http.Handler, apiKeys []string) *http.ServeMux {
+	mux := http.NewServeMux()
+	// idempotent replays the first response to a retried POST that has an Idempotency-Key
+	idempotent := newIdempotencyCache(cfg.IdempotencyTTL)
+	// withList picks the list from ?list= and checks the caller can read or write it
+	mux.Handle("GET /data", auth(withList(PermissionRead, http.HandlerFunc(handleGet))))
+	mux.Handle("GET /data/{id}", auth(withList(PermissionRead, http.HandlerFunc(handleGetEntry))))
+	mux.Handle("POST /data", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handlePost)))))
+	mux.Handle("POST /data/bulk", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleBulk)))))
+	mux.Handle("POST /data/clear-completed", auth(withList(PermissionWrite, http.HandlerFunc(handleClearCompleted))))
+	mux.Handle("DELETE /data", auth(withList(PermissionWrite, http.HandlerFunc(handleClearAll))))
+	mux.Handle("PUT /data/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleUpdate))))
+	mux.Handle("PATCH /data/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleUpdate))))
+	mux.Handle("DELETE /data/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleDelete))))
+	mux.Handle("POST /data/{id}/complete", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleComplete)))))
+	mux.Handle("POST /data/{id}/restore", auth(withList(PermissionWrite, http.HandlerFunc(handleRestore))))
+	mux.Handle("GET /export/csv", auth(withList(PermissionRead, http.HandlerFunc(handleExportCSV))))
+	mux.Handle("GET /export/markdown", auth(withList(PermissionRead, http.HandlerFunc(handleExportMarkdown))))
+	mux.Handle("GET /print", auth(withList(PermissionRead, http.HandlerFunc(handlePrint))))
+	mux.Handle("GET /tags", auth(withList(PermissionRead, http.HandlerFunc(handleGetTags))))
+	mux.Handle("GET /webhooks", auth(withList(PermissionRead, http.HandlerFunc(handleGetWebhooks))))
+	mux.Handle("POST /webhooks", auth(withList(PermissionWrite, http.HandlerFunc(handlePostWebhook))))
+	mux.Handle("DELETE /webhooks/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteWebhook))))
+	mux.Handle("GET /reminders", auth(withList(PermissionRead, http.HandlerFunc(handleGetReminders))))
+	mux.Handle("POST /data/{id}/snooze", auth(withList(PermissionWrite, http.HandlerFunc(handleSnooze))))
+	mux.Handle("GET /recurring", auth(withList(PermissionRead, http.HandlerFunc(handleGetRecurring))))
+	mux.Handle("POST /recurring", auth(withList(PermissionWrite, http.HandlerFunc(handlePostRecurring))))
+	mux.Handle("PUT /recurring/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handlePutRecurring))))
+	mux.Handle("DELETE /recurring/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteRecurring))))
+	mux.Handle("GET /stores", auth(withList(PermissionRead, http.HandlerFunc(handleGetStores))))
+	mux.Handle("POST /stores", auth(withList(PermissionWrite, http.HandlerFunc(handlePostStore))))
+	mux.Handle("DELETE /stores/{name}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteStore))))
+	mux.Handle("GET /categories", auth(withList(PermissionRead, http.HandlerFunc(handleGetCategories))))
+	mux.Handle("PUT /categories", auth(withList(PermissionWrite, http.HandlerFunc(handlePutCategories))))
+	mux.Handle("POST /categories", auth(withList(PermissionWrite, http.HandlerFunc(handlePostCategory))))
+	mux.Handle("DELETE /categories/{name}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteCategory))))
+	mux.Handle("GET /audit", auth(withList(PermissionRead, http.HandlerFunc(handleGetAudit))))
+	mux.Handle("POST /undo", auth(withList(PermissionWrite, http.HandlerFunc(handleUndo))))
+	mux.Handle("GET /changes", auth(withList(PermissionRead, http.HandlerFunc(handleGetChanges))))
+	mux.Handle("POST /sync", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleSync)))))
+	mux.Handle("GET /conflicts", auth(withList(PermissionRead, http.HandlerFunc(handleGetConflicts))))
+	mux.Handle("POST /conflicts/{id}/resolve", auth(withList(PermissionWrite, http.HandlerFunc(handleResolveConflict))))
+	mux.Handle("POST /trips/close", auth(withList(PermissionWrite, http.HandlerFunc(handleCloseTrip))))
+	mux.Handle("GET /trips", auth(withList(PermissionRead, http.HandlerFunc(handleGetTrips))))
+	if barcodes != nil {
+		mux.Handle("POST /scan", auth(withList(PermissionWrite, http.HandlerFunc(handleScan))))
+	}
+	mux.Handle("GET /recipes", auth(withList(PermissionRead, http.HandlerFunc(handleGetRecipes))))
+	mux.Handle("POST /recipes", auth(withList(PermissionWrite, http.HandlerFunc(handlePostRecipe))))
+	mux.Handle("GET /recipes/{id}", auth(withList(PermissionRead, http.HandlerFunc(handleGetRecipe))))
+	mux.Handle("PUT /recipes/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handlePutRecipe))))
+	mux.Handle("DELETE /recipes/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteRecipe))))
+	mux.Handle("POST /recipes/{id}/add-to-list", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleAddRecipeToList)))))
+	mux.Handle("GET /mealplan", auth(withList(PermissionRead, http.HandlerFunc(handleGetMealPlan))))
+	mux.Handle("POST /mealplan", auth(withList(PermissionWrite, http.HandlerFunc(handlePostMeal))))
+	mux.Handle("PUT /mealplan/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handlePutMeal))))
+	mux.Handle("DELETE /mealplan/{id}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteMeal))))
+	mux.Handle("POST /mealplan/add-to-list", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleAddMealPlanToList)))))
+	// the digest needs -smtp-addr, the unsubscribe link has to work from the email so it is public
+	if digests.mail != nil {
+		mux.Handle("GET /digest", auth(withList(PermissionRead, http.HandlerFunc(handleGetDigest))))
+		mux.Handle("PUT /digest", auth(withList(PermissionWrite, http.HandlerFunc(handlePutDigest))))
+		mux.Handle("DELETE /digest", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteDigest))))
+		mux.HandleFunc("GET /digest/unsubscribe", handleUnsubscribePage)
+		mux.HandleFunc("POST /digest/unsubscribe", handleUnsubscribeDigest)
+	}
+	mux.Handle("GET /calendar.ics", requireBasicAuth(apiKeys, cfg.NoAuth, auth)(withList(PermissionRead, http.HandlerFunc(handleCalendar))))
+	mux.Handle("GET /aliases", auth(withList(PermissionRead, http.HandlerFunc(handleGetAliases))))
+	mux.Handle("PUT /aliases/{alias}", auth(withList(PermissionWrite, http.HandlerFunc(handlePutAlias))))
+	mux.Handle("DELETE /aliases/{alias}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteAlias))))
+	mux.Handle("GET /units", auth(withList(PermissionRead, http.HandlerFunc(handleGetUnits))))
+	mux.Handle("PUT /units/{unit}", auth(withList(PermissionWrite, http.HandlerFunc(handlePutUnit))))
+	mux.Handle("DELETE /units/{unit}", auth(withList(PermissionWrite, http.HandlerFunc(handleDeleteUnit))))
+	mux.Handle("GET /autocomplete", auth(withList(PermissionRead, http.HandlerFunc(handleAutocomplete))))
+	mux.Handle("GET /suggestions", auth(withList(PermissionRead, http.HandlerFunc(handleGetSuggestions))))
+	mux.Handle("GET /stats", auth(withList(PermissionRead, http.HandlerFunc(handleGetStats))))
+	mux.Handle("GET /trips/{id}", auth(withList(PermissionRead, http.HandlerFunc(handleGetTrip))))
+	mux.Handle("GET /trash", auth(withList(PermissionRead, http.HandlerFunc(handleGetTrash))))
+	mux.Handle("POST /data/{id}/uncomplete", auth(withList(PermissionWrite, idempotent.Wrap(http.HandlerFunc(handleUncomplete)))))
+
+	mux.Handle("GET /ws", auth(withList(PermissionRead, http.HandlerFunc(handleWebSocket))))
+	mux.Handle("GET /events", auth(withList(PermissionRead, http.HandlerFunc(handleEvents))))
+
+	// backups hold every user's data and password hashes, so they are for whoever runs the server rather than any one user
+	mux.Handle("GET /backup", auth(requireAdmin(http.HandlerFunc(handleBackup))))
+	mux.Handle("GET /backups", auth(requireAdmin(http.HandlerFunc(handleGetBackups))))
+	mux.Handle("POST /restore", auth(requireAdmin(http.HandlerFunc(handleRestoreBackup))))
+	mux.Handle("GET /admin/users", auth(requireAdmin(http.HandlerFunc(handleAdminGetUsers))))
+	mux.Handle("GET /admin/users/{username}", auth(requireAdmin(http.HandlerFunc(handleAdminGetUser))))
+	mux.Handle("PUT /admin/users/{username}/role", auth(requireAdmin(http.HandlerFunc(handleAdminPutRole))))
+	mux.Handle("POST /admin/users/{username}/password", auth(requireAdmin(http.HandlerFunc(handleAdminSetPassword))))
+	mux.Handle("DELETE /admin/users/{username}", auth(requireAdmin(http.HandlerFunc(handleAdminDeleteUser))))
+	mux.Handle("DELETE /admin/users/{username}/2fa", auth(requireAdmin(http.HandlerFunc(handleAdminDisableTOTP))))
+	mux.Handle("POST /admin/delete-inactive", auth(requireAdmin(http.HandlerFunc(handleAdminDeleteInactive))))
+	mux.Handle("GET /admin/storage", auth(requireAdmin(handleAdminStorage(cfg))))
+	mux.Handle("POST /admin/compact", auth(requireAdmin(http.HandlerFunc(handleAdminCompact))))
+	mux.Handle("POST /admin/backup", auth(requireAdmin(handleAdminBackup(cfg.BackupKeep))))
+	mux.Handle("GET /lists", auth(http.HandlerFunc(handleGetLists)))
+	mux.Handle("GET /lists/{id}/summary", auth(withPathList(PermissionRead, http.HandlerFunc(handleListSummary))))
+	mux.Handle("PUT /lists/{id}/budget", auth(withPathList(PermissionWrite, http.HandlerFunc(handlePutBudget))))
+	mux.Handle("DELETE /lists/{id}/budget", auth(withPathList(PermissionWrite, http.HandlerFunc(handleDeleteBudget))))
+	mux.Handle("POST /lists/{id}/share-link", auth(withPathList(PermissionOwner, http.HandlerFunc(handlePostShareLink))))
+	mux.Handle("GET /lists/{id}/share-link", auth(withPathList(PermissionOwner, http.HandlerFunc(handleGetShareLink))))
+	mux.Handle("DELETE /lists/{id}/share-link", auth(withPathList(PermissionOwner, http.HandlerFunc(handleDeleteShareLink))))
+	mux.Handle("GET /lists/{id}/share-qr", auth(withPathList(PermissionOwner, http.HandlerFunc(handleGetShareQR))))
+	// the token in the link is all a share link needs
+	mux.HandleFunc("GET /shared", handleGetShared)
+	mux.Handle("GET /lists/{id}/guest-tokens", auth(withPathList(PermissionOwner, http.HandlerFunc(handleGetGuestTokens))))
+	mux.Handle("POST /lists/{id}/guest-tokens", auth(withPathList(PermissionOwner, http.HandlerFunc(handlePostGuestToken))))
+	mux.Handle("DELETE /lists/{id}/guest-tokens/{tokenId}", auth(withPathList(PermissionOwner, http.HandlerFunc(handleDeleteGuestToken))))
+	// guests have their own token that handleGuestAdd checks, it can't be used anywhere auth is
+	mux.HandleFunc("GET /guest", handleGuestPage)
+	mux.HandleFunc("POST /guest/add", handleGuestAdd)
+	mux.Handle("GET /lists/{id}/conflict-strategy", auth(withPathList(PermissionRead, http.HandlerFunc(handleGetConflictStrategy))))
+	mux.Handle("PUT /lists/{id}/conflict-strategy", auth(withPathList(PermissionWrite, http.HandlerFunc(handlePutConflictStrategy))))
+	mux.Handle("POST /lists/{id}/share", auth(http.HandlerFunc(handleShareList)))
+	mux.Handle("DELETE /lists/{id}/share/{username}", auth(http.HandlerFunc(handleUnshareList)))
+
+	if cfg.Registration {
+		mux.HandleFunc("POST /register", handleRegister)
+	} else {
+		mux.HandleFunc("POST /register", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "registration_closed", "registration is turned off on this server")
+		})
+	}
+	mux.HandleFunc("POST /login", handleLogin)
+	// the /simple routes take the API key as ?key= or a form field as well as a bearer token, see simple.go
+	simpleAuth := requireKey(apiKeys, auth)
+	simpleLimit := withSimpleLimit(cfg.SimpleRateLimit, cfg.SimpleRateBurst)
+	for _, method := range []string{"GET", "POST"} {
+		mux.Handle(method+" /simple/add", simpleAuth(simpleLimit(withList(PermissionWrite, http.HandlerFunc(handleSimpleAdd)))))
+		mux.Handle(method+" /simple/done", simpleAuth(simpleLimit(withList(PermissionWrite, http.HandlerFunc(handleSimpleDone)))))
+	}
+	mux.Handle("GET /simple/list", simpleAuth(simpleLimit(withList(PermissionRead, http.HandlerFunc(handleSimpleList)))))
+	// Alexa can't send a token, every request is signed by Amazon instead and handleAlexa checks that
+	if alexa != nil {
+		mux.HandleFunc("POST /alexa", handleAlexa)
+	}
+	// the same goes for the chat integrations, Slack and Discord sign their requests and the Matrix homeserver sends the hs_token
+	if len(slackSecret) > 0 {
+		mux.HandleFunc("POST /integrations/slack", handleSlack)
+	}
+	if len(discordKey) > 0 {
+		mux.HandleFunc("POST /integrations/discord", handleDiscord)
+	}
+	if matrix != nil {
+		mux.HandleFunc("PUT /integrations/matrix/_matrix/app/v1/transactions/{txnId}", handleMatrixTransaction)
+		// registrations made for older homeservers leave out the /_matrix/app/v1 prefix
+		mux.HandleFunc("PUT /integrations/matrix/transactions/{txnId}", handleMatrixTransaction)
+	}
+	mux.HandleFunc("POST /refresh", handleRefresh)
+	mux.HandleFunc("POST /logout", handleLogout)
+	mux.Handle("GET /me", auth(http.HandlerFunc(handleMe)))
+	mux.Handle("POST /me/password", auth(http.HandlerFunc(handleChangePassword)))
+	mux.Handle("PUT /me/email", auth(http.HandlerFunc(handleSetEmail)))
+	mux.Handle("POST /me/2fa", auth(http.HandlerFunc(handleEnrollTOTP)))
+	mux.Handle("POST /me/2fa/confirm", auth(http.HandlerFunc(handleConfirmTOTP)))
+	mux.Handle("DELETE /me/2fa", auth(http.HandlerFunc(handleDisableTOTP)))
+	// a forgotten password is reset through email, so it needs -smtp-addr
+	if resets != nil {
+		mux.HandleFunc("POST /auth/forgot", handleForgotPassword)
+		mux.HandleFunc("GET /auth/reset", handleResetPage)
+		mux.HandleFunc("POST /auth/reset", handleResetPassword)
+	}
+
+	if cfg.GRPC {
+		mux.Handle("POST /"+shoppinglistpb.ShoppingList_ServiceDesc.ServiceName+"/{method}", withGRPCErrors(auth(newGRPCHandler())))
+	}
+
+	// Prometheus scrapes without a token, it only exposes counts
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	// the API description is public so clients can be generated without an account
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /docs", handleDocs)
+	// the web UI logs in with POST /login like any other client, so its files are public too
+	site := newStaticSite(cfg.WebDir)
+	mux.HandleFunc("GET /{$}", site.handleIndex)
+	mux.HandleFunc("GET /static/", site.handleAsset)
+	return mux
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// matrixCommand is what a message has to start with for the bot to answer it
+const matrixCommand = "!shop"
+
+// matrixSeenTransactions is how many transaction IDs are remembered, the homeserver resends a transaction it didn't get an answer for
+const matrixSeenTransactions = 100
+
+// matrixAppService is a Matrix application service, the homeserver pushes room events to it and it answers "!shop add milk" messages on -chat-list
+// the homeserver is set up with a registration file whose url points at /integrations/matrix and whose tokens match -matrix-as-token and -matrix-hs-token
+// https://spec.matrix.org/latest/application-service-api/
+type matrixAppService struct {
+	client     *http.Client
+	homeserver string
+	asToken    string
+	hsToken    string
+	userID     string
+
+	txnMu sync.Mutex
+	seen  []string
+	// sent numbers the messages the bot sends, the client-server API needs a transaction ID for each
+	sent atomic.Int64
+}
+
+var matrix *matrixAppService
+
+// Returns nil when no homeserver is configured
+func newMatrixAppService(cfg Config) (*matrixAppService, error) {
+	if cfg.MatrixHomeserver == "" {
+		return nil, nil
+	}
+	if cfg.MatrixASToken == "" || cfg.MatrixHSToken == "" || cfg.MatrixUser == "" {
+		return nil, errors.New("-matrix-as-token, -matrix-hs-token and -matrix-user have to be set when -matrix-homeserver is")
+	}
+	return &matrixAppService{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		homeserver: strings.TrimSuffix(cfg.MatrixHomeserver, "/"),
+		asToken:    cfg.MatrixASToken,
+		hsToken:    cfg.MatrixHSToken,
+		userID:     cfg.MatrixUser,
+	}, nil
+}
+
+// matrixTransaction is the part of a transaction the bot uses
+type matrixTransaction struct {
+	Events []matrixEvent `json:"events"`
+}
+
+type matrixEvent struct {
+	Type     string `json:"type"`
+	RoomID   string `json:"room_id"`
+	Sender   string `json:"sender"`
+	StateKey string `json:"state_key"`
+	Content  struct {
+		MsgType    string `json:"msgtype"`
+		Body       string `json:"body"`
+		Membership string `json:"membership"`
+	} `json:"content"`
+}
+
+// Handle Put request from the homeserver with a transaction of room events
+// the homeserver authenticates with the hs_token, as a bearer token or as ?access_token on older versions
+func handleMatrixTransaction(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(matrix.hsToken)) != 1 {
+		slog.Warn("Rejected Matrix transaction with a bad token")
+		writeError(w, http.StatusForbidden, "M_FORBIDDEN", "the hs_token is wrong")
+		return
+	}
+	var txn matrixTransaction
+	err := json.NewDecoder(r.Body).Decode(&txn)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if matrix.firstSeen(r.PathValue("txnId")) {
+		for _, event := range txn.Events {
+			matrix.handleEvent(event)
+		}
+	}
+	// the homeserver resends the transaction until it gets a 200, so a repeat is answered the same without handling it again
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// Remembers the transaction ID and reports whether it is new
+func (m *matrixAppService) firstSeen(txnID string) bool {
+	m.txnMu.Lock()
+	defer m.txnMu.Unlock()
+	if slices.Contains(m.seen, txnID) {
+		return false
+	}
+	m.seen = append(m.seen, txnID)
+	if len(m.seen) > matrixSeenTransactions {
+		m.seen = m.seen[1:]
+	}
+	return true
+}
+
+// Joins rooms the bot is invited to and answers !shop messages, replies are sent in the background so the transaction is answered quickly
+func (m *matrixAppService) handleEvent(event matrixEvent) {
+	switch {
+	case event.Type == "m.room.member" && event.StateKey == m.userID && event.Content.Membership == "invite":
+		go m.call(http.MethodPost, "/rooms/"+url.PathEscape(event.RoomID)+"/join", struct{}{})
+	// only m.text is answered so the bot's own m.notice replies, and other bots', can't start a loop
+	case event.Type == "m.room.message" && event.Content.MsgType == "m.text" && event.Sender != m.userID:
+		text, ok := strings.CutPrefix(strings.TrimSpace(event.Content.Body), matrixCommand)
+		if !ok || (text != "" && text[0] != ' ') {
+			return
+		}
+		command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+		go func() {
+			reply := runChatCommand(chatListID, "matrix:"+event.Sender, matrixCommand+" ", command, args)
+			txnID := "shoppinglist-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.FormatInt(m.sent.Add(1), 10)
+			m.call(http.MethodPut, "/rooms/"+url.PathEscape(event.RoomID)+"/send/m.room.message/"+txnID, map[string]string{
+				"msgtype": "m.notice",
+				"body":    reply,
+			})
+		}()
+	}
+}
+
+// Calls the client-server API as the bot's user
+func (m *matrixAppService) call(method string, path string, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		slog.Error("Error encoding Matrix request", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, m.homeserver+"/_matrix/client/v3"+path, bytes.NewReader(data))
+	if err != nil {
+		slog.Error("Error creating Matrix request", "err", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+m.asToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		slog.Error("Error calling the Matrix homeserver", "path", path, "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Matrix homeserver returned an error", "path", path, "status", resp.StatusCode)
+	}
+}
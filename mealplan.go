@@ -0,0 +1,375 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dateLayout is how a day on the meal plan is written, e.g. 2024-05-01
+	dateLayout = "2006-01-02"
+	// maxMealLength is the most characters a meal's name or label can have
+	maxMealLength = 100
+	// maxPlanDays is the longest range POST /mealplan/add-to-list takes, so a typo in a year can't add a decade of food
+	maxPlanDays = 62
+)
+
+// PlannedMeal is something to eat on a day, either one of the list's recipes or a meal given by name like "eating out"
+type PlannedMeal struct {
+	ID     int    `json:"id"`
+	ListID int    `json:"list_id"`
+	Date   string `json:"date"`
+	// Meal says which meal of the day it is e.g. "dinner", "" when it doesn't matter
+	Meal string `json:"meal"`
+	// RecipeID is the recipe being cooked, 0 for a meal that is only a name
+	RecipeID int `json:"recipe_id"`
+	// Name is the recipe's name when there is one
+	Name string `json:"name"`
+	// Servings is how many to cook for, 0 means the recipe's own servings
+	Servings int `json:"servings"`
+	// AddedAt is when the recipe's ingredients were put on the list by POST /mealplan/add-to-list, null until they are
+	// so running it again for an overlapping range doesn't add the same meal twice
+	AddedAt *time.Time `json:"added_at"`
+}
+
+// mealRequest is the body of POST and PUT /mealplan
+type mealRequest struct {
+	Date     string `json:"date"`
+	Meal     string `json:"meal"`
+	RecipeID int    `json:"recipe_id"`
+	// Name is needed when there isn't a recipe, with one it defaults to the recipe's name
+	Name     string `json:"name"`
+	Servings int    `json:"servings"`
+}
+
+// mealPlanDoc is everything the meal plan subsystem saves
+type mealPlanDoc struct {
+	Meals  []PlannedMeal `json:"meals"`
+	NextID int           `json:"next_id"`
+}
+
+// mealPlanDocName is the Store document the meal plan is saved under
+const mealPlanDocName = "mealplan"
+
+// mealPlanRegistry holds every list's planned meals in memory and saves them to the Store on every change
+type mealPlanRegistry struct {
+	store Store
+	doc   mealPlanDoc
+}
+
+var mealPlan *mealPlanRegistry
+
+func loadMealPlan(store Store) (*mealPlanRegistry, error) {
+	m := &mealPlanRegistry{store: store}
+	err := store.LoadDoc(mealPlanDocName, &m.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if m.doc.Meals == nil {
+		m.doc.Meals = []PlannedMeal{}
+	}
+	m.doc.NextID = max(m.doc.NextID, 1)
+	return m, nil
+}
+
+func (m *mealPlanRegistry) save() error {
+	return m.store.SaveDoc(mealPlanDocName, m.doc)
+}
+
+// Between returns the list's meals from one day to another, both included, in date order
+// dates sort as strings because they are all written with dateLayout
+func (m *mealPlanRegistry) Between(listID int, from string, to string) []PlannedMeal {
+	meals := []PlannedMeal{}
+	for _, meal := range m.doc.Meals {
+		if meal.ListID == listID && meal.Date >= from && meal.Date <= to {
+			meals = append(meals, meal)
+		}
+	}
+	slices.SortStableFunc(meals, func(a, b PlannedMeal) int {
+		return cmp.Compare(a.Date, b.Date)
+	})
+	return meals
+}
+
+// Get returns the meal or ErrNotFound
+func (m *mealPlanRegistry) Get(listID int, id int) (PlannedMeal, error) {
+	i := m.index(listID, id)
+	if i < 0 {
+		return PlannedMeal{}, ErrNotFound
+	}
+	return m.doc.Meals[i], nil
+}
+
+// Put adds meal if its ID is 0, giving it one, or replaces the meal on the same list with its ID
+func (m *mealPlanRegistry) Put(meal PlannedMeal) (PlannedMeal, error) {
+	if meal.ID == 0 {
+		meal.ID = m.doc.NextID
+		m.doc.NextID++
+		m.doc.Meals = append(m.doc.Meals, meal)
+		return meal, m.save()
+	}
+	i := m.index(meal.ListID, meal.ID)
+	if i < 0 {
+		return PlannedMeal{}, ErrNotFound
+	}
+	m.doc.Meals[i] = meal
+	return meal, m.save()
+}
+
+// Delete takes the meal off the plan, ingredients it has already added stay on the list
+func (m *mealPlanRegistry) Delete(listID int, id int) error {
+	i := m.index(listID, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.doc.Meals = slices.Delete(m.doc.Meals, i, i+1)
+	return m.save()
+}
+
+// MarkAdded records that the meals' ingredients went on the list at now
+func (m *mealPlanRegistry) MarkAdded(meals []PlannedMeal, now time.Time) error {
+	for _, meal := range meals {
+		if i := m.index(meal.ListID, meal.ID); i >= 0 {
+			m.doc.Meals[i].AddedAt = &now
+		}
+	}
+	return m.save()
+}
+
+func (m *mealPlanRegistry) index(listID int, id int) int {
+	return slices.IndexFunc(m.doc.Meals, func(meal PlannedMeal) bool {
+		return meal.ID == id && meal.ListID == listID
+	})
+}
+
+// Turns a request body into a meal on the list, or returns everything that is wrong with it
+// callers must hold mu since the recipe is looked up
+func (req mealRequest) toMeal(listID int) (PlannedMeal, []fieldError) {
+	var errs []fieldError
+	date, err := time.Parse(dateLayout, strings.TrimSpace(req.Date))
+	if err != nil {
+		errs = append(errs, fieldError{Field: "date", Message: "date has to be a day like 2024-05-01"})
+	}
+	meal := PlannedMeal{
+		ListID:   listID,
+		Date:     date.Format(dateLayout),
+		Meal:     strings.ToLower(strings.Join(strings.Fields(req.Meal), " ")),
+		RecipeID: req.RecipeID,
+		Name:     strings.Join(strings.Fields(req.Name), " "),
+		Servings: req.Servings,
+	}
+	if len(meal.Meal) > maxMealLength {
+		errs = append(errs, fieldError{Field: "meal", Message: "meal can be at most " + strconv.Itoa(maxMealLength) + " characters"})
+	}
+	if req.Servings < 0 {
+		errs = append(errs, fieldError{Field: "servings", Message: "servings can't be negative"})
+	}
+	if req.RecipeID != 0 {
+		recipe, err := recipes.Get(listID, req.RecipeID)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "recipe_id", Message: "there is no recipe " + strconv.Itoa(req.RecipeID) + " on this list"})
+		} else if meal.Name == "" {
+			meal.Name = recipe.Name
+		}
+	}
+	if meal.Name == "" && req.RecipeID == 0 {
+		errs = append(errs, fieldError{Field: "name", Message: "a meal needs a recipe_id or a name"})
+	}
+	if len(meal.Name) > maxMealLength {
+		errs = append(errs, fieldError{Field: "name", Message: "name can be at most " + strconv.Itoa(maxMealLength) + " characters"})
+	}
+	return meal, errs
+}
+
+// Reads ?from= and ?to= from the request, from defaults to today in UTC and to to from, both are days like 2024-05-01
+func parseDateRange(r *http.Request) (from string, to string, err error) {
+	values := r.URL.Query()
+	start := time.Now().UTC()
+	if s := values.Get("from"); s != "" {
+		start, err = time.Parse(dateLayout, s)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: from has to be a day like 2024-05-01", errBadQuery)
+		}
+	}
+	end := start
+	if s := values.Get("to"); s != "" {
+		end, err = time.Parse(dateLayout, s)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: to has to be a day like 2024-05-01", errBadQuery)
+		}
+	}
+	if end.Before(start) {
+		return "", "", fmt.Errorf("%w: to can't be before from", errBadQuery)
+	}
+	return start.Format(dateLayout), end.Format(dateLayout), nil
+}
+
+// Handle Get request for the list's meal plan from ?from= to ?to=, both included, the whole plan when neither is given
+func handleGetMealPlan(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	from, to := "", "9999-12-31"
+	if values.Has("from") || values.Has("to") {
+		var err error
+		from, to, err = parseDateRange(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+			return
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, mealPlan.Between(requestIdentity(r).ListID, from, to))
+}
+
+// Handle Post request to plan a meal
+func handlePostMeal(w http.ResponseWriter, r *http.Request) {
+	putMeal(w, r, 0)
+}
+
+// Handle Put request to replace a planned meal, its ingredients count as not added yet again
+func handlePutMeal(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseMealID(w, r)
+	if !ok {
+		return
+	}
+	putMeal(w, r, id)
+}
+
+func putMeal(w http.ResponseWriter, r *http.Request, id int) {
+	var req mealRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	meal, errs := req.toMeal(requestIdentity(r).ListID)
+	if errs != nil {
+		writeFieldErrors(w, "invalid_meal", errs)
+		return
+	}
+	meal.ID = id
+	status := http.StatusCreated
+	if id != 0 {
+		status = http.StatusOK
+	}
+	meal, err = mealPlan.Put(meal)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "meal not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving meal plan", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, status, meal)
+}
+
+// Handle Delete request to take a meal off the plan
+func handleDeleteMeal(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseMealID(w, r)
+	if !ok {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := mealPlan.Delete(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "meal not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving meal plan", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Post request to put the ingredients of every meal planned from ?from= to ?to= on the list, merged like POST /recipes/{id}/add-to-list
+// meals whose ingredients were added before, meals without a recipe and meals whose recipe has since been deleted are skipped
+// the response is what each ingredient ended up as
+func handleAddMealPlanToList(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r)
+	if err == nil {
+		var start, end time.Time
+		start, _ = time.Parse(dateLayout, from)
+		end, _ = time.Parse(dateLayout, to)
+		if end.Sub(start) >= maxPlanDays*24*time.Hour {
+			err = fmt.Errorf("%w: from and to can be at most %d days apart", errBadQuery, maxPlanDays)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	now := time.Now().UTC()
+	var entries []Entry
+	var added []PlannedMeal
+	for _, meal := range mealPlan.Between(listID, from, to) {
+		if meal.AddedAt != nil || meal.RecipeID == 0 {
+			continue
+		}
+		recipe, err := recipes.Get(listID, meal.RecipeID)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, recipe.entries(cmp.Or(meal.Servings, recipe.Servings))...)
+		added = append(added, meal)
+	}
+	if len(entries) == 0 {
+		writeJSON(w, http.StatusOK, []Entry{})
+		return
+	}
+	for i := range entries {
+		prepareNewEntry(&entries[i], now)
+	}
+
+	var results, updated, created []Entry
+	err = trackChange(r, "mealplan", func(tx Store) error {
+		results, updated, created, err = addMerging(tx, listID, entries)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error adding meal plan", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// the entries are already on the list, so failing to record it is logged rather than failing the request
+	err = mealPlan.MarkAdded(added, now)
+	if err != nil {
+		slog.Error("Error saving meal plan", "err", err)
+	}
+	publishEntries(requestIdentity(r).Actor(), EventUpdated, updated...)
+	publishEntries(requestIdentity(r).Actor(), EventCreated, created...)
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Reads the {id} of a /mealplan/{id} route, writing the error itself when it isn't a number
+func parseMealID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "meal ID has to be a number")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics are written out by hand in the Prometheus text format rather than pulling in the client library
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// Upper bounds of the latency histogram buckets in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type requestKey struct {
+	method string
+	code   int
+}
+
+// histogram counts observations into latencyBuckets, counts[i] is the number at or below latencyBuckets[i] and isn't cumulative until it is written
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// serverMetrics holds every counter the server exposes on /metrics
+type serverMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram
+	// fileWriteErrors counts failed writes of the JSON data and document files
+	fileWriteErrors atomic.Uint64
+}
+
+var metrics = &serverMetrics{
+	requests:  make(map[requestKey]uint64),
+	latencies: make(map[string]*histogram),
+}
+
+// Records a finished request, streams pass observeLatency false because how long they stayed open isn't latency
+func (m *serverMetrics) observeRequest(method string, code int, duration time.Duration, observeLatency bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{method, code}]++
+	if !observeLatency {
+		return
+	}
+	h := m.latencies[method]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[method] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// Returns middleware that counts every request and how long it took
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		streaming := status == http.StatusSwitchingProtocols || rec.Header().Get("Content-Type") == "text/event-stream"
+		metrics.observeRequest(metricMethod(r.Method), status, time.Since(start), !streaming)
+	})
+}
+
+// Clients can send any method, so anything unusual is counted as "other" to stop them adding label values without end
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// Writes every metric in the Prometheus text format
+func (m *serverMetrics) writeTo(w io.Writer, entryCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP shoppinglist_http_requests_total Requests handled, by method and status code.")
+	fmt.Fprintln(w, "# TYPE shoppinglist_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if a.method != b.method {
+			if a.method < b.method {
+				return -1
+			}
+			return 1
+		}
+		return a.code - b.code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "shoppinglist_http_requests_total{method=%q,code=\"%d\"} %d\n", key.method, key.code, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP shoppinglist_http_request_duration_seconds How long requests took to answer, by method.")
+	fmt.Fprintln(w, "# TYPE shoppinglist_http_request_duration_seconds histogram")
+	methods := make([]string, 0, len(m.latencies))
+	for method := range m.latencies {
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	for _, method := range methods {
+		h := m.latencies[method]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "shoppinglist_http_request_duration_seconds_bucket{method=%q,le=%q} %d\n", method, le, cumulative)
+		}
+		fmt.Fprintf(w, "shoppinglist_http_request_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(w, "shoppinglist_http_request_duration_seconds_sum{method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(w, "shoppinglist_http_request_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP shoppinglist_entries Entries currently stored across every list.")
+	fmt.Fprintln(w, "# TYPE shoppinglist_entries gauge")
+	fmt.Fprintln(w, "shoppinglist_entries", entryCount)
+
+	fmt.Fprintln(w, "# HELP shoppinglist_file_write_errors_total Failed writes of the JSON data and document files.")
+	fmt.Fprintln(w, "# TYPE shoppinglist_file_write_errors_total counter")
+	fmt.Fprintln(w, "shoppinglist_file_write_errors_total", m.fileWriteErrors.Load())
+}
+
+// Handle Get request for the Prometheus metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	count, err := store.Count()
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error counting entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	var buf bytes.Buffer
+	metrics.writeTo(&buf, count)
+	writeBody(w, http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
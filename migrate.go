@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// migrateDocs are the documents the migrate command copies, everything a backup has plus the token signing secret,
+// the undo history, the data version and the barcode cache, so nobody is logged out, undo carries on working,
+// nothing is migrated twice and barcodes don't all have to be looked up again
+var migrateDocs = append(slices.Sorted(maps.Keys(backupDocs)), jwtSecretDocName, undoDocName, schemaDocName, barcodesDocName)
+
+// Runs "shoppinglist migrate", which copies every entry, document and audit record from one storage backend to another
+// and then reads them back from the new one to check they all arrived, it returns the exit code
+// the other settings e.g. -db-max-conns come from the environment the same as when serving
+func runMigrate(args []string, w io.Writer) int {
+	base, err := loadConfig(nil)
+	if err != nil {
+		fmt.Fprintln(w, "Error reading configuration: ", err)
+		return 2
+	}
+	from, to := base, base
+	fs := flag.NewFlagSet("shoppinglist migrate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(&from.Storage, "from", base.Storage, "storage backend to copy from: json, sqlite, bolt, postgres or redis")
+	fs.StringVar(&from.DataFile, "from-data", base.DataFile, "-data for the storage being copied from")
+	fs.StringVar(&to.Storage, "to", "", "storage backend to copy to: json, sqlite, bolt, postgres or redis")
+	fs.StringVar(&to.DataFile, "to-data", "", "-data for the storage being copied to")
+	force := fs.Bool("force", false, "replace the entries and documents that are already in the storage being copied to")
+	err = fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if to.Storage == "" || to.DataFile == "" {
+		fmt.Fprintln(w, "Error reading configuration: -to and -to-data have to be set")
+		return 2
+	}
+	if from.Storage == to.Storage && from.DataFile == to.DataFile {
+		fmt.Fprintln(w, "Error reading configuration: the storage to copy to is the one being copied from")
+		return 2
+	}
+
+	err = migrate(from, to, *force, w)
+	if err != nil {
+		fmt.Fprintln(w, "Error migrating: ", err)
+		return 1
+	}
+	return 0
+}
+
+func migrate(fromCfg Config, toCfg Config, force bool, w io.Writer) (err error) {
+	src, err := openStore(fromCfg)
+	if err != nil {
+		return fmt.Errorf("opening %s storage: %w", fromCfg.Storage, err)
+	}
+	defer src.Close()
+	dst, err := openStore(toCfg)
+	if err != nil {
+		return fmt.Errorf("opening %s storage: %w", toCfg.Storage, err)
+	}
+	// JSON only writes everything out on Close, so that has to have worked for the copy to count
+	defer func() {
+		closeErr := dst.Close()
+		if err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	if !force {
+		count, err := dst.Count()
+		if err != nil {
+			return err
+		}
+		var doc json.RawMessage
+		err = dst.LoadDoc(accountsDocName, &doc)
+		if count > 0 || err == nil {
+			return fmt.Errorf("the %s storage already has data in it, pass -force to replace it", toCfg.Storage)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	entries, err := src.All()
+	if err != nil {
+		return fmt.Errorf("reading entries: %w", err)
+	}
+	docs := make(map[string]json.RawMessage)
+	for _, name := range migrateDocs {
+		var doc json.RawMessage
+		err := src.LoadDoc(name, &doc)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		docs[name] = doc
+	}
+	audit, err := readAllAudit(src, entries, docs)
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+
+	err = dst.Transaction(func(tx Store) error {
+		err := tx.Replace(entries)
+		if err != nil {
+			return err
+		}
+		for _, name := range slices.Sorted(maps.Keys(docs)) {
+			err := tx.SaveDoc(name, docs[name])
+			if err != nil {
+				return fmt.Errorf("writing %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing entries: %w", err)
+	}
+	// a list that already has an audit log in the new storage keeps it rather than getting a second copy, that only
+	// happens with -force
+	copied := 0
+	for _, listID := range slices.Sorted(maps.Keys(audit)) {
+		existing, err := dst.Audit(auditQuery{ListID: listID})
+		if err != nil {
+			return fmt.Errorf("reading audit log: %w", err)
+		}
+		if len(existing) > 0 {
+			fmt.Fprintf(w, "Kept the audit log list %d already has in the %s storage\n", listID, toCfg.Storage)
+			delete(audit, listID)
+			continue
+		}
+		err = dst.AppendAudit(audit[listID])
+		if err != nil {
+			return fmt.Errorf("writing audit log: %w", err)
+		}
+		copied += len(audit[listID])
+	}
+
+	err = verifyMigration(dst, entries, docs, audit)
+	if err != nil {
+		return fmt.Errorf("checking the copy: %w", err)
+	}
+	fmt.Fprintf(w, "Copied %d entries, %d documents and %d audit records from %s to %s and checked them\n",
+		len(entries), len(docs), copied, fromCfg.Storage, toCfg.Storage)
+	return nil
+}
+
+// Reads the audit log of every list there are entries on or that an account owns, by list ID
+// the audit log can only be read one list at a time, a list that has been deleted with nothing left on it is missed
+func readAllAudit(s Store, entries []Entry, docs map[string]json.RawMessage) (map[int][]AuditRecord, error) {
+	listIDs := listIDs(entries)
+	if data, ok := docs[accountsDocName]; ok {
+		var accounts accountsDoc
+		err := json.Unmarshal(data, &accounts)
+		if err != nil {
+			return nil, err
+		}
+		for _, list := range accounts.Lists {
+			listIDs = append(listIDs, list.ID)
+		}
+	}
+	// the shared list used with API keys and -no-auth has no account
+	listIDs = append(listIDs, 0)
+
+	audit := make(map[int][]AuditRecord)
+	for _, listID := range listIDs {
+		if _, ok := audit[listID]; ok {
+			continue
+		}
+		records, err := s.Audit(auditQuery{ListID: listID})
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			audit[listID] = records
+		}
+	}
+	return audit, nil
+}
+
+// Reads everything back from the new storage and compares it with what was copied
+func verifyMigration(s Store, entries []Entry, docs map[string]json.RawMessage, audit map[int][]AuditRecord) error {
+	stored, err := s.All()
+	if err != nil {
+		return err
+	}
+	if len(stored) != len(entries) {
+		return fmt.Errorf("%d entries were copied but %d are stored", len(entries), len(stored))
+	}
+	for i := range entries {
+		want := entries[i]
+		normalizeEntry(&want)
+		if !reflect.DeepEqual(stored[i], want) {
+			return fmt.Errorf("entry %d is different once stored", want.ID)
+		}
+	}
+	for name, data := range docs {
+		var want, got any
+		json.Unmarshal(data, &want)
+		err := s.LoadDoc(name, &got)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("document %s is different once stored", name)
+		}
+	}
+	for listID, records := range audit {
+		stored, err := s.Audit(auditQuery{ListID: listID})
+		if err != nil {
+			return err
+		}
+		if len(stored) != len(records) {
+			return fmt.Errorf("%d audit records were copied for list %d but %d are stored", len(records), listID, len(stored))
+		}
+	}
+	return nil
+}
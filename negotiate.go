@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Picks which of the offered media types to answer with from the Accept header, or "" if the client takes none of them
+// the most specific range that matches an offer sets its quality, e.g. "text/*;q=0.5, text/html" takes text/plain at 0.5,
+// and when offers tie the earlier one wins, so with no Accept header or */* the first offer is used
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		offerType, _, _ := strings.Cut(offer, "/")
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			rangeType, rangeSubtype, _ := strings.Cut(r.mediaType, "/")
+			s := -1
+			switch {
+			case r.mediaType == offer:
+				s = 2
+			case rangeType == offerType && rangeSubtype == "*":
+				s = 1
+			case r.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// Writes entries as a plain text checklist, one a line e.g. "[ ] milk (2 l) @ Aldi"
+func renderPlainList(entries []Entry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		check := " "
+		if entry.Completed {
+			check = "x"
+		}
+		buf.WriteString("[" + check + "] " + entry.Item)
+		if amount := entryAmount(entry); amount != "1" {
+			buf.WriteString(" (" + amount + ")")
+		}
+		if entry.Store != "" {
+			buf.WriteString(" @ " + entry.Store)
+		}
+		if entry.Notes != "" {
+			buf.WriteString(" - " + strings.Join(strings.Fields(entry.Notes), " "))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// Writes the quantity and unit together e.g. "2 l", just "1" for one of something
+func entryAmount(entry Entry) string {
+	amount := formatQuantity(entry.Quantity)
+	if entry.Unit != "" {
+		amount += " " + entry.Unit
+	}
+	return amount
+}
+
+// listPage is a whole HTML page with no scripts or style sheets, so it works in e-readers and other simple browsers
+var listPage = template.Must(template.New("list").Funcs(template.FuncMap{"amount": entryAmount}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Shopping list</title>
+</head>
+<body>
+<h1>Shopping list</h1>
+<table border="1" cellpadding="4">
+<tr><th>Done</th><th>Item</th><th>Amount</th><th>Category</th><th>Store</th><th>Notes</th></tr>
+{{range .}}<tr><td>{{if .Completed}}&#x2713;{{end}}</td><td>{{if .Completed}}<s>{{.Item}}</s>{{else}}{{.Item}}{{end}}</td><td>{{amount .}}</td><td>{{.Category}}</td><td>{{.Store}}</td><td>{{.Notes}}</td></tr>
+{{else}}<tr><td colspan="6">Nothing on the list.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes entries as an HTML page with a table of them
+func renderHTMLList(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	err := listPage.Execute(&buf, entries)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifier pushes a short message to a phone through a notification service
+type notifier interface {
+	// Name is used in log messages
+	Name() string
+	Notify(ctx context.Context, title string, message string) error
+}
+
+// notifierConfig is one entry in the -notifiers-file, the fields that are used depend on Provider
+type notifierConfig struct {
+	// Provider is "ntfy", "pushover" or "telegram"
+	Provider string `json:"provider"`
+	// Server replaces the provider's public API URL, for a self-hosted ntfy or a proxy
+	Server string `json:"server"`
+	// Topic is the ntfy topic to publish to
+	Topic string `json:"topic"`
+	// Token is the ntfy access token, the Pushover application token or the Telegram bot token
+	Token string `json:"token"`
+	// User is the Pushover user or group key
+	User string `json:"user"`
+	// ChatID is the Telegram chat the bot sends to
+	ChatID string `json:"chat_id"`
+	// Lists are the list IDs to send changes from, empty for every list
+	Lists []int `json:"lists"`
+	// Events are the event types to send, empty for just "created"
+	Events []string `json:"events"`
+}
+
+// notifiersFile is the layout of the file passed with -notifiers-file
+type notifiersFile struct {
+	Notifiers []notifierConfig `json:"notifiers"`
+}
+
+// configuredNotifier is a notifier and the changes it wants to hear about
+type configuredNotifier struct {
+	notifier
+	lists  []int
+	events []string
+}
+
+func (n configuredNotifier) wants(e Event) bool {
+	return (len(n.lists) == 0 || slices.Contains(n.lists, e.ListID)) && slices.Contains(n.events, e.Type)
+}
+
+// Reads the notifiers from the JSON file at path, an empty path means there aren't any
+func loadNotifiers(path string) ([]configuredNotifier, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file notifiersFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var notifiers []configuredNotifier
+	for i, c := range file.Notifiers {
+		n, err := c.build(client)
+		if err != nil {
+			return nil, fmt.Errorf("%s: notifier %d: %w", path, i, err)
+		}
+		events := c.Events
+		if len(events) == 0 {
+			events = []string{EventCreated}
+		}
+		for _, eventType := range events {
+			switch eventType {
+			case EventCreated, EventUpdated, EventCompleted, EventDeleted:
+			default:
+				return nil, fmt.Errorf("%s: notifier %d: unknown event %q, expected created, updated, completed or deleted", path, i, eventType)
+			}
+		}
+		notifiers = append(notifiers, configuredNotifier{notifier: n, lists: c.Lists, events: events})
+	}
+	return notifiers, nil
+}
+
+func (c notifierConfig) build(client *http.Client) (notifier, error) {
+	switch c.Provider {
+	case "ntfy":
+		if c.Topic == "" {
+			return nil, errors.New("ntfy needs a topic")
+		}
+		return ntfyNotifier{client: client, server: serverURL(c.Server, "https://ntfy.sh"), topic: c.Topic, token: c.Token}, nil
+	case "pushover":
+		if c.Token == "" || c.User == "" {
+			return nil, errors.New("pushover needs a token and a user")
+		}
+		return pushoverNotifier{client: client, server: serverURL(c.Server, "https://api.pushover.net"), token: c.Token, user: c.User}, nil
+	case "telegram":
+		if c.Token == "" || c.ChatID == "" {
+			return nil, errors.New("telegram needs a token and a chat_id")
+		}
+		return telegramNotifier{client: client, server: serverURL(c.Server, "https://api.telegram.org"), token: c.Token, chatID: c.ChatID}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, expected ntfy, pushover or telegram", c.Provider)
+	}
+}
+
+// Returns the configured server, or the provider's public one when it is empty, without a trailing slash
+func serverURL(server string, fallback string) string {
+	if server == "" {
+		server = fallback
+	}
+	return strings.TrimSuffix(server, "/")
+}
+
+// ntfyNotifier publishes to an ntfy topic, https://docs.ntfy.sh/publish/
+type ntfyNotifier struct {
+	client *http.Client
+	server string
+	topic  string
+	token  string
+}
+
+func (n ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n ntfyNotifier) Notify(ctx context.Context, title string, message string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.server+"/"+url.PathEscape(n.topic), strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return sendNotification(n.client, req)
+}
+
+// pushoverNotifier sends through the Pushover messages API, https://pushover.net/api
+type pushoverNotifier struct {
+	client *http.Client
+	server string
+	token  string
+	user   string
+}
+
+func (n pushoverNotifier) Name() string {
+	return "pushover"
+}
+
+func (n pushoverNotifier) Notify(ctx context.Context, title string, message string) error {
+	form := url.Values{"token": {n.token}, "user": {n.user}, "title": {title}, "message": {message}}
+	return postForm(ctx, n.client, n.server+"/1/messages.json", form)
+}
+
+// telegramNotifier sends as a Telegram bot, https://core.telegram.org/bots/api#sendmessage
+type telegramNotifier struct {
+	client *http.Client
+	server string
+	token  string
+	chatID string
+}
+
+func (n telegramNotifier) Name() string {
+	return "telegram"
+}
+
+func (n telegramNotifier) Notify(ctx context.Context, title string, message string) error {
+	form := url.Values{"chat_id": {n.chatID}, "text": {title + "\n" + message}}
+	return postForm(ctx, n.client, n.server+"/bot"+n.token+"/sendMessage", form)
+}
+
+func postForm(ctx context.Context, client *http.Client, target string, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return sendNotification(client, req)
+}
+
+// Sends the request and turns a non-2xx response into an error
+// the URL is left out of errors because the Telegram one has the bot token in it
+func sendNotification(client *http.Client, req *http.Request) error {
+	req.Header.Set("User-Agent", "shoppinglist-notifier")
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Describes the change in an event as a short sentence e.g. "milk added by alice"
+func notificationMessage(e Event) string {
+	what := fmt.Sprintf("Entry %d", e.ID)
+	if e.Entry != nil {
+		what = e.Entry.Item
+	}
+	var verb string
+	switch e.Type {
+	case EventCreated:
+		verb = "added"
+	case EventUpdated:
+		verb = "changed"
+	case EventCompleted:
+		verb = "ticked off"
+	case EventDeleted:
+		verb = "removed"
+	}
+	if e.Actor == "" {
+		return what + " " + verb
+	}
+	return what + " " + verb + " by " + e.Actor
+}
+
+// Listens to every list's events and sends the ones each notifier wants until the hub shuts down
+// notifications aren't retried, a missed one isn't worth holding up the ones after it
+func runNotifiers(ctx context.Context, notifiers []configuredNotifier) {
+	if len(notifiers) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		sub := hub.SubscribeAll(eventHistorySize)
+		for e := range sub.send {
+			if e.Type == EventReset {
+				continue
+			}
+			title := fmt.Sprintf("Shopping list %d", e.ListID)
+			message := notificationMessage(e)
+			for _, n := range notifiers {
+				if !n.wants(e) {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := n.Notify(ctx, title, message)
+					if err != nil {
+						slog.Error("Error sending notification", "provider", n.Name(), "event", e.Seq, "err", err)
+					}
+				}()
+			}
+		}
+		// the hub closes send when it drops a subscriber that fell behind or when it shuts down
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Notifier fell behind and missed events")
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// apiOperation describes one route for the OpenAPI document, the request and response schemas are worked out from the Go types the handler decodes and writes
+// so a field added to Entry shows up in GET /openapi.json without anyone having to remember to document it
+type apiOperation struct {
+	// Pattern is the route's ServeMux pattern e.g. "GET /data/{id}"
+	Pattern string
+	Summary string
+	Query   []apiParam
+	// List is true for routes that work on the list picked with ?list=
+	List bool
+	// Request and Response are values of the body types, nil when there is no body
+	Request  any
+	Response any
+	// Status is the status of a successful response, 200 when it is 0
+	Status int
+	// ContentType is the type of a response that isn't JSON e.g. "text/csv"
+	ContentType string
+	// Public routes can be called without credentials
+	Public bool
+	// BasicAuth routes also take a username and password or an API key with "Authorization: Basic", see requireBasicAuth
+	BasicAuth bool
+}
+
+// apiParam is a query parameter, Type is a JSON Schema type
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// entryQueryParams are the parameters parseEntryQuery reads
+var entryQueryParams = []apiParam{
+	{"completed", "boolean", "only entries that are or aren't ticked off"},
+	{"q", "string", "only entries whose item contains this, ignoring case"},
+	{"tag", "string", "only entries with this tag, repeat it to need several"},
+	{"store", "string", "leave out entries for other shops"},
+	{"sort", "string", "item, created, completed or priority"},
+	{"order", "string", "asc or desc"},
+	{"groupBy", "string", "category to group the entries by aisle"},
+	{"limit", "integer", "the most entries to return"},
+	{"offset", "integer", "how many entries to skip"},
+}
+
+// apiOperations is every HTTP route in newRouter except the web UI's, the gRPC service is described by shoppinglist.proto instead
+var apiOperations = []apiOperation{
+	{Pattern: "GET /data", Summary: "List the entries, as a text/plain checklist or a text/html table if Accept asks for one", Query: entryQueryParams, List: true, Response: []Entry{}},
+	{Pattern: "GET /data/{id}", Summary: "Get an entry, its ETag is its revision", List: true, Response: Entry{}},
+	{Pattern: "POST /data", Summary: "Add entries, or a single entry, and get back what they were added as with their IDs, with ?dedupe=merge an item already on the list has its quantity increased", List: true,
+		Query: []apiParam{{"dedupe", "string", "merge to add to the quantity of items already on the list"}}, Request: []Entry{}, Response: []Entry{}, Status: http.StatusCreated},
+	{Pattern: "POST /data/bulk", Summary: "Make several changes at once, all or nothing", List: true, Request: []bulkOperation{}, Response: []bulkResult{}},
+	{Pattern: "POST /data/clear-completed", Summary: "Move every ticked off entry to the trash", List: true, Response: []Entry{}},
+	{Pattern: "DELETE /data", Summary: "Move every entry to the trash, needs ?confirm=true", List: true,
+		Query: []apiParam{{"confirm", "boolean", "true to confirm the whole list is to be emptied"}}, Response: []Entry{}},
+	{Pattern: "PUT /data/{id}", Summary: "Change an entry, send If-Match or revision to refuse stale changes", List: true, Request: EntryPatch{}, Response: Entry{}},
+	{Pattern: "PATCH /data/{id}", Summary: "Change the fields of an entry that are sent", List: true, Request: EntryPatch{}, Response: Entry{}},
+	{Pattern: "DELETE /data/{id}", Summary: "Move an entry to the trash", List: true, Status: http.StatusNoContent},
+	{Pattern: "POST /data/{id}/complete", Summary: "Tick an entry off", List: true, Response: Entry{}},
+	{Pattern: "POST /data/{id}/uncomplete", Summary: "Un-tick an entry", List: true, Response: Entry{}},
+	{Pattern: "POST /data/{id}/restore", Summary: "Take an entry back out of the trash", List: true, Response: Entry{}},
+	{Pattern: "POST /data/{id}/snooze", Summary: "Put off an entry's reminder", List: true, Request: snoozeRequest{}, Response: reminderStatus{}},
+	{Pattern: "GET /export/csv", Summary: "Export the list as CSV", List: true, ContentType: "text/csv"},
+	{Pattern: "GET /export/markdown", Summary: "Export the list as a Markdown checklist", List: true, ContentType: "text/markdown"},
+	{Pattern: "GET /print", Summary: "The list as a two column PDF checklist grouped by aisle to print, ticked off entries are left out unless completed is given", List: true, Query: entryQueryParams, ContentType: "application/pdf"},
+	{Pattern: "GET /tags", Summary: "List the tags in use and how many entries have each", List: true, Response: []tagCount{}},
+	{Pattern: "GET /webhooks", Summary: "List the list's webhooks", List: true, Response: []Webhook{}},
+	{Pattern: "POST /webhooks", Summary: "Add a webhook", List: true, Request: webhookRequest{}, Response: Webhook{}, Status: http.StatusCreated},
+	{Pattern: "DELETE /webhooks/{id}", Summary: "Remove a webhook", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /reminders", Summary: "List the reminders for entries with a due date", List: true, Response: []reminderStatus{}},
+	{Pattern: "GET /recurring", Summary: "List the recurring items", List: true, Response: []RecurringItem{}},
+	{Pattern: "POST /recurring", Summary: "Add a recurring item", List: true, Request: recurringRequest{}, Response: RecurringItem{}, Status: http.StatusCreated},
+	{Pattern: "PUT /recurring/{id}", Summary: "Change a recurring item", List: true, Request: recurringRequest{}, Response: RecurringItem{}},
+	{Pattern: "DELETE /recurring/{id}", Summary: "Remove a recurring item", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /stores", Summary: "List the shops and how many entries are for each", List: true, Response: []storeCount{}},
+	{Pattern: "POST /stores", Summary: "Add a shop", List: true, Request: struct {
+		Name string `json:"name"`
+	}{}, Response: []string{}, Status: http.StatusCreated},
+	{Pattern: "DELETE /stores/{name}", Summary: "Remove a shop", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /categories", Summary: "List the categories in aisle order", List: true, Response: []string{}},
+	{Pattern: "PUT /categories", Summary: "Set the categories and their order", List: true, Request: []string{}, Response: []string{}},
+	{Pattern: "POST /categories", Summary: "Add a category", List: true, Request: struct {
+		Name string `json:"name"`
+	}{}, Response: []string{}, Status: http.StatusCreated},
+	{Pattern: "DELETE /categories/{name}", Summary: "Remove a category", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /audit", Summary: "Read the audit log, newest first", List: true, Response: []AuditRecord{}, Query: []apiParam{
+		{"entry", "integer", "only records for this entry"},
+		{"item", "string", "only records for items whose name contains this"},
+		{"since", "string", "only records from this RFC 3339 time on"},
+		{"until", "string", "only records before this RFC 3339 time"},
+		{"limit", "integer", "the most records to return"},
+	}},
+	{Pattern: "POST /undo", Summary: "Revert the last change", List: true, Response: undoResponse{}},
+	{Pattern: "GET /changes", Summary: "Read the change feed from a cursor", List: true, Response: changeFeed{}, Query: []apiParam{
+		{"since", "string", "the cursor from the last response, leave it out to get the current cursor"},
+		{"limit", "integer", "the most changes to return"},
+	}},
+	{Pattern: "POST /sync", Summary: "Send changes made offline and get the changes made since", List: true, Request: syncRequest{}, Response: syncResponse{}},
+	{Pattern: "GET /conflicts", Summary: "List the unresolved sync conflicts", List: true, Response: []conflictView{}},
+	{Pattern: "POST /conflicts/{id}/resolve", Summary: "Settle a sync conflict", List: true, Request: resolveRequest{}, Response: Entry{}},
+	{Pattern: "POST /trips/close", Summary: "Close the shopping trip, keeping the ticked off entries in it and taking them off the list", List: true, Response: Trip{}, Status: http.StatusCreated},
+	{Pattern: "GET /trips", Summary: "List the closed trips without their entries, most recent first", List: true, Response: []Trip{}},
+	{Pattern: "GET /trips/{id}", Summary: "Get a closed trip with what was bought on it", List: true, Response: Trip{}},
+	{Pattern: "GET /stats", Summary: "How often items are bought and what was spent on each category each month, from the closed trips", List: true,
+		Query: []apiParam{{"limit", "integer", "how many items to list, 20 when it isn't given"}}, Response: purchaseStats{}},
+	{Pattern: "POST /scan", Summary: "Add the product with an EAN or UPC barcode, looked up in the -barcode-api database", List: true,
+		Request: scanRequest{}, Response: Entry{}, Status: http.StatusCreated},
+	{Pattern: "GET /recipes", Summary: "List the recipes", List: true, Response: []Recipe{}},
+	{Pattern: "POST /recipes", Summary: "Save a recipe", List: true, Request: recipeRequest{}, Response: Recipe{}, Status: http.StatusCreated},
+	{Pattern: "GET /recipes/{id}", Summary: "Get a recipe", List: true, Response: Recipe{}},
+	{Pattern: "PUT /recipes/{id}", Summary: "Replace a recipe", List: true, Request: recipeRequest{}, Response: Recipe{}},
+	{Pattern: "DELETE /recipes/{id}", Summary: "Delete a recipe", List: true, Status: http.StatusNoContent},
+	{Pattern: "POST /recipes/{id}/add-to-list", Summary: "Put a recipe's ingredients on the list scaled to the servings, adding to items already there", List: true,
+		Request: recipeServings{}, Response: []Entry{}},
+	{Pattern: "GET /mealplan", Summary: "The meals planned from one day to another, the whole plan when neither is given", List: true,
+		Query: []apiParam{{"from", "string", "the first day e.g. 2024-05-01, today when only to is given"}, {"to", "string", "the last day, the same as from when it isn't given"}}, Response: []PlannedMeal{}},
+	{Pattern: "POST /mealplan", Summary: "Plan a recipe or a named meal for a day", List: true, Request: mealRequest{}, Response: PlannedMeal{}, Status: http.StatusCreated},
+	{Pattern: "PUT /mealplan/{id}", Summary: "Replace a planned meal", List: true, Request: mealRequest{}, Response: PlannedMeal{}},
+	{Pattern: "DELETE /mealplan/{id}", Summary: "Take a meal off the plan", List: true, Status: http.StatusNoContent},
+	{Pattern: "POST /mealplan/add-to-list", Summary: "Put the ingredients of the meals planned from one day to another on the list, skipping meals already added", List: true,
+		Query: []apiParam{{"from", "string", "the first day e.g. 2024-05-01, today when it isn't given"}, {"to", "string", "the last day, the same as from when it isn't given"}}, Response: []Entry{}},
+	{Pattern: "GET /digest", Summary: "When and where your weekly email of the list goes, only with -smtp-addr", List: true, Response: digestResponse{}},
+	{Pattern: "PUT /digest", Summary: "Set up or change your weekly email of the list picked with ?list=", List: true, Request: digestSettings{}, Response: digestResponse{}},
+	{Pattern: "DELETE /digest", Summary: "Stop your weekly email and forget its settings", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /digest/unsubscribe", Summary: "The page the link at the bottom of the weekly email opens, which asks before turning it off", ContentType: "text/html", Public: true,
+		Query: []apiParam{{"token", "string", "the token from the link"}}},
+	{Pattern: "POST /digest/unsubscribe", Summary: "Turn off the weekly email, from the unsubscribe page or a mail client's one-click unsubscribe, the token can be in the form instead", ContentType: "text/html", Public: true,
+		Query: []apiParam{{"token", "string", "the token from the link"}}},
+	{Pattern: "GET /calendar.ics", Summary: "The entries with due dates and the planned meals as an iCalendar feed to subscribe to", List: true, ContentType: "text/calendar", BasicAuth: true},
+	{Pattern: "GET /aliases", Summary: "The list's aliases, from each alias to the item it stands for", List: true, Response: map[string]string{}},
+	{Pattern: "PUT /aliases/{alias}", Summary: "Make a name an alias for an item, entries added under it are added as the item", List: true, Request: aliasRequest{}, Response: map[string]string{}},
+	{Pattern: "DELETE /aliases/{alias}", Summary: "Stop a name being an alias", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /units", Summary: "The units quantities are converted between when entries are merged, the list's own and the built in ones", List: true, Response: []unitConversion{}},
+	{Pattern: "PUT /units/{unit}", Summary: "Give the list its own unit, or its own size for a built in one, in terms of a built in unit", List: true, Request: unitRequest{}, Response: unitConversion{}},
+	{Pattern: "DELETE /units/{unit}", Summary: "Take away one of the list's own units", List: true, Status: http.StatusNoContent},
+	{Pattern: "GET /autocomplete", Summary: "Item names used on the list before that start with q, the most used first, with their usual quantity and category", List: true,
+		Query: []apiParam{{"q", "string", "what has been typed so far"}, {"limit", "integer", "how many to return, 10 when it isn't given"}}, Response: []completion{}},
+	{Pattern: "GET /suggestions", Summary: "Items that are usually bought by now and aren't on the list, with a score from 0 to 1", List: true,
+		Query: []apiParam{{"limit", "integer", "how many to return, 10 when it isn't given"}}, Response: []suggestion{}},
+	{Pattern: "GET /trash", Summary: "List the entries in the trash", List: true, Response: []Entry{}},
+	{Pattern: "GET /ws", Summary: "Stream changes to the list over a WebSocket", List: true, Status: http.StatusSwitchingProtocols},
+	{Pattern: "GET /events", Summary: "Stream changes to the list as server-sent events", List: true, ContentType: "text/event-stream",
+		Query: []apiParam{{"lastEventId", "string", "the seq of the last event seen, the same as the Last-Event-ID header"}}},
+	{Pattern: "GET /backup", Summary: "Take a backup of everything, admins and API keys only", Response: Backup{}},
+	{Pattern: "GET /backups", Summary: "List the scheduled backups, admins and API keys only", Response: []storedBackup{}},
+	{Pattern: "POST /restore", Summary: "Restore a backup, admins and API keys only", Request: Backup{}, Response: restoreReport{},
+		Query: []apiParam{{"dry_run", "boolean", "report what would change without changing anything"}}},
+	{Pattern: "GET /admin/users", Summary: "List every account, admins and API keys only", Response: []userResponse{}},
+	{Pattern: "GET /admin/users/{username}", Summary: "Get an account with the lists it owns or has been shared, admins and API keys only", Response: adminUserResponse{}},
+	{Pattern: "PUT /admin/users/{username}/role", Summary: "Make a user an admin or take it away, admins and API keys only", Request: roleRequest{}, Response: adminUserResponse{}},
+	{Pattern: "POST /admin/users/{username}/password", Summary: "Set a user's password and log them out everywhere, admins and API keys only", Request: passwordRequest{}, Status: http.StatusNoContent},
+	{Pattern: "DELETE /admin/users/{username}", Summary: "Delete an account along with the lists it owns, admins and API keys only", Status: http.StatusNoContent},
+	{Pattern: "DELETE /admin/users/{username}/2fa", Summary: "Turn off a user's two-factor authentication when they have lost their authenticator app and recovery codes, admins and API keys only", Response: adminUserResponse{}},
+	{Pattern: "POST /admin/delete-inactive", Summary: "Delete the accounts that haven't logged in for a number of days, except admins, admins and API keys only", Response: inactiveReport{},
+		Query: []apiParam{{"days", "integer", "how many days without logging in makes an account inactive"}, {"dry_run", "boolean", "report who would be deleted without deleting anyone"}}},
+	{Pattern: "GET /admin/storage", Summary: "How much is stored and how big the storage is, admins and API keys only", Response: storageStats{}},
+	{Pattern: "POST /admin/compact", Summary: "Compact the storage now, only the json, sqlite and postgres storage need it, admins and API keys only", Status: http.StatusNoContent},
+	{Pattern: "POST /admin/backup", Summary: "Write a scheduled backup now, only with -backup-interval, admins and API keys only", Response: adminBackupResponse{}, Status: http.StatusCreated},
+	{Pattern: "GET /lists", Summary: "List the lists the caller owns or has been shared", Response: []listResponse{}},
+	{Pattern: "GET /lists/{id}/summary", Summary: "Summarise a list's spending against its budget", Response: listSummary{}},
+	{Pattern: "PUT /lists/{id}/budget", Summary: "Set a list's budget", Request: Budget{}, Response: Budget{}},
+	{Pattern: "DELETE /lists/{id}/budget", Summary: "Remove a list's budget", Status: http.StatusNoContent},
+	{Pattern: "POST /lists/{id}/share-link", Summary: "Make a read-only link to the list that works without an account, replacing its old one, only the owner can", Response: shareLinkResponse{}, Status: http.StatusCreated},
+	{Pattern: "GET /lists/{id}/share-link", Summary: "Get the list's read-only link", Response: shareLinkResponse{}},
+	{Pattern: "DELETE /lists/{id}/share-link", Summary: "Stop the list's read-only link working", Status: http.StatusNoContent},
+	{Pattern: "GET /lists/{id}/share-qr", Summary: "A QR code of the list's read-only link", ContentType: "image/png",
+		Query: []apiParam{{"scale", "integer", "pixels a module, 8 by default"}}},
+	{Pattern: "GET /shared", Summary: "A list shared with a read-only link, an HTML page unless Accept asks for JSON", Public: true, ContentType: "text/html",
+		Query: []apiParam{{"token", "string", "the token from the link"}}},
+	{Pattern: "GET /lists/{id}/guest-tokens", Summary: "List the list's guest tokens, which can only add items", Response: []guestTokenResponse{}},
+	{Pattern: "POST /lists/{id}/guest-tokens", Summary: "Make a token that can only add items to the list, the token and its link are only in this response, only the owner can",
+		Request: guestTokenRequest{}, Response: guestTokenResponse{}, Status: http.StatusCreated},
+	{Pattern: "DELETE /lists/{id}/guest-tokens/{tokenId}", Summary: "Stop a guest token working", Status: http.StatusNoContent},
+	{Pattern: "GET /guest", Summary: "The add form a guest link opens", Public: true, ContentType: "text/html",
+		Query: []apiParam{{"token", "string", "the guest token"}}},
+	{Pattern: "POST /guest/add", Summary: "Put an item on the list with a guest token as the bearer token, or from the guest form", Public: true,
+		Request: guestAddRequest{}, Response: guestAddRequest{}, Status: http.StatusCreated},
+	{Pattern: "GET /lists/{id}/conflict-strategy", Summary: "Get how a list settles sync conflicts", Response: conflictStrategy{}},
+	{Pattern: "PUT /lists/{id}/conflict-strategy", Summary: "Set how a list settles sync conflicts", Request: conflictStrategy{}, Response: conflictStrategy{}},
+	{Pattern: "POST /lists/{id}/share", Summary: "Share a list with another user as a viewer or editor", Request: shareRequest{}, Response: listResponse{}},
+	{Pattern: "DELETE /lists/{id}/share/{username}", Summary: "Stop sharing a list with a user", Response: listResponse{}},
+	{Pattern: "POST /register", Summary: "Create an account", Request: credentials{}, Response: userResponse{}, Status: http.StatusCreated, Public: true},
+	{Pattern: "POST /login", Summary: "Log in for an access and a refresh token, accounts with two-factor authentication send a code as well", Request: credentials{}, Response: tokenResponse{}, Public: true},
+	{Pattern: "POST /refresh", Summary: "Swap a refresh token for new tokens", Request: refreshRequest{}, Response: tokenResponse{}, Public: true},
+	{Pattern: "POST /logout", Summary: "Revoke a refresh token", Request: refreshRequest{}, Status: http.StatusNoContent, Public: true},
+	{Pattern: "GET /me", Summary: "Get the logged in user", Response: userResponse{}},
+	{Pattern: "POST /me/password", Summary: "Change the logged in user's password, which ends every session and returns new tokens", Request: passwordChange{}, Response: tokenResponse{}},
+	{Pattern: "PUT /me/email", Summary: "Set or remove the email password resets are sent to", Request: emailChange{}, Response: userResponse{}},
+	{Pattern: "POST /me/2fa", Summary: "Start setting up two-factor authentication, returns the secret as an otpauth:// URI and a QR code to scan", Request: totpEnrollRequest{}, Response: totpEnrollResponse{}, Status: http.StatusCreated},
+	{Pattern: "POST /me/2fa/confirm", Summary: "Turn two-factor authentication on with the first code from the authenticator app, returns recovery codes that are only shown this once", Request: totpCodeRequest{}, Response: recoveryCodesResponse{}},
+	{Pattern: "DELETE /me/2fa", Summary: "Turn two-factor authentication off with the password and a code or recovery code", Request: totpCodeRequest{}, Status: http.StatusNoContent},
+	{Pattern: "POST /auth/forgot", Summary: "Email a password reset token to the account, always 202 whether or not there is one, only with -smtp-addr", Request: forgotRequest{}, Status: http.StatusAccepted, Public: true},
+	{Pattern: "GET /auth/reset", Summary: "The form the link in a reset email opens", Public: true, ContentType: "text/html",
+		Query: []apiParam{{"token", "string", "the token from the email"}}},
+	{Pattern: "POST /auth/reset", Summary: "Set a new password with a reset token, as JSON or from the reset form", Request: resetRequest{}, Status: http.StatusNoContent, Public: true},
+	{Pattern: "GET /simple/add", Summary: "Add items from a webhook service like IFTTT or Zapier, everything is a query parameter, merged like POST /data?dedupe=merge", List: true, Query: simpleAddParams, Response: []Entry{}},
+	{Pattern: "POST /simple/add", Summary: "Add items with the same parameters as GET /simple/add sent as a urlencoded form", List: true, Query: simpleAddParams, Response: []Entry{}},
+	{Pattern: "GET /simple/done", Summary: "Tick off the first item still to get with this name, for webhook services", List: true, Query: simpleDoneParams, Response: Entry{}},
+	{Pattern: "POST /simple/done", Summary: "Tick off an item with the same parameters as GET /simple/done sent as a urlencoded form", List: true, Query: simpleDoneParams, Response: Entry{}},
+	{Pattern: "GET /simple/list", Summary: "List the items still to get, for webhook services", List: true, Query: []apiParam{simpleKeyParam}, Response: []Entry{}},
+	{Pattern: "POST /alexa", Summary: "Alexa custom skill endpoint for the AddItem, ReadList and CompleteItem intents, only with -alexa-skill-id, requests have to be signed by Amazon", Public: true,
+		Request: alexaRequest{}, Response: alexaResponse{}},
+	{Pattern: "POST /integrations/slack", Summary: "Slack slash command endpoint for add, list and done, only with -slack-signing-secret, requests have to be signed by Slack", Public: true,
+		Response: slackResponse{}},
+	{Pattern: "POST /integrations/discord", Summary: "Discord interactions endpoint for add, list and done, only with -discord-public-key, requests have to be signed by Discord", Public: true,
+		Request: discordInteraction{}, Response: discordResponse{}},
+	{Pattern: "PUT /integrations/matrix/_matrix/app/v1/transactions/{txnId}", Summary: "Matrix application service transactions, answers !shop messages, only with -matrix-homeserver, takes the hs_token", Public: true,
+		Request: matrixTransaction{}},
+	{Pattern: "GET /metrics", Summary: "Prometheus metrics", ContentType: "text/plain", Public: true},
+	{Pattern: "GET /openapi.json", Summary: "This document", ContentType: "application/json", Public: true},
+	{Pattern: "GET /docs", Summary: "Swagger UI for this document", ContentType: "text/html", Public: true},
+}
+
+// simpleKeyParam is how the /simple routes take an API key, see requireKey
+var simpleKeyParam = apiParam{"key", "string", "an API key, for services that can't set an Authorization header"}
+
+var simpleAddParams = []apiParam{
+	simpleKeyParam,
+	{"item", "string", "the item to add, can be given more than once"},
+	{"quantity", "number", "how many of each item"},
+	{"unit", "string", "the unit of the quantity"},
+	{"category", "string", "where the items are found in the shop"},
+}
+
+var simpleDoneParams = []apiParam{
+	simpleKeyParam,
+	{"item", "string", "the item to tick off, matched the same way duplicates are"},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-zA-Z_]+)\}`)
+
+// openAPIDocument is built the first time it is asked for, the routes and types can't change while the server runs
+var openAPIDocument = sync.OnceValue(func() []byte {
+	doc, err := json.Marshal(buildOpenAPI(apiOperations))
+	if err != nil {
+		panic(err)
+	}
+	return doc
+})
+
+// Builds an OpenAPI 3.0 document for the operations
+func buildOpenAPI(operations []apiOperation) map[string]any {
+	schemas := schemaBuilder{components: map[string]any{}}
+	errorSchema := schemas.schema(reflect.TypeFor[errorResponse]())
+
+	paths := map[string]map[string]any{}
+	for _, op := range operations {
+		method, path, _ := strings.Cut(op.Pattern, " ")
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationID(method, path),
+			"tags":        []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]},
+		}
+		if op.Public {
+			operation["security"] = []any{}
+		}
+		if op.BasicAuth {
+			operation["security"] = []any{map[string]any{"bearerAuth": []string{}}, map[string]any{"basicAuth": []string{}}}
+		}
+
+		params := []any{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			// every {id} is a number, the other path parameters are names
+			typ := "string"
+			if match[1] == "id" {
+				typ = "integer"
+			}
+			params = append(params, map[string]any{"name": match[1], "in": "path", "required": true, "schema": map[string]any{"type": typ}})
+		}
+		if op.List {
+			params = append(params, map[string]any{"name": "list", "in": "query", "description": "the list to use, the caller's own list when it is left out", "schema": map[string]any{"type": "integer"}})
+		}
+		for _, p := range op.Query {
+			params = append(params, map[string]any{"name": p.Name, "in": "query", "description": p.Description, "schema": map[string]any{"type": p.Type}})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(op.Request))}},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.Response != nil:
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(op.Response))}}
+		case op.ContentType != "":
+			success["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(status): success,
+			"default": map[string]any{
+				"description": "An error, code is a fixed string clients can switch on",
+				"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+			},
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Shopping list",
+			"version":     "1",
+			"description": "Every route except the public ones takes an API key or an access token from POST /login in \"Authorization: Bearer <token>\"",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+	}
+}
+
+// Makes an operationId like "getDataById" or "postListsByIdShare" from a route
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			segment = "by-" + strings.Trim(segment, "{}")
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '_' }) {
+			id += exportedName(word)
+		}
+	}
+	return id
+}
+
+// Upper cases the first letter, so unexported types like listResponse get schema names like ListResponse
+func exportedName(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// schemaBuilder turns Go types into JSON Schemas the way encoding/json would marshal them
+// named structs go into components so each is only described once and referred to everywhere else
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[optionalTime]():
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			// siblings of $ref are ignored in OpenAPI 3.0, so it has to be wrapped to be nullable
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := exportedName(t.Name())
+		if _, ok := b.components[name]; !ok {
+			// a placeholder first so a type that refers to itself doesn't recurse forever
+			b.components[name] = map[string]any{}
+			b.components[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// Describes a struct's fields, embedded structs have their fields pulled up as encoding/json does
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	b.addFields(t, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+	}
+}
+
+// Handle Get request for the OpenAPI document describing the HTTP API
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, http.StatusOK, "application/json", openAPIDocument())
+}
+
+// docsPage is Swagger UI pointed at /openapi.json, its script and styles come from a CDN so the browser needs to be online to use it
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Shopping list API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// Handle Get request for the API documentation
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	writeBody(w, http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
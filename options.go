@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowableMethods are the methods the router is asked about to answer OPTIONS, HEAD comes free with every GET route
+var allowableMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Returns middleware that answers OPTIONS for any path the router has routes for, with an Allow header listing their methods
+// CORS preflights are answered before they get here, this is for everything else that asks e.g. proxies and API explorers
+func withOptions(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allowed := []string{}
+		for _, method := range allowableMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			// the pattern is empty when nothing matches, or when a redirect or 405 would be sent
+			if _, pattern := mux.Handler(probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, "not_found", "nothing is served at this path")
+			return
+		}
+		w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
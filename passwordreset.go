@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A forgotten password is reset with a token emailed to the address on the account
+// POST /auth/forgot sends it and POST /auth/reset trades it and a new password for the change, GET /auth/reset is a form for the link in the email
+// tokens are kept hashed like sessions, work once, and stop working when they expire or the password changes some other way
+
+// resetResendAfter is how long POST /auth/forgot waits before emailing the same account again, so it can't be used to flood an inbox
+const resetResendAfter = 5 * time.Minute
+
+// passwordReset is a reset token that has been emailed and not used yet
+type passwordReset struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var ErrResetTooSoon = errors.New("a reset was asked for a moment ago")
+
+// passwordResetter emails reset tokens, it is nil without -smtp-addr and then the /auth routes aren't there
+type passwordResetter struct {
+	mail *mailer
+	ttl  time.Duration
+	// publicURL is -public-url, without it the email only has the token in it
+	// the link is never made from the request's Host, that would let anyone have a victim's token sent to a site of their choosing
+	publicURL string
+}
+
+var resets *passwordResetter
+
+func newPasswordResetter(cfg Config) *passwordResetter {
+	mail := newMailer(cfg)
+	if mail == nil {
+		return nil
+	}
+	return &passwordResetter{mail: mail, ttl: cfg.PasswordResetTTL, publicURL: strings.TrimSuffix(cfg.PublicURL, "/")}
+}
+
+// Returns a new slice of the reset tokens that haven't expired
+func liveResets(resets []passwordReset) []passwordReset {
+	now := time.Now()
+	live := []passwordReset{}
+	for _, reset := range resets {
+		if reset.ExpiresAt.After(now) {
+			live = append(live, reset)
+		}
+	}
+	return live
+}
+
+// StartReset makes a reset token for the account with login as its username or email and returns it along with the account
+// only the newest token works, ErrUserNotFound when there is no such account or it has no email to send the token to
+func (u *userRegistry) StartReset(login string, ttl time.Duration) (User, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var user User
+	found := false
+	for _, candidate := range u.doc.Users {
+		if strings.EqualFold(candidate.Username, login) || (candidate.Email != "" && strings.EqualFold(candidate.Email, login)) {
+			user, found = candidate, true
+			break
+		}
+	}
+	if !found || user.Email == "" {
+		return User{}, "", ErrUserNotFound
+	}
+	now := time.Now().UTC()
+	resets := liveResets(u.doc.Resets)
+	for _, reset := range resets {
+		if reset.UserID == user.ID && now.Sub(reset.CreatedAt) < resetResendAfter {
+			return User{}, "", ErrResetTooSoon
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return User{}, "", err
+	}
+	doc := u.doc
+	doc.Resets = slices.DeleteFunc(resets, func(reset passwordReset) bool { return reset.UserID == user.ID })
+	doc.Resets = append(doc.Resets, passwordReset{TokenHash: hashToken(token), UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(ttl)})
+	err = u.save(doc)
+	if err != nil {
+		return User{}, "", err
+	}
+	return user, token, nil
+}
+
+// ResetPassword sets the password of the account the token was sent to, using it up, or returns ErrInvalidToken
+// like SetPassword it ends the account's sessions
+func (u *userRegistry) ResetPassword(token string, password string) (User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	tokenHash := hashToken(token)
+	userID := 0
+	for _, reset := range liveResets(u.doc.Resets) {
+		if subtle.ConstantTimeCompare([]byte(reset.TokenHash), []byte(tokenHash)) == 1 {
+			userID = reset.UserID
+		}
+	}
+	index := slices.IndexFunc(u.doc.Users, func(user User) bool { return user.ID == userID })
+	if userID == 0 || index == -1 {
+		return User{}, ErrInvalidToken
+	}
+	doc := u.doc
+	setPasswordHash(&doc, index, hash)
+	err = u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return doc.Users[index], nil
+}
+
+// forgotRequest is the body of POST /auth/forgot, login is the username or the email on the account
+type forgotRequest struct {
+	Login string `json:"login"`
+}
+
+// resetRequest is the body of POST /auth/reset
+type resetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Handle Post request to email a password reset token
+// the answer is always 202 so it can't be used to find out which usernames or addresses have accounts, and the email goes out after it
+func handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.Login = strings.TrimSpace(req.Login)
+	if req.Login == "" {
+		writeError(w, http.StatusBadRequest, "invalid_login", "login is required, the username or the email on the account")
+		return
+	}
+
+	user, token, err := users.StartReset(req.Login, resets.ttl)
+	switch {
+	case err == nil:
+		slog.Info("Security event", "event", "password_reset_requested", "user", user.Username, "remote", remoteIP(r))
+		go resets.send(user, token)
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrResetTooSoon):
+		slog.Info("Security event", "event", "password_reset_refused", "login", req.Login, "remote", remoteIP(r), "reason", err.Error())
+	default:
+		slog.Error("Error starting password reset", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Emails the token to the user
+func (p *passwordResetter) send(user User, token string) {
+	var body strings.Builder
+	body.WriteString("Someone asked to reset the password of " + user.Username + " on the shopping list.\n\n")
+	if p.publicURL != "" {
+		body.WriteString("If it was you, open this link to choose a new one:\n\n")
+		body.WriteString(p.publicURL + "/auth/reset?token=" + url.QueryEscape(token) + "\n\n")
+	} else {
+		body.WriteString("If it was you, send this token and a new password to POST /auth/reset:\n\n")
+		body.WriteString(token + "\n\n")
+	}
+	body.WriteString("It works once and only until " + time.Now().Add(p.ttl).UTC().Format("2 January 2006 15:04 MST") + ".\n")
+	body.WriteString("If it wasn't you, ignore this email, your password hasn't changed.\n")
+
+	err := p.mail.Send([]string{user.Email}, "Reset your shopping list password", body.String())
+	if err != nil {
+		slog.Error("Error sending password reset email", "user", user.Username, "err", err)
+	}
+}
+
+// resetPage is the form the link in a reset email opens, like guestPage it has no scripts or style sheets
+var resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Reset your password</title>
+</head>
+<body>
+<h1>Reset your password</h1>
+{{if .Done}}<p>Your password has been changed, log in with the new one.</p>
+{{else}}{{if .Problem}}<p><strong>{{.Problem}}</strong></p>
+{{end}}<form method="post" action="/auth/reset">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>New password <input name="password" type="password" required minlength="{{.MinLength}}" autocomplete="new-password" autofocus></label></p>
+<p><button type="submit">Change password</button></p>
+</form>
+{{end}}</body>
+</html>
+`))
+
+type resetPageData struct {
+	Token     string
+	Problem   string
+	Done      bool
+	MinLength int
+}
+
+// Handle Get request for the form the link in a reset email opens, the token is only checked when the form is sent
+func handleResetPage(w http.ResponseWriter, r *http.Request) {
+	writeResetPage(w, http.StatusOK, resetPageData{Token: r.URL.Query().Get("token")})
+}
+
+func writeResetPage(w http.ResponseWriter, status int, data resetPageData) {
+	data.MinLength = minPasswordLength
+	var buf strings.Builder
+	err := resetPage.Execute(&buf, data)
+	if err != nil {
+		slog.Error("Error rendering HTML", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// the token is in the page, so it mustn't leak to other sites through the Referer or be kept by any cache
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	writeBody(w, status, "text/html; charset=utf-8", []byte(buf.String()))
+}
+
+// Handle Post request to set a new password with a reset token, as JSON or from the reset page's form, which gets the page back
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	fromForm := mediaType == "application/x-www-form-urlencoded"
+
+	var req resetRequest
+	if fromForm {
+		err := r.ParseForm()
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		req.Token = r.PostForm.Get("token")
+		req.Password = r.PostForm.Get("password")
+	} else {
+		err := decodeStrict(r.Body, &req)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+	if len(req.Password) < minPasswordLength {
+		problem := fmt.Sprintf("password has to be at least %d characters", minPasswordLength)
+		if fromForm {
+			writeResetPage(w, http.StatusBadRequest, resetPageData{Token: req.Token, Problem: problem})
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_password", problem)
+		return
+	}
+
+	user, err := users.ResetPassword(req.Token, req.Password)
+	if errors.Is(err, ErrInvalidToken) {
+		problem := "this reset link has already been used or has expired, ask for another one"
+		if fromForm {
+			writeResetPage(w, http.StatusBadRequest, resetPageData{Problem: problem, Token: req.Token})
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_token", problem)
+		return
+	}
+	if err != nil {
+		slog.Error("Error resetting password", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// whoever has the token can read the account's email, so a lockout from someone else guessing at the password is lifted
+	logins.Succeeded(user.Username, remoteIP(r))
+	slog.Info("Security event", "event", "password_reset", "user", user.Username, "remote", remoteIP(r))
+	if fromForm {
+		writeResetPage(w, http.StatusOK, resetPageData{Done: true})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
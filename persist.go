@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Writes data to path so that a crash at any point leaves either the old file or the new one, never half of each
+// the data goes to path.tmp first and is fsynced, the current file is kept as path.bak and then the temp file is renamed over the original
+// rename is atomic on the same filesystem so readers never see a partly written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		// Sync makes sure the bytes are on disk before the rename makes them visible
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = backupFile(path)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("keeping backup of %s: %w", path, err)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// Keeps the current contents of path as path.bak before it gets replaced
+// a hard link is used so the old file never has to be copied, falling back to a copy on filesystems without links
+func backupFile(path string) error {
+	bak := path + ".bak"
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	os.Remove(bak)
+	if os.Link(path, bak) == nil {
+		return nil
+	}
+	return copyFile(path, bak)
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fsyncs a directory so a rename inside it survives a power cut
+// errors are ignored because some platforms can't sync directories and the rename has already happened
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Checks the JSON data file at startup and puts path.bak back if the main file is missing, truncated or not valid JSON
+// a leftover path.tmp from a crash mid write is removed because it was never renamed into place
+func recoverDataFile(path string) error {
+	os.Remove(path + ".tmp")
+
+	data, err := os.ReadFile(path)
+	if err == nil && json.Valid(data) {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mainMissing := os.IsNotExist(err)
+
+	bak, bakErr := os.ReadFile(path + ".bak")
+	if bakErr != nil || !json.Valid(bak) {
+		// a brand new server has neither file, which is fine
+		if mainMissing && os.IsNotExist(bakErr) {
+			return nil
+		}
+		return errors.New(path + " is damaged and there is no valid " + path + ".bak to recover from")
+	}
+
+	slog.Warn("Recovering data file from backup", "path", path, "backup", path+".bak")
+	// the damaged file is kept aside so nothing is thrown away
+	if !mainMissing {
+		os.Rename(path, path+".corrupt")
+	}
+	return writeFileAtomic(path, bak)
+}
@@ -0,0 +1,6 @@
+//go:build postgres
+
+package main
+
+// The pgx driver is only linked in when building with -tags postgres so the default build stays small
+import _ "github.com/jackc/pgx/v5/stdlib"
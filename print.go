@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The PDF is written by hand rather than with a library, it only needs text, lines and boxes
+// it uses Helvetica, one of the fonts every PDF reader has built in, so no font has to be embedded
+// https://opensource.adobe.com/dc-acrobat-sdk-docs/pdfstandards/pdfreference1.4.pdf
+
+// Page and layout sizes in points, the page is A4 and the list is set in two columns
+const (
+	pdfPageWidth    = 595.28
+	pdfPageHeight   = 841.89
+	pdfMargin       = 36.0
+	pdfColumns      = 2
+	pdfColumnGap    = 18.0
+	pdfColumnWidth  = (pdfPageWidth - 2*pdfMargin - (pdfColumns-1)*pdfColumnGap) / pdfColumns
+	pdfFontSize     = 10.0
+	pdfHeadingSize  = 11.0
+	pdfTitleSize    = 16.0
+	pdfLineHeight   = 14.0
+	pdfHeadingSpace = 22.0
+	pdfCheckboxSize = 8.0
+)
+
+// helveticaWidths are the widths of the printable ASCII characters from Helvetica's metrics, in 1/1000 of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// Bold Helvetica is a little wider, headings are measured as if they were this much wider than the regular font
+const pdfBoldScale = 1.1
+
+// Returns how wide s is in points at size, characters outside ASCII are taken to be as wide as a digit
+func textWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += helveticaWidths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Shortens s with "..." until it is no wider than width
+func fitText(s string, size float64, width float64) string {
+	if textWidth(s, size) <= width {
+		return s
+	}
+	for s != "" {
+		_, n := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-n]
+		if textWidth(s+"...", size) <= width {
+			return strings.TrimSpace(s) + "..."
+		}
+	}
+	return ""
+}
+
+// winAnsiExtra are the characters WinAnsiEncoding has in 0x80 to 0x9F, where Latin-1 has control characters
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// Writes s as a PDF string in WinAnsiEncoding, the encoding the built in fonts are used with
+// characters it doesn't have, like emoji or CJK, come out as ?
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			// Latin-1 and WinAnsiEncoding are the same here, written as an octal escape so the string stays ASCII
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiExtra[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiExtra[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// printLayout places the list on pages top to bottom, filling one column before starting the next
+type printLayout struct {
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	column int
+	// y is the baseline of the next line
+	y float64
+	// top is where columns start on the current page, lower on the first page to leave room for the title
+	top float64
+}
+
+func (l *printLayout) newPage() {
+	l.page = &bytes.Buffer{}
+	l.pages = append(l.pages, l.page)
+	l.column = 0
+	l.top = pdfPageHeight - pdfMargin - pdfLineHeight
+	l.y = l.top
+}
+
+// Moves to the next column or page when there isn't height left in this one
+func (l *printLayout) need(height float64) {
+	if l.y-height+pdfLineHeight >= pdfMargin {
+		return
+	}
+	if l.column < pdfColumns-1 {
+		l.column++
+		l.y = l.top
+		return
+	}
+	l.newPage()
+}
+
+func (l *printLayout) left() float64 {
+	return pdfMargin + float64(l.column)*(pdfColumnWidth+pdfColumnGap)
+}
+
+func (l *printLayout) text(font string, size float64, x float64, s string) {
+	fmt.Fprintf(l.page, "BT /%s %s Tf %s %s Td %s Tj ET\n", font, pdfNumber(size), pdfNumber(x), pdfNumber(l.y), pdfString(s))
+}
+
+// Writes the title and date across the top of the first page
+func (l *printLayout) title(title string, subtitle string) {
+	l.text("F2", pdfTitleSize, pdfMargin, title)
+	l.y -= pdfLineHeight
+	l.text("F1", 8, pdfMargin, subtitle)
+	l.y -= pdfHeadingSpace
+	l.top = l.y
+}
+
+// Writes a category heading, kept with at least the first item under it
+func (l *printLayout) heading(s string) {
+	if l.y != l.top {
+		l.y -= pdfHeadingSpace - pdfLineHeight
+	}
+	l.need(2 * pdfLineHeight)
+	l.text("F2", pdfHeadingSize, l.left(), fitText(s, pdfHeadingSize*pdfBoldScale, pdfColumnWidth))
+	// a rule under the heading
+	fmt.Fprintf(l.page, "0.5 w %s %s m %s %s l S\n", pdfNumber(l.left()), pdfNumber(l.y-3), pdfNumber(l.left()+pdfColumnWidth), pdfNumber(l.y-3))
+	l.y -= pdfLineHeight + 2
+}
+
+// Writes an entry with a checkbox in front of it and the amount against the right of the column, ticked off entries get a ticked box
+func (l *printLayout) item(item string, amount string, ticked bool) {
+	l.need(pdfLineHeight)
+	x := l.left()
+	fmt.Fprintf(l.page, "0.75 w %s %s %s %s re S\n", pdfNumber(x), pdfNumber(l.y-1), pdfNumber(pdfCheckboxSize), pdfNumber(pdfCheckboxSize))
+	if ticked {
+		fmt.Fprintf(l.page, "%s %s m %s %s l %s %s l S\n",
+			pdfNumber(x+1.5), pdfNumber(l.y+3), pdfNumber(x+3.5), pdfNumber(l.y+0.5), pdfNumber(x+7), pdfNumber(l.y+6))
+	}
+	amountWidth := 0.0
+	if amount != "" {
+		amountWidth = textWidth(amount, pdfFontSize)
+		l.text("F1", pdfFontSize, x+pdfColumnWidth-amountWidth, amount)
+		amountWidth += 6
+	}
+	textX := x + pdfCheckboxSize + 6
+	l.text("F1", pdfFontSize, textX, fitText(item, pdfFontSize, x+pdfColumnWidth-amountWidth-textX))
+	l.y -= pdfLineHeight
+}
+
+// Formats a length for a content stream, PDF readers don't take exponents so %g can't be used
+func pdfNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 32)
+}
+
+// Puts the pages' content streams together into a PDF file, with the catalog, page tree and the two fonts before them
+func buildPDF(pages []*bytes.Buffer) ([]byte, error) {
+	var buf bytes.Buffer
+	// the binary comment tells tools that look at the start of the file that it isn't plain text
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	var offsets []int
+	object := func(dict string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\n", len(offsets), dict)
+		if stream != nil {
+			buf.WriteString("stream\n")
+			buf.Write(stream)
+			buf.WriteString("\nendstream\n")
+		}
+		buf.WriteString("endobj\n")
+	}
+
+	// objects 1 to 4 are fixed and each page is then a page object followed by its content stream
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = strconv.Itoa(5+2*i) + " 0 R"
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfNumber(pdfPageWidth), pdfNumber(pdfPageHeight), 6+2*i), nil)
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(page.Bytes())
+		err := zw.Close()
+		if err != nil {
+			return nil, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", compressed.Len()), compressed.Bytes())
+	}
+
+	// the cross-reference table has fixed width lines with the byte offset of every object
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), nil
+}
+
+// Handle Get request for the list as a PDF to print, a compact two column checklist grouped by aisle
+// it takes the same query parameters as GET /data, but leaves out ticked off entries unless ?completed= is given
+func handlePrint(w http.ResponseWriter, r *http.Request) {
+	entries, ok := exportEntries(w, r)
+	if !ok {
+		return
+	}
+	if !r.URL.Query().Has("completed") {
+		open := entries[:0]
+		for _, entry := range entries {
+			if !entry.Completed {
+				open = append(open, entry)
+			}
+		}
+		entries = open
+	}
+
+	mu.RLock()
+	groups := groupByCategory(entries, categories.For(requestIdentity(r).ListID))
+	mu.RUnlock()
+
+	layout := &printLayout{}
+	layout.newPage()
+	layout.title("Shopping list", "Printed "+time.Now().UTC().Format("2 January 2006 15:04 MST"))
+	if len(entries) == 0 {
+		layout.text("F1", pdfFontSize, pdfMargin, "Nothing left to get")
+	}
+	for _, group := range groups {
+		title := group.Category
+		if title == "" {
+			title = "other"
+		}
+		layout.heading(title)
+		for _, entry := range group.Entries {
+			amount := formatQuantity(entry.Quantity)
+			if entry.Unit != "" {
+				amount += " " + entry.Unit
+			}
+			if amount == "1" {
+				amount = ""
+			}
+			layout.item(entry.Item, amount, entry.Completed)
+		}
+	}
+
+	pdf, err := buildPDF(layout.pages)
+	if err != nil {
+		slog.Error("Error writing PDF", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// inline so the browser opens it in its viewer, ready to print
+	name := "shopping-list-" + time.Now().UTC().Format("2006-01-02") + ".pdf"
+	w.Header().Set("Content-Disposition", `inline; filename="`+name+`"`)
+	writeBody(w, http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// A QR code encoder for share links, written here rather than pulled in as it only needs one mode and one error correction level
+// text is encoded in byte mode at level M, which can lose 15% of the code and still scan, in the smallest of versions 1 to 10 it fits in
+// https://www.iso.org/standard/62021.html, the layout follows the standard's section 7 step by step
+
+// qrBlocks is how a version's codewords are split for error correction at level M:
+// group one has count1 blocks of data1 data codewords and group two count2 blocks of one more, every block gets ec codewords
+type qrBlocks struct {
+	ec, count1, data1, count2 int
+}
+
+// qrVersionsM are the level M block layouts of versions 1 to 10, 213 bytes fit in version 10 which is plenty for a link
+var qrVersionsM = [...]qrBlocks{
+	1:  {10, 1, 16, 0},
+	2:  {16, 1, 28, 0},
+	3:  {26, 1, 44, 0},
+	4:  {18, 2, 32, 0},
+	5:  {24, 2, 43, 0},
+	6:  {16, 4, 27, 0},
+	7:  {18, 4, 31, 0},
+	8:  {22, 2, 38, 2},
+	9:  {22, 3, 36, 2},
+	10: {26, 4, 43, 1},
+}
+
+// qrAlignment are the rows and columns the alignment patterns are centred on for each version
+var qrAlignment = [...][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+var errQRTooLong = errors.New("text is too long for a QR code")
+
+func (b qrBlocks) dataCodewords() int {
+	return b.count1*b.data1 + b.count2*(b.data1+1)
+}
+
+// qrCode is the grid of modules, dark is true for a black module, function marks the ones that aren't data
+type qrCode struct {
+	version  int
+	size     int
+	dark     [][]bool
+	function [][]bool
+}
+
+// Encodes text as a QR code
+func encodeQR(text []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v < len(qrVersionsM); v++ {
+		// 4 bits of mode, then an 8 bit length until version 10 where it is 16 bits
+		lengthBits := 8
+		if v >= 10 {
+			lengthBits = 16
+		}
+		if 4+lengthBits+8*len(text) <= 8*qrVersionsM[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	blocks := qrVersionsM[version]
+
+	// the data is the byte mode indicator, the length, the bytes, a terminator of up to four 0 bits and then padding
+	var bits qrBits
+	bits.append(0b0100, 4)
+	if version >= 10 {
+		bits.append(len(text), 16)
+	} else {
+		bits.append(len(text), 8)
+	}
+	for _, b := range text {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * blocks.dataCodewords()
+	bits.append(0, min(4, capacity-bits.n))
+	bits.append(0, (8-bits.n%8)%8)
+	for pad := 0xEC; bits.n < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	q := newQRCode(version)
+	q.drawFunctionPatterns()
+	q.drawData(interleaveQR(bits.bytes, blocks))
+	// the mask that makes the code easiest to scan is kept
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		penalty := q.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// masking is undone by doing it again
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+// qrBits collects the data bit by bit, most significant first
+type qrBits struct {
+	bytes []byte
+	n     int
+}
+
+func (b *qrBits) append(value int, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if value>>i&1 == 1 {
+			b.bytes[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+// Splits the data into blocks, works out each block's error correction codewords and interleaves them
+// the code is read a codeword from each block in turn, so damage to one part of it is spread over every block
+func interleaveQR(data []byte, b qrBlocks) []byte {
+	var dataBlocks, ecBlocks [][]byte
+	generator := rsGenerator(b.ec)
+	for i := 0; i < b.count1+b.count2; i++ {
+		n := b.data1
+		if i >= b.count1 {
+			n++
+		}
+		dataBlocks = append(dataBlocks, data[:n])
+		ecBlocks = append(ecBlocks, rsRemainder(data[:n], generator))
+		data = data[n:]
+	}
+	var out []byte
+	for i := 0; i <= b.data1; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < b.ec; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// Multiplies in GF(256) with the QR code's polynomial x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		// z*2, reduced by the polynomial when it overflows
+		carry := z >> 7
+		z = z<<1 ^ carry*0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// Returns the Reed-Solomon generator polynomial of degree n, the product of (x - 2^i) for i from 0 to n-1, without its leading 1
+func rsGenerator(n int) []byte {
+	g := make([]byte, n)
+	g[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range g {
+			g[j] = gfMultiply(g[j], root)
+			if j+1 < n {
+				g[j] ^= g[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return g
+}
+
+// Returns the error correction codewords for data, the remainder of dividing it by the generator
+func rsRemainder(data []byte, generator []byte) []byte {
+	r := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i, g := range generator {
+			r[i] ^= gfMultiply(g, factor)
+		}
+	}
+	return r
+}
+
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{version: version, size: size}
+	q.dark = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := 0; i < size; i++ {
+		q.dark[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	return q
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.dark[y][x] = dark
+	q.function[y][x] = true
+}
+
+// Draws the finder, timing and alignment patterns and the version information, and reserves where the format goes
+func (q *qrCode) drawFunctionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	// the three big squares in the corners, with a light border around them
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < q.size && y >= 0 && y < q.size {
+					d := max(abs(dx), abs(dy))
+					q.setFunction(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	positions := qrAlignment[q.version]
+	for i, y := range positions {
+		for j, x := range positions {
+			// the corners with finder patterns don't get one
+			last := len(positions) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0)
+	if q.version >= 7 {
+		rem := q.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := q.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+// Draws the two copies of the format information, the error correction level and mask with their own error correction
+func (q *qrCode) drawFormat(mask int) {
+	// level M is 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return bits>>i&1 == 1
+	}
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	// the module by the bottom left finder is always dark
+	q.setFunction(8, q.size-8, true)
+}
+
+// Fills the data modules two columns at a time, going up and down from the bottom right corner
+// modules left over at the end are the remainder bits and stay light
+func (q *qrCode) drawData(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern is skipped over
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				q.dark[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// Flips the data modules the mask pattern picks, so the code doesn't have large areas or patterns that confuse a scanner
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.dark[y][x] = !q.dark[y][x]
+			}
+		}
+	}
+}
+
+// Scores how hard the code would be to scan with the standard's four rules, lower is better
+func (q *qrCode) penalty() int {
+	penalty := 0
+	at := func(x, y int, columns bool) bool {
+		if columns {
+			return q.dark[x][y]
+		}
+		return q.dark[y][x]
+	}
+	for _, columns := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			// runs of five or more modules the same colour
+			run := 1
+			for x := 1; x < q.size; x++ {
+				if at(x, y, columns) == at(x-1, y, columns) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				penalty += run - 2
+			}
+			// patterns that look like a finder, dark light dark dark dark light dark with four light modules on one side
+			for x := 0; x+11 <= q.size; x++ {
+				var line [11]bool
+				for k := range line {
+					line[k] = at(x+k, y, columns)
+				}
+				if line == [11]bool{true, false, true, true, true, false, true, false, false, false, false} ||
+					line == [11]bool{false, false, false, false, true, false, true, true, true, false, true} {
+					penalty += 40
+				}
+			}
+		}
+	}
+	// 2x2 blocks of one colour
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.dark[y][x]
+				if q.dark[y][x+1] == c && q.dark[y+1][x] == c && q.dark[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	// how far the share of dark modules is from half, in steps of 5%
+	total := q.size * q.size
+	penalty += abs(dark*100/total-50) / 5 * 10
+	return penalty
+}
+
+// Draws the code as a black and white PNG with scale pixels a module and the four module light border scanners need
+func (q *qrCode) PNG(scale int) ([]byte, error) {
+	const border = 4
+	width := (q.size + 2*border) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.dark[y][x] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+border)*scale+px, (y+border)*scale+py, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entryQuery is what a client asked for with the query string of GET /data
+type entryQuery struct {
+	// Completed is nil when both completed and not completed entries are wanted
+	Completed *bool
+	// Search is matched against Item ignoring case
+	Search string
+	// Tags are tags an entry has to have all of
+	Tags []string
+	// Store is the shop the client is in, entries for other shops are left out
+	Store string
+	// Sort is "item", "created", "completed", "priority" or "" to keep the order they were added in
+	Sort string
+	Desc bool
+	// Limit is 0 when there isn't one
+	Limit  int
+	Offset int
+	// GroupBy is "category" or "" to not group
+	GroupBy string
+}
+
+var errBadQuery = errors.New("bad query parameter")
+
+// Reads ?completed=, ?q=, ?tag=, ?store=, ?sort=, ?order=, ?groupBy=, ?limit= and ?offset= from the request, anything missing means no filter
+func parseEntryQuery(r *http.Request) (entryQuery, error) {
+	values := r.URL.Query()
+	var q entryQuery
+
+	if completed := values.Get("completed"); completed != "" {
+		b, err := strconv.ParseBool(completed)
+		if err != nil {
+			return entryQuery{}, fmt.Errorf("%w: completed has to be true or false", errBadQuery)
+		}
+		q.Completed = &b
+	}
+	q.Search = strings.TrimSpace(values.Get("q"))
+	q.Tags = normalizeTags(values["tag"])
+	q.Store = strings.TrimSpace(values.Get("store"))
+
+	switch q.Sort = values.Get("sort"); q.Sort {
+	case "", "item", "created", "completed", "priority":
+	default:
+		return entryQuery{}, fmt.Errorf("%w: sort has to be item, created, completed or priority", errBadQuery)
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return entryQuery{}, fmt.Errorf("%w: order has to be asc or desc", errBadQuery)
+	}
+
+	switch q.GroupBy = values.Get("groupBy"); q.GroupBy {
+	case "", "category":
+	default:
+		return entryQuery{}, fmt.Errorf("%w: groupBy has to be category", errBadQuery)
+	}
+
+	var err error
+	q.Limit, err = parseNonNegative("limit", values.Get("limit"))
+	if err != nil {
+		return entryQuery{}, err
+	}
+	q.Offset, err = parseNonNegative("offset", values.Get("offset"))
+	if err != nil {
+		return entryQuery{}, err
+	}
+	return q, nil
+}
+
+// Returns middleware that turns away a request whose query string can't be decoded, e.g. a bad escape like %zz or a ; between parameters
+// url.Values leaves those parameters out without a word, so ?q=%zz would otherwise quietly become no filter at all
+// malformed escapes in the path never get this far, net/http answers them with 400 itself
+func withValidQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "the query string can't be decoded: "+err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseNonNegative(name string, s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s has to be a whole number of 0 or more", errBadQuery, name)
+	}
+	return n, nil
+}
+
+// Returns the page of entries that match the query and how many matched in total
+// offset and limit are applied after filtering and sorting
+func (q entryQuery) Apply(entries []Entry) ([]Entry, int) {
+	search := strings.ToLower(q.Search)
+	matched := []Entry{}
+	for _, entry := range entries {
+		if q.Completed != nil && entry.Completed != *q.Completed {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(entry.Item), search) {
+			continue
+		}
+		if !hasTags(entry, q.Tags) {
+			continue
+		}
+		if q.Store != "" && entry.Store != "" && !sameStore(entry.Store, q.Store) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+	q.sort(matched)
+
+	total := len(matched)
+	if q.Offset >= total {
+		return []Entry{}, total
+	}
+	matched = matched[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total
+}
+
+// Sets X-Total-Count and, when the client is paging with ?limit=, a Link header pointing at the next and previous pages
+// the links keep the rest of the request's query so filters and sorting carry over
+func (q entryQuery) setPageHeaders(w http.ResponseWriter, r *http.Request, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if q.Limit == 0 {
+		return
+	}
+
+	var links []string
+	if q.Offset+q.Limit < total {
+		links = append(links, pageLink(r, q.Offset+q.Limit, q.Limit, "next"))
+	}
+	if q.Offset > 0 {
+		links = append(links, pageLink(r, max(q.Offset-q.Limit, 0), q.Limit, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+func pageLink(r *http.Request, offset int, limit int, rel string) string {
+	values := r.URL.Query()
+	values.Set("offset", strconv.Itoa(offset))
+	values.Set("limit", strconv.Itoa(limit))
+	u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+}
+
+// Sorts entries in place, the sort is stable so entries that compare equal stay in the order they were added
+func (q entryQuery) sort(entries []Entry) {
+	var compare func(a, b Entry) int
+	switch q.Sort {
+	case "item":
+		compare = func(a, b Entry) int {
+			return strings.Compare(strings.ToLower(a.Item), strings.ToLower(b.Item))
+		}
+	case "created":
+		compare = func(a, b Entry) int {
+			return compareTimes(a.CreatedAt, b.CreatedAt)
+		}
+	case "completed":
+		// entries still to get come first, then the completed ones in the order they were ticked off
+		compare = func(a, b Entry) int {
+			if a.Completed != b.Completed {
+				if a.Completed {
+					return 1
+				}
+				return -1
+			}
+			return compareTimes(a.CompletedAt, b.CompletedAt)
+		}
+	case "priority":
+		// the most important entries come first, ?order=desc puts them last
+		compare = func(a, b Entry) int {
+			return priorityRank(b.Priority) - priorityRank(a.Priority)
+		}
+	default:
+		if q.Desc {
+			slices.Reverse(entries)
+		}
+		return
+	}
+	if q.Desc {
+		slices.SortStableFunc(entries, func(a, b Entry) int { return compare(b, a) })
+		return
+	}
+	slices.SortStableFunc(entries, compare)
+}
+
+// Compares two optional times, a missing time sorts before any other
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
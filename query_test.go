@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// routedRequest is what the router handed on for a request, recorded by the auth wrapper in place of the real one
+type routedRequest struct {
+	Pattern string
+	ID      string
+	Name    string
+	Query   url.Values
+}
+
+// Returns the router behind withValidQuery as the server runs it, with auth swapped for a handler that records where the request went
+// nothing gets past auth so the handlers, and the storage they need, are never reached
+func newTestRouter(routed *routedRequest) http.Handler {
+	// newRouter looks at whether digests can be mailed, without -smtp-addr there is no mailer
+	if digests == nil {
+		digests = &digestRegistry{}
+	}
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*routed = routedRequest{Pattern: r.Pattern, ID: r.PathValue("id"), Name: r.PathValue("name"), Query: r.URL.Query()}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	return withValidQuery(newRouter(Config{}, auth, nil))
+}
+
+func TestRouterSplitsPathAndQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		pattern string
+		id      string
+		query   url.Values
+	}{
+		{"no query", "GET", "/data", "GET /data", "", url.Values{}},
+		{"query", "GET", "/data?completed=true&q=milk", "GET /data", "", url.Values{"completed": {"true"}, "q": {"milk"}}},
+		{"id and query", "DELETE", "/data/12?list=3", "DELETE /data/{id}", "12", url.Values{"list": {"3"}}},
+		{"question mark in a value", "GET", "/data?q=what%3F", "GET /data", "", url.Values{"q": {"what?"}}},
+		{"repeated parameter", "GET", "/data?tag=dairy&tag=fresh", "GET /data", "", url.Values{"tag": {"dairy", "fresh"}}},
+		{"empty query", "GET", "/data/7?", "GET /data/{id}", "7", url.Values{}},
+		{"plus is a space", "GET", "/data?q=oat+milk", "GET /data", "", url.Values{"q": {"oat milk"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routed routedRequest
+			w := httptest.NewRecorder()
+			newTestRouter(&routed).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want it routed: %s", w.Code, w.Body)
+			}
+			if routed.Pattern != tt.pattern {
+				t.Errorf("pattern = %q, want %q", routed.Pattern, tt.pattern)
+			}
+			if routed.ID != tt.id {
+				t.Errorf("id = %q, want %q", routed.ID, tt.id)
+			}
+			if routed.Query.Encode() != tt.query.Encode() {
+				t.Errorf("query = %v, want %v", routed.Query, tt.query)
+			}
+		})
+	}
+}
+
+func TestRouterDecodesPathSegments(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		pattern string
+		id      string
+		segment string
+	}{
+		{"space and unicode", "DELETE", "/stores/Caf%C3%A9%20Nord", "DELETE /stores/{name}", "", "Café Nord"},
+		{"unescaped unicode", "DELETE", "/categories/Fr%C3%BCchte", "DELETE /categories/{name}", "", "Früchte"},
+		{"escaped digit in an id", "GET", "/data/%34%32", "GET /data/{id}", "42", ""},
+		{"escaped slash stays in its segment", "DELETE", "/stores/Marks%2FSpencer", "DELETE /stores/{name}", "", "Marks/Spencer"},
+		{"escaped slash before a suffix", "GET", "/lists/1%2F2/summary", "GET /lists/{id}/summary", "1/2", ""},
+		{"escaped percent", "DELETE", "/categories/100%25%20juice", "DELETE /categories/{name}", "", "100% juice"},
+		{"plus stays a plus in the path", "DELETE", "/stores/M+S", "DELETE /stores/{name}", "", "M+S"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routed routedRequest
+			w := httptest.NewRecorder()
+			newTestRouter(&routed).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want it routed: %s", w.Code, w.Body)
+			}
+			if routed.Pattern != tt.pattern {
+				t.Errorf("pattern = %q, want %q", routed.Pattern, tt.pattern)
+			}
+			if routed.ID != tt.id {
+				t.Errorf("id = %q, want %q", routed.ID, tt.id)
+			}
+			if routed.Name != tt.segment {
+				t.Errorf("name = %q, want %q", routed.Name, tt.segment)
+			}
+		})
+	}
+}
+
+func TestRouterRejectsMalformedQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"bad escape", "/data?q=%zz"},
+		{"truncated escape", "/data?q=milk%2"},
+		{"bad escape in a key", "/data?%zz=1"},
+		{"semicolon", "/data?q=milk;completed=true"},
+		{"semicolon alone", "/data?q=milk;"},
+		{"bad escape after good parameters", "/data/5?list=1&q=%G0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routed routedRequest
+			w := httptest.NewRecorder()
+			newTestRouter(&routed).ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if routed.Pattern != "" {
+				t.Errorf("request reached %q, want it turned away first", routed.Pattern)
+			}
+			var body errorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatalf("body isn't an error response: %v", err)
+			}
+			if body.Error.Code != "invalid_query" {
+				t.Errorf("error code = %q, want invalid_query", body.Error.Code)
+			}
+		})
+	}
+}
+
+// A malformed escape in the path can't be made with httptest.NewRequest, so it is sent over a real connection
+// net/http turns it away before the router, which is why withValidQuery only looks at the query
+func TestServerRejectsMalformedPathEscape(t *testing.T) {
+	var routed routedRequest
+	server := httptest.NewServer(newTestRouter(&routed))
+	defer server.Close()
+
+	for _, target := range []string{"/stores/%zz", "/data/1%", "/categories/%C3%2"} {
+		t.Run(target, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_, err = conn.Write([]byte("DELETE " + target + " HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(status, "HTTP/1.1 400 ") {
+				t.Errorf("status line = %q, want 400", strings.TrimSpace(status))
+			}
+			if routed.Pattern != "" {
+				t.Errorf("request reached %q, want it turned away first", routed.Pattern)
+			}
+		})
+	}
+}
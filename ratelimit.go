@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often buckets of clients that have gone quiet are thrown away
+const rateLimitSweepInterval = 10 * time.Minute
+
+// rateLimiter is a token bucket per client IP
+// every client can make burst requests at once and then rate requests a second after that
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(max(burst, 1)),
+		clients:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Takes a token from the client's bucket, if there isn't one it returns false and how long until there will be
+func (l *rateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	// refill for the time since the client was last seen, never past the burst size
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Drops buckets that would be full again by now, they are the same as a new one so nothing is lost, callers must hold l.mu
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Returns middleware that answers 429 Too Many Requests to a client IP that goes over the limit
+// a rate of 0 turns limiting off
+// the IP is the one the connection came from, behind a reverse proxy every client looks the same so the limit should be set on the proxy instead
+func withRateLimit(rate float64, burst int, next http.Handler) http.Handler {
+	if rate <= 0 {
+		return next
+	}
+	limiter := newRateLimiter(rate, burst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		ok, wait := limiter.Allow(client, time.Now())
+		if !ok {
+			// Retry-After is in whole seconds so it is rounded up, a client that waits that long will get through
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIngredients is the most ingredients a recipe can have
+const maxIngredients = 100
+
+// Recipe is a dish with what it takes to make it, for putting its ingredients on the list in one go
+type Recipe struct {
+	ID     int    `json:"id"`
+	ListID int    `json:"list_id"`
+	Name   string `json:"name"`
+	// Servings is how many the ingredients are for, adding the recipe for a different number scales them
+	Servings    int          `json:"servings"`
+	Ingredients []Ingredient `json:"ingredients"`
+}
+
+// Ingredient is one thing a recipe needs, Quantity 0 is for things like salt that aren't measured
+type Ingredient struct {
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Category string  `json:"category"`
+}
+
+// recipeRequest is the body of POST and PUT /recipes
+type recipeRequest struct {
+	Name string `json:"name"`
+	// Servings defaults to 1
+	Servings    int          `json:"servings"`
+	Ingredients []Ingredient `json:"ingredients"`
+}
+
+// recipeServings is the optional body of POST /recipes/{id}/add-to-list
+type recipeServings struct {
+	// Servings is how many to cook for, the recipe's own servings when it isn't given
+	Servings int `json:"servings"`
+}
+
+// recipesDoc is everything the recipes subsystem saves
+type recipesDoc struct {
+	Recipes []Recipe `json:"recipes"`
+	NextID  int      `json:"next_id"`
+}
+
+// recipesDocName is the Store document the recipes are saved under
+const recipesDocName = "recipes"
+
+// recipeRegistry holds the recipes in memory and saves them to the Store on every change
+type recipeRegistry struct {
+	store Store
+	doc   recipesDoc
+}
+
+var recipes *recipeRegistry
+
+func loadRecipes(store Store) (*recipeRegistry, error) {
+	r := &recipeRegistry{store: store}
+	err := store.LoadDoc(recipesDocName, &r.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if r.doc.Recipes == nil {
+		r.doc.Recipes = []Recipe{}
+	}
+	r.doc.NextID = max(r.doc.NextID, 1)
+	return r, nil
+}
+
+func (r *recipeRegistry) save() error {
+	return r.store.SaveDoc(recipesDocName, r.doc)
+}
+
+// For returns the list's recipes
+func (r *recipeRegistry) For(listID int) []Recipe {
+	list := []Recipe{}
+	for _, recipe := range r.doc.Recipes {
+		if recipe.ListID == listID {
+			list = append(list, recipe)
+		}
+	}
+	return list
+}
+
+// Get returns the recipe or ErrNotFound
+func (r *recipeRegistry) Get(listID int, id int) (Recipe, error) {
+	i := r.index(listID, id)
+	if i < 0 {
+		return Recipe{}, ErrNotFound
+	}
+	return r.doc.Recipes[i], nil
+}
+
+// Put adds recipe if its ID is 0, giving it one, or replaces the recipe on the same list with its ID
+func (r *recipeRegistry) Put(recipe Recipe) (Recipe, error) {
+	if recipe.ID == 0 {
+		recipe.ID = r.doc.NextID
+		r.doc.NextID++
+		r.doc.Recipes = append(r.doc.Recipes, recipe)
+		return recipe, r.save()
+	}
+	i := r.index(recipe.ListID, recipe.ID)
+	if i < 0 {
+		return Recipe{}, ErrNotFound
+	}
+	r.doc.Recipes[i] = recipe
+	return recipe, r.save()
+}
+
+// Delete removes the recipe, entries it has already added stay on the list
+func (r *recipeRegistry) Delete(listID int, id int) error {
+	i := r.index(listID, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.doc.Recipes = slices.Delete(r.doc.Recipes, i, i+1)
+	return r.save()
+}
+
+func (r *recipeRegistry) index(listID int, id int) int {
+	return slices.IndexFunc(r.doc.Recipes, func(recipe Recipe) bool {
+		return recipe.ID == id && recipe.ListID == listID
+	})
+}
+
+// Turns a request body into a recipe for the list, or returns everything that is wrong with it
+func (req recipeRequest) toRecipe(listID int) (Recipe, []fieldError) {
+	var errs []fieldError
+	name := strings.Join(strings.Fields(req.Name), " ")
+	if name == "" {
+		errs = append(errs, fieldError{Field: "name", Message: "name can't be empty"})
+	}
+	if req.Servings == 0 {
+		req.Servings = 1
+	}
+	if req.Servings < 0 {
+		errs = append(errs, fieldError{Field: "servings", Message: "servings can't be negative"})
+	}
+	if len(req.Ingredients) == 0 || len(req.Ingredients) > maxIngredients {
+		errs = append(errs, fieldError{Field: "ingredients", Message: "a recipe needs from 1 to " + strconv.Itoa(maxIngredients) + " ingredients"})
+	}
+	ingredients := make([]Ingredient, len(req.Ingredients))
+	for i, ingredient := range req.Ingredients {
+		field := "ingredients[" + strconv.Itoa(i) + "]."
+		for _, e := range patchErrors(EntryPatch{Item: &ingredient.Item, Quantity: &ingredient.Quantity, Unit: &ingredient.Unit}) {
+			errs = append(errs, fieldError{Field: field + e.Field, Message: "ingredient " + strconv.Itoa(i) + ": " + e.Message})
+		}
+		ingredients[i] = Ingredient{
+			Item:     strings.Join(strings.Fields(ingredient.Item), " "),
+			Quantity: ingredient.Quantity,
+			Unit:     strings.TrimSpace(ingredient.Unit),
+			Category: normalizeCategory(ingredient.Category),
+		}
+	}
+	return Recipe{ListID: listID, Name: name, Servings: req.Servings, Ingredients: ingredients}, errs
+}
+
+// Returns the recipe's ingredients as entries for the number of servings, an unmeasured ingredient is added as one of it
+// scaled quantities are rounded to two decimal places so 3 eggs for 4 people doesn't become 2.25 eggs for 3 with a long tail
+func (recipe Recipe) entries(servings int) []Entry {
+	scale := float64(servings) / float64(recipe.Servings)
+	entries := make([]Entry, len(recipe.Ingredients))
+	for i, ingredient := range recipe.Ingredients {
+		quantity := 1.0
+		if ingredient.Quantity > 0 {
+			quantity = math.Round(ingredient.Quantity*scale*100) / 100
+		}
+		entries[i] = Entry{
+			Item:     ingredient.Item,
+			Quantity: quantity,
+			Unit:     ingredient.Unit,
+			Category: ingredient.Category,
+			Notes:    "for " + recipe.Name,
+		}
+	}
+	return entries
+}
+
+// Handle Get request for the list's recipes
+func handleGetRecipes(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, recipes.For(requestIdentity(r).ListID))
+}
+
+// Handle Get request for a recipe
+func handleGetRecipe(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRecipeID(w, r)
+	if !ok {
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	recipe, err := recipes.Get(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recipe not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, recipe)
+}
+
+// Handle Post request to save a recipe
+func handlePostRecipe(w http.ResponseWriter, r *http.Request) {
+	putRecipe(w, r, 0)
+}
+
+// Handle Put request to replace a recipe
+func handlePutRecipe(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRecipeID(w, r)
+	if !ok {
+		return
+	}
+	putRecipe(w, r, id)
+}
+
+func putRecipe(w http.ResponseWriter, r *http.Request, id int) {
+	var req recipeRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	recipe, errs := req.toRecipe(requestIdentity(r).ListID)
+	if errs != nil {
+		writeFieldErrors(w, "invalid_recipe", errs)
+		return
+	}
+	recipe.ID = id
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	status := http.StatusCreated
+	if id != 0 {
+		status = http.StatusOK
+	}
+	recipe, err = recipes.Put(recipe)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recipe not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving recipe", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, status, recipe)
+}
+
+// Handle Delete request to remove a recipe
+func handleDeleteRecipe(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRecipeID(w, r)
+	if !ok {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := recipes.Delete(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recipe not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting recipe", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Post request to put a recipe's ingredients on the list, scaled to the servings in the body or the recipe's own
+// an ingredient that is already on the list and not ticked off has its quantity increased like ?dedupe=merge does,
+// and the response is what each ingredient ended up as
+func handleAddRecipeToList(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseRecipeID(w, r)
+	if !ok {
+		return
+	}
+	var body recipeServings
+	// the body is optional, so an empty one is fine
+	data, err := io.ReadAll(r.Body)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		err = decodeStrict(bytes.NewReader(data), &body)
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if body.Servings < 0 {
+		writeFieldErrors(w, "invalid_servings", []fieldError{{Field: "servings", Message: "servings can't be negative"}})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	recipe, err := recipes.Get(listID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recipe not found")
+		return
+	}
+	servings := cmp.Or(body.Servings, recipe.Servings)
+	entries := recipe.entries(servings)
+	now := time.Now().UTC()
+	for i := range entries {
+		prepareNewEntry(&entries[i], now)
+	}
+
+	var results, updated, created []Entry
+	err = trackChange(r, "recipe", func(tx Store) error {
+		results, updated, created, err = addMerging(tx, listID, entries)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error adding recipe", "err", err)
+		writeInternalError(w)
+		return
+	}
+	publishEntries(requestIdentity(r).Actor(), EventUpdated, updated...)
+	publishEntries(requestIdentity(r).Actor(), EventCreated, created...)
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Reads the {id} of a /recipes/{id} route, writing the error itself when it isn't a number
+func parseRecipeID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "recipe ID has to be a number")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// How often the scheduler looks for recurring items that are due
+const recurringCheckInterval = time.Minute
+
+// RecurringItem is an item that is put back on a list every EveryDays days
+type RecurringItem struct {
+	ID       int     `json:"id"`
+	ListID   int     `json:"list_id"`
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Category string  `json:"category"`
+	Store    string  `json:"store"`
+	// EveryDays is how many days apart the item is added, 7 for weekly
+	EveryDays int `json:"every_days"`
+	// NextDue is when it is next added to the list
+	NextDue time.Time `json:"next_due"`
+	// LastAdded is when the scheduler last put it on the list, null if it never has
+	LastAdded *time.Time `json:"last_added"`
+}
+
+// recurringRequest is the body of POST and PUT /recurring, the interval can be given by name or as a number of days
+type recurringRequest struct {
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Category string  `json:"category"`
+	Store    string  `json:"store"`
+	// Interval is "daily", "weekly" or "fortnightly", EveryDays is used when it is empty
+	Interval  string `json:"interval"`
+	EveryDays int    `json:"every_days"`
+	// NextDue defaults to now, so a new recurring item is added straight away
+	NextDue *time.Time `json:"next_due"`
+}
+
+// recurringDoc is everything the recurring items subsystem saves
+type recurringDoc struct {
+	Items  []RecurringItem `json:"items"`
+	NextID int             `json:"next_id"`
+}
+
+// recurringDocName is the Store document the recurring items are saved under
+const recurringDocName = "recurring"
+
+// recurringRegistry holds the recurring items in memory and saves them to the Store on every change
+type recurringRegistry struct {
+	store Store
+	doc   recurringDoc
+}
+
+var recurring *recurringRegistry
+
+func loadRecurring(store Store) (*recurringRegistry, error) {
+	r := &recurringRegistry{store: store}
+	err := store.LoadDoc(recurringDocName, &r.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if r.doc.Items == nil {
+		r.doc.Items = []RecurringItem{}
+	}
+	r.doc.NextID = max(r.doc.NextID, 1)
+	return r, nil
+}
+
+func (r *recurringRegistry) save() error {
+	return r.store.SaveDoc(recurringDocName, r.doc)
+}
+
+// For returns the list's recurring items
+func (r *recurringRegistry) For(listID int) []RecurringItem {
+	items := []RecurringItem{}
+	for _, item := range r.doc.Items {
+		if item.ListID == listID {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Put adds item if its ID is 0, giving it one, or replaces the item on the same list with its ID
+func (r *recurringRegistry) Put(item RecurringItem) (RecurringItem, error) {
+	if item.ID == 0 {
+		item.ID = r.doc.NextID
+		r.doc.NextID++
+		r.doc.Items = append(r.doc.Items, item)
+		return item, r.save()
+	}
+	i := r.index(item.ListID, item.ID)
+	if i < 0 {
+		return RecurringItem{}, ErrNotFound
+	}
+	r.doc.Items[i] = item
+	return item, r.save()
+}
+
+// Delete removes the recurring item, entries it has already added stay on the list
+func (r *recurringRegistry) Delete(listID int, id int) error {
+	i := r.index(listID, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.doc.Items = slices.Delete(r.doc.Items, i, i+1)
+	return r.save()
+}
+
+func (r *recurringRegistry) index(listID int, id int) int {
+	return slices.IndexFunc(r.doc.Items, func(item RecurringItem) bool {
+		return item.ID == id && item.ListID == listID
+	})
+}
+
+// Adds every recurring item that is due to its list and moves its NextDue on, returns the entries that were added
+// an item that is still on the list and not ticked off isn't added a second time, it just waits for the next interval
+// a server that was down for a few intervals adds the item once rather than once per missed interval
+func (r *recurringRegistry) addDue(now time.Time) ([]Entry, error) {
+	var added []Entry
+	changed := false
+	for i := range r.doc.Items {
+		item := &r.doc.Items[i]
+		if item.NextDue.After(now) {
+			continue
+		}
+		entry := Entry{Item: item.Item, Quantity: item.Quantity, Unit: item.Unit, Category: item.Category, Store: item.Store}
+		prepareNewEntry(&entry, now)
+		entries := []Entry{entry}
+		canonicalItems(item.ListID, entries)
+
+		changes, err := recordChanges(func(tx Store) error {
+			existing, err := tx.List(item.ListID)
+			if err != nil {
+				return err
+			}
+			for _, e := range liveEntries(existing) {
+				if !e.Completed && dedupeKey(item.ListID, e) == dedupeKey(item.ListID, entries[0]) {
+					return nil
+				}
+			}
+			_, err = tx.Add(item.ListID, entries)
+			return err
+		})
+		if err != nil {
+			return added, err
+		}
+		auditChanges(systemActor, 0, "recurring", now, changes)
+		for _, change := range changes {
+			added = append(added, *change.After)
+		}
+		if len(changes) > 0 {
+			item.LastAdded = &now
+		}
+
+		interval := time.Duration(item.EveryDays) * 24 * time.Hour
+		for !item.NextDue.After(now) {
+			item.NextDue = item.NextDue.Add(interval)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	return added, r.save()
+}
+
+// Checks for due recurring items every interval until ctx is cancelled
+func runRecurring(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		mu.Lock()
+		added, err := recurring.addDue(time.Now().UTC())
+		mu.Unlock()
+		if err != nil {
+			slog.Error("Error adding recurring items", "err", err)
+		}
+		if len(added) > 0 {
+			publishEntries(systemActor, EventCreated, added...)
+			slog.Info("Added recurring items", "entries", len(added))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Turns a request body into a recurring item for the list, or returns what is wrong with it
+func (req recurringRequest) toItem(listID int, now time.Time) (RecurringItem, string) {
+	if req.Item == "" {
+		return RecurringItem{}, "item is required"
+	}
+	if req.Quantity < 0 {
+		return RecurringItem{}, "quantity can't be negative"
+	}
+	days := req.EveryDays
+	switch req.Interval {
+	case "":
+	case "daily":
+		days = 1
+	case "weekly":
+		days = 7
+	case "fortnightly":
+		days = 14
+	default:
+		return RecurringItem{}, "interval has to be daily, weekly or fortnightly, or use every_days"
+	}
+	if days < 1 {
+		return RecurringItem{}, "every_days has to be at least 1"
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	item := RecurringItem{
+		ListID:    listID,
+		Item:      req.Item,
+		Quantity:  req.Quantity,
+		Unit:      req.Unit,
+		Category:  normalizeCategory(req.Category),
+		Store:     req.Store,
+		EveryDays: days,
+		NextDue:   now,
+	}
+	if req.NextDue != nil {
+		item.NextDue = req.NextDue.UTC()
+	}
+	return item, ""
+}
+
+// Handle Get request for the list's recurring items
+func handleGetRecurring(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, recurring.For(requestIdentity(r).ListID))
+}
+
+// Handle Post request to add a recurring item, the scheduler puts it on the list once it is due
+func handlePostRecurring(w http.ResponseWriter, r *http.Request) {
+	putRecurring(w, r, 0)
+}
+
+// Handle Put request to replace a recurring item, LastAdded is kept
+func handlePutRecurring(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "recurring item ID has to be a number")
+		return
+	}
+	putRecurring(w, r, id)
+}
+
+func putRecurring(w http.ResponseWriter, r *http.Request, id int) {
+	var req recurringRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	item, problem := req.toItem(requestIdentity(r).ListID, time.Now().UTC())
+	if problem != "" {
+		writeError(w, http.StatusBadRequest, "invalid_recurring", problem)
+		return
+	}
+	item.ID = id
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	status := http.StatusCreated
+	if id != 0 {
+		status = http.StatusOK
+		if i := recurring.index(item.ListID, id); i >= 0 {
+			item.LastAdded = recurring.doc.Items[i].LastAdded
+		}
+	}
+	item, err = recurring.Put(item)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recurring item not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error saving recurring item", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, status, item)
+}
+
+// Handle Delete request to stop an item recurring
+func handleDeleteRecurring(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "recurring item ID has to be a number")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = recurring.Delete(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "recurring item not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting recurring item", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
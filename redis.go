@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a small Redis client speaking RESP2, just enough for the Redis store
+// https://redis.io/docs/latest/develop/reference/protocol-spec/
+
+// How long a round trip to Redis can take before the connection is dropped and the command fails
+const redisTimeout = 10 * time.Second
+
+// redisError is an error reply from the server e.g. "WRONGTYPE Operation against a key holding the wrong kind of value"
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient sends commands over one connection, it reconnects on the next command after the connection fails
+// replies are decoded into string (simple strings), int64, []byte (bulk strings), []any (arrays), redisError or nil
+type redisClient struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// Parses a redis:// or rediss:// (TLS) URL, e.g. redis://:password@localhost:6379/0, the path is the database number
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, errors.New("the redis address has to be a redis:// or rediss:// URL, e.g. redis://localhost:6379/0")
+	}
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q, it has to be a number", db)
+		}
+	}
+	return c, nil
+}
+
+// Do sends one command and returns its reply, an error reply is returned as the error
+func (c *redisClient) Do(args ...string) (any, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends every command before reading any reply, so they cost one round trip
+// error replies are left in the slice for the caller to look at
+func (c *redisClient) Pipeline(commands [][]string) ([]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replies, err := c.pipeline(commands)
+	if err != nil {
+		// the replies could be out of step with the commands now so the connection can't be used again
+		c.close()
+	}
+	return replies, err
+}
+
+func (c *redisClient) pipeline(commands [][]string) ([]any, error) {
+	if c.conn == nil {
+		err := c.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var buf []byte
+	for _, args := range commands {
+		buf = appendRedisCommand(buf, args)
+	}
+	_, err := c.conn.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(commands))
+	for i := range replies {
+		replies[i], err = readRedisReply(c.rd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// Dials the server, logs in and picks the database
+func (c *redisClient) connect() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.password != "" {
+		auth := []string{"AUTH", c.password}
+		if c.username != "" {
+			auth = []string{"AUTH", c.username, c.password}
+		}
+		setup = append(setup, auth)
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := c.pipeline(setup)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redisError); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Close closes the connection, a later command opens a new one
+func (c *redisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+// Encodes a command as an array of bulk strings, the only form the server needs to accept
+func appendRedisCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func readRedisReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		_, err = io.ReadFull(rd, data)
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = readRedisReply(rd)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How often the reminder engine looks for entries that have become due
+const reminderCheckInterval = time.Minute
+
+// reminderAction is something that is done when an entry becomes due
+type reminderAction interface {
+	Remind(ctx context.Context, entry Entry) error
+}
+
+// Builds the actions from -reminders, a comma separated list of "log", "webhook=<url>" and "email=<address>"
+func parseReminderActions(spec string, mail *mailer) ([]reminderAction, error) {
+	var actions []reminderAction
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		kind, target, _ := strings.Cut(part, "=")
+		switch kind {
+		case "":
+		case "log":
+			actions = append(actions, logReminder{})
+		case "webhook":
+			if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+				return nil, fmt.Errorf("reminder webhook %q has to be an http or https URL", target)
+			}
+			actions = append(actions, webhookReminder{url: target, client: &http.Client{Timeout: 10 * time.Second}})
+		case "email":
+			if mail == nil {
+				return nil, errors.New("email reminders need -smtp-addr to be set")
+			}
+			if !strings.Contains(target, "@") {
+				return nil, fmt.Errorf("reminder email %q isn't an email address", target)
+			}
+			actions = append(actions, emailReminder{mail: mail, to: target})
+		default:
+			return nil, fmt.Errorf("unknown reminder action %q, expected log, webhook=<url> or email=<address>", part)
+		}
+	}
+	return actions, nil
+}
+
+// logReminder writes a log line
+type logReminder struct{}
+
+func (logReminder) Remind(ctx context.Context, entry Entry) error {
+	slog.Info("Entry is due", "id", entry.ID, "list_id", entry.ListID, "item", entry.Item, "due_by", entry.DueBy)
+	return nil
+}
+
+// webhookReminder POSTs the entry as JSON to a URL
+type webhookReminder struct {
+	url    string
+	client *http.Client
+}
+
+// reminderPayload is the body a reminder webhook gets
+type reminderPayload struct {
+	Type  string `json:"type"`
+	Entry Entry  `json:"entry"`
+}
+
+func (a webhookReminder) Remind(ctx context.Context, entry Entry) error {
+	body, err := json.Marshal(reminderPayload{Type: "reminder", Entry: entry})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("reminder webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// emailReminder emails one address
+type emailReminder struct {
+	mail *mailer
+	to   string
+}
+
+func (a emailReminder) Remind(ctx context.Context, entry Entry) error {
+	subject := "Reminder: " + entry.Item + " is due"
+	body := fmt.Sprintf("%s was due by %s and is still on your shopping list.\n", entry.Item, entry.DueBy.Format("Mon 2 Jan 15:04 MST"))
+	if entry.Notes != "" {
+		body += "\n" + entry.Notes + "\n"
+	}
+	return a.mail.Send([]string{a.to}, subject, body)
+}
+
+// reminderState is what the engine remembers about an entry with a due date
+type reminderState struct {
+	// FiredFor is the due date the reminder was last sent for, so changing DueBy arms it again
+	FiredFor *time.Time `json:"fired_for,omitempty"`
+	// SnoozedUntil holds the reminder back until then
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+}
+
+// remindersDoc is the state of every entry's reminder, keyed by entry ID
+type remindersDoc struct {
+	Entries map[int]reminderState `json:"entries"`
+}
+
+// remindersDocName is the Store document the reminder state is saved under
+const remindersDocName = "reminders"
+
+// reminderEngine works out which entries have become due and sends their reminders
+// its state is only touched with mu held, the actions run after it has been released
+type reminderEngine struct {
+	store   Store
+	doc     remindersDoc
+	actions []reminderAction
+}
+
+var reminders *reminderEngine
+
+func loadReminders(store Store, actions []reminderAction) (*reminderEngine, error) {
+	e := &reminderEngine{store: store, actions: actions}
+	err := store.LoadDoc(remindersDocName, &e.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if e.doc.Entries == nil {
+		e.doc.Entries = make(map[int]reminderState)
+	}
+	return e, nil
+}
+
+// Returns the entries whose reminder should go out now and marks them as sent, callers must hold mu
+// the state is saved before the reminders are sent so a crash can lose a reminder but never send one twice
+// state for entries that are gone, done or no longer have a due date is dropped
+func (e *reminderEngine) due(now time.Time) ([]Entry, error) {
+	entries, err := e.store.All()
+	if err != nil {
+		return nil, err
+	}
+	var fire []Entry
+	keep := make(map[int]reminderState)
+	for _, entry := range liveEntries(entries) {
+		if entry.DueBy == nil || entry.Completed {
+			continue
+		}
+		state, ok := e.doc.Entries[entry.ID]
+		if ok {
+			keep[entry.ID] = state
+		}
+		if entry.DueBy.After(now) {
+			continue
+		}
+		if state.SnoozedUntil != nil {
+			if state.SnoozedUntil.After(now) {
+				continue
+			}
+		} else if state.FiredFor != nil && state.FiredFor.Equal(*entry.DueBy) {
+			continue
+		}
+		keep[entry.ID] = reminderState{FiredFor: entry.DueBy}
+		fire = append(fire, entry)
+	}
+	if len(fire) == 0 && len(keep) == len(e.doc.Entries) {
+		return nil, nil
+	}
+	e.doc.Entries = keep
+	return fire, e.store.SaveDoc(remindersDocName, e.doc)
+}
+
+// Checks for due entries every interval until ctx is cancelled
+func (e *reminderEngine) run(ctx context.Context, interval time.Duration) {
+	if len(e.actions) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		mu.Lock()
+		fire, err := e.due(time.Now().UTC())
+		mu.Unlock()
+		if err != nil {
+			slog.Error("Error checking reminders", "err", err)
+		}
+		for _, entry := range fire {
+			for _, action := range e.actions {
+				err := action.Remind(ctx, entry)
+				if err != nil {
+					slog.Error("Error sending reminder", "id", entry.ID, "err", err)
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reminderStatus is an entry with a due date and where its reminder is up to
+type reminderStatus struct {
+	Entry        Entry      `json:"entry"`
+	Reminded     bool       `json:"reminded"`
+	SnoozedUntil *time.Time `json:"snoozed_until"`
+}
+
+func (e *reminderEngine) status(entry Entry) reminderStatus {
+	state := e.doc.Entries[entry.ID]
+	reminded := state.FiredFor != nil && entry.DueBy != nil && state.FiredFor.Equal(*entry.DueBy)
+	return reminderStatus{Entry: entry, Reminded: reminded, SnoozedUntil: state.SnoozedUntil}
+}
+
+// Handle Get request for the entries on the list that have a due date and aren't done, soonest first
+func handleGetReminders(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries, err := store.List(requestIdentity(r).ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	statuses := []reminderStatus{}
+	for _, entry := range liveEntries(entries) {
+		if entry.DueBy != nil && !entry.Completed {
+			statuses = append(statuses, reminders.status(entry))
+		}
+	}
+	slices.SortStableFunc(statuses, func(a, b reminderStatus) int {
+		return a.Entry.DueBy.Compare(*b.Entry.DueBy)
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// snoozeRequest is the body of POST /data/{id}/snooze, either a duration like "30m" or a time to snooze until
+type snoozeRequest struct {
+	For   string     `json:"for"`
+	Until *time.Time `json:"until"`
+}
+
+// Handle Post request to hold an entry's reminder back, once the snooze is over it is sent (again)
+func handleSnooze(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+	var req snoozeRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	var until time.Time
+	switch {
+	case req.Until != nil && req.For == "":
+		until = req.Until.UTC()
+	case req.For != "" && req.Until == nil:
+		d, err := time.ParseDuration(req.For)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_snooze", "for has to be a positive duration like 30m or 2h")
+			return
+		}
+		until = now.Add(d)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_snooze", "send either for or until")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, err := getEntry(store, requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "entry not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	if entry.DueBy == nil {
+		writeError(w, http.StatusConflict, "no_due_date", "entry has no due date to be reminded about")
+		return
+	}
+
+	state := reminders.doc.Entries[entry.ID]
+	state.SnoozedUntil = &until
+	reminders.doc.Entries[entry.ID] = state
+	err = reminders.store.SaveDoc(remindersDocName, reminders.doc)
+	if err != nil {
+		slog.Error("Error saving reminders", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, reminders.status(entry))
+}
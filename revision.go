@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrRevisionConflict is returned when a change was made to an older revision of an entry than the one stored
+var ErrRevisionConflict = errors.New("entry has been changed since")
+
+// Returns the entry's ETag, which is its revision, weak like the list's because the gzipped and plain bodies share it
+func entryETag(entry Entry) string {
+	return `W/"` + strconv.Itoa(entry.Revision) + `"`
+}
+
+// Returns the revision a PUT or PATCH was made to, from If-Match or else the body's revision
+// ok is false when the client sent neither, or If-Match: *, and so is happy to overwrite whatever is there
+func expectedRevision(r *http.Request, patch EntryPatch) (revision int, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if patch.Revision == nil {
+			return 0, false, nil
+		}
+		return *patch.Revision, true, nil
+	}
+	if header == "*" {
+		return 0, false, nil
+	}
+	// the W/ is ignored so the ETag from GET can be sent back as it is
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	revision, err = strconv.Atoi(tag)
+	if err != nil {
+		return 0, false, fmt.Errorf("If-Match has to be one entry's ETag, e.g. %s", entryETag(Entry{Revision: 3}))
+	}
+	return revision, true, nil
+}
+
+// Checks a change was made to the stored revision of the entry, returning ErrRevisionConflict if it wasn't
+func checkRevision(entry Entry, expected int) error {
+	if expected != entry.Revision {
+		return fmt.Errorf("%w revision %d, it is at revision %d now", ErrRevisionConflict, expected, entry.Revision)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Role names what someone can do, owner, editor and viewer are what a user can do with one list and admin is what they can do with the server
+// list roles are kept as a Permission on the list so lists saved before roles existed don't need migrating
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+	// RoleAdmin is an account role, an admin is treated as the owner of every list and can manage the accounts with /admin
+	RoleAdmin Role = "admin"
+	// RoleUser is the account role of everyone who isn't an admin
+	RoleUser Role = "user"
+)
+
+var ErrLastAdmin = errors.New("the last admin can't stop being one")
+
+// Role is the list role the permission gives, "" for PermissionNone
+func (p Permission) Role() Role {
+	switch p {
+	case PermissionRead:
+		return RoleViewer
+	case PermissionWrite:
+		return RoleEditor
+	case PermissionOwner:
+		return RoleOwner
+	default:
+		return ""
+	}
+}
+
+// Reads the permission a share asks for, either as a role or the older read and write, false if it isn't one a share can give
+func parseSharePermission(s string) (Permission, bool) {
+	switch Role(s) {
+	case RoleViewer:
+		return PermissionRead, true
+	case RoleEditor:
+		return PermissionWrite, true
+	}
+	switch Permission(s) {
+	case PermissionRead, PermissionWrite:
+		return Permission(s), true
+	case "none":
+		return PermissionNone, true
+	}
+	return PermissionNone, false
+}
+
+// AccountRole is the user's role on the server, accounts saved before roles existed have none and are users
+func (u User) AccountRole() Role {
+	if u.Role == RoleAdmin {
+		return RoleAdmin
+	}
+	return RoleUser
+}
+
+// actsAsAdmin reports whether the user gets an admin's access, with -require-admin-2fa admins only do once they have turned on two-factor authentication
+func (u User) actsAsAdmin() bool {
+	return u.AccountRole() == RoleAdmin && (!requireAdmin2FA || u.TwoFactor())
+}
+
+// IsAdmin reports whether the user is an admin now, the role isn't in access tokens so taking it away works straight away
+func (u *userRegistry) IsAdmin(userID int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.userByID(userID)
+	return ok && user.actsAsAdmin()
+}
+
+// Users returns every account in the order they were made
+func (u *userRegistry) Users() []User {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]User{}, u.doc.Users...)
+}
+
+// ByUsername returns the account with the username, which is matched ignoring case like at login
+func (u *userRegistry) ByUsername(username string) (User, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, user := range u.doc.Users {
+		if strings.EqualFold(user.Username, username) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// SetRole makes username an admin or a user
+// the last admin can't be made a user, so there is always someone left who can manage the accounts without an API key
+func (u *userRegistry) SetRole(username string, role Role) (User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	index := -1
+	admins := 0
+	for i, user := range u.doc.Users {
+		if strings.EqualFold(user.Username, username) {
+			index = i
+		}
+		if user.AccountRole() == RoleAdmin {
+			admins++
+		}
+	}
+	if index == -1 {
+		return User{}, ErrUserNotFound
+	}
+	user := u.doc.Users[index]
+	if user.AccountRole() == RoleAdmin && role != RoleAdmin && admins == 1 {
+		return User{}, ErrLastAdmin
+	}
+	// users are saved without a role so the document reads the same as before roles for anyone who isn't an admin
+	user.Role = ""
+	if role == RoleAdmin {
+		user.Role = RoleAdmin
+	}
+
+	doc := u.doc
+	doc.Users = append([]User{}, u.doc.Users...)
+	doc.Users[index] = user
+	err := u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// Returns middleware that only lets admins, API keys and -no-auth requests through
+// API keys and -no-auth belong to whoever runs the server, so they can do anything an admin can
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := requestIdentity(r).User
+		if user != nil && !users.IsAdmin(user.ID) {
+			if account, ok := users.User(user.ID); ok && account.AccountRole() == RoleAdmin {
+				writeError(w, http.StatusForbidden, "totp_required", "admins have to turn on two-factor authentication with POST /me/2fa first")
+				return
+			}
+			writeError(w, http.StatusForbidden, "forbidden", "only an admin or an API key can do this")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
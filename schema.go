@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// schemaDocName is the document the version of the stored data is kept in, data from before it existed is version 0
+const schemaDocName = "schema"
+
+type schemaDoc struct {
+	Version int `json:"version"`
+}
+
+// dataMigration upgrades the stored data by one version
+// Migrate has to be safe to run twice, the version is saved after its changes so a crash in between runs it again
+type dataMigration struct {
+	Description string
+	Migrate     func(tx Store, now time.Time) error
+}
+
+// dataMigrations[i] takes the data from version i to i+1, they run in order on startup
+// only ever add to the end, a migration that has run on someone's data can't be changed
+var dataMigrations = []dataMigration{
+	{"give entries added before created_at was recorded the time of the upgrade", backfillCreatedAt},
+	{"give completed entries ticked off before completed_at was recorded their created_at", backfillCompletedAt},
+	{"give entries added before revisions were recorded revision 1", backfillRevision},
+}
+
+// Brings the stored data up to the latest version, the changes are written to the audit log as the system's
+// refuses to start on data written by a newer server, which may have changed things this one doesn't know about
+func migrateData(s Store) error {
+	var doc schemaDoc
+	err := s.LoadDoc(schemaDocName, &doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if doc.Version > len(dataMigrations) {
+		return fmt.Errorf("the data is at version %d but this server only knows up to %d, upgrade the server", doc.Version, len(dataMigrations))
+	}
+
+	for version := doc.Version; version < len(dataMigrations); version++ {
+		migration := dataMigrations[version]
+		now := time.Now().UTC()
+		changes, err := recordChanges(func(tx Store) error {
+			return migration.Migrate(tx, now)
+		})
+		if err != nil {
+			return fmt.Errorf("migrating to version %d: %w", version+1, err)
+		}
+		auditChanges(systemActor, 0, "migrate", now, changes)
+		err = s.SaveDoc(schemaDocName, schemaDoc{Version: version + 1})
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrated data", "version", version+1, "migration", migration.Description, "entries", len(changes))
+	}
+	return nil
+}
+
+func backfillCreatedAt(tx Store, now time.Time) error {
+	return updateAll(tx, func(entry *Entry) bool {
+		if entry.CreatedAt != nil {
+			return false
+		}
+		entry.CreatedAt = &now
+		return true
+	})
+}
+
+func backfillCompletedAt(tx Store, now time.Time) error {
+	return updateAll(tx, func(entry *Entry) bool {
+		if !entry.Completed || entry.CompletedAt != nil || entry.CreatedAt == nil {
+			return false
+		}
+		completed := *entry.CreatedAt
+		entry.CompletedAt = &completed
+		return true
+	})
+}
+
+// updateAll bumps the revision of every entry it changes, which takes these from 0 to 1
+func backfillRevision(tx Store, now time.Time) error {
+	return updateAll(tx, func(entry *Entry) bool {
+		return entry.Revision == 0
+	})
+}
+
+// Runs change on every entry on every list and stores the ones it reports it changed
+func updateAll(tx Store, change func(entry *Entry) bool) error {
+	entries, err := tx.All()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !change(&entry) {
+			continue
+		}
+		entry.Revision++
+		err := tx.Update(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
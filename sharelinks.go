@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A share link lets anyone who has it read a list without an account, e.g. a QR code on the fridge for the household to scan
+// each list has at most one link, making a new one replaces it and deleting it stops the old link working
+
+// maxQRScale is the most pixels a module GET /lists/{id}/share-qr draws, a bigger code than that is better scaled by whatever shows it
+const maxQRScale = 32
+
+// shareLink is a list's read-only link, the token is kept as it is so the link and its QR code can be shown again
+type shareLink struct {
+	ListID    int       `json:"list_id"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// shareLinkResponse is a share link as the owner sees it
+type shareLinkResponse struct {
+	ListID    int       `json:"list_id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// shareLinksDoc is what the share links subsystem saves
+type shareLinksDoc struct {
+	Links []shareLink `json:"links"`
+}
+
+const shareLinksDocName = "sharelinks"
+
+// shareLinkRegistry holds the share links in memory and saves them to the Store on every change
+type shareLinkRegistry struct {
+	store Store
+	doc   shareLinksDoc
+	// publicURL is -public-url, without it links are made from the Host of the request that asks for them
+	publicURL string
+}
+
+var shareLinks *shareLinkRegistry
+
+func loadShareLinks(store Store, publicURL string) (*shareLinkRegistry, error) {
+	s := &shareLinkRegistry{store: store, publicURL: strings.TrimSuffix(publicURL, "/")}
+	err := store.LoadDoc(shareLinksDocName, &s.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if s.doc.Links == nil {
+		s.doc.Links = []shareLink{}
+	}
+	return s, nil
+}
+
+// Saves doc and only then makes it the current state, like userRegistry.save, so a failed write can't leave
+// a revoked link working again after a restart or a new one working only until then
+func (s *shareLinkRegistry) save(doc shareLinksDoc) error {
+	err := s.store.SaveDoc(shareLinksDocName, doc)
+	if err != nil {
+		return err
+	}
+	s.doc = doc
+	return nil
+}
+
+// For returns the list's link, false when it doesn't have one
+func (s *shareLinkRegistry) For(listID int) (shareLink, bool) {
+	for _, link := range s.doc.Links {
+		if link.ListID == listID {
+			return link, true
+		}
+	}
+	return shareLink{}, false
+}
+
+// ByToken returns the link with the token, every link is compared in constant time so the response time doesn't give one away
+func (s *shareLinkRegistry) ByToken(token string) (shareLink, bool) {
+	var found shareLink
+	ok := false
+	for _, link := range s.doc.Links {
+		if validAPIKey([]string{link.Token}, token) {
+			found, ok = link, true
+		}
+	}
+	return found, ok && token != ""
+}
+
+// Put replaces the list's link with link
+func (s *shareLinkRegistry) Put(link shareLink) error {
+	doc := s.doc
+	doc.Links = slices.DeleteFunc(slices.Clone(s.doc.Links), func(l shareLink) bool {
+		return l.ListID == link.ListID
+	})
+	doc.Links = append(doc.Links, link)
+	return s.save(doc)
+}
+
+// Delete takes the list's link away, ErrNotFound if it didn't have one
+func (s *shareLinkRegistry) Delete(listID int) error {
+	doc := s.doc
+	doc.Links = slices.DeleteFunc(slices.Clone(s.doc.Links), func(l shareLink) bool {
+		return l.ListID == listID
+	})
+	if len(doc.Links) == len(s.doc.Links) {
+		return ErrNotFound
+	}
+	return s.save(doc)
+}
+
+// URL is where the link's list can be read
+func (s *shareLinkRegistry) URL(r *http.Request, link shareLink) string {
+	base := s.publicURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + "/shared?token=" + link.Token
+}
+
+func (s *shareLinkRegistry) response(r *http.Request, link shareLink) shareLinkResponse {
+	return shareLinkResponse{ListID: link.ListID, URL: s.URL(r, link), CreatedAt: link.CreatedAt, CreatedBy: link.CreatedBy}
+}
+
+// Handle Post request to make a read-only link to the list, replacing the one it had so the old link stops working
+func handlePostShareLink(w http.ResponseWriter, r *http.Request) {
+	token, err := newToken()
+	if err != nil {
+		slog.Error("Error making share link token", "err", err)
+		writeInternalError(w)
+		return
+	}
+	id := requestIdentity(r)
+	link := shareLink{ListID: id.ListID, Token: token, CreatedAt: time.Now().UTC(), CreatedBy: id.Actor()}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = shareLinks.Put(link)
+	if err != nil {
+		slog.Error("Error saving share link", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, shareLinks.response(r, link))
+}
+
+// Handle Get request for the list's share link
+func handleGetShareLink(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	link, ok := shareLinks.For(requestIdentity(r).ListID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "the list doesn't have a share link")
+		return
+	}
+	writeJSON(w, http.StatusOK, shareLinks.response(r, link))
+}
+
+// Handle Delete request to stop the list's share link working
+func handleDeleteShareLink(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := shareLinks.Delete(requestIdentity(r).ListID)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "the list doesn't have a share link")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting share link", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Get request for a PNG QR code of the list's share link, ?scale= is how many pixels a module is, 8 when it isn't given
+func handleGetShareQR(w http.ResponseWriter, r *http.Request) {
+	scale := 8
+	if s := r.URL.Query().Get("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxQRScale {
+			writeError(w, http.StatusBadRequest, "invalid_query", "scale has to be a whole number from 1 to "+strconv.Itoa(maxQRScale))
+			return
+		}
+		scale = n
+	}
+
+	mu.RLock()
+	link, ok := shareLinks.For(requestIdentity(r).ListID)
+	mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "the list doesn't have a share link, make one with POST /lists/{id}/share-link")
+		return
+	}
+	code, err := encodeQR([]byte(shareLinks.URL(r, link)))
+	if errors.Is(err, errQRTooLong) {
+		writeError(w, http.StatusUnprocessableEntity, "link_too_long", "the share link is too long for a QR code, use a shorter -public-url")
+		return
+	}
+	if err != nil {
+		slog.Error("Error making QR code", "err", err)
+		writeInternalError(w)
+		return
+	}
+	picture, err := code.PNG(scale)
+	if err != nil {
+		slog.Error("Error drawing QR code", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeBody(w, http.StatusOK, "image/png", picture)
+}
+
+// Handle Get request for a shared list, anyone with the token can read it
+// the token is in the query rather than the path so it stays out of the request log, as with GET /digest/unsubscribe
+// it is an HTML page for whoever scans the QR code unless Accept asks for JSON, either way the entries are in aisle order
+func handleGetShared(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r.Header.Get("Accept"), "text/html", "application/json")
+	if format == "" {
+		writeError(w, http.StatusNotAcceptable, "not_acceptable", "a shared list can be had as text/html or application/json")
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	// the link is the only credential, so it mustn't leak to other sites through the Referer or be kept by shared caches
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	link, ok := shareLinks.ByToken(r.URL.Query().Get("token"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "this share link doesn't exist or has been turned off")
+		return
+	}
+	entries, err := store.List(link.ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	page := []Entry{}
+	for _, group := range groupByCategory(liveEntries(entries), categories.For(link.ListID)) {
+		page = append(page, group.Entries...)
+	}
+	if format == "application/json" {
+		writeJSONWithETag(w, r, page)
+		return
+	}
+	body, err := renderHTMLList(page)
+	if err != nil {
+		slog.Error("Error rendering HTML", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeBodyTagged(w, r, "text/html; charset=utf-8", body, "")
+}
@@ -0,0 +1,1060 @@
+// The gRPC API, served on the same address as the HTTP API (HTTP/2 over TLS, or h2c without -tls-cert)
+// every call takes the same credentials as HTTP, an "authorization: Bearer <token>" metadata entry with an API key or access token,
+// and behaves exactly like the HTTP endpoint named next to it, errors included
+// the server's Go code in shoppinglistpb is generated from it with go generate, which needs protoc, protoc-gen-go and protoc-gen-go-grpc
+// clients in other languages are generated the same way with their own protoc plugins
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: shoppinglist.proto
+
+package shoppinglistpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Item is an entry on a list, the same fields as in the JSON API
+type Item struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Item          string                 `protobuf:"bytes,2,opt,name=item,proto3" json:"item,omitempty"`
+	Completed     bool                   `protobuf:"varint,3,opt,name=completed,proto3" json:"completed,omitempty"`
+	Quantity      float64                `protobuf:"fixed64,4,opt,name=quantity,proto3" json:"quantity,omitempty"`
+	Unit          string                 `protobuf:"bytes,5,opt,name=unit,proto3" json:"unit,omitempty"`
+	Category      string                 `protobuf:"bytes,6,opt,name=category,proto3" json:"category,omitempty"`
+	Tags          []string               `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty"`
+	Priority      string                 `protobuf:"bytes,8,opt,name=priority,proto3" json:"priority,omitempty"`
+	Notes         string                 `protobuf:"bytes,9,opt,name=notes,proto3" json:"notes,omitempty"`
+	Price         float64                `protobuf:"fixed64,10,opt,name=price,proto3" json:"price,omitempty"`
+	Currency      string                 `protobuf:"bytes,11,opt,name=currency,proto3" json:"currency,omitempty"`
+	Store         string                 `protobuf:"bytes,12,opt,name=store,proto3" json:"store,omitempty"`
+	DueBy         *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=due_by,json=dueBy,proto3" json:"due_by,omitempty"`
+	ListId        int64                  `protobuf:"varint,14,opt,name=list_id,json=listId,proto3" json:"list_id,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	CompletedAt   *timestamppb.Timestamp `protobuf:"bytes,16,opt,name=completed_at,json=completedAt,proto3" json:"completed_at,omitempty"`
+	Revision      int64                  `protobuf:"varint,17,opt,name=revision,proto3" json:"revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Item) Reset() {
+	*x = Item{}
+	mi := &file_shoppinglist_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Item) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Item) ProtoMessage() {}
+
+func (x *Item) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Item.ProtoReflect.Descriptor instead.
+func (*Item) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Item) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Item) GetItem() string {
+	if x != nil {
+		return x.Item
+	}
+	return ""
+}
+
+func (x *Item) GetCompleted() bool {
+	if x != nil {
+		return x.Completed
+	}
+	return false
+}
+
+func (x *Item) GetQuantity() float64 {
+	if x != nil {
+		return x.Quantity
+	}
+	return 0
+}
+
+func (x *Item) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Item) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *Item) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Item) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *Item) GetNotes() string {
+	if x != nil {
+		return x.Notes
+	}
+	return ""
+}
+
+func (x *Item) GetPrice() float64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *Item) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Item) GetStore() string {
+	if x != nil {
+		return x.Store
+	}
+	return ""
+}
+
+func (x *Item) GetDueBy() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DueBy
+	}
+	return nil
+}
+
+func (x *Item) GetListId() int64 {
+	if x != nil {
+		return x.ListId
+	}
+	return 0
+}
+
+func (x *Item) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Item) GetCompletedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CompletedAt
+	}
+	return nil
+}
+
+func (x *Item) GetRevision() int64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+type ListItemsRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	ListId *int64                 `protobuf:"varint,1,opt,name=list_id,json=listId,proto3,oneof" json:"list_id,omitempty"`
+	// completed, query, tags and store are ?completed=, ?q=, ?tag= and ?store=
+	Completed     *bool    `protobuf:"varint,2,opt,name=completed,proto3,oneof" json:"completed,omitempty"`
+	Query         string   `protobuf:"bytes,3,opt,name=query,proto3" json:"query,omitempty"`
+	Tags          []string `protobuf:"bytes,4,rep,name=tags,proto3" json:"tags,omitempty"`
+	Store         string   `protobuf:"bytes,5,opt,name=store,proto3" json:"store,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListItemsRequest) Reset() {
+	*x = ListItemsRequest{}
+	mi := &file_shoppinglist_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListItemsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListItemsRequest) ProtoMessage() {}
+
+func (x *ListItemsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListItemsRequest.ProtoReflect.Descriptor instead.
+func (*ListItemsRequest) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListItemsRequest) GetListId() int64 {
+	if x != nil && x.ListId != nil {
+		return *x.ListId
+	}
+	return 0
+}
+
+func (x *ListItemsRequest) GetCompleted() bool {
+	if x != nil && x.Completed != nil {
+		return *x.Completed
+	}
+	return false
+}
+
+func (x *ListItemsRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *ListItemsRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *ListItemsRequest) GetStore() string {
+	if x != nil {
+		return x.Store
+	}
+	return ""
+}
+
+type ListItemsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*Item                `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListItemsResponse) Reset() {
+	*x = ListItemsResponse{}
+	mi := &file_shoppinglist_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListItemsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListItemsResponse) ProtoMessage() {}
+
+func (x *ListItemsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListItemsResponse.ProtoReflect.Descriptor instead.
+func (*ListItemsResponse) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListItemsResponse) GetItems() []*Item {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type AddItemsRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	ListId *int64                 `protobuf:"varint,1,opt,name=list_id,json=listId,proto3,oneof" json:"list_id,omitempty"`
+	// id, list_id, created_at, completed_at and revision are set by the server
+	Items         []*Item `protobuf:"bytes,2,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddItemsRequest) Reset() {
+	*x = AddItemsRequest{}
+	mi := &file_shoppinglist_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddItemsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddItemsRequest) ProtoMessage() {}
+
+func (x *AddItemsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddItemsRequest.ProtoReflect.Descriptor instead.
+func (*AddItemsRequest) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AddItemsRequest) GetListId() int64 {
+	if x != nil && x.ListId != nil {
+		return *x.ListId
+	}
+	return 0
+}
+
+func (x *AddItemsRequest) GetItems() []*Item {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type AddItemsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*Item                `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddItemsResponse) Reset() {
+	*x = AddItemsResponse{}
+	mi := &file_shoppinglist_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddItemsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddItemsResponse) ProtoMessage() {}
+
+func (x *AddItemsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddItemsResponse.ProtoReflect.Descriptor instead.
+func (*AddItemsResponse) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AddItemsResponse) GetItems() []*Item {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+// UpdateItemRequest only changes the fields that are set
+type UpdateItemRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	ListId    *int64                 `protobuf:"varint,1,opt,name=list_id,json=listId,proto3,oneof" json:"list_id,omitempty"`
+	Id        int64                  `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	Item      *string                `protobuf:"bytes,3,opt,name=item,proto3,oneof" json:"item,omitempty"`
+	Completed *bool                  `protobuf:"varint,4,opt,name=completed,proto3,oneof" json:"completed,omitempty"`
+	Quantity  *float64               `protobuf:"fixed64,5,opt,name=quantity,proto3,oneof" json:"quantity,omitempty"`
+	Unit      *string                `protobuf:"bytes,6,opt,name=unit,proto3,oneof" json:"unit,omitempty"`
+	Category  *string                `protobuf:"bytes,7,opt,name=category,proto3,oneof" json:"category,omitempty"`
+	// tags replaces every tag, an empty Tags takes them all away
+	Tags     *Tags                  `protobuf:"bytes,8,opt,name=tags,proto3" json:"tags,omitempty"`
+	Priority *string                `protobuf:"bytes,9,opt,name=priority,proto3,oneof" json:"priority,omitempty"`
+	Notes    *string                `protobuf:"bytes,10,opt,name=notes,proto3,oneof" json:"notes,omitempty"`
+	Price    *float64               `protobuf:"fixed64,11,opt,name=price,proto3,oneof" json:"price,omitempty"`
+	Currency *string                `protobuf:"bytes,12,opt,name=currency,proto3,oneof" json:"currency,omitempty"`
+	Store    *string                `protobuf:"bytes,13,opt,name=store,proto3,oneof" json:"store,omitempty"`
+	DueBy    *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=due_by,json=dueBy,proto3" json:"due_by,omitempty"`
+	// clear_due_by takes the due date away
+	ClearDueBy bool `protobuf:"varint,15,opt,name=clear_due_by,json=clearDueBy,proto3" json:"clear_due_by,omitempty"`
+	// revision is checked like If-Match, the call fails with ABORTED if the item has changed since
+	Revision      *int64 `protobuf:"varint,16,opt,name=revision,proto3,oneof" json:"revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateItemRequest) Reset() {
+	*x = UpdateItemRequest{}
+	mi := &file_shoppinglist_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateItemRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateItemRequest) ProtoMessage() {}
+
+func (x *UpdateItemRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateItemRequest.ProtoReflect.Descriptor instead.
+func (*UpdateItemRequest) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdateItemRequest) GetListId() int64 {
+	if x != nil && x.ListId != nil {
+		return *x.ListId
+	}
+	return 0
+}
+
+func (x *UpdateItemRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateItemRequest) GetItem() string {
+	if x != nil && x.Item != nil {
+		return *x.Item
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetCompleted() bool {
+	if x != nil && x.Completed != nil {
+		return *x.Completed
+	}
+	return false
+}
+
+func (x *UpdateItemRequest) GetQuantity() float64 {
+	if x != nil && x.Quantity != nil {
+		return *x.Quantity
+	}
+	return 0
+}
+
+func (x *UpdateItemRequest) GetUnit() string {
+	if x != nil && x.Unit != nil {
+		return *x.Unit
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetCategory() string {
+	if x != nil && x.Category != nil {
+		return *x.Category
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetTags() *Tags {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *UpdateItemRequest) GetPriority() string {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetNotes() string {
+	if x != nil && x.Notes != nil {
+		return *x.Notes
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetPrice() float64 {
+	if x != nil && x.Price != nil {
+		return *x.Price
+	}
+	return 0
+}
+
+func (x *UpdateItemRequest) GetCurrency() string {
+	if x != nil && x.Currency != nil {
+		return *x.Currency
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetStore() string {
+	if x != nil && x.Store != nil {
+		return *x.Store
+	}
+	return ""
+}
+
+func (x *UpdateItemRequest) GetDueBy() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DueBy
+	}
+	return nil
+}
+
+func (x *UpdateItemRequest) GetClearDueBy() bool {
+	if x != nil {
+		return x.ClearDueBy
+	}
+	return false
+}
+
+func (x *UpdateItemRequest) GetRevision() int64 {
+	if x != nil && x.Revision != nil {
+		return *x.Revision
+	}
+	return 0
+}
+
+type Tags struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tags          []string               `protobuf:"bytes,1,rep,name=tags,proto3" json:"tags,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Tags) Reset() {
+	*x = Tags{}
+	mi := &file_shoppinglist_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Tags) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tags) ProtoMessage() {}
+
+func (x *Tags) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tags.ProtoReflect.Descriptor instead.
+func (*Tags) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Tags) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type DeleteItemRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ListId        *int64                 `protobuf:"varint,1,opt,name=list_id,json=listId,proto3,oneof" json:"list_id,omitempty"`
+	Id            int64                  `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteItemRequest) Reset() {
+	*x = DeleteItemRequest{}
+	mi := &file_shoppinglist_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteItemRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteItemRequest) ProtoMessage() {}
+
+func (x *DeleteItemRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteItemRequest.ProtoReflect.Descriptor instead.
+func (*DeleteItemRequest) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeleteItemRequest) GetListId() int64 {
+	if x != nil && x.ListId != nil {
+		return *x.ListId
+	}
+	return 0
+}
+
+func (x *DeleteItemRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type DeleteItemResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteItemResponse) Reset() {
+	*x = DeleteItemResponse{}
+	mi := &file_shoppinglist_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteItemResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteItemResponse) ProtoMessage() {}
+
+func (x *DeleteItemResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteItemResponse.ProtoReflect.Descriptor instead.
+func (*DeleteItemResponse) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{8}
+}
+
+type WatchChangesRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	ListId *int64                 `protobuf:"varint,1,opt,name=list_id,json=listId,proto3,oneof" json:"list_id,omitempty"`
+	// since_seq is the seq of the last change the client saw, the ones after it that the server still has are sent first
+	SinceSeq      uint64 `protobuf:"varint,2,opt,name=since_seq,json=sinceSeq,proto3" json:"since_seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchChangesRequest) Reset() {
+	*x = WatchChangesRequest{}
+	mi := &file_shoppinglist_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchChangesRequest) ProtoMessage() {}
+
+func (x *WatchChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchChangesRequest.ProtoReflect.Descriptor instead.
+func (*WatchChangesRequest) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WatchChangesRequest) GetListId() int64 {
+	if x != nil && x.ListId != nil {
+		return *x.ListId
+	}
+	return 0
+}
+
+func (x *WatchChangesRequest) GetSinceSeq() uint64 {
+	if x != nil {
+		return x.SinceSeq
+	}
+	return 0
+}
+
+// Change is one event from GET /events
+type Change struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Seq   uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	// type is "created", "updated", "completed", "deleted" or "reset"
+	Type   string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	ListId int64  `protobuf:"varint,3,opt,name=list_id,json=listId,proto3" json:"list_id,omitempty"`
+	Id     int64  `protobuf:"varint,4,opt,name=id,proto3" json:"id,omitempty"`
+	// item isn't set for "deleted" and "reset"
+	Item          *Item                  `protobuf:"bytes,5,opt,name=item,proto3" json:"item,omitempty"`
+	Actor         string                 `protobuf:"bytes,6,opt,name=actor,proto3" json:"actor,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Change) Reset() {
+	*x = Change{}
+	mi := &file_shoppinglist_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Change) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Change) ProtoMessage() {}
+
+func (x *Change) ProtoReflect() protoreflect.Message {
+	mi := &file_shoppinglist_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Change.ProtoReflect.Descriptor instead.
+func (*Change) Descriptor() ([]byte, []int) {
+	return file_shoppinglist_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Change) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Change) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Change) GetListId() int64 {
+	if x != nil {
+		return x.ListId
+	}
+	return 0
+}
+
+func (x *Change) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Change) GetItem() *Item {
+	if x != nil {
+		return x.Item
+	}
+	return nil
+}
+
+func (x *Change) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *Change) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_shoppinglist_proto protoreflect.FileDescriptor
+
+const file_shoppinglist_proto_rawDesc = "" +
+	"\n" +
+	"\x12shoppinglist.proto\x12\x0fshoppinglist.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x84\x04\n" +
+	"\x04Item\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04item\x18\x02 \x01(\tR\x04item\x12\x1c\n" +
+	"\tcompleted\x18\x03 \x01(\bR\tcompleted\x12\x1a\n" +
+	"\bquantity\x18\x04 \x01(\x01R\bquantity\x12\x12\n" +
+	"\x04unit\x18\x05 \x01(\tR\x04unit\x12\x1a\n" +
+	"\bcategory\x18\x06 \x01(\tR\bcategory\x12\x12\n" +
+	"\x04tags\x18\a \x03(\tR\x04tags\x12\x1a\n" +
+	"\bpriority\x18\b \x01(\tR\bpriority\x12\x14\n" +
+	"\x05notes\x18\t \x01(\tR\x05notes\x12\x14\n" +
+	"\x05price\x18\n" +
+	" \x01(\x01R\x05price\x12\x1a\n" +
+	"\bcurrency\x18\v \x01(\tR\bcurrency\x12\x14\n" +
+	"\x05store\x18\f \x01(\tR\x05store\x121\n" +
+	"\x06due_by\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\x05dueBy\x12\x17\n" +
+	"\alist_id\x18\x0e \x01(\x03R\x06listId\x129\n" +
+	"\n" +
+	"created_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12=\n" +
+	"\fcompleted_at\x18\x10 \x01(\v2\x1a.google.protobuf.TimestampR\vcompletedAt\x12\x1a\n" +
+	"\brevision\x18\x11 \x01(\x03R\brevision\"\xad\x01\n" +
+	"\x10ListItemsRequest\x12\x1c\n" +
+	"\alist_id\x18\x01 \x01(\x03H\x00R\x06listId\x88\x01\x01\x12!\n" +
+	"\tcompleted\x18\x02 \x01(\bH\x01R\tcompleted\x88\x01\x01\x12\x14\n" +
+	"\x05query\x18\x03 \x01(\tR\x05query\x12\x12\n" +
+	"\x04tags\x18\x04 \x03(\tR\x04tags\x12\x14\n" +
+	"\x05store\x18\x05 \x01(\tR\x05storeB\n" +
+	"\n" +
+	"\b_list_idB\f\n" +
+	"\n" +
+	"_completed\"@\n" +
+	"\x11ListItemsResponse\x12+\n" +
+	"\x05items\x18\x01 \x03(\v2\x15.shoppinglist.v1.ItemR\x05items\"h\n" +
+	"\x0fAddItemsRequest\x12\x1c\n" +
+	"\alist_id\x18\x01 \x01(\x03H\x00R\x06listId\x88\x01\x01\x12+\n" +
+	"\x05items\x18\x02 \x03(\v2\x15.shoppinglist.v1.ItemR\x05itemsB\n" +
+	"\n" +
+	"\b_list_id\"?\n" +
+	"\x10AddItemsResponse\x12+\n" +
+	"\x05items\x18\x01 \x03(\v2\x15.shoppinglist.v1.ItemR\x05items\"\x97\x05\n" +
+	"\x11UpdateItemRequest\x12\x1c\n" +
+	"\alist_id\x18\x01 \x01(\x03H\x00R\x06listId\x88\x01\x01\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x03R\x02id\x12\x17\n" +
+	"\x04item\x18\x03 \x01(\tH\x01R\x04item\x88\x01\x01\x12!\n" +
+	"\tcompleted\x18\x04 \x01(\bH\x02R\tcompleted\x88\x01\x01\x12\x1f\n" +
+	"\bquantity\x18\x05 \x01(\x01H\x03R\bquantity\x88\x01\x01\x12\x17\n" +
+	"\x04unit\x18\x06 \x01(\tH\x04R\x04unit\x88\x01\x01\x12\x1f\n" +
+	"\bcategory\x18\a \x01(\tH\x05R\bcategory\x88\x01\x01\x12)\n" +
+	"\x04tags\x18\b \x01(\v2\x15.shoppinglist.v1.TagsR\x04tags\x12\x1f\n" +
+	"\bpriority\x18\t \x01(\tH\x06R\bpriority\x88\x01\x01\x12\x19\n" +
+	"\x05notes\x18\n" +
+	" \x01(\tH\aR\x05notes\x88\x01\x01\x12\x19\n" +
+	"\x05price\x18\v \x01(\x01H\bR\x05price\x88\x01\x01\x12\x1f\n" +
+	"\bcurrency\x18\f \x01(\tH\tR\bcurrency\x88\x01\x01\x12\x19\n" +
+	"\x05store\x18\r \x01(\tH\n" +
+	"R\x05store\x88\x01\x01\x121\n" +
+	"\x06due_by\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\x05dueBy\x12 \n" +
+	"\fclear_due_by\x18\x0f \x01(\bR\n" +
+	"clearDueBy\x12\x1f\n" +
+	"\brevision\x18\x10 \x01(\x03H\vR\brevision\x88\x01\x01B\n" +
+	"\n" +
+	"\b_list_idB\a\n" +
+	"\x05_itemB\f\n" +
+	"\n" +
+	"_completedB\v\n" +
+	"\t_quantityB\a\n" +
+	"\x05_unitB\v\n" +
+	"\t_categoryB\v\n" +
+	"\t_priorityB\b\n" +
+	"\x06_notesB\b\n" +
+	"\x06_priceB\v\n" +
+	"\t_currencyB\b\n" +
+	"\x06_storeB\v\n" +
+	"\t_revision\"\x1a\n" +
+	"\x04Tags\x12\x12\n" +
+	"\x04tags\x18\x01 \x03(\tR\x04tags\"M\n" +
+	"\x11DeleteItemRequest\x12\x1c\n" +
+	"\alist_id\x18\x01 \x01(\x03H\x00R\x06listId\x88\x01\x01\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x03R\x02idB\n" +
+	"\n" +
+	"\b_list_id\"\x14\n" +
+	"\x12DeleteItemResponse\"\\\n" +
+	"\x13WatchChangesRequest\x12\x1c\n" +
+	"\alist_id\x18\x01 \x01(\x03H\x00R\x06listId\x88\x01\x01\x12\x1b\n" +
+	"\tsince_seq\x18\x02 \x01(\x04R\bsinceSeqB\n" +
+	"\n" +
+	"\b_list_id\"\xc8\x01\n" +
+	"\x06Change\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x17\n" +
+	"\alist_id\x18\x03 \x01(\x03R\x06listId\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\x03R\x02id\x12)\n" +
+	"\x04item\x18\x05 \x01(\v2\x15.shoppinglist.v1.ItemR\x04item\x12\x14\n" +
+	"\x05actor\x18\x06 \x01(\tR\x05actor\x12.\n" +
+	"\x04time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x04time2\xa4\x03\n" +
+	"\fShoppingList\x12R\n" +
+	"\tListItems\x12!.shoppinglist.v1.ListItemsRequest\x1a\".shoppinglist.v1.ListItemsResponse\x12O\n" +
+	"\bAddItems\x12 .shoppinglist.v1.AddItemsRequest\x1a!.shoppinglist.v1.AddItemsResponse\x12G\n" +
+	"\n" +
+	"UpdateItem\x12\".shoppinglist.v1.UpdateItemRequest\x1a\x15.shoppinglist.v1.Item\x12U\n" +
+	"\n" +
+	"DeleteItem\x12\".shoppinglist.v1.DeleteItemRequest\x1a#.shoppinglist.v1.DeleteItemResponse\x12O\n" +
+	"\fWatchChanges\x12$.shoppinglist.v1.WatchChangesRequest\x1a\x17.shoppinglist.v1.Change0\x01B/Z-github.com/rachvm/shoppingList/shoppinglistpbb\x06proto3"
+
+var (
+	file_shoppinglist_proto_rawDescOnce sync.Once
+	file_shoppinglist_proto_rawDescData []byte
+)
+
+func file_shoppinglist_proto_rawDescGZIP() []byte {
+	file_shoppinglist_proto_rawDescOnce.Do(func() {
+		file_shoppinglist_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shoppinglist_proto_rawDesc), len(file_shoppinglist_proto_rawDesc)))
+	})
+	return file_shoppinglist_proto_rawDescData
+}
+
+var file_shoppinglist_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_shoppinglist_proto_goTypes = []any{
+	(*Item)(nil),                  // 0: shoppinglist.v1.Item
+	(*ListItemsRequest)(nil),      // 1: shoppinglist.v1.ListItemsRequest
+	(*ListItemsResponse)(nil),     // 2: shoppinglist.v1.ListItemsResponse
+	(*AddItemsRequest)(nil),       // 3: shoppinglist.v1.AddItemsRequest
+	(*AddItemsResponse)(nil),      // 4: shoppinglist.v1.AddItemsResponse
+	(*UpdateItemRequest)(nil),     // 5: shoppinglist.v1.UpdateItemRequest
+	(*Tags)(nil),                  // 6: shoppinglist.v1.Tags
+	(*DeleteItemRequest)(nil),     // 7: shoppinglist.v1.DeleteItemRequest
+	(*DeleteItemResponse)(nil),    // 8: shoppinglist.v1.DeleteItemResponse
+	(*WatchChangesRequest)(nil),   // 9: shoppinglist.v1.WatchChangesRequest
+	(*Change)(nil),                // 10: shoppinglist.v1.Change
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+}
+var file_shoppinglist_proto_depIdxs = []int32{
+	11, // 0: shoppinglist.v1.Item.due_by:type_name -> google.protobuf.Timestamp
+	11, // 1: shoppinglist.v1.Item.created_at:type_name -> google.protobuf.Timestamp
+	11, // 2: shoppinglist.v1.Item.completed_at:type_name -> google.protobuf.Timestamp
+	0,  // 3: shoppinglist.v1.ListItemsResponse.items:type_name -> shoppinglist.v1.Item
+	0,  // 4: shoppinglist.v1.AddItemsRequest.items:type_name -> shoppinglist.v1.Item
+	0,  // 5: shoppinglist.v1.AddItemsResponse.items:type_name -> shoppinglist.v1.Item
+	6,  // 6: shoppinglist.v1.UpdateItemRequest.tags:type_name -> shoppinglist.v1.Tags
+	11, // 7: shoppinglist.v1.UpdateItemRequest.due_by:type_name -> google.protobuf.Timestamp
+	0,  // 8: shoppinglist.v1.Change.item:type_name -> shoppinglist.v1.Item
+	11, // 9: shoppinglist.v1.Change.time:type_name -> google.protobuf.Timestamp
+	1,  // 10: shoppinglist.v1.ShoppingList.ListItems:input_type -> shoppinglist.v1.ListItemsRequest
+	3,  // 11: shoppinglist.v1.ShoppingList.AddItems:input_type -> shoppinglist.v1.AddItemsRequest
+	5,  // 12: shoppinglist.v1.ShoppingList.UpdateItem:input_type -> shoppinglist.v1.UpdateItemRequest
+	7,  // 13: shoppinglist.v1.ShoppingList.DeleteItem:input_type -> shoppinglist.v1.DeleteItemRequest
+	9,  // 14: shoppinglist.v1.ShoppingList.WatchChanges:input_type -> shoppinglist.v1.WatchChangesRequest
+	2,  // 15: shoppinglist.v1.ShoppingList.ListItems:output_type -> shoppinglist.v1.ListItemsResponse
+	4,  // 16: shoppinglist.v1.ShoppingList.AddItems:output_type -> shoppinglist.v1.AddItemsResponse
+	0,  // 17: shoppinglist.v1.ShoppingList.UpdateItem:output_type -> shoppinglist.v1.Item
+	8,  // 18: shoppinglist.v1.ShoppingList.DeleteItem:output_type -> shoppinglist.v1.DeleteItemResponse
+	10, // 19: shoppinglist.v1.ShoppingList.WatchChanges:output_type -> shoppinglist.v1.Change
+	15, // [15:20] is the sub-list for method output_type
+	10, // [10:15] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_shoppinglist_proto_init() }
+func file_shoppinglist_proto_init() {
+	if File_shoppinglist_proto != nil {
+		return
+	}
+	file_shoppinglist_proto_msgTypes[1].OneofWrappers = []any{}
+	file_shoppinglist_proto_msgTypes[3].OneofWrappers = []any{}
+	file_shoppinglist_proto_msgTypes[5].OneofWrappers = []any{}
+	file_shoppinglist_proto_msgTypes[7].OneofWrappers = []any{}
+	file_shoppinglist_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shoppinglist_proto_rawDesc), len(file_shoppinglist_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_shoppinglist_proto_goTypes,
+		DependencyIndexes: file_shoppinglist_proto_depIdxs,
+		MessageInfos:      file_shoppinglist_proto_msgTypes,
+	}.Build()
+	File_shoppinglist_proto = out.File
+	file_shoppinglist_proto_goTypes = nil
+	file_shoppinglist_proto_depIdxs = nil
+}
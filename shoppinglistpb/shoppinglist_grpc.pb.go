@@ -0,0 +1,293 @@
+// The gRPC API, served on the same address as the HTTP API (HTTP/2 over TLS, or h2c without -tls-cert)
+// every call takes the same credentials as HTTP, an "authorization: Bearer <token>" metadata entry with an API key or access token,
+// and behaves exactly like the HTTP endpoint named next to it, errors included
+// the server's Go code in shoppinglistpb is generated from it with go generate, which needs protoc, protoc-gen-go and protoc-gen-go-grpc
+// clients in other languages are generated the same way with their own protoc plugins
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: shoppinglist.proto
+
+package shoppinglistpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ShoppingList_ListItems_FullMethodName    = "/shoppinglist.v1.ShoppingList/ListItems"
+	ShoppingList_AddItems_FullMethodName     = "/shoppinglist.v1.ShoppingList/AddItems"
+	ShoppingList_UpdateItem_FullMethodName   = "/shoppinglist.v1.ShoppingList/UpdateItem"
+	ShoppingList_DeleteItem_FullMethodName   = "/shoppinglist.v1.ShoppingList/DeleteItem"
+	ShoppingList_WatchChanges_FullMethodName = "/shoppinglist.v1.ShoppingList/WatchChanges"
+)
+
+// ShoppingListClient is the client API for ShoppingList service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShoppingListClient interface {
+	// GET /data
+	ListItems(ctx context.Context, in *ListItemsRequest, opts ...grpc.CallOption) (*ListItemsResponse, error)
+	// POST /data
+	AddItems(ctx context.Context, in *AddItemsRequest, opts ...grpc.CallOption) (*AddItemsResponse, error)
+	// PATCH /data/{id}
+	UpdateItem(ctx context.Context, in *UpdateItemRequest, opts ...grpc.CallOption) (*Item, error)
+	// DELETE /data/{id}
+	DeleteItem(ctx context.Context, in *DeleteItemRequest, opts ...grpc.CallOption) (*DeleteItemResponse, error)
+	// GET /events, the stream stays open until the client cancels it or the server shuts down
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Change], error)
+}
+
+type shoppingListClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShoppingListClient(cc grpc.ClientConnInterface) ShoppingListClient {
+	return &shoppingListClient{cc}
+}
+
+func (c *shoppingListClient) ListItems(ctx context.Context, in *ListItemsRequest, opts ...grpc.CallOption) (*ListItemsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListItemsResponse)
+	err := c.cc.Invoke(ctx, ShoppingList_ListItems_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shoppingListClient) AddItems(ctx context.Context, in *AddItemsRequest, opts ...grpc.CallOption) (*AddItemsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddItemsResponse)
+	err := c.cc.Invoke(ctx, ShoppingList_AddItems_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shoppingListClient) UpdateItem(ctx context.Context, in *UpdateItemRequest, opts ...grpc.CallOption) (*Item, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Item)
+	err := c.cc.Invoke(ctx, ShoppingList_UpdateItem_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shoppingListClient) DeleteItem(ctx context.Context, in *DeleteItemRequest, opts ...grpc.CallOption) (*DeleteItemResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteItemResponse)
+	err := c.cc.Invoke(ctx, ShoppingList_DeleteItem_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shoppingListClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Change], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ShoppingList_ServiceDesc.Streams[0], ShoppingList_WatchChanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchChangesRequest, Change]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ShoppingList_WatchChangesClient = grpc.ServerStreamingClient[Change]
+
+// ShoppingListServer is the server API for ShoppingList service.
+// All implementations must embed UnimplementedShoppingListServer
+// for forward compatibility.
+type ShoppingListServer interface {
+	// GET /data
+	ListItems(context.Context, *ListItemsRequest) (*ListItemsResponse, error)
+	// POST /data
+	AddItems(context.Context, *AddItemsRequest) (*AddItemsResponse, error)
+	// PATCH /data/{id}
+	UpdateItem(context.Context, *UpdateItemRequest) (*Item, error)
+	// DELETE /data/{id}
+	DeleteItem(context.Context, *DeleteItemRequest) (*DeleteItemResponse, error)
+	// GET /events, the stream stays open until the client cancels it or the server shuts down
+	WatchChanges(*WatchChangesRequest, grpc.ServerStreamingServer[Change]) error
+	mustEmbedUnimplementedShoppingListServer()
+}
+
+// UnimplementedShoppingListServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedShoppingListServer struct{}
+
+func (UnimplementedShoppingListServer) ListItems(context.Context, *ListItemsRequest) (*ListItemsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListItems not implemented")
+}
+func (UnimplementedShoppingListServer) AddItems(context.Context, *AddItemsRequest) (*AddItemsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddItems not implemented")
+}
+func (UnimplementedShoppingListServer) UpdateItem(context.Context, *UpdateItemRequest) (*Item, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateItem not implemented")
+}
+func (UnimplementedShoppingListServer) DeleteItem(context.Context, *DeleteItemRequest) (*DeleteItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteItem not implemented")
+}
+func (UnimplementedShoppingListServer) WatchChanges(*WatchChangesRequest, grpc.ServerStreamingServer[Change]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedShoppingListServer) mustEmbedUnimplementedShoppingListServer() {}
+func (UnimplementedShoppingListServer) testEmbeddedByValue()                      {}
+
+// UnsafeShoppingListServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShoppingListServer will
+// result in compilation errors.
+type UnsafeShoppingListServer interface {
+	mustEmbedUnimplementedShoppingListServer()
+}
+
+func RegisterShoppingListServer(s grpc.ServiceRegistrar, srv ShoppingListServer) {
+	// If the following call pancis, it indicates UnimplementedShoppingListServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ShoppingList_ServiceDesc, srv)
+}
+
+func _ShoppingList_ListItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListItemsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShoppingListServer).ListItems(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShoppingList_ListItems_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShoppingListServer).ListItems(ctx, req.(*ListItemsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShoppingList_AddItems_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddItemsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShoppingListServer).AddItems(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShoppingList_AddItems_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShoppingListServer).AddItems(ctx, req.(*AddItemsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShoppingList_UpdateItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShoppingListServer).UpdateItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShoppingList_UpdateItem_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShoppingListServer).UpdateItem(ctx, req.(*UpdateItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShoppingList_DeleteItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShoppingListServer).DeleteItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShoppingList_DeleteItem_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShoppingListServer).DeleteItem(ctx, req.(*DeleteItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShoppingList_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShoppingListServer).WatchChanges(m, &grpc.GenericServerStream[WatchChangesRequest, Change]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ShoppingList_WatchChangesServer = grpc.ServerStreamingServer[Change]
+
+// ShoppingList_ServiceDesc is the grpc.ServiceDesc for ShoppingList service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ShoppingList_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shoppinglist.v1.ShoppingList",
+	HandlerType: (*ShoppingListServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListItems",
+			Handler:    _ShoppingList_ListItems_Handler,
+		},
+		{
+			MethodName: "AddItems",
+			Handler:    _ShoppingList_AddItems_Handler,
+		},
+		{
+			MethodName: "UpdateItem",
+			Handler:    _ShoppingList_UpdateItem_Handler,
+		},
+		{
+			MethodName: "DeleteItem",
+			Handler:    _ShoppingList_DeleteItem_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _ShoppingList_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "shoppinglist.proto",
+}
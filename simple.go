@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The /simple routes are for webhook automation services like IFTTT and Zapier, which can't send a JSON array or set an Authorization header
+// everything is a query parameter or a urlencoded form field, the API key is sent as key and both GET and POST work
+// they have their own rate limit and their own log line, so a misfiring applet can't use up the limit of the apps or get lost among their requests
+
+// Returns middleware that takes an API key from the key parameter, a request without one goes through auth as usual
+func requireKey(keys []string, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withToken := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// ParseForm reads the query and a urlencoded body into r.Form, which the handlers read too
+			err := r.ParseForm()
+			if err != nil {
+				var tooBig *http.MaxBytesError
+				if errors.As(err, &tooBig) {
+					writeBodyError(w, err)
+					return
+				}
+				writeError(w, http.StatusBadRequest, "invalid_form", "the body can't be decoded: "+err.Error())
+				return
+			}
+			key := r.Form.Get("key")
+			if key == "" {
+				withToken.ServeHTTP(w, r)
+				return
+			}
+			if !validAPIKey(keys, key) {
+				writeError(w, http.StatusUnauthorized, "unauthorized", "key isn't a valid API key")
+				return
+			}
+			ctx := context.WithValue(r.Context(), identityKey, identity{ListID: 0})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Returns middleware that rate limits the /simple routes and logs each request with what it was for, one limiter is shared by all of them
+// the limit is per caller rather than per IP like withRateLimit, as every applet on IFTTT or Zapier calls from the same few addresses
+// a rate of 0 turns limiting off but the requests are still logged
+func withSimpleLimit(rate float64, burst int) func(http.Handler) http.Handler {
+	var limiter *rateLimiter
+	if rate > 0 {
+		limiter = newRateLimiter(rate, burst)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestIdentity(r)
+			remote, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				remote = r.RemoteAddr
+			}
+			if limiter != nil {
+				ok, wait := limiter.Allow(id.Caller(), time.Now())
+				if !ok {
+					slog.Warn("Simple request rate limited", "path", r.URL.Path, "caller", id.Caller(), "remote", remote)
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, slow down")
+					return
+				}
+			}
+			slog.Info("Simple request", "path", r.URL.Path, "caller", id.Caller(), "remote", remote, "item", r.Form["item"])
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handle Get or Post request to add items, /simple/add?item=milk&quantity=2&unit=l
+// item can be given more than once to add several, quantity, unit and category go on each of them
+// an item that is still on the list has the quantity added to it the same as POST /data?dedupe=merge
+func handleSimpleAdd(w http.ResponseWriter, r *http.Request) {
+	items := r.Form["item"]
+	if len(items) == 0 {
+		writeFieldErrors(w, "invalid_entry", []fieldError{{Field: "item", Message: "is required"}})
+		return
+	}
+	var quantity float64
+	if s := r.Form.Get("quantity"); s != "" {
+		var err error
+		quantity, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			writeFieldErrors(w, "invalid_entry", []fieldError{{Field: "quantity", Message: "has to be a number"}})
+			return
+		}
+	}
+	now := time.Now().UTC()
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, Entry{
+			Item:     strings.TrimSpace(item),
+			Quantity: quantity,
+			Unit:     strings.TrimSpace(r.Form.Get("unit")),
+			Category: strings.TrimSpace(r.Form.Get("category")),
+		})
+	}
+	if errs := prepareNewEntries(entries, now); errs != nil {
+		writeFieldErrors(w, "invalid_entry", errs)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	var results, updated, created []Entry
+	err := trackChange(r, "add", func(tx Store) error {
+		var err error
+		results, updated, created, err = addMerging(tx, listID, entries)
+		return err
+	})
+	if err != nil {
+		slog.Error("Error adding entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	publishEntries(requestIdentity(r).Actor(), EventUpdated, updated...)
+	publishEntries(requestIdentity(r).Actor(), EventCreated, created...)
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Handle Get or Post request to tick off an item by name, /simple/done?item=milk
+// the first entry still to get that matches with itemKey is ticked off, so "tomato" finds "Tomatoes"
+func handleSimpleDone(w http.ResponseWriter, r *http.Request) {
+	item := strings.TrimSpace(r.Form.Get("item"))
+	if item == "" {
+		writeFieldErrors(w, "invalid_entry", []fieldError{{Field: "item", Message: "is required"}})
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	entries, err := store.List(listID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	key := itemKey(listID, item)
+	for _, entry := range liveEntries(entries) {
+		if entry.Completed || itemKey(listID, entry.Item) != key {
+			continue
+		}
+		setCompleted(&entry, true)
+		entry.Revision++
+		err = trackChange(r, "complete", func(tx Store) error {
+			return tx.Update(entry)
+		})
+		if err != nil {
+			slog.Error("Error updating entry", "err", err)
+			writeInternalError(w)
+			return
+		}
+		publishEntries(requestIdentity(r).Actor(), EventCompleted, entry)
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+	writeError(w, http.StatusNotFound, "not_found", item+" isn't on the list")
+}
+
+// Handle Get request for the items still to get, as plain entries without any paging or filters
+func handleSimpleList(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	entries, err := store.List(requestIdentity(r).ListID)
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	open := []Entry{}
+	for _, entry := range liveEntries(entries) {
+		if !entry.Completed {
+			open = append(open, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, open)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackMaxAge is how far a request's timestamp can be from now, Slack recommends five minutes so a captured request can't be replayed later
+const slackMaxAge = 5 * time.Minute
+
+// slackSecret is -slack-signing-secret, POST /integrations/slack is only served when it is set
+var slackSecret []byte
+
+// slackResponse is a slash command reply, in_channel replies are seen by everyone in the channel and ephemeral ones only by the sender
+type slackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// Handle Post request from a Slack slash command such as "/shop add milk", working on -chat-list
+// Slack signs every request with the app's signing secret instead of sending a token
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func handleSlack(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !validSlackSignature(r.Header, body, time.Now()) {
+		slog.Warn("Rejected Slack request with a bad signature")
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "the request isn't signed by Slack")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_form", "the body isn't a slash command")
+		return
+	}
+
+	command, args, _ := strings.Cut(strings.TrimSpace(form.Get("text")), " ")
+	actor := "slack:" + form.Get("user_name")
+	reply := runChatCommand(chatListID, actor, form.Get("command")+" ", command, args)
+	// help and the list are only for whoever asked, changes are shown to the channel so everyone knows
+	responseType := "ephemeral"
+	if c := strings.ToLower(command); c == "add" || c == "done" {
+		responseType = "in_channel"
+	}
+	writeJSON(w, http.StatusOK, slackResponse{ResponseType: responseType, Text: escapeSlack(reply)})
+}
+
+// Checks X-Slack-Signature is "v0=" and the hex HMAC-SHA256 of "v0:<timestamp>:<body>", and the timestamp is recent
+func validSlackSignature(header http.Header, body []byte, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > slackMaxAge || age < -slackMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, slackSecret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(header.Get("X-Slack-Signature")))
+}
+
+// Slack reads <, > and & in message text as markup, so item names with them in have to be escaped
+func escapeSlack(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver needs cgo, so it is only linked in when building with -tags sqlite
+import _ "github.com/mattn/go-sqlite3"
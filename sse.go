@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How often a comment line is sent on an idle stream so proxies don't close it and dead clients are noticed
+const sseHeartbeatInterval = 15 * time.Second
+
+// Handle Get request for a text/event-stream of the list's changes (Server-Sent Events)
+// each event has its Seq as the id, so a browser's EventSource sends it back as Last-Event-ID when it reconnects and gets what it missed
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// the stream stays open for as long as the client wants, so the server's write timeout is turned off for it
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		slog.Error("Error starting event stream", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	listID := requestIdentity(r).ListID
+	var sub *subscriber
+	// EventSource sends Last-Event-ID itself, ?lastEventId= is for clients that can't set headers
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	if lastID != "" {
+		since, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_event_id", "Last-Event-ID has to be an event seq")
+			return
+		}
+		sub = hub.SubscribeSince(listID, since)
+	} else {
+		sub = hub.Subscribe(listID)
+	}
+	defer hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// a HEAD request only wants to know the stream is there
+	if r.Method == http.MethodHead {
+		return
+	}
+	// retry tells EventSource how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 3000\n\n")
+	rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.send:
+			if !ok {
+				// the hub dropped us, the client will reconnect and catch up from its last event
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("Error marshalling event", "err", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			if err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// lines starting with a colon are comments which clients ignore
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// defaultStatsItems is how many items GET /stats lists when ?limit= isn't given
+const defaultStatsItems = 20
+
+// purchaseStats is the GET /stats response, worked out from the list's closed trips
+type purchaseStats struct {
+	Trips int `json:"trips"`
+	// Items are the items that have been bought, the most often bought first
+	Items []itemStats `json:"items"`
+	// Spending is what was spent each month on each category, oldest month first
+	Spending []categorySpending `json:"spending"`
+}
+
+// itemStats is how often an item has been bought, items with the same name whatever its case or spacing are the same item
+type itemStats struct {
+	// Item is the name it was last bought under
+	Item string `json:"item"`
+	// Times is how many trips it was bought on
+	Times      int       `json:"times"`
+	LastBought time.Time `json:"last_bought"`
+	// AverageDays is the average number of days between buying it, null until it has been bought twice
+	AverageDays *float64 `json:"average_days"`
+}
+
+// categorySpending is what was spent on a category in a month, "" is the entries without a category
+type categorySpending struct {
+	Month    string  `json:"month"`
+	Category string  `json:"category"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// Returns when an entry on a trip was bought, when it was ticked off if that is known and otherwise when the trip was closed
+func boughtAt(trip Trip, entry Entry) time.Time {
+	if entry.CompletedAt != nil {
+		return *entry.CompletedAt
+	}
+	return trip.ClosedAt
+}
+
+// Works out how often each item on the trips was bought, the most often bought first, callers must hold mu
+// an item that is on a trip more than once is counted once for that trip
+func purchaseHistory(tripList []Trip) []itemStats {
+	type history struct {
+		item  string
+		last  time.Time
+		times []time.Time
+	}
+	byName := make(map[string]*history)
+	var names []string
+	for _, trip := range tripList {
+		seen := make(map[string]bool)
+		for _, entry := range trip.Entries {
+			name := itemKey(trip.ListID, entry.Item)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			h := byName[name]
+			if h == nil {
+				h = &history{}
+				byName[name] = h
+				names = append(names, name)
+			}
+			at := boughtAt(trip, entry)
+			h.times = append(h.times, at)
+			if !at.Before(h.last) {
+				h.item, h.last = entry.Item, at
+			}
+		}
+	}
+
+	stats := make([]itemStats, 0, len(names))
+	for _, name := range names {
+		h := byName[name]
+		slices.SortFunc(h.times, time.Time.Compare)
+		s := itemStats{Item: h.item, Times: len(h.times), LastBought: h.last}
+		if len(h.times) > 1 {
+			days := h.times[len(h.times)-1].Sub(h.times[0]).Hours() / 24 / float64(len(h.times)-1)
+			days = math.Round(days*10) / 10
+			s.AverageDays = &days
+		}
+		stats = append(stats, s)
+	}
+	slices.SortStableFunc(stats, func(a, b itemStats) int {
+		return cmp.Or(cmp.Compare(b.Times, a.Times), b.LastBought.Compare(a.LastBought))
+	})
+	return stats
+}
+
+// Adds up what was spent on each category each month, entries without a price aren't counted
+// and entries without a currency are taken to be in the list's budget currency
+func categorySpendingFor(tripList []Trip, currency string) []categorySpending {
+	type key struct{ month, category, currency string }
+	totals := make(map[key]float64)
+	for _, trip := range tripList {
+		for _, entry := range trip.Entries {
+			if entry.Price == 0 {
+				continue
+			}
+			k := key{boughtAt(trip, entry).Format("2006-01"), entry.Category, cmp.Or(entry.Currency, currency)}
+			totals[k] += entry.Price * entry.Quantity
+		}
+	}
+	spending := make([]categorySpending, 0, len(totals))
+	for k, amount := range totals {
+		spending = append(spending, categorySpending{Month: k.month, Category: k.category, Currency: k.currency, Amount: roundMoney(amount)})
+	}
+	slices.SortFunc(spending, func(a, b categorySpending) int {
+		return cmp.Or(cmp.Compare(a.Month, b.Month), cmp.Compare(a.Category, b.Category), cmp.Compare(a.Currency, b.Currency))
+	})
+	return spending
+}
+
+// Handle Get request for what has been bought on the list's closed trips
+// ?limit= is how many items to list, 20 when it isn't given
+func handleGetStats(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseNonNegative("limit", r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		limit = defaultStatsItems
+	}
+
+	listID := requestIdentity(r).ListID
+	mu.RLock()
+	tripList := trips.WithEntries(listID)
+	budget, _ := budgets.For(listID)
+	items := purchaseHistory(tripList)
+	mu.RUnlock()
+
+	stats := purchaseStats{
+		Trips:    len(tripList),
+		Items:    items[:min(limit, len(items))],
+		Spending: categorySpendingFor(tripList, budget.Currency),
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Store is where entries are kept, handlers only talk to this interface so the storage can be swapped without touching them
+// implementations don't lock across calls, handlers hold mu around anything that reads then writes
+// every entry belongs to a list and every call is scoped to one, so one user can never reach another user's entries by ID
+type Store interface {
+	// List returns every entry in the list in the order they were added
+	List(listID int) ([]Entry, error)
+	// Get returns the entry with the given ID in the list or ErrNotFound
+	Get(listID int, id int) (Entry, error)
+	// Add stores new entries in the list, assigning their IDs, and returns them as stored
+	Add(listID int, entries []Entry) ([]Entry, error)
+	// Update replaces the stored entry that has the same ID and list or returns ErrNotFound
+	Update(entry Entry) error
+	// Delete removes the entry with the given ID from the list for good or returns ErrNotFound
+	// handlers move entries to the trash with Update instead, this is for purging it
+	Delete(listID int, id int) error
+	// All returns every entry on every list, ordered by ID
+	All() ([]Entry, error)
+	// Replace swaps every entry on every list for entries, keeping their IDs, new entries get IDs after the highest one
+	// it is for restoring a backup
+	Replace(entries []Entry) error
+	// Count returns how many entries there are across every list
+	Count() (int, error)
+	// Transaction runs fn with a Store whose entry changes are kept together if fn returns nil and all dropped if it returns an error
+	// fn has to use the Store it is given rather than this one, documents saved inside it are written straight away as usual
+	Transaction(fn func(tx Store) error) error
+	// AppendAudit adds records to the end of the audit log, nothing in it is ever changed or removed
+	AppendAudit(records []AuditRecord) error
+	// Audit returns the records in the audit log that match q, oldest first
+	Audit(q auditQuery) ([]AuditRecord, error)
+	// LoadDoc decodes the document saved under name into v or returns ErrNotFound if there isn't one yet
+	// documents hold everything that isn't an entry (e.g. user accounts) so each subsystem doesn't need its own storage
+	LoadDoc(name string, v any) error
+	// SaveDoc stores v as JSON under name, replacing what was there
+	SaveDoc(name string, v any) error
+	// Close makes sure every change is persisted and releases the storage, it is called once on shutdown
+	Close() error
+}
+
+// ErrNotFound is returned by a Store when there is no entry with the requested ID
+var ErrNotFound = errors.New("entry not found")
+
+// Opens the store picked with the -storage flag
+// -data is the JSON file for "json", the database file for "sqlite" and "bolt" and the connection URL for "postgres" and "redis"
+// -sync only matters for "json" because the databases always commit before returning
+func openStore(cfg Config) (Store, error) {
+	switch cfg.Storage {
+	case "json":
+		return newJSONStore(cfg.DataFile, cfg.SyncWrites)
+	case "sqlite":
+		return newSQLiteStore(cfg.DataFile)
+	case "bolt":
+		return newBoltStore(cfg.DataFile)
+	case "postgres":
+		return newPostgresStore(cfg.DataFile, poolConfig{MaxConns: cfg.DBMaxConns, MaxIdleConns: cfg.DBMaxIdleConns, ConnLifetime: cfg.DBConnLifetime})
+	case "redis":
+		return newRedisStore(cfg.DataFile, cfg.RedisCompletedTTL)
+	default:
+		return nil, fmt.Errorf("unknown storage %q, expected json, sqlite, bolt, postgres or redis", cfg.Storage)
+	}
+}
@@ -0,0 +1,356 @@
+//go:build bolt
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bbolt buckets, each list's entries get a bucket of their own inside listsBucket keyed by the list ID
+var (
+	listsBucket = []byte("lists")
+	docsBucket  = []byte("docs")
+	auditBucket = []byte("audit")
+	// metaBucket's sequence is the entry ID counter
+	metaBucket = []byte("meta")
+)
+
+// boltStore keeps entries in a bbolt file, a pure Go key/value store, so it needs neither cgo like SQLite nor rewriting a whole file like JSON
+// entries are stored as JSON keyed by their ID in big endian, so a cursor walks a list in the order it was added
+type boltStore struct {
+	db *bolt.DB
+	// tx is set on the Store handed to a Transaction's fn, every call then runs inside it
+	tx *bolt.Tx
+}
+
+// Opens the bbolt file, creating the buckets, and copies the JSON data in the first time
+// the JSON store is looked for next to it with the same name, e.g. data.json for data.db
+func newBoltStore(path string) (Store, error) {
+	_, statErr := os.Stat(path)
+	// the timeout stops a second server started on the same file from hanging forever waiting for the lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	s := &boltStore{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{listsBucket, docsBucket, auditBucket, metaBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	jsonPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+	if os.IsNotExist(statErr) && jsonPath != path {
+		err = s.importJSON(jsonPath)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("copying %s into %s: %w", jsonPath, path, err)
+		}
+	}
+	return s, nil
+}
+
+// Copies the entries and documents from the JSON store at path, if there is one, the audit log stays where it is
+func (s *boltStore) importJSON(path string) error {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	from, err := newJSONStore(path, true)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	backup, err := takeBackup(from)
+	if err != nil {
+		return err
+	}
+	// the token signing secret comes too so people don't get logged out by the switch
+	var secret json.RawMessage
+	err = from.LoadDoc(jwtSecretDocName, &secret)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	err = s.Transaction(func(tx Store) error {
+		err := tx.Replace(backup.Entries)
+		if err != nil {
+			return err
+		}
+		for name, doc := range backup.Docs {
+			err := tx.SaveDoc(name, doc)
+			if err != nil {
+				return err
+			}
+		}
+		if secret != nil {
+			return tx.SaveDoc(jwtSecretDocName, secret)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Copied JSON data into bolt storage", "from", path, "entries", len(backup.Entries), "docs", len(backup.Docs))
+	return nil
+}
+
+// Runs fn in a read-write transaction, joining the one the store is already in
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// Runs fn in a read-only transaction, joining the one the store is already in
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+func boltKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// Returns the list's bucket, or nil if nothing has ever been added to it
+func listBucket(tx *bolt.Tx, listID int) *bolt.Bucket {
+	return tx.Bucket(listsBucket).Bucket(boltKey(listID))
+}
+
+func (s *boltStore) List(listID int) ([]Entry, error) {
+	entries := []Entry{}
+	err := s.view(func(tx *bolt.Tx) error {
+		b := listBucket(tx, listID)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var entry Entry
+			err := json.Unmarshal(v, &entry)
+			entries = append(entries, entry)
+			return err
+		})
+	})
+	return entries, err
+}
+
+func (s *boltStore) All() ([]Entry, error) {
+	entries := []Entry{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(listsBucket).ForEachBucket(func(listKey []byte) error {
+			return tx.Bucket(listsBucket).Bucket(listKey).ForEach(func(k, v []byte) error {
+				var entry Entry
+				err := json.Unmarshal(v, &entry)
+				entries = append(entries, entry)
+				return err
+			})
+		})
+	})
+	slices.SortStableFunc(entries, func(a, b Entry) int { return a.ID - b.ID })
+	return entries, err
+}
+
+func (s *boltStore) Get(listID int, id int) (Entry, error) {
+	var entry Entry
+	err := s.view(func(tx *bolt.Tx) error {
+		b := listBucket(tx, listID)
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get(boltKey(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &entry)
+	})
+	return entry, err
+}
+
+func (s *boltStore) Add(listID int, newEntries []Entry) ([]Entry, error) {
+	err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(listsBucket).CreateBucketIfNotExists(boltKey(listID))
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket(metaBucket)
+		for i := range newEntries {
+			id, err := meta.NextSequence()
+			if err != nil {
+				return err
+			}
+			newEntries[i].ID = int(id)
+			newEntries[i].ListID = listID
+			err = putEntry(b, newEntries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEntries, nil
+}
+
+func putEntry(b *bolt.Bucket, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(boltKey(entry.ID), data)
+}
+
+func (s *boltStore) Update(entry Entry) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := listBucket(tx, entry.ListID)
+		if b == nil || b.Get(boltKey(entry.ID)) == nil {
+			return ErrNotFound
+		}
+		return putEntry(b, entry)
+	})
+}
+
+func (s *boltStore) Delete(listID int, id int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := listBucket(tx, listID)
+		if b == nil || b.Get(boltKey(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(boltKey(id))
+	})
+}
+
+// The ID counter is only ever moved forward so IDs given out before the restore aren't reused
+func (s *boltStore) Replace(entries []Entry) error {
+	return s.update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(listsBucket)
+		if err != nil {
+			return err
+		}
+		lists, err := tx.CreateBucket(listsBucket)
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket(metaBucket)
+		next := meta.Sequence()
+		for _, entry := range entries {
+			b, err := lists.CreateBucketIfNotExists(boltKey(entry.ListID))
+			if err != nil {
+				return err
+			}
+			err = putEntry(b, entry)
+			if err != nil {
+				return err
+			}
+			next = max(next, uint64(entry.ID))
+		}
+		return meta.SetSequence(next)
+	})
+}
+
+func (s *boltStore) Count() (int, error) {
+	count := 0
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(listsBucket).ForEachBucket(func(listKey []byte) error {
+			count += tx.Bucket(listsBucket).Bucket(listKey).Stats().KeyN
+			return nil
+		})
+	})
+	return count, err
+}
+
+// bbolt rolls the whole transaction back when fn returns an error, documents saved inside it included
+func (s *boltStore) Transaction(fn func(tx Store) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return fn(&boltStore{db: s.db, tx: tx})
+	})
+}
+
+// Audit records are keyed by the bucket's sequence so they stay in the order they were added
+func (s *boltStore) AppendAudit(records []AuditRecord) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = b.Put(boltKey(int(seq)), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// There is no index on the audit log so every record is read, like the JSON store does
+func (s *boltStore) Audit(q auditQuery) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(k, v []byte) error {
+			var record AuditRecord
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if q.Matches(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (s *boltStore) LoadDoc(name string, v any) error {
+	return s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(docsBucket).Get([]byte(name))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+func (s *boltStore) SaveDoc(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(docsBucket).Put([]byte(name), data)
+	})
+}
+
+// bbolt fsyncs every transaction as it commits so there is nothing left to write
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
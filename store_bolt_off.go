@@ -0,0 +1,10 @@
+//go:build !bolt
+
+package main
+
+import "errors"
+
+// bbolt is only linked in when building with -tags bolt so the default build doesn't need the dependency
+func newBoltStore(path string) (Store, error) {
+	return nil, errors.New("bolt storage is not available in this build, rebuild with -tags bolt")
+}
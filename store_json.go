@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// jsonStore keeps every entry in a single JSON file, which is how the server has always stored the list
+// the entries are loaded into memory once at startup so reads never touch the disk
+// each change is appended to a journal next to the file rather than rewriting the whole file, with syncWrites set it is fsynced before the call returns
+// once the journal has journalCompactEvery records a background goroutine writes the file again and empties the journal
+type jsonStore struct {
+	path       string
+	syncWrites bool
+
+	// mu guards entries, nextID and the journal, the background compaction reads them while handlers are changing them
+	mu      sync.Mutex
+	entries []Entry
+	// nextID is the ID the next added entry gets, it only ever goes up so an ID is never given out twice even after deletes
+	nextID int
+	// journal is open for appending, it is nil on a transaction's copy which collects its changes in pending instead
+	journal *os.File
+	// journaled is how many records have been appended since the file was last written
+	journaled int
+	pending   []journalOp
+
+	// kick wakes the background compaction up, it has room for one signal because a pending signal already covers any later change
+	kick chan struct{}
+
+	// auditMu makes sure records from two changes never end up interleaved in the audit log
+	auditMu sync.Mutex
+}
+
+// Opens the JSON file store, first recovering the file from its backup if the last write was cut short
+func newJSONStore(path string, syncWrites bool) (*jsonStore, error) {
+	err := recoverDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &jsonStore{path: path, syncWrites: syncWrites, kick: make(chan struct{}, 1)}
+	s.entries, err = s.load()
+	// a missing file just means nothing has been added yet
+	if os.IsNotExist(err) {
+		s.entries, err = []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadNextID()
+	if err != nil {
+		return nil, err
+	}
+	err = s.replayJournal()
+	if err != nil {
+		return nil, err
+	}
+	s.journal, err = os.OpenFile(s.journalPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	go s.writer()
+	return s, nil
+}
+
+func (s *jsonStore) List(listID int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a new slice so callers can't change the cached entries by accident
+	entries := []Entry{}
+	for _, entry := range s.entries {
+		if entry.ListID == listID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *jsonStore) All() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := slices.Clone(s.entries)
+	slices.SortStableFunc(entries, func(a, b Entry) int { return a.ID - b.ID })
+	return entries, nil
+}
+
+func (s *jsonStore) Get(listID int, id int) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.ID == id && entry.ListID == listID {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (s *jsonStore) Add(listID int, newEntries []Entry) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Assign IDs to new entries and append to existing entries
+	ops := make([]journalOp, 0, len(newEntries))
+	for i := range newEntries {
+		newEntries[i].ID = s.nextID
+		newEntries[i].ListID = listID
+		s.nextID++
+		entry := newEntries[i]
+		ops = append(ops, journalOp{Op: "put", Entry: &entry})
+	}
+	s.entries = append(s.entries, newEntries...)
+	return newEntries, s.persist(ops...)
+}
+
+func (s *jsonStore) Update(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// indexing into the slice rather than using the range copy so the change sticks
+	for i := range s.entries {
+		if s.entries[i].ID == entry.ID && s.entries[i].ListID == entry.ListID {
+			s.entries[i] = entry
+			return s.persist(journalOp{Op: "put", Entry: &entry})
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *jsonStore) Delete(listID int, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Filter out the entry with the given ID
+	// found records whether anything was removed so a missing ID can return ErrNotFound
+	newEntries := []Entry{}
+	found := false
+	for _, entry := range s.entries {
+		if entry.ID == id && entry.ListID == listID {
+			found = true
+			continue
+		}
+		newEntries = append(newEntries, entry)
+	}
+	if !found {
+		return ErrNotFound
+	}
+	s.entries = newEntries
+	return s.persist(journalOp{Op: "delete", ListID: listID, ID: id})
+}
+
+func (s *jsonStore) Replace(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = slices.Clone(entries)
+	// the counter never goes down so IDs given out before the restore aren't reused
+	for _, entry := range entries {
+		s.nextID = max(s.nextID, entry.ID+1)
+	}
+	return s.persist(journalOp{Op: "replace", Entries: entries})
+}
+
+// The transaction works on a copy of the entries that replaces the real ones once fn succeeds
+// the copy has no journal so its changes are collected and appended as one record, which a crash can't leave half written
+// s.mu is held throughout so nothing else can change the entries underneath it
+func (s *jsonStore) Transaction(fn func(tx Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &jsonStore{path: s.path, entries: slices.Clone(s.entries), nextID: s.nextID}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	s.entries = tx.entries
+	s.nextID = tx.nextID
+	return s.persist(tx.pending...)
+}
+
+func (s *jsonStore) Count() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), nil
+}
+
+// Documents are kept in their own file next to the data file e.g. data.users.json
+// they are small and rarely change so they are read and written straight away rather than cached
+func (s *jsonStore) LoadDoc(name string, v any) error {
+	path := s.docPath(name)
+	err := recoverDataFile(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(file, v)
+}
+
+func (s *jsonStore) SaveDoc(name string, v any) error {
+	file, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.docPath(name), file)
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+	}
+	return err
+}
+
+func (s *jsonStore) docPath(name string) string {
+	return strings.TrimSuffix(s.path, ".json") + "." + name + ".json"
+}
+
+// The audit log is a file of one JSON record per line next to the data file e.g. data.audit.jsonl
+// it is appended to rather than rewritten so it never has to be held in memory and old records can't be lost by a bad write
+func (s *jsonStore) AppendAudit(records []AuditRecord) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	f, err := os.OpenFile(s.auditPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil && s.syncWrites {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+	}
+	return err
+}
+
+func (s *jsonStore) Audit(q auditQuery) ([]AuditRecord, error) {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	records := []AuditRecord{}
+	f, err := os.Open(s.auditPath())
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record AuditRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// a crash part way through an append leaves half a line at the end, the rest of the log is still fine
+			slog.Warn("Skipping unreadable audit log line", "path", s.auditPath(), "err", err)
+			continue
+		}
+		if q.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (s *jsonStore) auditPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".audit.jsonl"
+}
+
+// Flush writes the entries to the file and empties the journal, it is how the journal is compacted
+// s.mu is held while the file is written, which only happens every journalCompactEvery changes
+// a crash after the file is written but before the journal is emptied is fine because replaying a record twice changes nothing
+func (s *jsonStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journaled == 0 {
+		return nil
+	}
+	err := s.save(s.entries, s.nextID)
+	if err != nil {
+		return err
+	}
+	err = s.journal.Truncate(0)
+	if err == nil {
+		err = s.journal.Sync()
+	}
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+		return err
+	}
+	s.journaled = 0
+	return nil
+}
+
+// Compact writes the file and empties the journal straight away rather than waiting for journalCompactEvery changes
+func (s *jsonStore) Compact() error {
+	return s.Flush()
+}
+
+// Close compacts the journal so the file is complete on its own, then closes the journal
+func (s *jsonStore) Close() error {
+	err := s.Flush()
+	closeErr := s.journal.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// journalCompactEvery is how many records the journal gets before the background compaction writes the file
+const journalCompactEvery = 1000
+
+// journalRecord is one line of the journal, every change made by one call or one transaction
+type journalRecord struct {
+	Ops []journalOp `json:"ops"`
+	// NextID is the ID counter after the change, so IDs aren't reused after a restart even when the newest entries were deleted
+	NextID int `json:"next_id"`
+}
+
+// journalOp is one change to the entries
+type journalOp struct {
+	// Op is "put" to add or replace Entry, "delete" to remove the entry with ListID and ID, or "replace" to swap every entry for Entries
+	Op      string  `json:"op"`
+	Entry   *Entry  `json:"entry,omitempty"`
+	ListID  int     `json:"list_id,omitempty"`
+	ID      int     `json:"id,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Applies an op to the entries, applying one again gives the same result, which replay relies on
+func (s *jsonStore) apply(op journalOp) {
+	switch op.Op {
+	case "put":
+		i := slices.IndexFunc(s.entries, func(e Entry) bool { return e.ID == op.Entry.ID })
+		if i < 0 {
+			s.entries = append(s.entries, *op.Entry)
+		} else {
+			s.entries[i] = *op.Entry
+		}
+		s.nextID = max(s.nextID, op.Entry.ID+1)
+	case "delete":
+		s.entries = slices.DeleteFunc(s.entries, func(e Entry) bool { return e.ID == op.ID && e.ListID == op.ListID })
+	case "replace":
+		s.entries = slices.Clone(op.Entries)
+	}
+}
+
+// Records a change that has been made to the cached entries, callers must hold s.mu
+// on a transaction's copy the ops are kept for the commit, otherwise they are appended to the journal as one line
+func (s *jsonStore) persist(ops ...journalOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if s.journal == nil {
+		s.pending = append(s.pending, ops...)
+		return nil
+	}
+	line, err := json.Marshal(journalRecord{Ops: ops, NextID: s.nextID})
+	if err != nil {
+		return err
+	}
+	_, err = s.journal.Write(append(line, '\n'))
+	if err == nil && s.syncWrites {
+		err = s.journal.Sync()
+	}
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+		return err
+	}
+	s.journaled++
+	if s.journaled >= journalCompactEvery {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Runs in its own goroutine and compacts the journal whenever persist kicks it
+func (s *jsonStore) writer() {
+	for range s.kick {
+		err := s.Flush()
+		if err != nil {
+			slog.Error("Error writing file", "path", s.path, "err", err)
+		}
+	}
+}
+
+// Applies the journal left by the last run on top of the entries loaded from the file, then compacts it
+// a crash part way through an append leaves half a line at the end, that change never returned so it is dropped
+func (s *jsonStore) replayJournal() error {
+	data, err := os.ReadFile(s.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	records := 0
+	for line := range bytes.Lines(data) {
+		var record journalRecord
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			slog.Warn("Dropping unreadable journal record", "path", s.journalPath(), "record", records+1, "err", err)
+			break
+		}
+		for _, op := range record.Ops {
+			s.apply(op)
+		}
+		s.nextID = max(s.nextID, record.NextID)
+		records++
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	slog.Info("Replayed journal", "path", s.journalPath(), "records", records)
+	for i := range s.entries {
+		normalizeEntry(&s.entries[i])
+	}
+	err = s.save(s.entries, s.nextID)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(s.journalPath(), 0)
+}
+
+func (s *jsonStore) journalPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".journal.jsonl"
+}
+
+// Reads every entry from the JSON file
+func (s *jsonStore) load() ([]Entry, error) {
+	file, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	err = json.Unmarshal(file, &entries)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		normalizeEntry(&entries[i])
+	}
+	return entries, nil
+}
+
+// Writes every entry back to the JSON file, followed by the ID counter
+// if the counter doesn't get written loadNextID still starts after the highest ID in the file, so the worst case is reusing the ID of an entry deleted at the end
+func (s *jsonStore) save(entries []Entry, nextID int) error {
+	// MarshallIndent does the same as marshall but just gets everything in the right format
+	file, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.path, file)
+	if err != nil {
+		metrics.fileWriteErrors.Add(1)
+		return err
+	}
+	return s.SaveDoc(idsDocName, idsDoc{NextID: nextID})
+}
+
+// idsDocName is the document the JSON store keeps its ID counter in, the data file itself is just the array of entries
+const idsDocName = "ids"
+
+type idsDoc struct {
+	NextID int `json:"next_id"`
+}
+
+// Sets nextID from the saved counter and the entries that were loaded, whichever is higher
+// files written before the counter existed gave out IDs as len(entries)+1, so after a delete two entries could share an ID
+// those are found here and every entry after the first with an ID gets a new one, the file is rewritten straight away so the new IDs stick
+func (s *jsonStore) loadNextID() error {
+	var doc idsDoc
+	err := s.LoadDoc(idsDocName, &doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	s.nextID = max(doc.NextID, 1)
+	for _, entry := range s.entries {
+		s.nextID = max(s.nextID, entry.ID+1)
+	}
+
+	seen := make(map[int]bool)
+	renumbered := 0
+	for i := range s.entries {
+		if s.entries[i].ID > 0 && !seen[s.entries[i].ID] {
+			seen[s.entries[i].ID] = true
+			continue
+		}
+		slog.Warn("Giving entry a new ID because another entry already has it", "old_id", s.entries[i].ID, "new_id", s.nextID, "item", s.entries[i].Item)
+		s.entries[i].ID = s.nextID
+		s.nextID++
+		renumbered++
+	}
+	if renumbered == 0 && doc.NextID != 0 {
+		return nil
+	}
+	return s.save(s.entries, s.nextID)
+}
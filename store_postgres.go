@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// postgresDriver is the database/sql driver name registered by postgres_driver.go
+const postgresDriver = "pgx"
+
+// postgresMigrationLock is the advisory lock key held while migrating, so servers starting together don't both run a migration
+const postgresMigrationLock = 7405617
+
+// postgresMigrations bring the schema up to date, each one runs once in order and the number done is kept in schema_version
+// only ever add to the end, a migration that has run on someone's database can't be changed
+var postgresMigrations = []string{
+	// entries are JSON documents like in SQLite, so adding a field to Entry doesn't need a migration
+	`CREATE TABLE entries (
+		id BIGSERIAL PRIMARY KEY,
+		list_id BIGINT NOT NULL,
+		data JSONB NOT NULL
+	);
+	CREATE INDEX entries_list_id ON entries (list_id)`,
+	// the time is kept as Unix nanoseconds so ranges compare as numbers, the same as SQLite
+	`CREATE TABLE audit (
+		id BIGSERIAL PRIMARY KEY,
+		time BIGINT NOT NULL,
+		list_id BIGINT NOT NULL,
+		entry_id BIGINT NOT NULL,
+		data JSONB NOT NULL
+	);
+	CREATE INDEX audit_list_time ON audit (list_id, time)`,
+	`CREATE TABLE docs (
+		name TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	)`,
+}
+
+// postgresStore keeps entries in PostgreSQL so several servers can share one database
+// each server still keeps the documents (users, categories...) it loaded at startup in memory, so changes to those made
+// through one server are only seen by the others once they restart
+type postgresStore struct {
+	db *sql.DB
+	// tx is set on the Store handed to a Transaction's fn, every statement then runs inside it
+	tx *sql.Tx
+}
+
+func (s *postgresStore) conn() sqlConn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// Connects to the database at dsn, a postgres:// URL or "host=... dbname=..." string, and runs any migrations it is missing
+func newPostgresStore(dsn string, pool poolConfig) (*postgresStore, error) {
+	// the driver is only linked in when building with -tags postgres so the default build doesn't need it
+	if !slices.Contains(sql.Drivers(), postgresDriver) {
+		return nil, errors.New("postgres storage is not available in this build, rebuild with -tags postgres")
+	}
+	db, err := sql.Open(postgresDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnLifetime)
+
+	err = migratePostgres(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+// Runs the migrations the database hasn't had yet, all in one transaction so a failed one leaves the schema as it was
+// refuses to start against a database migrated by a newer server, which may have changed things this one doesn't know about
+func migratePostgres(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", postgresMigrationLock)
+	if err != nil {
+		return fmt.Errorf("locking for migrations: %w", err)
+	}
+	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("creating schema_version table: %w", err)
+	}
+	var version int
+	err = tx.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.Exec("INSERT INTO schema_version (version) VALUES (0)")
+	}
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("the database is at schema version %d but this server only knows up to %d, upgrade the server", version, len(postgresMigrations))
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		_, err = tx.Exec(postgresMigrations[i])
+		if err != nil {
+			return fmt.Errorf("running migration %d: %w", i+1, err)
+		}
+		slog.Info("Migrated database", "version", i+1)
+	}
+	_, err = tx.Exec("UPDATE schema_version SET version = $1", len(postgresMigrations))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) List(listID int) ([]Entry, error) {
+	rows, err := s.conn().Query("SELECT id, list_id, data FROM entries WHERE list_id = $1 ORDER BY id", listID)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (s *postgresStore) All() ([]Entry, error) {
+	rows, err := s.conn().Query("SELECT id, list_id, data FROM entries ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (s *postgresStore) Get(listID int, id int) (Entry, error) {
+	row := s.conn().QueryRow("SELECT id, list_id, data FROM entries WHERE id = $1 AND list_id = $2", id, listID)
+	entry, err := scanEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s *postgresStore) Add(listID int, newEntries []Entry) ([]Entry, error) {
+	err := s.inTx(func(tx *postgresStore) error {
+		for i := range newEntries {
+			newEntries[i].ListID = listID
+			data, err := json.Marshal(newEntries[i])
+			if err != nil {
+				return err
+			}
+			err = tx.conn().QueryRow("INSERT INTO entries (list_id, data) VALUES ($1, $2) RETURNING id", listID, string(data)).Scan(&newEntries[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEntries, nil
+}
+
+func (s *postgresStore) Update(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	result, err := s.conn().Exec("UPDATE entries SET data = $1 WHERE id = $2 AND list_id = $3", string(data), entry.ID, entry.ListID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (s *postgresStore) Delete(listID int, id int) error {
+	result, err := s.conn().Exec("DELETE FROM entries WHERE id = $1 AND list_id = $2", id, listID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Inserting with explicit IDs doesn't move the sequence, so it is moved past the highest restored ID by hand
+// it is never moved back, that way IDs handed out before the restore aren't reused
+func (s *postgresStore) Replace(entries []Entry) error {
+	return s.inTx(func(tx *postgresStore) error {
+		_, err := tx.conn().Exec("DELETE FROM entries")
+		if err != nil {
+			return err
+		}
+		highest := 0
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			_, err = tx.conn().Exec("INSERT INTO entries (id, list_id, data) VALUES ($1, $2, $3)", entry.ID, entry.ListID, string(data))
+			if err != nil {
+				return err
+			}
+			highest = max(highest, entry.ID)
+		}
+		_, err = tx.conn().Exec("SELECT setval('entries_id_seq', $1) FROM entries_id_seq WHERE last_value < $1", highest)
+		return err
+	})
+}
+
+func (s *postgresStore) Transaction(fn func(tx Store) error) error {
+	return s.inTx(func(tx *postgresStore) error {
+		return fn(tx)
+	})
+}
+
+// Runs fn with a store whose statements all go in one transaction that is committed if fn returns nil
+// inside a transaction already it joins that one rather than starting another on a second connection
+func (s *postgresStore) inTx(fn func(tx *postgresStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(&postgresStore{db: s.db, tx: tx})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) Count() (int, error) {
+	var count int
+	err := s.conn().QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+	return count, err
+}
+
+func (s *postgresStore) AppendAudit(records []AuditRecord) error {
+	return s.inTx(func(tx *postgresStore) error {
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			_, err = tx.conn().Exec("INSERT INTO audit (time, list_id, entry_id, data) VALUES ($1, $2, $3, $4)", record.Time.UnixNano(), record.ListID, record.EntryID, string(data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *postgresStore) Audit(q auditQuery) ([]AuditRecord, error) {
+	query := "SELECT data FROM audit WHERE list_id = $1"
+	args := []any{q.ListID}
+	if q.EntryID != 0 {
+		args = append(args, q.EntryID)
+		query += fmt.Sprintf(" AND entry_id = $%d", len(args))
+	}
+	if !q.Since.IsZero() {
+		args = append(args, q.Since.UnixNano())
+		query += fmt.Sprintf(" AND time >= $%d", len(args))
+	}
+	if !q.Until.IsZero() {
+		args = append(args, q.Until.UnixNano())
+		query += fmt.Sprintf(" AND time < $%d", len(args))
+	}
+	rows, err := s.conn().Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		var record AuditRecord
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *postgresStore) LoadDoc(name string, v any) error {
+	var data []byte
+	err := s.conn().QueryRow("SELECT data FROM docs WHERE name = $1", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *postgresStore) SaveDoc(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec("INSERT INTO docs (name, data) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET data = excluded.data", name, string(data))
+	return err
+}
+
+// Compact runs VACUUM on the tables, autovacuum does this too but not necessarily straight after a lot has been deleted
+func (s *postgresStore) Compact() error {
+	_, err := s.db.Exec("VACUUM ANALYZE entries, docs")
+	return err
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+// poolConfig is how many connections a database store keeps open to the server
+type poolConfig struct {
+	MaxConns     int
+	MaxIdleConns int
+	ConnLifetime time.Duration
+}
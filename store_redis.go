@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// redisPrefix starts every key the store uses so the database can be shared with other things
+const redisPrefix = "shoppinglist:"
+
+// The Redis keys, every entry is a JSON string under its own key so completed ones can be given a TTL
+// the sorted sets keep the IDs of the entries in each list and in total, scored by ID so they come back in the order they were added
+func redisEntryKey(id int) string    { return redisPrefix + "entry:" + strconv.Itoa(id) }
+func redisListKey(listID int) string { return redisPrefix + "list:" + strconv.Itoa(listID) }
+func redisDocKey(name string) string { return redisPrefix + "doc:" + name }
+
+const (
+	redisAllKey    = redisPrefix + "entries"
+	redisListsKey  = redisPrefix + "lists"
+	redisNextIDKey = redisPrefix + "next_id"
+	redisAuditKey  = redisPrefix + "audit"
+)
+
+// redisStore keeps entries in Redis, for running the server as a stateless container next to a managed Redis
+// with completedTTL set, completed entries expire on their own that long after they were last changed
+// an expired entry's ID is left in its sorted sets and skipped when read, then removed by the next List of its list
+type redisStore struct {
+	client       *redisClient
+	completedTTL time.Duration
+	// tx is set on the Store handed to a Transaction's fn, changes are kept in it and sent in one MULTI when fn returns
+	tx *redisTx
+}
+
+// redisTx is a transaction's changes that haven't been sent yet, reads inside it see them on top of what is in Redis
+type redisTx struct {
+	// entries are the changed entries by ID, nil for one that was deleted
+	entries map[int]*Entry
+	// deleted are the lists the deleted entries were on, by ID
+	deleted map[int]int
+	// replaced is set by Replace, everything in Redis is then ignored and deleted on commit
+	replaced bool
+	highest  int
+}
+
+// Connects to Redis at rawURL, e.g. redis://:password@localhost:6379/0
+func newRedisStore(rawURL string, completedTTL time.Duration) (*redisStore, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	_, err = client.Do("PING")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	return &redisStore{client: client, completedTTL: completedTTL}, nil
+}
+
+func (s *redisStore) List(listID int) ([]Entry, error) {
+	var entries []Entry
+	if s.tx == nil || !s.tx.replaced {
+		var err error
+		entries, err = s.load(redisListKey(listID))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.overlay(entries, func(entry *Entry) bool { return entry.ListID == listID }), nil
+}
+
+func (s *redisStore) All() ([]Entry, error) {
+	var entries []Entry
+	if s.tx == nil || !s.tx.replaced {
+		var err error
+		entries, err = s.load(redisAllKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.overlay(entries, func(entry *Entry) bool { return true }), nil
+}
+
+// Reads the entries whose IDs are in the sorted set, dropping the IDs of any that have expired
+func (s *redisStore) load(set string) ([]Entry, error) {
+	reply, err := s.client.Do("ZRANGE", set, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	ids, _ := reply.([]any)
+	if len(ids) == 0 {
+		return []Entry{}, nil
+	}
+	mget := []string{"MGET"}
+	for _, id := range ids {
+		mget = append(mget, redisPrefix+"entry:"+redisString(id))
+	}
+	reply, err = s.client.Do(mget...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]any)
+	entries := []Entry{}
+	expired := []string{"ZREM", set}
+	for i, value := range values {
+		data, ok := value.([]byte)
+		if !ok {
+			expired = append(expired, redisString(ids[i]))
+			continue
+		}
+		var entry Entry
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, err
+		}
+		normalizeEntry(&entry)
+		entries = append(entries, entry)
+	}
+	if len(expired) > 2 {
+		_, err = s.client.Do(expired...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Puts a transaction's changes on top of entries read from Redis, keeping the ones in the transaction that match
+func (s *redisStore) overlay(entries []Entry, match func(entry *Entry) bool) []Entry {
+	if s.tx == nil {
+		return entries
+	}
+	entries = slices.DeleteFunc(entries, func(entry Entry) bool {
+		_, changed := s.tx.entries[entry.ID]
+		return changed
+	})
+	for _, entry := range s.tx.entries {
+		if entry != nil && match(entry) {
+			entries = append(entries, *entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return a.ID - b.ID })
+	return entries
+}
+
+func (s *redisStore) Get(listID int, id int) (Entry, error) {
+	if s.tx != nil {
+		if entry, ok := s.tx.entries[id]; ok {
+			if entry == nil || entry.ListID != listID {
+				return Entry{}, ErrNotFound
+			}
+			return *entry, nil
+		}
+		if s.tx.replaced {
+			return Entry{}, ErrNotFound
+		}
+	}
+	reply, err := s.client.Do("GET", redisEntryKey(id))
+	if err != nil {
+		return Entry{}, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	var entry Entry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	if entry.ListID != listID {
+		return Entry{}, ErrNotFound
+	}
+	normalizeEntry(&entry)
+	return entry, nil
+}
+
+// IDs are taken from the counter straight away, so a transaction that is dropped leaves a gap like SQLite's AUTOINCREMENT does
+func (s *redisStore) Add(listID int, newEntries []Entry) ([]Entry, error) {
+	err := s.inTx(func(tx *redisStore) error {
+		for i := range newEntries {
+			reply, err := tx.client.Do("INCR", redisNextIDKey)
+			if err != nil {
+				return err
+			}
+			id, _ := reply.(int64)
+			newEntries[i].ID = int(id)
+			newEntries[i].ListID = listID
+			entry := newEntries[i]
+			tx.tx.entries[entry.ID] = &entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEntries, nil
+}
+
+func (s *redisStore) Update(entry Entry) error {
+	return s.inTx(func(tx *redisStore) error {
+		_, err := tx.Get(entry.ListID, entry.ID)
+		if err != nil {
+			return err
+		}
+		tx.tx.entries[entry.ID] = &entry
+		return nil
+	})
+}
+
+func (s *redisStore) Delete(listID int, id int) error {
+	return s.inTx(func(tx *redisStore) error {
+		_, err := tx.Get(listID, id)
+		if err != nil {
+			return err
+		}
+		tx.tx.entries[id] = nil
+		tx.tx.deleted[id] = listID
+		return nil
+	})
+}
+
+func (s *redisStore) Replace(entries []Entry) error {
+	return s.inTx(func(tx *redisStore) error {
+		tx.tx.replaced = true
+		tx.tx.entries = make(map[int]*Entry, len(entries))
+		for _, entry := range entries {
+			tx.tx.entries[entry.ID] = &entry
+			tx.tx.highest = max(tx.tx.highest, entry.ID)
+		}
+		return nil
+	})
+}
+
+func (s *redisStore) Count() (int, error) {
+	entries, err := s.All()
+	return len(entries), err
+}
+
+func (s *redisStore) Transaction(fn func(tx Store) error) error {
+	return s.inTx(func(tx *redisStore) error {
+		return fn(tx)
+	})
+}
+
+// Runs fn with a store that collects its changes and then sends them all in one MULTI/EXEC so they are applied together
+// inside a transaction already it joins that one
+func (s *redisStore) inTx(fn func(tx *redisStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx := &redisStore{client: s.client, completedTTL: s.completedTTL, tx: &redisTx{entries: make(map[int]*Entry), deleted: make(map[int]int)}}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	return s.commit(tx.tx)
+}
+
+func (s *redisStore) commit(tx *redisTx) error {
+	commands := [][]string{{"MULTI"}}
+	if tx.replaced {
+		// everything is deleted first, the IDs and lists have to be read before MULTI because nothing inside it returns until EXEC
+		reply, err := s.client.Do("ZRANGE", redisAllKey, "0", "-1")
+		if err != nil {
+			return err
+		}
+		del := []string{"DEL", redisAllKey, redisListsKey}
+		ids, _ := reply.([]any)
+		for _, id := range ids {
+			del = append(del, redisPrefix+"entry:"+redisString(id))
+		}
+		reply, err = s.client.Do("SMEMBERS", redisListsKey)
+		if err != nil {
+			return err
+		}
+		lists, _ := reply.([]any)
+		for _, listID := range lists {
+			del = append(del, redisPrefix+"list:"+redisString(listID))
+		}
+		commands = append(commands, del)
+
+		// the counter is never moved back so IDs given out before the restore aren't reused
+		reply, err = s.client.Do("GET", redisNextIDKey)
+		if err != nil {
+			return err
+		}
+		next, _ := strconv.Atoi(redisString(reply))
+		commands = append(commands, []string{"SET", redisNextIDKey, strconv.Itoa(max(next, tx.highest))})
+	}
+	for _, id := range slices.Sorted(maps.Keys(tx.entries)) {
+		entry := tx.entries[id]
+		if entry == nil {
+			continue
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		set := []string{"SET", redisEntryKey(id), string(data)}
+		if entry.Completed && s.completedTTL > 0 {
+			set = append(set, "PX", strconv.FormatInt(s.completedTTL.Milliseconds(), 10))
+		}
+		score := strconv.Itoa(id)
+		commands = append(commands,
+			set,
+			[]string{"ZADD", redisListKey(entry.ListID), score, score},
+			[]string{"ZADD", redisAllKey, score, score},
+			[]string{"SADD", redisListsKey, strconv.Itoa(entry.ListID)},
+		)
+	}
+	for id, listID := range tx.deleted {
+		if tx.entries[id] != nil || tx.replaced {
+			continue
+		}
+		commands = append(commands,
+			[]string{"DEL", redisEntryKey(id)},
+			[]string{"ZREM", redisListKey(listID), strconv.Itoa(id)},
+			[]string{"ZREM", redisAllKey, strconv.Itoa(id)},
+		)
+	}
+	if len(commands) == 1 {
+		return nil
+	}
+	commands = append(commands, []string{"EXEC"})
+
+	replies, err := s.client.Pipeline(commands)
+	if err != nil {
+		return err
+	}
+	// a command that was wrong is reported when it is queued, one that fails when it runs is in the EXEC reply
+	for _, reply := range replies {
+		if err, ok := reply.(redisError); ok {
+			return err
+		}
+	}
+	results, _ := replies[len(replies)-1].([]any)
+	for _, result := range results {
+		if err, ok := result.(redisError); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) AppendAudit(records []AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	rpush := []string{"RPUSH", redisAuditKey}
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		rpush = append(rpush, string(data))
+	}
+	_, err := s.client.Do(rpush...)
+	return err
+}
+
+// There is no index on the audit log so every record is read, like the JSON store does
+func (s *redisStore) Audit(q auditQuery) ([]AuditRecord, error) {
+	reply, err := s.client.Do("LRANGE", redisAuditKey, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]any)
+	records := []AuditRecord{}
+	for _, value := range values {
+		data, _ := value.([]byte)
+		var record AuditRecord
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			return nil, err
+		}
+		if q.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *redisStore) LoadDoc(name string, v any) error {
+	reply, err := s.client.Do("GET", redisDocKey(name))
+	if err != nil {
+		return err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *redisStore) SaveDoc(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("SET", redisDocKey(name), string(data))
+	return err
+}
+
+// Redis persists on its own schedule, how much survives a crash is down to its appendfsync setting
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// Returns a bulk or simple string reply as a string, e.g. an ID from a sorted set
+func redisString(reply any) string {
+	switch v := reply.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return ""
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// sqliteDriver is the database/sql driver name registered by sqlite_driver.go
+const sqliteDriver = "sqlite3"
+
+// sqliteStore keeps entries in a SQLite database so a change only touches one row instead of rewriting the whole list
+// each row holds the entry as a JSON document, that way adding a field to Entry doesn't need a schema change
+type sqliteStore struct {
+	db *sql.DB
+	// tx is set on the Store handed to a Transaction's fn, every statement then runs inside it
+	tx *sql.Tx
+}
+
+// sqlConn is what *sql.DB and *sql.Tx have in common so the same queries work in and out of a transaction
+type sqlConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *sqliteStore) conn() sqlConn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	// the driver is only linked in when building with -tags sqlite because it needs cgo
+	if !slices.Contains(sql.Drivers(), sqliteDriver) {
+		return nil, errors.New("sqlite storage is not available in this build, rebuild with -tags sqlite")
+	}
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time so a single connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	err = createSQLiteSchema(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// Creates the tables on a new database and brings an older one up to date
+func createSQLiteSchema(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating entries table: %w", err)
+	}
+	// list_id is its own column rather than only being in data so a list can be fetched with an index
+	// databases made before lists existed don't have it, their entries end up in list 0 like the JSON store's
+	err = addColumnIfMissing(db, "entries", "list_id", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("adding list_id column: %w", err)
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS entries_list_id ON entries (list_id)")
+	if err != nil {
+		return fmt.Errorf("creating list_id index: %w", err)
+	}
+	// the time is kept as Unix nanoseconds so ranges compare as numbers, the record itself is JSON like the entries
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		list_id INTEGER NOT NULL,
+		entry_id INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating audit table: %w", err)
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS audit_list_time ON audit (list_id, time)")
+	if err != nil {
+		return fmt.Errorf("creating audit index: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS docs (
+		name TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating docs table: %w", err)
+	}
+	return nil
+}
+
+// SQLite has no ADD COLUMN IF NOT EXISTS so the existing columns are looked up first
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+func (s *sqliteStore) List(listID int) ([]Entry, error) {
+	rows, err := s.conn().Query("SELECT id, list_id, data FROM entries WHERE list_id = ? ORDER BY id", listID)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (s *sqliteStore) All() ([]Entry, error) {
+	rows, err := s.conn().Query("SELECT id, list_id, data FROM entries ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (s *sqliteStore) Get(listID int, id int) (Entry, error) {
+	row := s.conn().QueryRow("SELECT id, list_id, data FROM entries WHERE id = ? AND list_id = ?", id, listID)
+	entry, err := scanEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s *sqliteStore) Add(listID int, newEntries []Entry) ([]Entry, error) {
+	// a transaction means either every entry in the POST is stored or none are
+	err := s.inTx(func(tx *sqliteStore) error {
+		for i := range newEntries {
+			newEntries[i].ListID = listID
+			data, err := json.Marshal(newEntries[i])
+			if err != nil {
+				return err
+			}
+			result, err := tx.conn().Exec("INSERT INTO entries (list_id, data) VALUES (?, ?)", listID, data)
+			if err != nil {
+				return err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			newEntries[i].ID = int(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEntries, nil
+}
+
+func (s *sqliteStore) Update(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	result, err := s.conn().Exec("UPDATE entries SET data = ? WHERE id = ? AND list_id = ?", data, entry.ID, entry.ListID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (s *sqliteStore) Delete(listID int, id int) error {
+	result, err := s.conn().Exec("DELETE FROM entries WHERE id = ? AND list_id = ?", id, listID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// AUTOINCREMENT never hands out an ID lower than the highest it has seen, so new entries still come after the restored ones
+func (s *sqliteStore) Replace(entries []Entry) error {
+	return s.inTx(func(tx *sqliteStore) error {
+		_, err := tx.conn().Exec("DELETE FROM entries")
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			_, err = tx.conn().Exec("INSERT INTO entries (id, list_id, data) VALUES (?, ?, ?)", entry.ID, entry.ListID, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) Transaction(fn func(tx Store) error) error {
+	return s.inTx(func(tx *sqliteStore) error {
+		return fn(tx)
+	})
+}
+
+// Runs fn with a store whose statements all go in one transaction that is committed if fn returns nil
+// inside a transaction already it joins that one, there is only one connection so starting a second would wait forever
+func (s *sqliteStore) inTx(fn func(tx *sqliteStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(&sqliteStore{db: s.db, tx: tx})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Count() (int, error) {
+	var count int
+	err := s.conn().QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) AppendAudit(records []AuditRecord) error {
+	return s.inTx(func(tx *sqliteStore) error {
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			_, err = tx.conn().Exec("INSERT INTO audit (time, list_id, entry_id, data) VALUES (?, ?, ?, ?)", record.Time.UnixNano(), record.ListID, record.EntryID, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) Audit(q auditQuery) ([]AuditRecord, error) {
+	query := "SELECT data FROM audit WHERE list_id = ?"
+	args := []any{q.ListID}
+	if q.EntryID != 0 {
+		query += " AND entry_id = ?"
+		args = append(args, q.EntryID)
+	}
+	if !q.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		query += " AND time < ?"
+		args = append(args, q.Until.UnixNano())
+	}
+	rows, err := s.conn().Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		var record AuditRecord
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqliteStore) LoadDoc(name string, v any) error {
+	var data []byte
+	err := s.conn().QueryRow("SELECT data FROM docs WHERE name = ?", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *sqliteStore) SaveDoc(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec("INSERT INTO docs (name, data) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET data = excluded.data", name, data)
+	return err
+}
+
+// Compact rebuilds the database file with VACUUM, giving back the space deleted entries left behind
+func (s *sqliteStore) Compact() error {
+	_, err := s.db.Exec("VACUUM")
+	return err
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// scanner is the part of *sql.Row and *sql.Rows that scanEntry needs
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Decodes one id, list_id, data row into an Entry, the columns are the source of truth for the ID and list
+func scanEntry(row scanner) (Entry, error) {
+	var id, listID int
+	var data []byte
+	err := row.Scan(&id, &listID, &data)
+	if err != nil {
+		return Entry{}, err
+	}
+	var entry Entry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.ID = id
+	entry.ListID = listID
+	normalizeEntry(&entry)
+	return entry, nil
+}
+
+// Decodes every row of an id, list_id, data query and closes rows
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Turns an UPDATE or DELETE that matched nothing into ErrNotFound
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const maxStoreLength = 50
+
+// shopsDoc holds the shops each list's entries can be bought at, in the order they were added
+type shopsDoc struct {
+	Lists map[int][]string `json:"lists"`
+}
+
+// shopsDocName is the Store document the shops are saved under
+const shopsDocName = "stores"
+
+// shopRegistry holds every list's shops in memory and saves them to the Store on every change
+// it is called a shop registry to keep it apart from the Store the entries are kept in
+type shopRegistry struct {
+	store Store
+	doc   shopsDoc
+}
+
+var shops *shopRegistry
+
+func loadShops(store Store) (*shopRegistry, error) {
+	s := &shopRegistry{store: store}
+	err := store.LoadDoc(shopsDocName, &s.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if s.doc.Lists == nil {
+		s.doc.Lists = make(map[int][]string)
+	}
+	return s, nil
+}
+
+// For returns the list's shops
+func (s *shopRegistry) For(listID int) []string {
+	names := slices.Clone(s.doc.Lists[listID])
+	if names == nil {
+		names = []string{}
+	}
+	return names
+}
+
+// Set replaces the list's shops and saves them
+func (s *shopRegistry) Set(listID int, names []string) error {
+	if len(names) == 0 {
+		delete(s.doc.Lists, listID)
+	} else {
+		s.doc.Lists[listID] = names
+	}
+	return s.store.SaveDoc(shopsDocName, s.doc)
+}
+
+// Shop names keep the case they were typed in but "ALDI" and "aldi" are the same shop
+func sameStore(a string, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// storeCount is one shop in the GET /stores response
+type storeCount struct {
+	Name string `json:"name"`
+	// Entries is how many entries still to get are for this shop
+	Entries int `json:"entries"`
+}
+
+// Handle Get request for the list's shops and how many entries are still to be bought at each
+// shops that entries name but that were never added with POST /stores are included too
+func handleGetStores(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	listID := requestIdentity(r).ListID
+	entries, err := store.List(listID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	result := []storeCount{}
+	for _, name := range shops.For(listID) {
+		result = append(result, storeCount{Name: name})
+	}
+	for _, entry := range liveEntries(entries) {
+		if entry.Store == "" || entry.Completed {
+			continue
+		}
+		i := slices.IndexFunc(result, func(s storeCount) bool { return sameStore(s.Name, entry.Store) })
+		if i < 0 {
+			i = len(result)
+			result = append(result, storeCount{Name: entry.Store})
+		}
+		result[i].Entries++
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Handle Post request to add a shop to the list
+func handlePostStore(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	name := strings.Join(strings.Fields(body.Name), " ")
+	if name == "" || len(name) > maxStoreLength {
+		writeError(w, http.StatusBadRequest, "invalid_store", "store names can't be empty or longer than 50 characters")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	names := shops.For(listID)
+	if slices.ContainsFunc(names, func(existing string) bool { return sameStore(existing, name) }) {
+		writeError(w, http.StatusConflict, "store_exists", "store "+name+" is already on the list")
+		return
+	}
+	names = append(names, name)
+	err = shops.Set(listID, names)
+	if err != nil {
+		slog.Error("Error saving stores", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, names)
+}
+
+// Handle Delete request to take a shop off the list, entries for it keep it and still show up with ?store=
+func handleDeleteStore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	names := shops.For(listID)
+	i := slices.IndexFunc(names, func(existing string) bool { return sameStore(existing, name) })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "store_not_found", "store not found")
+		return
+	}
+	err := shops.Set(listID, slices.Delete(names, i, i+1))
+	if err != nil {
+		slog.Error("Error saving stores", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
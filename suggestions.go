@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// defaultSuggestions is how many suggestions GET /suggestions returns when ?limit= isn't given
+const defaultSuggestions = 10
+
+// suggestion is an item that is usually bought by now and isn't on the list
+type suggestion struct {
+	Item string `json:"item"`
+	// Score is from 0 to 1, higher the more often the item is bought and the longer it is since it last was
+	Score       float64   `json:"score"`
+	Times       int       `json:"times"`
+	AverageDays float64   `json:"average_days"`
+	LastBought  time.Time `json:"last_bought"`
+	DaysSince   float64   `json:"days_since"`
+}
+
+// Scores the items in history that aren't on the list, the most likely to be needed first
+// an item has to have been bought twice to know how often it is needed, so items bought once aren't suggested
+// the score is how far through its usual interval an item is, up to 1, weighed down for items bought only a few times
+func suggest(listID int, history []itemStats, onList map[string]bool, now time.Time) []suggestion {
+	suggestions := []suggestion{}
+	for _, item := range history {
+		if item.AverageDays == nil || onList[itemKey(listID, item.Item)] {
+			continue
+		}
+		since := now.Sub(item.LastBought).Hours() / 24
+		due := min(since/max(*item.AverageDays, 1), 1)
+		score := math.Round(due*(1-1/float64(item.Times))*100) / 100
+		if score <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, suggestion{
+			Item:        item.Item,
+			Score:       score,
+			Times:       item.Times,
+			AverageDays: *item.AverageDays,
+			LastBought:  item.LastBought,
+			DaysSince:   math.Round(since*10) / 10,
+		})
+	}
+	slices.SortStableFunc(suggestions, func(a, b suggestion) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return suggestions
+}
+
+// Handle Get request for items that are usually bought by now and aren't on the list, from the list's closed trips
+// ?limit= is how many to return, 10 when it isn't given
+func handleGetSuggestions(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseNonNegative("limit", r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if r.URL.Query().Get("limit") == "" {
+		limit = defaultSuggestions
+	}
+
+	listID := requestIdentity(r).ListID
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries, err := store.List(listID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	// anything on the list counts, an item that is ticked off but still there has just been bought
+	onList := make(map[string]bool)
+	for _, entry := range liveEntries(entries) {
+		onList[itemKey(listID, entry.Item)] = true
+	}
+	suggestions := suggest(listID, purchaseHistory(trips.WithEntries(listID)), onList, time.Now().UTC())
+	writeJSON(w, http.StatusOK, suggestions[:min(limit, len(suggestions))])
+}
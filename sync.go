@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The change feed is the list's audit log read in order, a cursor is how many of its records the client has seen
+// so it stays valid across restarts and works the same on every storage backend
+
+// The most characters a client_id can have, UUIDs are the usual choice and are far shorter
+const maxClientIDLength = 255
+
+// How POST /sync settles a mutation made to an older revision of an entry than the stored one
+const (
+	// ResolutionLastWriterWins keeps whichever change was made last, going by the mutation's changed_at
+	ResolutionLastWriterWins = "last-writer-wins"
+	// ResolutionMerge takes the fields the client changed that nobody else has changed since, the stored value wins the rest
+	ResolutionMerge = "merge"
+	// ResolutionKeepBoth merges when none of the fields conflict and otherwise leaves the stored entry as it is and
+	// keeps the client's version as a Conflict for someone to resolve
+	ResolutionKeepBoth = "keep-both"
+)
+
+// errCursorReset is returned for a cursor from further along the feed than it now goes, e.g. after a backup was restored
+var errCursorReset = errors.New("the change feed has been reset since this cursor was handed out, load the list again and start from the cursor GET /changes gives without since")
+
+// feedChange is one change in the feed, the entry as it was left rather than what was done to it
+type feedChange struct {
+	// Cursor is where the feed carries on from after this change
+	Cursor    string    `json:"cursor"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	EntryID   int       `json:"entry_id"`
+	// Deleted is true when the entry was moved to the trash or removed for good, the client should drop its copy
+	Deleted bool `json:"deleted"`
+	// Entry is null when the entry was removed for good
+	Entry *Entry `json:"entry"`
+}
+
+type changeFeed struct {
+	Changes []feedChange `json:"changes"`
+	// Cursor is the since to ask with next time
+	Cursor string `json:"cursor"`
+	// More is true when limit cut the feed short, the rest comes from asking again with Cursor
+	More bool `json:"more"`
+}
+
+// syncRequest is the body of POST /sync
+type syncRequest struct {
+	// Since is the cursor from the client's last sync, the response has the changes made since then when it is set
+	Since *string `json:"since"`
+	// Resolution is ResolutionLastWriterWins, the default, ResolutionMerge or ResolutionKeepBoth, a list's strategy takes its place
+	Resolution string         `json:"resolution"`
+	Mutations  []syncMutation `json:"mutations"`
+}
+
+// syncMutation is a change the client made while offline, the entry fields sit next to the action as in POST /data/bulk
+type syncMutation struct {
+	// Action is "add", "update" or "delete"
+	Action string `json:"action"`
+	// ID is the server's ID for the entry, one the client added offline can be named by its ClientID instead
+	ID int `json:"id"`
+	// ClientID is the client's own ID for the entry, it is required to add one so a retried sync doesn't add it twice
+	ClientID string `json:"client_id"`
+	// ChangedAt is when the client made the change, it is now when it isn't sent
+	ChangedAt *time.Time `json:"changed_at"`
+	EntryPatch
+}
+
+// syncResult says what happened to one mutation, in the same order as the request
+type syncResult struct {
+	Action   string `json:"action"`
+	ID       int    `json:"id"`
+	ClientID string `json:"client_id,omitempty"`
+	// Status is "applied", "merged" when some fields had been changed by someone else but none the client changed,
+	// "conflict" when the stored entry kept its own value for some or all of the change, or "not_found"
+	Status string `json:"status"`
+	// Conflicts are the fields the stored entry kept its own value for
+	Conflicts []string `json:"conflicts,omitempty"`
+	// ConflictID is the Conflict the client's version was kept as, under keep-both
+	ConflictID int `json:"conflict_id,omitempty"`
+	// Entry is the entry as it is stored now, which the client should take in place of its own copy
+	Entry *Entry `json:"entry,omitempty"`
+}
+
+type syncResponse struct {
+	Results []syncResult `json:"results"`
+	changeFeed
+}
+
+// Handle Get request for the changes made to the list since ?since=, oldest first
+// without since there are no changes, only the cursor to start from, so a new client gets that and then GET /data
+// ?limit= pages through a long feed
+func handleGetChanges(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	limit, err := parseNonNegative("limit", values.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	mu.RLock()
+	records, err := store.Audit(auditQuery{ListID: requestIdentity(r).ListID})
+	mu.RUnlock()
+	if err != nil {
+		slog.Error("Error reading audit log", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	from := len(records)
+	if values.Has("since") {
+		from, err = parseCursor(values.Get("since"), len(records))
+		if err != nil {
+			writeCursorError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, buildFeed(records, from, limit))
+}
+
+// Handle Post request with the changes a client made while offline, they are applied together in one transaction
+// unlike POST /data/bulk a conflict doesn't stop the rest, it is settled by the request's resolution and reported in the result
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Resolution == "" {
+		req.Resolution = ResolutionLastWriterWins
+	}
+	if !validResolution(req.Resolution) {
+		writeError(w, http.StatusBadRequest, "invalid_resolution", "resolution has to be "+ResolutionLastWriterWins+", "+ResolutionMerge+" or "+ResolutionKeepBoth)
+		return
+	}
+	for i, m := range req.Mutations {
+		message := ""
+		switch m.Action {
+		case "add":
+			if m.ClientID == "" {
+				message = "client_id is required to add an entry"
+			} else if m.Item == nil {
+				message = "item is required to add an entry"
+			}
+		case "update", "delete":
+			if m.ID == 0 && m.ClientID == "" {
+				message = "id or client_id is required to " + m.Action + " an entry"
+			}
+		default:
+			message = "action has to be add, update or delete"
+		}
+		if len(m.ClientID) > maxClientIDLength {
+			message = "client_id can be at most " + strconv.Itoa(maxClientIDLength) + " characters"
+		}
+		if problem := patchProblem(m.EntryPatch); problem != "" {
+			message = problem
+		}
+		if message != "" {
+			writeError(w, http.StatusBadRequest, "invalid_mutation", fmt.Sprintf("mutation %d: %s", i, message))
+			return
+		}
+	}
+
+	id := requestIdentity(r)
+	results := make([]syncResult, 0, len(req.Mutations))
+	// the events are kept back until the transaction has been committed
+	type pendingEvent struct {
+		eventType string
+		entry     Entry
+	}
+	var events []pendingEvent
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the cursor is checked before anything is changed so a bad one doesn't leave the client not knowing what happened
+	records, err := store.Audit(auditQuery{ListID: id.ListID})
+	if err != nil {
+		slog.Error("Error reading audit log", "err", err)
+		writeInternalError(w)
+		return
+	}
+	from := -1
+	if req.Since != nil {
+		from, err = parseCursor(*req.Since, len(records))
+		if err != nil {
+			writeCursorError(w, err)
+			return
+		}
+	}
+
+	// a list that has picked a strategy uses it whatever the client asks for
+	resolution := req.Resolution
+	if strategy := conflicts.Strategy(id.ListID); strategy != "" {
+		resolution = strategy
+	}
+	var s *syncer
+	now := time.Now().UTC()
+	err = trackChange(r, "sync", func(tx Store) error {
+		s, err = newSyncer(tx, id.ListID, resolution, id.Actor(), now)
+		if err != nil {
+			return err
+		}
+		for _, m := range req.Mutations {
+			result, eventType, err := s.apply(m)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+			s.count++
+			if eventType != "" {
+				events = append(events, pendingEvent{eventType, *result.Entry})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error applying sync", "err", err)
+		writeInternalError(w)
+		return
+	}
+	for _, e := range events {
+		publishEntries(id.Actor(), e.eventType, e.entry)
+	}
+	pending := make([]Conflict, len(s.conflicts))
+	for i, p := range s.conflicts {
+		pending[i] = p.conflict
+	}
+	added, err := conflicts.Add(pending)
+	if err != nil {
+		slog.Error("Error saving conflicts", "err", err)
+		writeInternalError(w)
+		return
+	}
+	for i, conflict := range added {
+		results[s.conflicts[i].result].ConflictID = conflict.ID
+	}
+
+	// the feed includes the client's own changes, which it can tell apart by their revision
+	records, err = store.Audit(auditQuery{ListID: id.ListID})
+	if err != nil {
+		slog.Error("Error reading audit log", "err", err)
+		writeInternalError(w)
+		return
+	}
+	if from < 0 {
+		from = len(records)
+	}
+	writeJSON(w, http.StatusOK, syncResponse{Results: results, changeFeed: buildFeed(records, from, 0)})
+}
+
+// Returns where in the audit log a cursor points
+func parseCursor(cursor string, length int) (int, error) {
+	n, err := strconv.Atoi(cursor)
+	if err != nil || n < 0 {
+		return 0, errors.New("since has to be a cursor from an earlier response")
+	}
+	if n > length {
+		return 0, errCursorReset
+	}
+	return n, nil
+}
+
+func writeCursorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCursorReset) {
+		writeError(w, http.StatusGone, "cursor_reset", err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+}
+
+// Returns the changes from the from'th audit record on, at most limit of them if limit isn't 0
+func buildFeed(records []AuditRecord, from int, limit int) changeFeed {
+	feed := changeFeed{Changes: []feedChange{}}
+	end := len(records)
+	if limit > 0 && from+limit < end {
+		end = from + limit
+		feed.More = true
+	}
+	for i, record := range records[from:end] {
+		feed.Changes = append(feed.Changes, feedChange{
+			Cursor:    strconv.Itoa(from + i + 1),
+			Time:      record.Time,
+			Operation: record.Operation,
+			EntryID:   record.EntryID,
+			Deleted:   record.After == nil || record.After.DeletedAt != nil,
+			Entry:     record.After,
+		})
+	}
+	feed.Cursor = strconv.Itoa(end)
+	return feed
+}
+
+// syncer applies the mutations of one POST /sync inside its transaction
+type syncer struct {
+	tx         Store
+	listID     int
+	resolution string
+	now        time.Time
+	// clientIDs are the server IDs of the list's entries that were added with a client_id, trash included
+	clientIDs map[string]int
+	actor     string
+	// count is how many mutations have been applied so far
+	count int
+	// conflicts are the ones keep-both noted, they are only saved once the transaction has been committed
+	conflicts []pendingConflict
+}
+
+// pendingConflict is a conflict that hasn't been saved yet and the index of the result it goes with
+type pendingConflict struct {
+	result   int
+	conflict Conflict
+}
+
+func newSyncer(tx Store, listID int, resolution string, actor string, now time.Time) (*syncer, error) {
+	entries, err := tx.List(listID)
+	if err != nil {
+		return nil, err
+	}
+	s := &syncer{tx: tx, listID: listID, resolution: resolution, actor: actor, now: now, clientIDs: make(map[string]int)}
+	for _, entry := range entries {
+		if entry.ClientID != "" {
+			s.clientIDs[entry.ClientID] = entry.ID
+		}
+	}
+	return s, nil
+}
+
+// Applies one mutation and returns its result and the event it should send, if any
+func (s *syncer) apply(m syncMutation) (syncResult, string, error) {
+	result := syncResult{Action: m.Action, ID: m.ID, ClientID: m.ClientID}
+	if result.ID == 0 {
+		result.ID = s.clientIDs[m.ClientID]
+	}
+
+	if m.Action == "add" {
+		// an entry that is already there came from an earlier try at the same sync
+		if result.ID != 0 {
+			return s.found(result, "applied", nil)
+		}
+		entry := Entry{ClientID: m.ClientID}
+		applyPatch(&entry, m.EntryPatch)
+		prepareNewEntry(&entry, s.now)
+		entries := []Entry{entry}
+		canonicalItems(s.listID, entries)
+		created, err := s.tx.Add(s.listID, entries)
+		if err != nil {
+			return result, "", err
+		}
+		s.clientIDs[m.ClientID] = created[0].ID
+		result.ID = created[0].ID
+		result.Status = "applied"
+		result.Entry = &created[0]
+		return result, EventCreated, nil
+	}
+
+	entry, err := s.tx.Get(s.listID, result.ID)
+	if errors.Is(err, ErrNotFound) {
+		result.Status = "not_found"
+		return result, "", nil
+	}
+	if err != nil {
+		return result, "", err
+	}
+	if m.Action == "delete" && entry.DeletedAt != nil {
+		return s.found(result, "applied", nil)
+	}
+
+	// an update to an entry in the trash is a conflict whatever revision it was made to, someone deleted it since
+	stale := entry.DeletedAt != nil || (m.Revision != nil && *m.Revision != entry.Revision)
+	if !stale {
+		return s.write(result, "applied", entry, m)
+	}
+
+	records, err := s.tx.Audit(auditQuery{ListID: s.listID, EntryID: entry.ID})
+	if err != nil {
+		return result, "", err
+	}
+	if s.resolution == ResolutionLastWriterWins {
+		changedAt := s.now
+		if m.ChangedAt != nil {
+			changedAt = *m.ChangedAt
+		}
+		if len(records) > 0 && changedAt.Before(records[len(records)-1].Time) {
+			return s.found(result, "conflict", slices.Sorted(maps.Keys(patchFields(m.EntryPatch))))
+		}
+		return s.write(result, "applied", entry, m)
+	}
+
+	// a deletion and an entry someone has changed since can't be merged
+	if m.Action == "delete" || entry.DeletedAt != nil {
+		if s.resolution == ResolutionKeepBoth {
+			return s.keepBoth(result, entry, m, slices.Sorted(maps.Keys(patchFields(m.EntryPatch))))
+		}
+		return s.found(result, "conflict", slices.Sorted(maps.Keys(patchFields(m.EntryPatch))))
+	}
+	var base *Entry
+	for _, record := range slices.Backward(records) {
+		if record.After != nil && m.Revision != nil && record.After.Revision == *m.Revision {
+			base = record.After
+			break
+		}
+	}
+	merged, fields := mergePatch(base, entry, m.EntryPatch)
+	// keep-both only merges when nothing conflicts, otherwise the client's whole change is kept for later
+	if s.resolution == ResolutionKeepBoth && len(fields) > 0 {
+		return s.keepBoth(result, entry, m, fields)
+	}
+	status := "merged"
+	if len(fields) > 0 {
+		status = "conflict"
+	}
+	if reflect.DeepEqual(merged, entry) {
+		return s.found(result, status, fields)
+	}
+	merged.Revision++
+	err = s.tx.Update(merged)
+	if err != nil {
+		return result, "", err
+	}
+	result.Status = status
+	result.Conflicts = fields
+	result.Entry = &merged
+	return result, updateEvent(entry, merged), nil
+}
+
+// Leaves the stored entry as it is and notes the client's version as a conflict to be resolved by hand
+func (s *syncer) keepBoth(result syncResult, entry Entry, m syncMutation, fields []string) (syncResult, string, error) {
+	conflict := Conflict{ListID: s.listID, EntryID: entry.ID, Time: s.now, Actor: s.actor, Action: m.Action, Fields: fields}
+	if m.Action == "update" {
+		client := entry
+		client.DeletedAt = nil
+		applyPatch(&client, m.EntryPatch)
+		normalizeEntry(&client)
+		conflict.Client = &client
+	}
+	if conflict.Fields == nil {
+		conflict.Fields = []string{}
+	}
+	s.conflicts = append(s.conflicts, pendingConflict{result: s.count, conflict: conflict})
+	return s.found(result, "conflict", fields)
+}
+
+// Makes the change to the stored entry, an update to one in the trash takes it back out
+func (s *syncer) write(result syncResult, status string, entry Entry, m syncMutation) (syncResult, string, error) {
+	if m.Action == "delete" {
+		deleted, err := softDelete(s.tx, s.listID, entry.ID, s.now)
+		if err != nil {
+			return result, "", err
+		}
+		result.Status = status
+		result.Entry = &deleted
+		return result, EventDeleted, nil
+	}
+	before := entry
+	entry.DeletedAt = nil
+	applyPatch(&entry, m.EntryPatch)
+	entry.Revision++
+	err := s.tx.Update(entry)
+	if err != nil {
+		return result, "", err
+	}
+	result.Status = status
+	result.Entry = &entry
+	return result, updateEvent(before, entry), nil
+}
+
+// Reports the mutation as having left the stored entry as it is
+func (s *syncer) found(result syncResult, status string, conflicts []string) (syncResult, string, error) {
+	entry, err := s.tx.Get(s.listID, result.ID)
+	if err != nil {
+		return result, "", err
+	}
+	result.Status = status
+	result.Conflicts = conflicts
+	result.Entry = &entry
+	return result, "", nil
+}
+
+// Returns the event for an entry changing from before to after
+func updateEvent(before Entry, after Entry) string {
+	// clients dropped the entry when it was deleted, so to them it is a new one
+	if before.DeletedAt != nil {
+		return EventCreated
+	}
+	if after.Completed && !before.Completed {
+		return EventCompleted
+	}
+	return EventUpdated
+}
+
+// Merges a patch made to base into current, the entry as it is stored now, and returns it with the fields that weren't merged
+// a field takes the patch's value if it is the same in base and current, i.e. nobody else has changed it, or if both
+// changed it to the same thing, when base isn't known every field that differs is a conflict
+func mergePatch(base *Entry, current Entry, patch EntryPatch) (Entry, []string) {
+	merged := current
+	currentFields := entryFields(current)
+	var baseFields map[string]json.RawMessage
+	if base != nil {
+		baseFields = entryFields(*base)
+	}
+	var conflicts []string
+	fields := patchFields(patch)
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if base != nil && bytes.Equal(baseFields[name], currentFields[name]) {
+			applyPatch(&merged, fields[name])
+			continue
+		}
+		wanted := current
+		applyPatch(&wanted, fields[name])
+		normalizeEntry(&wanted)
+		if !bytes.Equal(entryFields(wanted)[name], currentFields[name]) {
+			conflicts = append(conflicts, name)
+		}
+	}
+	return merged, conflicts
+}
+
+// Returns the entry's fields as JSON by their names, so they can be compared one at a time
+func entryFields(entry Entry) map[string]json.RawMessage {
+	data, _ := json.Marshal(entry)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// Splits a patch into one patch per field that was sent, by the field's JSON name, the revision isn't a field
+func patchFields(patch EntryPatch) map[string]EntryPatch {
+	fields := make(map[string]EntryPatch)
+	v := reflect.ValueOf(patch)
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		field := v.Field(i)
+		sent := false
+		switch value := field.Interface().(type) {
+		case optionalTime:
+			sent = value.Set
+		default:
+			sent = !field.IsNil()
+		}
+		if name == "revision" || !sent {
+			continue
+		}
+		var single EntryPatch
+		reflect.ValueOf(&single).Elem().Field(i).Set(field)
+		fields[name] = single
+	}
+	return fields
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	maxTagLength    = 32
+	maxTagsPerEntry = 20
+)
+
+// Lowercases and trims each tag, dropping empty ones and repeats, so "Urgent" and " urgent" are the same tag
+// the result is never nil so entries always have "tags": [] rather than null
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeItemName(tag)
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// Returns what is wrong with a set of tags sent by a client, or "" if nothing is
+func tagsProblem(tags []string) string {
+	if len(tags) > maxTagsPerEntry {
+		return "an entry can have at most " + strconv.Itoa(maxTagsPerEntry) + " tags"
+	}
+	for _, tag := range tags {
+		if len(normalizeItemName(tag)) > maxTagLength {
+			return "tags can be at most " + strconv.Itoa(maxTagLength) + " characters"
+		}
+	}
+	return ""
+}
+
+// Reports whether the entry has every one of tags
+func hasTags(entry Entry, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(entry.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagCount is one tag in the GET /tags response
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Handle Get request for every tag used on the list with how many entries have it, most used first
+// entries in the trash aren't counted
+func handleGetTags(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries, err := store.List(requestIdentity(r).ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	counts := make(map[string]int)
+	for _, entry := range liveEntries(entries) {
+		for _, tag := range entry.Tags {
+			counts[tag]++
+		}
+	}
+	tags := []tagCount{}
+	for tag, count := range counts {
+		tags = append(tags, tagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(tags, func(a, b tagCount) int {
+		return cmp.Or(b.Count-a.Count, cmp.Compare(a.Tag, b.Tag))
+	})
+	writeJSON(w, http.StatusOK, tags)
+}
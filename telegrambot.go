@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a getUpdates call waits for a message before returning empty, Telegram allows up to 50 seconds
+const telegramPollTimeout = 30 * time.Second
+
+// telegramBot long-polls the Telegram Bot API and works on one list for the chats it is allowed to talk to
+// https://core.telegram.org/bots/api#getupdates
+type telegramBot struct {
+	client *http.Client
+	api    string
+	token  string
+	listID int
+	chats  []int64
+}
+
+// telegramUpdate is the part of a getUpdates result the bot uses
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From *struct {
+			Username  string `json:"username"`
+			FirstName string `json:"first_name"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+// Returns nil when no bot token is configured
+func newTelegramBot(cfg Config) (*telegramBot, error) {
+	if cfg.TelegramToken == "" {
+		return nil, nil
+	}
+	var chats []int64
+	for _, s := range strings.Split(cfg.TelegramChats, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		chat, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat ID %q in -telegram-chats", s)
+		}
+		chats = append(chats, chat)
+	}
+	// anyone can message a bot, so without this anyone who found it could change the list
+	if len(chats) == 0 {
+		return nil, errors.New("-telegram-chats has to be set when -telegram-token is")
+	}
+	return &telegramBot{
+		client: &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+		api:    strings.TrimSuffix(cfg.TelegramAPI, "/"),
+		token:  cfg.TelegramToken,
+		listID: cfg.TelegramList,
+		chats:  chats,
+	}, nil
+}
+
+// Polls for messages and answers them until ctx is cancelled
+func (b *telegramBot) run(ctx context.Context) {
+	slog.Info("Telegram bot started", "list_id", b.listID, "chats", len(b.chats))
+	var offset int64
+	for {
+		updates, err := b.getUpdates(ctx, offset)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error polling Telegram", "err", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, update := range updates {
+			// confirming an update is done by asking for the ones after it
+			offset = update.UpdateID + 1
+			msg := update.Message
+			if msg == nil || !strings.HasPrefix(msg.Text, "/") {
+				continue
+			}
+			if !slices.Contains(b.chats, msg.Chat.ID) {
+				slog.Warn("Ignoring Telegram message from a chat that isn't allowed", "chat", msg.Chat.ID)
+				continue
+			}
+			actor := "telegram"
+			if msg.From != nil {
+				actor = "telegram:" + cmp.Or(msg.From.Username, msg.From.FirstName)
+			}
+			reply := b.handle(actor, msg.Text)
+			err := b.send(ctx, msg.Chat.ID, reply)
+			if err != nil {
+				slog.Error("Error replying on Telegram", "chat", msg.Chat.ID, "err", err)
+			}
+		}
+	}
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	query := url.Values{
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"timeout":         {strconv.Itoa(int(telegramPollTimeout.Seconds()))},
+		"allowed_updates": {`["message"]`},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.api+"/bot"+b.token+"/getUpdates?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		OK          bool             `json:"ok"`
+		Description string           `json:"description"`
+		Result      []telegramUpdate `json:"result"`
+	}
+	err = b.call(req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if !body.OK {
+		return nil, fmt.Errorf("telegram: %s", body.Description)
+	}
+	return body.Result, nil
+}
+
+func (b *telegramBot) send(ctx context.Context, chatID int64, text string) error {
+	form := url.Values{"chat_id": {strconv.FormatInt(chatID, 10)}, "text": {text}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.api+"/bot"+b.token+"/sendMessage", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return b.call(req, nil)
+}
+
+// Makes a Bot API call and decodes the response into v if it isn't nil
+// the URL is left out of errors because it has the bot token in it
+func (b *telegramBot) call(req *http.Request, v any) error {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telegram returned %s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Runs a command and returns the reply, in groups commands can be addressed to a bot as /add@shoppingbot
+func (b *telegramBot) handle(actor string, text string) string {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	command, _, _ = strings.Cut(command, "@")
+	return runChatCommand(b.listID, actor, "/", strings.TrimPrefix(command, "/"), args)
+}
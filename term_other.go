@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var resizeSignals []os.Signal
+
+var errNoTerminal = errors.New("the TUI needs a Unix terminal, use the other commands or the web UI instead")
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// resizeSignals are sent when the terminal changes size, so the TUI can redraw
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
+
+// Puts the terminal into raw mode so keys arrive one at a time without being echoed, and returns a function that puts it back
+// it is the same as cfmakeraw, including leaving Ctrl-C to the program rather than the terminal
+func makeRaw(fd int) (func() error, error) {
+	var old syscall.Termios
+	err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&old))
+	if err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&old)) }, nil
+}
+
+// Returns the terminal's width and height in characters
+func terminalSize(fd int) (int, int, error) {
+	var size struct{ rows, cols, xpixel, ypixel uint16 }
+	err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
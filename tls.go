@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+// certbot renews well before expiry so checking once a minute is plenty
+const certCheckInterval = time.Minute
+
+// certReloader hands out the current TLS certificate and swaps in a new one when the files on disk change
+// this lets renewed Let's Encrypt certificates be picked up without restarting the server
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	// mu guards cert and modTime, TLS handshakes read the certificate while watch replaces it
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Loads the certificate and key, failing straight away if they can't be used so a typo in a path is caught at startup
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate so every new connection gets whichever certificate is current
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Checks the files every interval until ctx is cancelled and reloads them when either has been modified
+// if the new files can't be loaded (e.g. the key has been written but not the certificate yet) the old certificate keeps being used and the next check tries again
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			slog.Error("Error checking TLS certificate", "err", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		err = r.reload()
+		if err != nil {
+			slog.Error("Error reloading TLS certificate, still using the old one", "err", err)
+			continue
+		}
+		slog.Info("Reloaded TLS certificate", "cert", r.certFile)
+	}
+}
+
+func (r *certReloader) reload() error {
+	// the modification time is read before the files so a change made while loading is noticed on the next check
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// Returns whichever of the certificate and key files was modified most recently
+func (r *certReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
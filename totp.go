@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Two-factor authentication with TOTP (RFC 6238), the 6 digit codes authenticator apps show every 30 seconds
+// a user turns it on with POST /me/2fa, which gives them the secret as an otpauth:// URI and a QR code to scan, and then sends a code to POST /me/2fa/confirm
+// from then on POST /login needs a code as well as the password, or one of the recovery codes they were given when it was confirmed
+// Basic auth stays password only as calendar apps can't send a code, it only reaches the read-only feeds
+
+const (
+	totpStep   = 30
+	totpDigits = 6
+	// totpSkew is how many steps either side of now a code is still taken, for clocks that are a little out
+	totpSkew = 1
+	// totpIssuer is the name authenticator apps show the code under
+	totpIssuer        = "Shopping List"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPRequired   = errors.New("a two-factor code is required")
+	ErrBadTOTP        = errors.New("wrong two-factor code")
+	ErrTOTPEnabled    = errors.New("two-factor authentication is already on")
+	ErrTOTPNotStarted = errors.New("two-factor authentication hasn't been set up")
+)
+
+// requireAdmin2FA is -require-admin-2fa, an admin without two-factor authentication is treated as a user until they turn it on
+var requireAdmin2FA bool
+
+// totpSettings is a user's two-factor authentication
+type totpSettings struct {
+	// Secret is base32 as it goes in the otpauth:// URI, it has to be kept as it is to check codes against
+	Secret string `json:"secret"`
+	// Enabled is false between POST /me/2fa and the first code being confirmed, login doesn't ask for a code until then
+	Enabled bool `json:"enabled"`
+	// LastStep is the time step of the last code used, a code can't be used twice so one seen over someone's shoulder is no good
+	LastStep int64 `json:"last_step"`
+	// RecoveryCodes are hashes of the recovery codes not used yet, each works once in place of a code
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactor reports whether the user has to send a code to log in
+func (u User) TwoFactor() bool {
+	return u.TOTP != nil && u.TOTP.Enabled
+}
+
+// Returns the code for a time step, HMAC-SHA1 truncated to totpDigits as in RFC 4226
+func totpCode(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	code := strconv.Itoa(int(n % 1000000))
+	return strings.Repeat("0", totpDigits-len(code)) + code
+}
+
+// Returns the time step code is for if it is right at now, it has to be after lastStep so a code can't be used twice
+func checkTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpStep
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Makes a new random secret, 20 bytes as RFC 4226 recommends
+func newTOTPSecret() string {
+	key := make([]byte, 20)
+	rand.Read(key)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+}
+
+// The otpauth:// URI authenticator apps read from the QR code
+func totpURI(username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpStep))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + query.Encode()
+}
+
+// Makes a set of recovery codes, they are shown to the user once and only their hashes are kept
+// each is 10 characters from base32 split in two, about 50 bits, which is plenty for something that locks out after a few wrong tries
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		rand.Read(b)
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes
+}
+
+// Recovery codes are matched without their dash, spaces or case so they can be typed however they were written down
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// Checks code against the user's second factor in doc, using it up, callers must hold u.mu
+// code is a TOTP code or a recovery code, ErrTOTPRequired when it is empty and ErrBadTOTP when it is wrong
+func checkSecondFactor(doc *accountsDoc, userID int, code string) error {
+	index := slices.IndexFunc(doc.Users, func(user User) bool { return user.ID == userID })
+	if index == -1 || !doc.Users[index].TwoFactor() {
+		return nil
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrTOTPRequired
+	}
+	settings := *doc.Users[index].TOTP
+	if step, ok := checkTOTP(settings.Secret, code, settings.LastStep, time.Now()); ok {
+		settings.LastStep = step
+	} else {
+		hash := hashToken(normalizeRecoveryCode(code))
+		i := slices.Index(settings.RecoveryCodes, hash)
+		if i == -1 {
+			return ErrBadTOTP
+		}
+		settings.RecoveryCodes = slices.Delete(slices.Clone(settings.RecoveryCodes), i, i+1)
+		slog.Warn("Security event", "event", "recovery_code_used", "user", doc.Users[index].Username, "left", len(settings.RecoveryCodes))
+	}
+	doc.Users = append([]User{}, doc.Users...)
+	doc.Users[index].TOTP = &settings
+	return nil
+}
+
+// StartTOTP gives the user a new secret to set up in their authenticator app, two-factor stays off until ConfirmTOTP
+func (u *userRegistry) StartTOTP(userID int) (User, error) {
+	return u.changeTOTP(userID, func(user *User) error {
+		if user.TwoFactor() {
+			return ErrTOTPEnabled
+		}
+		user.TOTP = &totpSettings{Secret: newTOTPSecret(), RecoveryCodes: []string{}}
+		return nil
+	})
+}
+
+// ConfirmTOTP turns two-factor on once code shows the authenticator app has the secret and returns the recovery codes
+func (u *userRegistry) ConfirmTOTP(userID int, code string) ([]string, error) {
+	var codes []string
+	_, err := u.changeTOTP(userID, func(user *User) error {
+		if user.TOTP == nil {
+			return ErrTOTPNotStarted
+		}
+		if user.TOTP.Enabled {
+			return ErrTOTPEnabled
+		}
+		step, ok := checkTOTP(user.TOTP.Secret, strings.TrimSpace(code), 0, time.Now())
+		if !ok {
+			return ErrBadTOTP
+		}
+		var hashes []string
+		codes, hashes = newRecoveryCodes()
+		user.TOTP = &totpSettings{Secret: user.TOTP.Secret, Enabled: true, LastStep: step, RecoveryCodes: hashes}
+		return nil
+	})
+	return codes, err
+}
+
+// DisableTOTP turns two-factor off, when code isn't nil it has to be a code or recovery code first
+// admins pass nil to turn it off for a user who has lost their authenticator app and recovery codes
+func (u *userRegistry) DisableTOTP(userID int, code *string) (User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	doc := u.doc
+	if code != nil {
+		err := checkSecondFactor(&doc, userID, *code)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	index := slices.IndexFunc(doc.Users, func(user User) bool { return user.ID == userID })
+	if index == -1 {
+		return User{}, ErrUserNotFound
+	}
+	doc.Users = append([]User{}, doc.Users...)
+	doc.Users[index].TOTP = nil
+	err := u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return doc.Users[index], nil
+}
+
+// Runs change on a copy of the user and saves it if it returns nil
+func (u *userRegistry) changeTOTP(userID int, change func(user *User) error) (User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	index := slices.IndexFunc(u.doc.Users, func(user User) bool { return user.ID == userID })
+	if index == -1 {
+		return User{}, ErrUserNotFound
+	}
+	user := u.doc.Users[index]
+	err := change(&user)
+	if err != nil {
+		return User{}, err
+	}
+	doc := u.doc
+	doc.Users = append([]User{}, u.doc.Users...)
+	doc.Users[index] = user
+	err = u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// totpEnrollRequest is the body of POST /me/2fa
+type totpEnrollRequest struct {
+	Password string `json:"password"`
+}
+
+// totpEnrollResponse is what POST /me/2fa returns, the secret three ways for however the authenticator app takes it
+type totpEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	// QRCode is a PNG of the URI as a data: URL, so it can go straight into an <img>
+	QRCode string `json:"qr_code"`
+}
+
+// totpCodeRequest is the body of POST /me/2fa/confirm and DELETE /me/2fa, which also needs the password
+type totpCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password,omitempty"`
+}
+
+// recoveryCodesResponse is what POST /me/2fa/confirm returns, the only time the recovery codes are shown
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Handle Post request to start setting up two-factor authentication, a new secret replaces one that was never confirmed
+func handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpEnrollRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !confirmPassword(w, r, req.Password) {
+		return
+	}
+
+	user, err := users.StartTOTP(requestIdentity(r).User.ID)
+	if errors.Is(err, ErrTOTPEnabled) {
+		writeError(w, http.StatusConflict, "totp_enabled", "two-factor authentication is already on, turn it off first to set it up again")
+		return
+	}
+	if err != nil {
+		slog.Error("Error starting two-factor setup", "err", err)
+		writeInternalError(w)
+		return
+	}
+	uri := totpURI(user.Username, user.TOTP.Secret)
+	code, err := encodeQR([]byte(uri))
+	if err != nil {
+		slog.Error("Error making QR code", "err", err)
+		writeInternalError(w)
+		return
+	}
+	picture, err := code.PNG(8)
+	if err != nil {
+		slog.Error("Error drawing QR code", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// the secret is as good as a password for the second factor, so it mustn't be kept by any cache
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, totpEnrollResponse{
+		Secret: user.TOTP.Secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(picture),
+	})
+}
+
+// Handle Post request with the first code from the authenticator app, which turns two-factor authentication on
+func handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	id := requestIdentity(r)
+	if id.User == nil {
+		writeError(w, http.StatusNotFound, "no_account", "API keys aren't tied to an account")
+		return
+	}
+
+	codes, err := users.ConfirmTOTP(id.User.ID, req.Code)
+	if errors.Is(err, ErrTOTPNotStarted) {
+		writeError(w, http.StatusConflict, "totp_not_started", "start with POST /me/2fa to get a secret for the authenticator app")
+		return
+	}
+	if errors.Is(err, ErrTOTPEnabled) {
+		writeError(w, http.StatusConflict, "totp_enabled", "two-factor authentication is already on")
+		return
+	}
+	if errors.Is(err, ErrBadTOTP) {
+		writeError(w, http.StatusBadRequest, "invalid_code", "that isn't the code the authenticator app shows, check the phone's clock is right")
+		return
+	}
+	if err != nil {
+		slog.Error("Error confirming two-factor setup", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Security event", "event", "totp_enabled", "user", id.User.Username, "remote", remoteIP(r))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Handle Delete request to turn two-factor authentication off, it needs the password and a code or recovery code
+func handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !confirmPassword(w, r, req.Password) {
+		return
+	}
+
+	id := requestIdentity(r)
+	_, err = users.DisableTOTP(id.User.ID, &req.Code)
+	if errors.Is(err, ErrTOTPRequired) || errors.Is(err, ErrBadTOTP) {
+		logins.Failed(id.User.Username, remoteIP(r), time.Now())
+		writeError(w, http.StatusForbidden, "invalid_code", "a code from the authenticator app or a recovery code is needed to turn two-factor authentication off")
+		return
+	}
+	if err != nil {
+		slog.Error("Error turning two-factor authentication off", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Security event", "event", "totp_disabled", "user", id.User.Username, "remote", remoteIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Delete request from an admin to turn a user's two-factor authentication off, for when they've lost their phone and recovery codes
+func handleAdminDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	user, ok := users.ByUsername(username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "user_not_found", "there is no user called "+username)
+		return
+	}
+	user, err := users.DisableTOTP(user.ID, nil)
+	if err != nil {
+		slog.Error("Error turning two-factor authentication off", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Warn("Security event", "event", "totp_disabled", "user", user.Username, "by", requestIdentity(r).Actor(), "remote", remoteIP(r))
+	writeJSON(w, http.StatusOK, newAdminUserResponse(user))
+}
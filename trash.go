@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Deleting an entry only moves it to the trash by setting DeletedAt, it stays stored until it is restored or purged
+// everything apart from the trash endpoints treats an entry in the trash as if it didn't exist
+
+// How often entries that have been in the trash longer than -trash-retention are removed for good
+const trashPurgeInterval = time.Hour
+
+// Returns the entry with the given ID or ErrNotFound if there isn't one or it is in the trash
+func getEntry(s Store, listID int, id int) (Entry, error) {
+	entry, err := s.Get(listID, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if entry.DeletedAt != nil {
+		return Entry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+// Returns the entries that aren't in the trash
+func liveEntries(entries []Entry) []Entry {
+	live := []Entry{}
+	for _, entry := range entries {
+		if entry.DeletedAt == nil {
+			live = append(live, entry)
+		}
+	}
+	return live
+}
+
+// Moves an entry to the trash
+func softDelete(s Store, listID int, id int, now time.Time) (Entry, error) {
+	entry, err := getEntry(s, listID, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.DeletedAt = &now
+	entry.Revision++
+	return entry, s.Update(entry)
+}
+
+// Handle Get request for the entries in the list's trash, most recently deleted first
+func handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries, err := store.List(requestIdentity(r).ListID)
+	if err != nil {
+		slog.Error("Error reading entries", "err", err)
+		writeInternalError(w)
+		return
+	}
+	trash := []Entry{}
+	for _, entry := range entries {
+		if entry.DeletedAt != nil {
+			trash = append(trash, entry)
+		}
+	}
+	slices.SortStableFunc(trash, func(a, b Entry) int {
+		return b.DeletedAt.Compare(*a.DeletedAt)
+	})
+	writeJSON(w, http.StatusOK, trash)
+}
+
+// Handle Post request to take an entry back out of the trash
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseEntryID(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_id", "entry ID has to be a number")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, err := store.Get(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) || (err == nil && entry.DeletedAt == nil) {
+		writeError(w, http.StatusNotFound, "not_in_trash", "entry isn't in the trash")
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	entry.DeletedAt = nil
+	entry.Revision++
+	err = trackChange(r, "restore", func(tx Store) error {
+		return tx.Update(entry)
+	})
+	if err != nil {
+		slog.Error("Error restoring entry", "err", err)
+		writeInternalError(w)
+		return
+	}
+	// clients dropped the entry when it was deleted, so to them it is a new one
+	publishEntries(requestIdentity(r).Actor(), EventCreated, entry)
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// Removes entries for good once they have been in the trash for longer than retention
+func purgeTrash(retention time.Duration, now time.Time) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entries, err := store.All()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := now.Add(-retention)
+	purged := 0
+	changes, err := recordChanges(func(tx Store) error {
+		for _, entry := range entries {
+			if entry.DeletedAt == nil || entry.DeletedAt.After(cutoff) {
+				continue
+			}
+			err := tx.Delete(entry.ListID, entry.ID)
+			if err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	auditChanges(systemActor, 0, "purge", now, changes)
+	return purged, nil
+}
+
+// Purges the trash every interval until ctx is cancelled, a retention of 0 keeps deleted entries forever
+func runTrashPurge(ctx context.Context, retention time.Duration, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := purgeTrash(retention, time.Now().UTC())
+		if err != nil {
+			slog.Error("Error purging trash", "err", err)
+		} else if purged > 0 {
+			slog.Info("Purged trash", "entries", purged)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Trip is a shopping trip that has been closed, with the entries that were ticked off on it
+type Trip struct {
+	ID       int       `json:"id"`
+	ListID   int       `json:"list_id"`
+	ClosedAt time.Time `json:"closed_at"`
+	// Count is how many entries were bought on the trip
+	Count int `json:"count"`
+	// Entries are the entries as they were when the trip was closed, left out of GET /trips to keep it short
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// tripsDoc is everything the trips subsystem saves
+type tripsDoc struct {
+	Trips  []Trip `json:"trips"`
+	NextID int    `json:"next_id"`
+}
+
+// tripsDocName is the Store document the trips are saved under
+const tripsDocName = "trips"
+
+// tripsRegistry holds the closed trips in memory and saves them to the Store on every change
+type tripsRegistry struct {
+	store Store
+	doc   tripsDoc
+}
+
+var trips *tripsRegistry
+
+func loadTrips(store Store) (*tripsRegistry, error) {
+	t := &tripsRegistry{store: store}
+	err := store.LoadDoc(tripsDocName, &t.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if t.doc.Trips == nil {
+		t.doc.Trips = []Trip{}
+	}
+	t.doc.NextID = max(t.doc.NextID, 1)
+	return t, nil
+}
+
+func (t *tripsRegistry) save() error {
+	return t.store.SaveDoc(tripsDocName, t.doc)
+}
+
+// For returns the list's trips without their entries, most recent first
+func (t *tripsRegistry) For(listID int) []Trip {
+	list := []Trip{}
+	for _, trip := range slices.Backward(t.doc.Trips) {
+		if trip.ListID == listID {
+			trip.Entries = nil
+			list = append(list, trip)
+		}
+	}
+	return list
+}
+
+// WithEntries returns the list's trips with their entries, oldest first
+func (t *tripsRegistry) WithEntries(listID int) []Trip {
+	var list []Trip
+	for _, trip := range t.doc.Trips {
+		if trip.ListID == listID {
+			list = append(list, trip)
+		}
+	}
+	return list
+}
+
+// Get returns the trip with its entries or ErrNotFound
+func (t *tripsRegistry) Get(listID int, id int) (Trip, error) {
+	i := slices.IndexFunc(t.doc.Trips, func(trip Trip) bool {
+		return trip.ID == id && trip.ListID == listID
+	})
+	if i < 0 {
+		return Trip{}, ErrNotFound
+	}
+	return t.doc.Trips[i], nil
+}
+
+// Add saves a new trip, giving it an ID
+func (t *tripsRegistry) Add(trip Trip) (Trip, error) {
+	trip.ID = t.doc.NextID
+	t.doc.NextID++
+	t.doc.Trips = append(t.doc.Trips, trip)
+	return trip, t.save()
+}
+
+// Handle Post request to close the current shopping trip
+// the entries that are ticked off are kept in a new trip and moved to the trash, so the list is left with what is still to get
+// POST /undo puts the entries back on the list but the trip stays
+func handleCloseTrip(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	now := time.Now().UTC()
+	bought := []Entry{}
+	var cleared []Entry
+	err := trackChange(r, "close-trip", func(tx Store) error {
+		entries, err := tx.List(listID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range liveEntries(entries) {
+			if !entry.Completed {
+				continue
+			}
+			bought = append(bought, entry)
+			deleted, err := softDelete(tx, listID, entry.ID, now)
+			if err != nil {
+				return err
+			}
+			cleared = append(cleared, deleted)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error closing trip", "err", err)
+		writeInternalError(w)
+		return
+	}
+	if len(bought) == 0 {
+		writeError(w, http.StatusConflict, "nothing_completed", "nothing on the list is ticked off, so there is no trip to close")
+		return
+	}
+
+	trip, err := trips.Add(Trip{ListID: listID, ClosedAt: now, Count: len(bought), Entries: bought})
+	if err != nil {
+		slog.Error("Error saving trip", "err", err)
+		writeInternalError(w)
+		return
+	}
+	publishEntries(requestIdentity(r).Actor(), EventDeleted, cleared...)
+	writeJSON(w, http.StatusCreated, trip)
+}
+
+// Handle Get request for the list's closed trips, most recent first
+func handleGetTrips(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	writeJSON(w, http.StatusOK, trips.For(requestIdentity(r).ListID))
+}
+
+// Handle Get request for a closed trip with what was bought on it
+func handleGetTrip(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "trip ID has to be a number")
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	trip, err := trips.Get(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "trip not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, trip)
+}
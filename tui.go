@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tuiHelp is the bottom line of the TUI when nothing else is being shown there
+const tuiHelp = "↑/↓ move  space tick  a add  d delete  r refresh  q quit"
+
+// tui is the state of "shoppinglist tui", a full screen client that follows the list's changes live through GET /events
+type tui struct {
+	client  *apiClient
+	out     io.Writer
+	entries []Entry
+	// cursor is the index of the selected entry and offset the index of the first one on screen
+	cursor int
+	offset int
+	// adding is true while the item to add is being typed, input is what has been typed so far
+	adding bool
+	input  []rune
+	// status is shown in place of the help until the next key, errors from the server go here
+	status string
+}
+
+// Runs the TUI until q or Ctrl-C, the terminal is put back the way it was however it ends
+func cliTUI(c *apiClient, args []string, w io.Writer) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("the TUI has to be run in a terminal: %w", err)
+	}
+	defer restore()
+	// the alternate screen keeps the TUI out of the terminal's scrollback, and the cursor is hidden since the selection shows where it is
+	fmt.Fprint(w, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(w, "\x1b[?25h\x1b[?1049l")
+
+	t := &tui{client: c, out: w}
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 1)
+	go c.watchChanges(ctx, changes)
+	resized := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resized, resizeSignals...)
+		defer signal.Stop(resized)
+	}
+
+	t.load()
+	for {
+		t.draw(fd)
+		select {
+		case key, ok := <-keys:
+			if !ok || t.handleKey(key) {
+				return nil
+			}
+		case <-changes:
+			t.load()
+		case <-resized:
+		}
+	}
+}
+
+// Sends the keys read from the terminal one at a time, fast typing or a paste can arrive in a single read
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for _, key := range splitKeys(string(buf[:n])) {
+			keys <- key
+		}
+	}
+}
+
+// Splits terminal input into keys, an escape sequence like an arrow key is one key and so is each character
+func splitKeys(s string) []string {
+	var keys []string
+	for s != "" {
+		n := 0
+		switch {
+		case strings.HasPrefix(s, "\x1b[") || strings.HasPrefix(s, "\x1bO"):
+			// a CSI sequence ends with its first byte from @ to ~, an SS3 one is always three bytes
+			n = len(s)
+			for i := 2; i < len(s); i++ {
+				if s[i] >= '@' && s[i] <= '~' {
+					n = i + 1
+					break
+				}
+			}
+		default:
+			_, n = utf8.DecodeRuneInString(s)
+		}
+		keys = append(keys, s[:n])
+		s = s[n:]
+	}
+	return keys
+}
+
+// Acts on a key and reports whether it was the one to quit
+func (t *tui) handleKey(key string) bool {
+	t.status = ""
+	if t.adding {
+		t.handleInput(key)
+		return false
+	}
+	switch key {
+	case "q", "\x03":
+		return true
+	case "\x1b[A", "\x1bOA", "k":
+		t.cursor = max(t.cursor-1, 0)
+	case "\x1b[B", "\x1bOB", "j":
+		t.cursor = min(t.cursor+1, max(len(t.entries)-1, 0))
+	case " ", "x":
+		if len(t.entries) > 0 {
+			entry := t.entries[t.cursor]
+			action := "/complete"
+			if entry.Completed {
+				action = "/uncomplete"
+			}
+			t.change(http.MethodPost, "/data/"+strconv.Itoa(entry.ID)+action, nil)
+		}
+	case "d":
+		if len(t.entries) > 0 {
+			t.change(http.MethodDelete, "/data/"+strconv.Itoa(t.entries[t.cursor].ID), nil)
+		}
+	case "a":
+		t.adding = true
+		t.input = nil
+	case "r":
+		t.load()
+	}
+	return false
+}
+
+// Edits the item being added, Enter adds it and Esc or Ctrl-C gives up
+func (t *tui) handleInput(key string) {
+	switch key {
+	case "\r", "\n":
+		t.adding = false
+		item := strings.TrimSpace(string(t.input))
+		if item != "" {
+			t.change(http.MethodPost, "/data", []Entry{{Item: item}})
+		}
+	case "\x1b", "\x03":
+		t.adding = false
+	case "\x7f", "\b":
+		if len(t.input) > 0 {
+			t.input = t.input[:len(t.input)-1]
+		}
+	default:
+		// keys that aren't a character e.g. the arrows are ignored
+		r, _ := utf8.DecodeRuneInString(key)
+		if len(key) == utf8.RuneLen(r) && unicode.IsPrint(r) {
+			t.input = append(t.input, r)
+		}
+	}
+}
+
+// Makes a change and reloads the list, the change's own event would reload it too but this way it shows straight away
+func (t *tui) change(method string, path string, body any) {
+	err := t.client.do(method, path, nil, body, nil)
+	if err != nil {
+		t.status = err.Error()
+	}
+	t.load()
+}
+
+// Fetches the list again keeping the same entry selected, or the one in its place if it has gone
+func (t *tui) load() {
+	selected := -1
+	if t.cursor < len(t.entries) {
+		selected = t.entries[t.cursor].ID
+	}
+	var entries []Entry
+	err := t.client.do(http.MethodGet, "/data", nil, nil, &entries)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.entries = entries
+	if i := slices.IndexFunc(entries, func(e Entry) bool { return e.ID == selected }); i >= 0 {
+		t.cursor = i
+	}
+	t.cursor = max(min(t.cursor, len(entries)-1), 0)
+}
+
+// Redraws the whole screen, the list is short enough that working out what changed isn't worth it
+func (t *tui) draw(fd int) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width <= 0 || height < 3 {
+		width, height = 80, 24
+	}
+	// the first line is the title and the last one the help, the rest are entries
+	rows := height - 2
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+rows {
+		t.offset = t.cursor - rows + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	left := 0
+	for _, entry := range t.entries {
+		if !entry.Completed {
+			left++
+		}
+	}
+	b.WriteString("\x1b[1m" + fitLine(fmt.Sprintf("Shopping list  %d to get, %d ticked off", left, len(t.entries)-left), width) + "\x1b[0m\r\n")
+	if len(t.entries) == 0 {
+		b.WriteString("Nothing on the list, press a to add something\r\n")
+	}
+	for i := t.offset; i < len(t.entries) && i < t.offset+rows; i++ {
+		line := fitLine(formatEntryLine(t.entries[i]), width)
+		if i == t.cursor {
+			// reverse video across the whole width so the selection is easy to see
+			line = "\x1b[7m" + line + strings.Repeat(" ", width-utf8.RuneCountInString(line)) + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	fmt.Fprintf(&b, "\x1b[%d;1H", height)
+	switch {
+	case t.adding:
+		b.WriteString(fitLine("Add: "+string(t.input)+"_", width))
+	case t.status != "":
+		b.WriteString("\x1b[31m" + fitLine(t.status, width) + "\x1b[0m")
+	default:
+		b.WriteString("\x1b[2m" + fitLine(tuiHelp, width) + "\x1b[0m")
+	}
+	io.WriteString(t.out, b.String())
+}
+
+// Cuts a line down to the terminal's width so it doesn't wrap onto the next one
+func fitLine(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return s
+}
+
+// Follows GET /events and sends on changes whenever the list changes, reconnecting until ctx is done
+// a change is also sent after every reconnect since events from while it was down might have been missed
+func (c *apiClient) watchChanges(ctx context.Context, changes chan<- struct{}) {
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	// the stream stays open for as long as the TUI runs, so it can't have the usual timeout
+	stream := &http.Client{}
+	for connected := false; ; {
+		req, err := c.request(ctx, http.MethodGet, "/events", nil, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		res, err := stream.Do(req)
+		if err == nil && res.StatusCode == http.StatusOK {
+			if connected {
+				notify()
+			}
+			connected = true
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				if strings.HasPrefix(scanner.Text(), "event:") {
+					notify()
+				}
+			}
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
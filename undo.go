@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// undoOperation is one change a caller made, with what is needed to put things back how they were
+type undoOperation struct {
+	// Action is what the change was e.g. "add", "update", "delete" or "bulk"
+	Action string    `json:"action"`
+	ListID int       `json:"list_id"`
+	Time   time.Time `json:"time"`
+	// Before is every entry the change touched as it was beforehand, undoing stores them again
+	Before []Entry `json:"before"`
+	// Created are the IDs of entries the change added, undoing removes them for good
+	Created []int `json:"created"`
+}
+
+// undoDoc is the undo history of every caller, keyed by identity.Caller, oldest first
+type undoDoc struct {
+	Operations map[string][]undoOperation `json:"operations"`
+}
+
+// undoDocName is the Store document the undo history is saved under
+const undoDocName = "undo"
+
+// How many changes are kept per caller, the oldest is dropped once there are more
+const maxUndoOperations = 20
+
+// undoLog is the undo history, loaded once and saved to the Store after every change
+type undoLog struct {
+	store Store
+	doc   undoDoc
+}
+
+var undos *undoLog
+
+// Builds the undo history entry for a change from what it did to each entry
+func newUndoOperation(action string, listID int, now time.Time, changes []entryChange) undoOperation {
+	op := undoOperation{Action: action, ListID: listID, Time: now}
+	for _, change := range changes {
+		if change.Before == nil {
+			op.Created = append(op.Created, change.After.ID)
+		} else {
+			op.Before = append(op.Before, *change.Before)
+		}
+	}
+	return op
+}
+
+func loadUndoLog(store Store) (*undoLog, error) {
+	l := &undoLog{store: store}
+	err := store.LoadDoc(undoDocName, &l.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if l.doc.Operations == nil {
+		l.doc.Operations = make(map[string][]undoOperation)
+	}
+	return l, nil
+}
+
+// Record adds op to the end of the caller's history
+// the change has already been stored by now, so failing to save the history is logged rather than failing the request
+func (l *undoLog) Record(caller string, op undoOperation) {
+	if len(op.Before) == 0 && len(op.Created) == 0 {
+		return
+	}
+	ops := append(l.doc.Operations[caller], op)
+	if len(ops) > maxUndoOperations {
+		ops = slices.Clone(ops[len(ops)-maxUndoOperations:])
+	}
+	l.doc.Operations[caller] = ops
+	l.save()
+}
+
+// Pop removes and returns the caller's most recent change to the list, false if there isn't one
+func (l *undoLog) Pop(caller string, listID int) (undoOperation, bool) {
+	ops := l.doc.Operations[caller]
+	for i := len(ops) - 1; i >= 0; i-- {
+		if ops[i].ListID != listID {
+			continue
+		}
+		op := ops[i]
+		l.doc.Operations[caller] = slices.Delete(ops, i, i+1)
+		if len(l.doc.Operations[caller]) == 0 {
+			delete(l.doc.Operations, caller)
+		}
+		l.save()
+		return op, true
+	}
+	return undoOperation{}, false
+}
+
+func (l *undoLog) save() {
+	err := l.store.SaveDoc(undoDocName, l.doc)
+	if err != nil {
+		slog.Error("Error saving undo history", "err", err)
+	}
+}
+
+// undoResponse is what POST /undo sends back
+type undoResponse struct {
+	// Undone is the action that was reverted
+	Undone string    `json:"undone"`
+	Time   time.Time `json:"time"`
+	// Entries are the entries that were put back, entries that were removed aren't included
+	Entries []Entry `json:"entries"`
+}
+
+// Handle Post request to revert the caller's most recent change to the list
+// each call goes one step further back, up to the last maxUndoOperations changes
+// entries are put back as they were even if someone else has changed them since, an entry purged from the trash since can't be brought back and is skipped
+func handleUndo(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := requestIdentity(r)
+	op, ok := undos.Pop(id.Caller(), id.ListID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "nothing_to_undo", "there are no changes to undo")
+		return
+	}
+
+	type pendingEvent struct {
+		eventType string
+		entry     Entry
+	}
+	var events []pendingEvent
+	restored := []Entry{}
+	// an undo is audited like any other change but isn't added to the history itself, so undoing again goes further back
+	changes, err := recordChanges(func(tx Store) error {
+		for _, entryID := range op.Created {
+			err := tx.Delete(op.ListID, entryID)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			events = append(events, pendingEvent{EventDeleted, Entry{ID: entryID, ListID: op.ListID}})
+		}
+		for _, before := range op.Before {
+			current, err := tx.Get(before.ListID, before.ID)
+			if errors.Is(err, ErrNotFound) {
+				slog.Warn("Entry can't be restored by undo because it no longer exists", "id", before.ID, "list_id", before.ListID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// the entry goes back to how it was but as a new revision, so a client holding the old one still gets a conflict
+			before.Revision = current.Revision + 1
+			err = tx.Update(before)
+			if err != nil {
+				return err
+			}
+			restored = append(restored, before)
+			// clients drop entries that go to the trash, so moving in or out of it looks like a delete or an add to them
+			switch {
+			case before.DeletedAt != nil && current.DeletedAt == nil:
+				events = append(events, pendingEvent{EventDeleted, before})
+			case before.DeletedAt == nil && current.DeletedAt != nil:
+				events = append(events, pendingEvent{EventCreated, before})
+			case before.DeletedAt == nil:
+				events = append(events, pendingEvent{EventUpdated, before})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// the operation goes back on the history so the undo can be tried again
+		undos.Record(id.Caller(), op)
+		slog.Error("Error undoing change", "err", err)
+		writeInternalError(w)
+		return
+	}
+
+	auditChanges(id.Actor(), id.UserID(), "undo", time.Now().UTC(), changes)
+
+	for _, e := range events {
+		publishEntries(id.Actor(), e.eventType, e.entry)
+	}
+	writeJSON(w, http.StatusOK, undoResponse{Undone: op.Action, Time: op.Time, Entries: restored})
+}
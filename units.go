@@ -0,0 +1,298 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// unitDef says what a unit measures and how big it is, so quantities in different units of the same thing can be added up
+type unitDef struct {
+	// Dimension is "mass" or "volume"
+	Dimension string `json:"dimension"`
+	// Factor is how many of the dimension's base unit (g or ml) one of the unit is, e.g. 1000 for kg
+	Factor float64 `json:"factor"`
+}
+
+// baseUnits are the units each dimension is measured in, every other unit is a multiple of one of them
+var baseUnits = map[string]string{
+	"mass":   "g",
+	"volume": "ml",
+}
+
+// builtinUnits are the units every list knows, by their normalized name, cups, pints and the like are the US ones
+// a list that means something else by one of them can give it its own size with PUT /units/{unit}
+var builtinUnits = map[string]unitDef{
+	"mg":          {"mass", 0.001},
+	"milligram":   {"mass", 0.001},
+	"g":           {"mass", 1},
+	"gr":          {"mass", 1},
+	"gram":        {"mass", 1},
+	"kg":          {"mass", 1000},
+	"kilo":        {"mass", 1000},
+	"kilogram":    {"mass", 1000},
+	"oz":          {"mass", 28.349523125},
+	"ounce":       {"mass", 28.349523125},
+	"lb":          {"mass", 453.59237},
+	"pound":       {"mass", 453.59237},
+	"ml":          {"volume", 1},
+	"millilitre":  {"volume", 1},
+	"milliliter":  {"volume", 1},
+	"cl":          {"volume", 10},
+	"centilitre":  {"volume", 10},
+	"centiliter":  {"volume", 10},
+	"dl":          {"volume", 100},
+	"decilitre":   {"volume", 100},
+	"deciliter":   {"volume", 100},
+	"l":           {"volume", 1000},
+	"litre":       {"volume", 1000},
+	"liter":       {"volume", 1000},
+	"tsp":         {"volume", 4.92892159375},
+	"teaspoon":    {"volume", 4.92892159375},
+	"tbsp":        {"volume", 14.78676478125},
+	"tablespoon":  {"volume", 14.78676478125},
+	"fl oz":       {"volume", 29.5735295625},
+	"fluid ounce": {"volume", 29.5735295625},
+	"cup":         {"volume", 236.5882365},
+	"pt":          {"volume", 473.176473},
+	"pint":        {"volume", 473.176473},
+	"qt":          {"volume", 946.352946},
+	"quart":       {"volume", 946.352946},
+	"gal":         {"volume", 3785.411784},
+	"gallon":      {"volume", 3785.411784},
+}
+
+// Returns the form of a unit used to look it up, "Fl. Oz" is "fl oz"
+func normalizeUnit(unit string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(unit, ".", " ")), " "))
+}
+
+// Looks a normalized unit up in table, trying it without a trailing s too so "cups" and "lbs" are found
+func lookupUnit(table map[string]unitDef, name string) (unitDef, bool) {
+	if def, ok := table[name]; ok {
+		return def, true
+	}
+	if len(name) > 2 && strings.HasSuffix(name, "s") {
+		def, ok := table[strings.TrimSuffix(name, "s")]
+		return def, ok
+	}
+	return unitDef{}, false
+}
+
+// unitsDoc holds each list's own units, by normalized name
+type unitsDoc struct {
+	Lists map[int]map[string]unitDef `json:"lists"`
+}
+
+// unitsDocName is the Store document the lists' units are saved under
+const unitsDocName = "units"
+
+// unitRegistry holds every list's own units in memory and saves them to the Store on every change
+type unitRegistry struct {
+	store Store
+	doc   unitsDoc
+}
+
+var units *unitRegistry
+
+func loadUnits(store Store) (*unitRegistry, error) {
+	u := &unitRegistry{store: store}
+	err := store.LoadDoc(unitsDocName, &u.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if u.doc.Lists == nil {
+		u.doc.Lists = make(map[int]map[string]unitDef)
+	}
+	return u, nil
+}
+
+// For returns the list's own units
+func (u *unitRegistry) For(listID int) map[string]unitDef {
+	list := maps.Clone(u.doc.Lists[listID])
+	if list == nil {
+		list = map[string]unitDef{}
+	}
+	return list
+}
+
+// Lookup returns what a unit on the list measures, the list's own units come before the built in ones, false if it is neither
+func (u *unitRegistry) Lookup(listID int, unit string) (unitDef, bool) {
+	name := normalizeUnit(unit)
+	if u != nil {
+		if def, ok := lookupUnit(u.doc.Lists[listID], name); ok {
+			return def, true
+		}
+	}
+	return lookupUnit(builtinUnits, name)
+}
+
+// Set gives the list its own unit, or takes it away when def is nil, and saves them
+func (u *unitRegistry) Set(listID int, name string, def *unitDef) error {
+	list := u.doc.Lists[listID]
+	if def == nil {
+		delete(list, name)
+		if len(list) == 0 {
+			delete(u.doc.Lists, listID)
+		}
+	} else {
+		if list == nil {
+			list = make(map[string]unitDef)
+			u.doc.Lists[listID] = list
+		}
+		list[name] = *def
+	}
+	return u.store.SaveDoc(unitsDocName, u.doc)
+}
+
+// Returns the part of dedupeKey that compares units, units of the same dimension are the same so 500 g and 1 kg of flour merge
+// units that aren't in the table only match themselves, ignoring case, callers must hold mu
+func unitKey(listID int, unit string) string {
+	if def, ok := units.Lookup(listID, unit); ok {
+		return "\x01" + def.Dimension
+	}
+	return strings.ToLower(strings.TrimSpace(unit))
+}
+
+// Adds entry's quantity to into, which has the same dedupeKey, converting between their units when they differ
+// the total is given in the bigger of the two units if there is at least one of it, otherwise the smaller, so 500 g + 1 kg is 1.5 kg
+// and 2 cups + 1 pint is 2 pints, into's price is per unit so it is converted too, callers must hold mu
+func mergeQuantity(listID int, into *Entry, entry Entry) {
+	from, fromOK := units.Lookup(listID, into.Unit)
+	added, addedOK := units.Lookup(listID, entry.Unit)
+	if normalizeUnit(into.Unit) == normalizeUnit(entry.Unit) || !fromOK || !addedOK || from.Dimension != added.Dimension {
+		into.Quantity += entry.Quantity
+		return
+	}
+	total := into.Quantity*from.Factor + entry.Quantity*added.Factor
+	big, small := *into, entry
+	bigDef, smallDef := from, added
+	if added.Factor > from.Factor {
+		big, small = entry, *into
+		bigDef, smallDef = added, from
+	}
+	unit, def := small.Unit, smallDef
+	if total >= bigDef.Factor {
+		unit, def = big.Unit, bigDef
+	}
+	into.Quantity = math.Round(total/def.Factor*1000) / 1000
+	into.Price = math.Round(into.Price*def.Factor/from.Factor*1e6) / 1e6
+	into.Unit = unit
+}
+
+// unitConversion is one unit in GET /units, e.g. {"unit": "kg", "dimension": "mass", "equals": 1000, "base": "g", "custom": false}
+type unitConversion struct {
+	Unit      string  `json:"unit"`
+	Dimension string  `json:"dimension"`
+	Equals    float64 `json:"equals"`
+	Base      string  `json:"base"`
+	// Custom is true for the list's own units, which were added with PUT /units/{unit}
+	Custom bool `json:"custom"`
+}
+
+func newUnitConversion(name string, def unitDef, custom bool) unitConversion {
+	return unitConversion{Unit: name, Dimension: def.Dimension, Equals: def.Factor, Base: baseUnits[def.Dimension], Custom: custom}
+}
+
+// unitRequest is the body of PUT /units/{unit}, e.g. {"equals": 568.26, "unit": "ml"} for a British pint
+type unitRequest struct {
+	Equals float64 `json:"equals"`
+	// Unit is the built in unit Equals is in
+	Unit string `json:"unit"`
+}
+
+// Handle Get request for every unit the list can convert between, its own and the built in ones it hasn't replaced
+// ordered by dimension then size
+func handleGetUnits(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	custom := units.For(requestIdentity(r).ListID)
+	list := []unitConversion{}
+	for name, def := range custom {
+		list = append(list, newUnitConversion(name, def, true))
+	}
+	for name, def := range builtinUnits {
+		if _, ok := custom[name]; !ok {
+			list = append(list, newUnitConversion(name, def, false))
+		}
+	}
+	slices.SortFunc(list, func(a, b unitConversion) int {
+		return cmp.Or(cmp.Compare(a.Dimension, b.Dimension), cmp.Compare(a.Equals, b.Equals), cmp.Compare(a.Unit, b.Unit))
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Handle Put request to give the list its own unit, or its own size for a built in one, in terms of a built in unit
+// entries in it are merged with entries in any other unit of the same dimension from then on
+func handlePutUnit(w http.ResponseWriter, r *http.Request) {
+	var body unitRequest
+	err := decodeStrict(r.Body, &body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	name := normalizeUnit(r.PathValue("unit"))
+	if name == "" || len(name) > maxUnitLength {
+		writeError(w, http.StatusBadRequest, "invalid_unit", "units can't be empty or longer than "+strconv.Itoa(maxUnitLength)+" characters")
+		return
+	}
+	for _, base := range baseUnits {
+		if name == base {
+			writeError(w, http.StatusBadRequest, "invalid_unit", base+" is a base unit, its size can't be changed")
+			return
+		}
+	}
+	var errs []fieldError
+	if body.Equals <= 0 || math.IsInf(body.Equals, 0) {
+		errs = append(errs, fieldError{Field: "equals", Message: "equals has to be more than 0"})
+	}
+	// only built in units can be used so a list's units never depend on each other
+	def, ok := lookupUnit(builtinUnits, normalizeUnit(body.Unit))
+	if !ok {
+		errs = append(errs, fieldError{Field: "unit", Message: "unit has to be a built in unit like g or ml"})
+	}
+	if errs != nil {
+		writeFieldErrors(w, "invalid_unit", errs)
+		return
+	}
+	def.Factor *= body.Equals
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	err = units.Set(requestIdentity(r).ListID, name, &def)
+	if err != nil {
+		slog.Error("Error saving units", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUnitConversion(name, def, true))
+}
+
+// Handle Delete request to take away one of the list's own units, a built in unit it replaced goes back to its usual size
+func handleDeleteUnit(w http.ResponseWriter, r *http.Request) {
+	name := normalizeUnit(r.PathValue("unit"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	listID := requestIdentity(r).ListID
+	if _, ok := units.For(listID)[name]; !ok {
+		writeError(w, http.StatusNotFound, "unit_not_found", "unit not found")
+		return
+	}
+	err := units.Set(listID, name, nil)
+	if err != nil {
+		slog.Error("Error saving units", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
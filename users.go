@@ -0,0 +1,845 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// PasswordHash is never sent to clients, see hashPassword for the format
+	PasswordHash string `json:"password_hash"`
+	// ListID is the user's own list, the one /data works on
+	ListID    int       `json:"list_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is RoleAdmin for admins and empty for everyone else, see AccountRole
+	Role Role `json:"role,omitempty"`
+	// Email is where password reset emails go, accounts don't need one but can't reset their password without it
+	Email string `json:"email,omitempty"`
+	// LastSeenAt is when the user last logged in, refreshed a session or sent their password to a feed, see lastSeenEvery
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// TOTP is the user's two-factor authentication, nil when they have never set it up, see totp.go
+	TOTP *totpSettings `json:"totp,omitempty"`
+}
+
+// List is a shopping list owned by one user, list 0 is never stored and holds the entries from before accounts existed
+type List struct {
+	ID      int    `json:"id"`
+	OwnerID int    `json:"owner_id"`
+	Name    string `json:"name"`
+	// Shares are the other users the owner has given access to
+	Shares []Share `json:"shares"`
+}
+
+// session is a logged in client, only a hash of the token is kept so a copy of the data can't be used to log in
+type session struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// accountsDoc is everything the users subsystem saves, kept in one document so a registration is saved in one write
+type accountsDoc struct {
+	Users    []User    `json:"users"`
+	Lists    []List    `json:"lists"`
+	Sessions []session `json:"sessions"`
+	// Resets are the password reset tokens that haven't been used yet, see passwordreset.go
+	Resets     []passwordReset `json:"resets"`
+	NextUserID int             `json:"next_user_id"`
+	NextListID int             `json:"next_list_id"`
+}
+
+// accountsDocName is the Store document the accounts are saved under
+const accountsDocName = "users"
+
+var (
+	ErrUsernameTaken  = errors.New("username is already taken")
+	ErrEmailTaken     = errors.New("email is already used by another account")
+	ErrBadCredentials = errors.New("wrong username or password")
+)
+
+// usernamePattern keeps usernames to characters that are safe in URLs and logs
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+const minPasswordLength = 8
+
+// lastSeenEvery is how stale LastSeenAt gets before a password sent to a feed updates it
+// calendar apps send it with every poll and saving the accounts each time would be a write per request
+const lastSeenEvery = time.Hour
+
+// userRegistry holds the accounts in memory and saves them to the Store on every change
+type userRegistry struct {
+	store      Store
+	sessionTTL time.Duration
+
+	mu  sync.Mutex
+	doc accountsDoc
+}
+
+var users *userRegistry
+
+func loadUsers(store Store, sessionTTL time.Duration) (*userRegistry, error) {
+	u := &userRegistry{store: store, sessionTTL: sessionTTL}
+	err := store.LoadDoc(accountsDocName, &u.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	// IDs start at 1 so list 0 stays free for the entries from before accounts existed
+	if u.doc.NextUserID == 0 {
+		u.doc.NextUserID = 1
+	}
+	if u.doc.NextListID == 0 {
+		u.doc.NextListID = 1
+	}
+	// accounts from before LastSeenAt was kept count as seen now, otherwise the first clean up of inactive accounts would take them all
+	doc := u.doc
+	doc.Users = append([]User{}, u.doc.Users...)
+	changed := false
+	for i := range doc.Users {
+		if doc.Users[i].LastSeenAt.IsZero() {
+			doc.Users[i].LastSeenAt = time.Now().UTC()
+			changed = true
+		}
+	}
+	if changed {
+		err = u.save(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Register creates a user along with their own list, email can be empty
+func (u *userRegistry) Register(username string, password string, email string) (User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, existing := range u.doc.Users {
+		if strings.EqualFold(existing.Username, username) {
+			return User{}, ErrUsernameTaken
+		}
+		if email != "" && strings.EqualFold(existing.Email, email) {
+			return User{}, ErrEmailTaken
+		}
+	}
+
+	user := User{
+		ID:           u.doc.NextUserID,
+		Username:     username,
+		PasswordHash: hash,
+		Email:        email,
+		ListID:       u.doc.NextListID,
+		CreatedAt:    time.Now().UTC(),
+		LastSeenAt:   time.Now().UTC(),
+	}
+	list := List{ID: user.ListID, OwnerID: user.ID, Name: username + "'s list", Shares: []Share{}}
+
+	doc := u.doc
+	doc.Users = append(doc.Users, user)
+	doc.Lists = append(doc.Lists, list)
+	doc.NextUserID++
+	doc.NextListID++
+	err = u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// Login checks the password and, for users with two-factor authentication, the code then starts a session
+// it returns the refresh token the client trades for access tokens with POST /refresh, and when that refresh token expires
+// ErrTOTPRequired means the password was right but a code is needed too, ErrBadTOTP that the code was wrong
+func (u *userRegistry) Login(username string, password string, code string) (User, string, time.Time, error) {
+	user, err := u.checkCredentials(username, password)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, err = u.stillCurrent(user)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+
+	doc := u.doc
+	err = checkSecondFactor(&doc, user.ID, code)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	doc.Sessions = liveSessions(u.doc.Sessions, "")
+	token, expires, err := u.startSession(&doc, user.ID)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	return user, token, expires, nil
+}
+
+// StartSession starts a session for a user who has already shown who they are, like Login without the checks
+func (u *userRegistry) StartSession(userID int) (User, string, time.Time, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, ok := u.userByID(userID)
+	if !ok {
+		return User{}, "", time.Time{}, ErrUserNotFound
+	}
+	doc := u.doc
+	doc.Sessions = liveSessions(u.doc.Sessions, "")
+	token, expires, err := u.startSession(&doc, user.ID)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	return user, token, expires, nil
+}
+
+// Authenticate checks the password without starting a session, for clients like calendar apps that send it with every request
+func (u *userRegistry) Authenticate(username string, password string) (User, error) {
+	user, err := u.checkCredentials(username, password)
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, err = u.stillCurrent(user)
+	if err != nil {
+		return User{}, err
+	}
+	if time.Since(user.LastSeenAt) > lastSeenEvery {
+		doc := u.doc
+		seen(&doc, user.ID)
+		// the request can go ahead without it, it only matters to the clean up of inactive accounts
+		err = u.save(doc)
+		if err != nil {
+			slog.Error("Error saving when a user was last seen", "err", err)
+		}
+	}
+	return user, nil
+}
+
+// Returns the user with the username if the password is theirs or ErrBadCredentials, callers mustn't hold u.mu
+// hashing the password takes a good part of a second, so only the look up holds the lock and other requests aren't kept waiting
+// the account can change once the lock is released, so callers go on with what stillCurrent returns
+func (u *userRegistry) checkCredentials(username string, password string) (User, error) {
+	u.mu.Lock()
+	var user *User
+	for i := range u.doc.Users {
+		if strings.EqualFold(u.doc.Users[i].Username, username) {
+			found := u.doc.Users[i]
+			user = &found
+			break
+		}
+	}
+	u.mu.Unlock()
+
+	// the password is still hashed when the user doesn't exist so the response time doesn't reveal which usernames are real
+	if user == nil {
+		checkPassword(dummyPasswordHash, password)
+		return User{}, ErrBadCredentials
+	}
+	if !checkPassword(user.PasswordHash, password) {
+		return User{}, ErrBadCredentials
+	}
+	return *user, nil
+}
+
+// Returns the user checkCredentials accepted as they are now, or ErrBadCredentials if they were deleted
+// or their password changed while it was being checked, callers must hold u.mu
+func (u *userRegistry) stillCurrent(checked User) (User, error) {
+	user, ok := u.userByID(checked.ID)
+	if !ok || user.PasswordHash != checked.PasswordHash {
+		return User{}, ErrBadCredentials
+	}
+	return user, nil
+}
+
+// Refresh swaps a refresh token for a new one and returns the user it belongs to
+// the old token stops working straight away, so a stolen refresh token only works until the real client next refreshes
+func (u *userRegistry) Refresh(token string) (User, string, time.Time, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	hash := hashToken(token)
+	now := time.Now()
+	userID := 0
+	for _, s := range u.doc.Sessions {
+		if subtle.ConstantTimeCompare([]byte(s.TokenHash), []byte(hash)) == 1 && s.ExpiresAt.After(now) {
+			userID = s.UserID
+			break
+		}
+	}
+	user, ok := u.userByID(userID)
+	if !ok {
+		return User{}, "", time.Time{}, ErrInvalidToken
+	}
+
+	doc := u.doc
+	doc.Sessions = liveSessions(u.doc.Sessions, hash)
+	newToken, expires, err := u.startSession(&doc, user.ID)
+	if err != nil {
+		return User{}, "", time.Time{}, err
+	}
+	return user, newToken, expires, nil
+}
+
+// Logout ends the session for a refresh token, it is not an error if it had already gone
+// access tokens already handed out keep working until they expire, which is why they are short lived
+func (u *userRegistry) Logout(token string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	doc := u.doc
+	doc.Sessions = liveSessions(u.doc.Sessions, hashToken(token))
+	return u.save(doc)
+}
+
+// User returns the account with the given ID
+func (u *userRegistry) User(id int) (User, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.userByID(id)
+}
+
+// SetPassword changes the user's password and ends all their sessions, so whoever knew the old one is logged out once their access token expires
+// password reset tokens the user already has stop working too
+func (u *userRegistry) SetPassword(userID int, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	index := slices.IndexFunc(u.doc.Users, func(user User) bool { return user.ID == userID })
+	if index == -1 {
+		return ErrUserNotFound
+	}
+	doc := u.doc
+	setPasswordHash(&doc, index, hash)
+	return u.save(doc)
+}
+
+// Sets the password hash of the user at index in doc and drops their sessions and password reset tokens, callers must hold u.mu
+// a reset token asked for before the password changed could otherwise be used to change it back
+func setPasswordHash(doc *accountsDoc, index int, hash string) {
+	doc.Users = append([]User{}, doc.Users...)
+	doc.Users[index].PasswordHash = hash
+	userID := doc.Users[index].ID
+	doc.Sessions = slices.DeleteFunc(liveSessions(doc.Sessions, ""), func(s session) bool { return s.UserID == userID })
+	doc.Resets = slices.DeleteFunc(liveResets(doc.Resets), func(reset passwordReset) bool { return reset.UserID == userID })
+}
+
+// DeleteUser removes the account, its sessions, the lists it owns and its shares of other lists, returning the IDs of the lists it owned
+// the entries and everything else kept per list aren't in the accounts document, so the caller deletes those
+func (u *userRegistry) DeleteUser(userID int) ([]int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, ok := u.userByID(userID)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.AccountRole() == RoleAdmin {
+		admins := 0
+		for _, other := range u.doc.Users {
+			if other.AccountRole() == RoleAdmin {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+
+	doc := u.doc
+	doc.Users = slices.DeleteFunc(append([]User{}, u.doc.Users...), func(other User) bool { return other.ID == userID })
+	doc.Sessions = slices.DeleteFunc(liveSessions(u.doc.Sessions, ""), func(s session) bool { return s.UserID == userID })
+	doc.Resets = slices.DeleteFunc(liveResets(u.doc.Resets), func(reset passwordReset) bool { return reset.UserID == userID })
+	doc.Lists = []List{}
+	var owned []int
+	for _, list := range u.doc.Lists {
+		if list.OwnerID == userID {
+			owned = append(owned, list.ID)
+			continue
+		}
+		list.Shares = slices.DeleteFunc(append([]Share{}, list.Shares...), func(share Share) bool { return share.UserID == userID })
+		doc.Lists = append(doc.Lists, list)
+	}
+	err := u.save(doc)
+	if err != nil {
+		return nil, err
+	}
+	return owned, nil
+}
+
+// SetEmail changes the address password reset emails go to, "" takes it away
+func (u *userRegistry) SetEmail(userID int, email string) (User, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	index := -1
+	for i, user := range u.doc.Users {
+		if user.ID == userID {
+			index = i
+		} else if email != "" && strings.EqualFold(user.Email, email) {
+			return User{}, ErrEmailTaken
+		}
+	}
+	if index == -1 {
+		return User{}, ErrUserNotFound
+	}
+	doc := u.doc
+	doc.Users = append([]User{}, u.doc.Users...)
+	doc.Users[index].Email = email
+	err := u.save(doc)
+	if err != nil {
+		return User{}, err
+	}
+	return doc.Users[index], nil
+}
+
+// Sets when userID was last seen to now in doc, copying the users first so u.doc is left alone until doc is saved
+func seen(doc *accountsDoc, userID int) {
+	doc.Users = append([]User{}, doc.Users...)
+	for i := range doc.Users {
+		if doc.Users[i].ID == userID {
+			doc.Users[i].LastSeenAt = time.Now().UTC()
+		}
+	}
+}
+
+// Adds a new session for userID to doc and saves it, callers must hold u.mu
+func (u *userRegistry) startSession(doc *accountsDoc, userID int) (string, time.Time, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().UTC().Add(u.sessionTTL)
+	seen(doc, userID)
+	doc.Sessions = append(doc.Sessions, session{TokenHash: hashToken(token), UserID: userID, ExpiresAt: expires})
+	err = u.save(*doc)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Returns a new slice of the sessions that haven't expired, leaving out the one with dropHash
+// expired sessions are dropped whenever sessions change so the document doesn't grow forever
+func liveSessions(sessions []session, dropHash string) []session {
+	now := time.Now()
+	live := []session{}
+	for _, s := range sessions {
+		if s.ExpiresAt.After(now) && s.TokenHash != dropHash {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
+// callers must hold u.mu
+func (u *userRegistry) userByID(id int) (User, bool) {
+	for _, user := range u.doc.Users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// Saves doc and only then makes it the current state, so a failed write doesn't leave memory and storage disagreeing
+// callers must hold u.mu
+func (u *userRegistry) save(doc accountsDoc) error {
+	err := u.store.SaveDoc(accountsDocName, doc)
+	if err != nil {
+		return err
+	}
+	u.doc = doc
+	return nil
+}
+
+// Passwords are stored as "pbkdf2-sha256$<iterations>$<salt>$<hash>" so the cost can be raised later without breaking old hashes
+const passwordIterations = 600000
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return "pbkdf2-sha256$" + strconv.Itoa(passwordIterations) + "$" + enc.EncodeToString(salt) + "$" + enc.EncodeToString(key), nil
+}
+
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked against when a login names a user that doesn't exist
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+// Makes a random token for a client to hold on to, 32 bytes so it can't be guessed
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// credentials is the body of POST /register and POST /login
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Email is optional and only read by POST /register, it is needed to reset a forgotten password
+	Email string `json:"email,omitempty"`
+	// Code is only read by POST /login, the two-factor code or a recovery code for accounts that have turned it on
+	Code string `json:"code,omitempty"`
+}
+
+// userResponse is what clients see of a user, leaving out the password hash
+type userResponse struct {
+	ID         int       `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email,omitempty"`
+	ListID     int       `json:"list_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Role       Role      `json:"role"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	TwoFactor  bool      `json:"two_factor"`
+}
+
+func newUserResponse(user User) userResponse {
+	return userResponse{ID: user.ID, Username: user.Username, Email: user.Email, ListID: user.ListID, CreatedAt: user.CreatedAt, Role: user.AccountRole(), LastSeenAt: user.LastSeenAt, TwoFactor: user.TwoFactor()}
+}
+
+// Handle Post request to create an account
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	err := decodeStrict(r.Body, &creds)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !usernamePattern.MatchString(creds.Username) {
+		writeError(w, http.StatusBadRequest, "invalid_username", "username has to be 3 to 32 letters, numbers, dots, dashes or underscores")
+		return
+	}
+	if len(creds.Password) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, "invalid_password", fmt.Sprintf("password has to be at least %d characters", minPasswordLength))
+		return
+	}
+
+	creds.Email = strings.TrimSpace(creds.Email)
+	if creds.Email != "" && !validEmailAddress(creds.Email) {
+		writeError(w, http.StatusBadRequest, "invalid_email", creds.Email+" isn't an email address")
+		return
+	}
+
+	user, err := users.Register(creds.Username, creds.Password, creds.Email)
+	if errors.Is(err, ErrUsernameTaken) {
+		writeError(w, http.StatusConflict, "username_taken", "that username is already taken")
+		return
+	}
+	if errors.Is(err, ErrEmailTaken) {
+		writeError(w, http.StatusConflict, "email_taken", "that email is already used by another account")
+		return
+	}
+	if err != nil {
+		slog.Error("Error registering user", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Registered user", "user", user.Username)
+	writeJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+// tokenResponse is returned by POST /login and POST /refresh
+// the access token goes in "Authorization: Bearer <token>", the refresh token is only sent to POST /refresh and POST /logout
+type tokenResponse struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// refreshRequest is the body of POST /refresh and POST /logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Handle Post request to log in
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	err := decodeStrict(r.Body, &creds)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ip := remoteIP(r)
+	if wait := logins.Check(creds.Username, ip, time.Now()); wait > 0 {
+		writeLoginLocked(w, wait)
+		return
+	}
+	user, refresh, refreshExpires, err := users.Login(creds.Username, creds.Password, creds.Code)
+	if errors.Is(err, ErrBadCredentials) {
+		logins.Failed(creds.Username, ip, time.Now())
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "wrong username or password")
+		return
+	}
+	// leaving the code out isn't a failure, it is how a client finds out it has to ask for one
+	if errors.Is(err, ErrTOTPRequired) {
+		writeError(w, http.StatusUnauthorized, "totp_required", "this account has two-factor authentication, send the code from the authenticator app or a recovery code as well")
+		return
+	}
+	if errors.Is(err, ErrBadTOTP) {
+		logins.Failed(creds.Username, ip, time.Now())
+		writeError(w, http.StatusUnauthorized, "invalid_code", "wrong two-factor code")
+		return
+	}
+	if err != nil {
+		slog.Error("Error logging in", "err", err)
+		writeInternalError(w)
+		return
+	}
+	logins.Succeeded(creds.Username, ip)
+	writeTokens(w, user, refresh, refreshExpires)
+}
+
+// Handle Post request to get a new access token with a refresh token
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "invalid_token", "refresh_token is required")
+		return
+	}
+
+	user, refresh, refreshExpires, err := users.Refresh(req.RefreshToken)
+	if errors.Is(err, ErrInvalidToken) {
+		writeError(w, http.StatusUnauthorized, "invalid_token", "refresh token is invalid or has expired, log in again")
+		return
+	}
+	if err != nil {
+		slog.Error("Error refreshing session", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeTokens(w, user, refresh, refreshExpires)
+}
+
+func writeTokens(w http.ResponseWriter, user User, refresh string, refreshExpires time.Time) {
+	access, expires, err := tokens.Issue(user)
+	if err != nil {
+		slog.Error("Error signing access token", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresAt:        expires.UTC(),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpires,
+	})
+}
+
+// Handle Post request to end a session, the body has the refresh token to revoke
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "invalid_token", "refresh_token is required")
+		return
+	}
+	err = users.Logout(req.RefreshToken)
+	if err != nil {
+		slog.Error("Error logging out", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle Get request for the logged in user's own account
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	id := requestIdentity(r)
+	if id.User == nil {
+		// API keys aren't tied to an account
+		writeError(w, http.StatusNotFound, "no_account", "API keys aren't tied to an account")
+		return
+	}
+	// the token only carries the basics so the rest of the account is looked up
+	user, ok := users.User(id.User.ID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no_account", "this account no longer exists")
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// passwordChange is the body of POST /me/password
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// emailChange is the body of PUT /me/email
+type emailChange struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Checks password is the logged in user's, so a stolen access token alone can't change their password or where resets go
+// wrong guesses count towards the login lockout, it writes the error response and returns false when the password isn't right
+func confirmPassword(w http.ResponseWriter, r *http.Request, password string) bool {
+	id := requestIdentity(r)
+	if id.User == nil {
+		writeError(w, http.StatusNotFound, "no_account", "API keys aren't tied to an account")
+		return false
+	}
+	ip := remoteIP(r)
+	if wait := logins.Check(id.User.Username, ip, time.Now()); wait > 0 {
+		writeLoginLocked(w, wait)
+		return false
+	}
+	_, err := users.Authenticate(id.User.Username, password)
+	if errors.Is(err, ErrBadCredentials) {
+		logins.Failed(id.User.Username, ip, time.Now())
+		// not 401, that would tell the client its access token is no good
+		writeError(w, http.StatusForbidden, "wrong_password", "the password isn't right")
+		return false
+	}
+	if err != nil {
+		slog.Error("Error checking password", "err", err)
+		writeInternalError(w)
+		return false
+	}
+	return true
+}
+
+// Handle Post request to change the logged in user's password
+// every session ends including the caller's, so the response has new tokens to carry on with
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req passwordChange
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		writeError(w, http.StatusBadRequest, "invalid_password", fmt.Sprintf("password has to be at least %d characters", minPasswordLength))
+		return
+	}
+	if !confirmPassword(w, r, req.CurrentPassword) {
+		return
+	}
+
+	id := requestIdentity(r)
+	err = users.SetPassword(id.User.ID, req.NewPassword)
+	if err != nil {
+		slog.Error("Error setting password", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Security event", "event", "password_changed", "user", id.User.Username, "remote", remoteIP(r))
+	user, refresh, refreshExpires, err := users.StartSession(id.User.ID)
+	if err != nil {
+		slog.Error("Error logging in", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeTokens(w, user, refresh, refreshExpires)
+}
+
+// Handle Put request to change or remove the email password resets are sent to
+func handleSetEmail(w http.ResponseWriter, r *http.Request) {
+	var req emailChange
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" && !validEmailAddress(req.Email) {
+		writeError(w, http.StatusBadRequest, "invalid_email", req.Email+" isn't an email address")
+		return
+	}
+	if !confirmPassword(w, r, req.Password) {
+		return
+	}
+
+	user, err := users.SetEmail(requestIdentity(r).User.ID, req.Email)
+	if errors.Is(err, ErrEmailTaken) {
+		writeError(w, http.StatusConflict, "email_taken", "that email is already used by another account")
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "no_account", "this account no longer exists")
+		return
+	}
+	if err != nil {
+		slog.Error("Error setting email", "err", err)
+		writeInternalError(w)
+		return
+	}
+	slog.Info("Security event", "event", "email_changed", "user", user.Username, "remote", remoteIP(r))
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxItemLength = 200
+	maxUnitLength = 20
+)
+
+// fieldError is what is wrong with one field of a request body, e.g. {"field": "item", "message": "item can't be empty"}
+// field is the field's JSON name, with the index in front for bodies that are arrays e.g. "[2].quantity"
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// entryBody is what PUT and PATCH decode, the fields the server sets are taken and ignored
+// so an entry from GET /data can be sent back with some of its fields changed
+type entryBody struct {
+	EntryPatch
+	ID          json.RawMessage `json:"id"`
+	ListID      json.RawMessage `json:"list_id"`
+	CreatedAt   json.RawMessage `json:"created_at"`
+	CompletedAt json.RawMessage `json:"completed_at"`
+	DeletedAt   json.RawMessage `json:"deleted_at"`
+	ClientID    json.RawMessage `json:"client_id"`
+}
+
+// Returns everything that is wrong with the fields a client sent for an entry, or nil if nothing is
+// fields that weren't sent (nil) aren't checked
+func patchErrors(patch EntryPatch) []fieldError {
+	var errs []fieldError
+	check := func(field string, problem string) {
+		if problem != "" {
+			errs = append(errs, fieldError{Field: field, Message: problem})
+		}
+	}
+	if patch.Item != nil {
+		item := strings.TrimSpace(*patch.Item)
+		if item == "" {
+			check("item", "item can't be empty")
+		} else if utf8.RuneCountInString(item) > maxItemLength {
+			check("item", "item can be at most "+strconv.Itoa(maxItemLength)+" characters")
+		}
+	}
+	if patch.Quantity != nil && *patch.Quantity < 0 {
+		check("quantity", "quantity can't be negative")
+	}
+	if patch.Unit != nil && utf8.RuneCountInString(strings.TrimSpace(*patch.Unit)) > maxUnitLength {
+		check("unit", "unit can be at most "+strconv.Itoa(maxUnitLength)+" characters")
+	}
+	if patch.Tags != nil {
+		check("tags", tagsProblem(*patch.Tags))
+	}
+	if patch.Priority != nil && *patch.Priority != "" && priorityRank(*patch.Priority) == 0 {
+		check("priority", "priority has to be low, normal, high or urgent")
+	}
+	if patch.Notes != nil && utf8.RuneCountInString(strings.TrimSpace(*patch.Notes)) > maxNotesLength {
+		check("notes", "notes can be at most "+strconv.Itoa(maxNotesLength)+" characters")
+	}
+	if patch.Price != nil && *patch.Price < 0 {
+		check("price", "price can't be negative")
+	}
+	if patch.Currency != nil && !validCurrency(*patch.Currency) {
+		check("currency", "currency has to be a three letter code like EUR")
+	}
+	if patch.Store != nil && len(strings.TrimSpace(*patch.Store)) > maxStoreLength {
+		check("store", "store can be at most "+strconv.Itoa(maxStoreLength)+" characters")
+	}
+	return errs
+}
+
+// patchErrors for a whole new entry, where item is required
+func entryErrors(entry Entry) []fieldError {
+	return patchErrors(EntryPatch{Item: &entry.Item, Quantity: &entry.Quantity, Unit: &entry.Unit, Tags: &entry.Tags, Priority: &entry.Priority, Notes: &entry.Notes, Price: &entry.Price, Currency: &entry.Currency, Store: &entry.Store})
+}
+
+// Returns the first thing wrong with the fields a client sent for an entry, or "" if nothing is
+// for callers that report one problem at a time
+func patchProblem(patch EntryPatch) string {
+	if errs := patchErrors(patch); len(errs) > 0 {
+		return errs[0].Message
+	}
+	return ""
+}
+
+// patchProblem for a whole new entry
+func entryProblem(entry Entry) string {
+	if errs := entryErrors(entry); len(errs) > 0 {
+		return errs[0].Message
+	}
+	return ""
+}
+
+// errTrailingData is returned for a body with something after its JSON value, e.g. two arrays one after the other
+var errTrailingData = errors.New("there is more after the JSON value, a body has to be exactly one value")
+
+// entriesBodyMessage is what a POST /data body that is neither an entry nor any entries is turned away with
+const entriesBodyMessage = "request body has to be an entry object or an array of entries"
+
+// Reports whether a body that has to hold at least one of something, n of which were decoded, held nothing
+// e.g. it was empty, null, [] or "milk", these are wrong as a whole so they are turned away with one message rather than against a field
+func emptyBody(n int, err error) bool {
+	if err == nil {
+		return n == 0
+	}
+	var typeErr *json.UnmarshalTypeError
+	return errors.Is(err, io.EOF) || errors.As(err, &typeErr) && typeErr.Field == ""
+}
+
+// unknownFieldsError is returned for a body with fields that aren't known, naming every one of them
+type unknownFieldsError struct {
+	fields []fieldError
+}
+
+func (e *unknownFieldsError) Error() string {
+	names := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		names[i] = f.Field
+	}
+	return "unknown fields " + strings.Join(names, ", ")
+}
+
+// Decodes a JSON body into v, turning down fields v doesn't have so a typo like "iten" isn't silently dropped
+// and anything after the value so a body that was glued together by mistake isn't half read
+func decodeStrict(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err != nil {
+		// the decoder stops at the first unknown field, so the body is gone through again to find all of them
+		if _, ok := unknownFieldName(err); ok {
+			var value any
+			if json.Unmarshal(data, &value) == nil {
+				if fields := unknownFields(value, reflect.TypeOf(v).Elem(), ""); len(fields) > 0 {
+					return &unknownFieldsError{fields: fields}
+				}
+			}
+		}
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+// Returns the name from the decoder's error for an unknown field, it has no error type for them, only this message
+func unknownFieldName(err error) (string, bool) {
+	name, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return "", false
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	return name, true
+}
+
+// Finds every field in a decoded body that the type it is decoded into doesn't have, path is where value is in the body
+// types that decode themselves e.g. time.Time and json.RawMessage take whatever they are given
+func unknownFields(value any, t reflect.Type, path string) []fieldError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[json.Unmarshaler]()) {
+		return nil
+	}
+	var errs []fieldError
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := value.([]any)
+		for i, item := range items {
+			errs = append(errs, unknownFields(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")...)
+		}
+	case reflect.Map:
+		object, _ := value.(map[string]any)
+		for key, item := range object {
+			errs = append(errs, unknownFields(item, t.Elem(), joinFieldPath(path, key))...)
+		}
+	case reflect.Struct:
+		object, _ := value.(map[string]any)
+		fields := jsonFields(t)
+		// go through the keys in order so the same body always gets the same error
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			field := joinFieldPath(path, key)
+			// the decoder matches names without minding case, so this has to as well
+			i := slices.IndexFunc(fields, func(f reflect.StructField) bool { return strings.EqualFold(jsonFieldName(f), key) })
+			if i < 0 {
+				errs = append(errs, fieldError{Field: field, Message: field + " isn't a field that can be sent here"})
+				continue
+			}
+			errs = append(errs, unknownFields(object[key], fields[i].Type, field)...)
+		}
+	}
+	return errs
+}
+
+// Returns the fields of a struct that JSON is decoded into, with the fields of embedded structs in with its own
+func jsonFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for _, f := range reflect.VisibleFields(t) {
+		_, tagged := f.Tag.Lookup("json")
+		if !f.IsExported() || f.Tag.Get("json") == "-" || f.Anonymous && !tagged {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// Returns the name a struct field has in JSON
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Turns an error from decoding a body into what was wrong with which fields, ok is false for errors that aren't about fields
+// e.g. {"item": 123} is "item has to be a string" rather than the decoder's message about Go types
+func decodeFieldErrors(err error) ([]fieldError, bool) {
+	var unknown *unknownFieldsError
+	if errors.As(err, &unknown) {
+		return unknown.fields, true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return []fieldError{{Message: "request body has to be " + jsonTypeName(typeErr.Type)}}, true
+		}
+		field := fieldPath(typeErr.Field)
+		return []fieldError{{Field: field, Message: field + " has to be " + jsonTypeName(typeErr.Type)}}, true
+	}
+	// an unknown field the second pass couldn't place, it is still reported by name
+	if name, ok := unknownFieldName(err); ok {
+		return []fieldError{{Field: name, Message: name + " isn't a field that can be sent here"}}, true
+	}
+	return nil, false
+}
+
+// Writes the decoder's path to a field the way fieldError does, e.g. "0.item" is "[0].item"
+func fieldPath(path string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// Describes the JSON a Go type is decoded from, for messages about a field sent as the wrong type
+func jsonTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a different type"
+}
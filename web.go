@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webFiles is the web UI, built into the binary so there is nothing to install next to it
+//
+//go:embed web
+var webFiles embed.FS
+
+// staticSite serves the web UI's files with caching headers
+// index.html is never cached without checking with the server, and it links to each asset as static/name?v=<hash of the asset>
+// so the assets themselves can be cached for good, a new build changes the links rather than leaving browsers with old copies
+type staticSite struct {
+	fsys fs.FS
+	// live is true with -web-dir, files are read on every request and nothing is cached for long since they are being worked on
+	live bool
+
+	mu    sync.Mutex
+	files map[string]*staticFile
+}
+
+// staticFile is a file read into memory with what is needed to serve it
+type staticFile struct {
+	body        []byte
+	contentType string
+	// hash is the hex SHA-256 of body, its first characters are the ?v= of the asset's links
+	hash string
+	// gzipped is the precompressed copy, nil when there isn't one
+	gzipped []byte
+}
+
+// assetVersionLength is how many characters of an asset's hash go in ?v=, enough that two builds never share one
+const assetVersionLength = 12
+
+// assetLinkPattern finds the links in index.html that are versioned
+var assetLinkPattern = regexp.MustCompile(`(href|src)="static/([^"?]+)"`)
+
+// Serves dir when it is set and the files built into the binary otherwise
+func newStaticSite(dir string) *staticSite {
+	if dir != "" {
+		return &staticSite{fsys: os.DirFS(dir), live: true}
+	}
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return &staticSite{fsys: root, files: make(map[string]*staticFile)}
+}
+
+// Reads a file along with name.gz if it is there, built in text files without one are gzipped here once
+// index.html has its asset links versioned on the way in
+func (s *staticSite) file(name string) (*staticFile, error) {
+	if !s.live {
+		s.mu.Lock()
+		f, ok := s.files[name]
+		s.mu.Unlock()
+		if ok {
+			return f, nil
+		}
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err == nil && info.IsDir() {
+		// directories aren't listed
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "index.html" {
+		body = s.versionLinks(body)
+	}
+	f := &staticFile{body: body, contentType: mime.TypeByExtension(path.Ext(name))}
+	if f.contentType == "" {
+		f.contentType = http.DetectContentType(body)
+	}
+	sum := sha256.Sum256(body)
+	f.hash = hex.EncodeToString(sum[:])
+
+	// index.html's precompressed copy would have the old links in it, so it is always compressed here
+	gzipped, err := fs.ReadFile(s.fsys, name+".gz")
+	switch {
+	case err == nil && name != "index.html":
+		f.gzipped = gzipped
+	case !s.live && len(body) >= gzipMinSize && compressible(f.contentType):
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		f.gzipped = buf.Bytes()
+	}
+
+	if !s.live {
+		s.mu.Lock()
+		s.files[name] = f
+		s.mu.Unlock()
+	}
+	return f, nil
+}
+
+// Points index.html's links at the current version of each asset, a link to an asset that doesn't exist is left alone
+func (s *staticSite) versionLinks(page []byte) []byte {
+	return assetLinkPattern.ReplaceAllFunc(page, func(link []byte) []byte {
+		match := assetLinkPattern.FindSubmatch(link)
+		asset, err := s.file("static/" + string(match[2]))
+		if err != nil {
+			return link
+		}
+		return []byte(string(match[1]) + `="static/` + string(match[2]) + "?v=" + asset.hash[:assetVersionLength] + `"`)
+	})
+}
+
+// Reports whether a content type is text that gzip can shrink, images and fonts are compressed already
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "svg")
+}
+
+// Writes a file with its ETag, answering If-None-Match with 304 and sending the gzipped copy to clients that take it
+func (s *staticSite) serve(w http.ResponseWriter, r *http.Request, f *staticFile, cacheControl string) {
+	body := f.body
+	// the ETag is strong, so the gzipped bytes need a different one from the plain bytes
+	etag := `"` + f.hash[:32] + `"`
+	if f.gzipped != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			body = f.gzipped
+			etag = `"` + f.hash[:32] + `-gzip"`
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	// ServeContent handles If-None-Match, HEAD and Range, the zero time leaves Last-Modified out
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// Handle Get request for the web UI's page, it is a client of the JSON API like any other
+func (s *staticSite) handleIndex(w http.ResponseWriter, r *http.Request) {
+	f, err := s.file("index.html")
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	// no-cache still lets the browser keep it, it just has to check the ETag first so a new build is picked up straight away
+	s.serve(w, r, f, "no-cache")
+}
+
+// Handle Get request for the web UI's scripts and styles under /static/
+func (s *staticSite) handleAsset(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := s.file(name)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	cacheControl := "no-cache"
+	if !s.live && r.URL.Query().Get("v") == f.hash[:assetVersionLength] {
+		cacheControl = "public, max-age=31536000, immutable"
+	}
+	s.serve(w, r, f, cacheControl)
+}
+
+func (s *staticSite) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	slog.Error("Error reading web UI file", "err", err)
+	writeInternalError(w)
+}
@@ -0,0 +1,202 @@
+// The web UI is a client of the JSON API like any other, it keeps its tokens in localStorage
+// and reloads the list whenever GET /events says something changed, so other devices' changes show up too
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+let accessToken = localStorage.getItem("accessToken") || "";
+let refreshToken = localStorage.getItem("refreshToken") || "";
+let events = null;
+
+function saveTokens(access, refresh) {
+  accessToken = access;
+  refreshToken = refresh;
+  localStorage.setItem("accessToken", access);
+  localStorage.setItem("refreshToken", refresh);
+}
+
+// Calls the API, trying once more with a refreshed access token if it has expired
+async function api(method, path, body) {
+  let res = await send(method, path, body);
+  if (res.status === 401 && refreshToken && (await refresh())) {
+    res = await send(method, path, body);
+  }
+  if (res.status === 401) {
+    showLogin();
+    throw new Error("log in to see the list");
+  }
+  if (!res.ok) {
+    const err = await res.json().catch(() => null);
+    throw new Error(err && err.error ? err.error.message : res.statusText);
+  }
+  return res.status === 204 || res.headers.get("Content-Length") === "0" ? null : res.json();
+}
+
+function send(method, path, body) {
+  const headers = {};
+  if (accessToken) {
+    headers["Authorization"] = "Bearer " + accessToken;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  return fetch(path, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+}
+
+async function refresh() {
+  const res = await fetch("refresh", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
+  if (!res.ok) {
+    saveTokens("", "");
+    return false;
+  }
+  const tokens = await res.json();
+  saveTokens(tokens.access_token, tokens.refresh_token);
+  return true;
+}
+
+function showLogin() {
+  if (events) {
+    events.close();
+    events = null;
+  }
+  $("list").hidden = true;
+  $("login").hidden = false;
+}
+
+function showError(id, err) {
+  $(id).textContent = err ? err.message : "";
+}
+
+async function load() {
+  let entries;
+  try {
+    entries = await api("GET", "data");
+  } catch (err) {
+    showError("error", err);
+    return;
+  }
+  showError("error", null);
+  $("login").hidden = true;
+  $("list").hidden = false;
+  render(entries);
+  listen();
+}
+
+function render(entries) {
+  const list = $("entries");
+  list.replaceChildren();
+  for (const entry of entries) {
+    const li = document.createElement("li");
+    li.classList.toggle("completed", entry.completed);
+
+    const box = document.createElement("input");
+    box.type = "checkbox";
+    box.id = "entry-" + entry.id;
+    box.checked = entry.completed;
+    box.addEventListener("change", () => change("POST", "data/" + entry.id + (box.checked ? "/complete" : "/uncomplete")));
+
+    const label = document.createElement("label");
+    label.htmlFor = box.id;
+    label.textContent = entry.item + " ";
+    if (entry.quantity !== 1 || entry.unit) {
+      const quantity = document.createElement("span");
+      quantity.className = "quantity";
+      quantity.textContent = [entry.quantity, entry.unit].filter(Boolean).join(" ");
+      label.append(quantity);
+    }
+
+    const remove = document.createElement("button");
+    remove.textContent = "Delete";
+    remove.setAttribute("aria-label", "Delete " + entry.item);
+    remove.addEventListener("click", () => change("DELETE", "data/" + entry.id));
+
+    li.append(box, label, remove);
+    list.append(li);
+  }
+  $("empty").hidden = entries.length > 0;
+}
+
+async function change(method, path, body) {
+  try {
+    await api(method, path, body);
+  } catch (err) {
+    showError("error", err);
+  }
+  load();
+}
+
+// Reloads the list on every change event, EventSource reconnects by itself if the connection drops
+function listen() {
+  if (events) {
+    return;
+  }
+  const query = accessToken ? "?access_token=" + encodeURIComponent(accessToken) : "";
+  events = new EventSource("events" + query);
+  for (const type of ["created", "updated", "completed", "deleted", "reset"]) {
+    events.addEventListener(type, load);
+  }
+  // an expired token makes the stream fail for good, load refreshes it and starts a new one after a pause so a server that is down isn't hammered
+  events.onerror = () => {
+    if (events.readyState === EventSource.CLOSED) {
+      events = null;
+      setTimeout(load, 5000);
+    }
+  };
+}
+
+$("add").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const input = e.target.elements.item;
+  const item = input.value.trim();
+  if (item) {
+    input.value = "";
+    change("POST", "data", [{ item }]);
+  }
+});
+
+$("login").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const form = e.target.elements;
+  if (form.apiKey.value) {
+    saveTokens(form.apiKey.value, "");
+  } else {
+    const res = await fetch("login", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ username: form.username.value, password: form.password.value, code: form.code.value }),
+    });
+    const body = await res.json().catch(() => null);
+    // accounts with two-factor authentication need a code as well, the field only shows once the server asks for it
+    if (body && body.error && body.error.code === "totp_required") {
+      form.code.hidden = false;
+      form.code.focus();
+    }
+    if (!res.ok) {
+      showError("login-error", new Error(body && body.error ? body.error.message : res.statusText));
+      return;
+    }
+    saveTokens(body.access_token, body.refresh_token);
+  }
+  e.target.reset();
+  form.code.hidden = true;
+  showError("login-error", null);
+  load();
+});
+
+$("logout").addEventListener("click", async () => {
+  if (refreshToken) {
+    await fetch("logout", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    }).catch(() => {});
+  }
+  saveTokens("", "");
+  showLogin();
+});
+
+load();
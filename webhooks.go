@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How many times a delivery is tried before it is given up on
+	webhookAttempts = 6
+	// The wait before the first retry, it doubles after every failed attempt
+	webhookBackoff = time.Second
+	// How many deliveries can be in flight at once
+	webhookConcurrency = 8
+)
+
+// Webhook is a URL that gets a POST for every event on a list that matches Events
+type Webhook struct {
+	ID     int    `json:"id"`
+	ListID int    `json:"list_id"`
+	URL    string `json:"url"`
+	// Events are the event types to send e.g. "created", empty for all of them
+	Events []string `json:"events"`
+	// Secret signs every payload so the receiver can check it came from this server, it is only shown when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reports whether the webhook wants events of this type
+func (h Webhook) wants(eventType string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, eventType)
+}
+
+// webhooksDoc is everything the webhooks subsystem saves
+type webhooksDoc struct {
+	Hooks  []Webhook `json:"hooks"`
+	NextID int       `json:"next_id"`
+}
+
+// webhooksDocName is the Store document the webhooks are saved under
+const webhooksDocName = "webhooks"
+
+// webhookRegistry holds the webhooks and delivers events to them
+// it has its own lock because events are delivered from the dispatcher goroutine, not from handlers holding mu
+type webhookRegistry struct {
+	store  Store
+	client *http.Client
+
+	mu  sync.Mutex
+	doc webhooksDoc
+}
+
+var webhooks *webhookRegistry
+
+func loadWebhooks(store Store) (*webhookRegistry, error) {
+	w := &webhookRegistry{store: store, client: &http.Client{Timeout: 10 * time.Second}}
+	err := store.LoadDoc(webhooksDocName, &w.doc)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if w.doc.Hooks == nil {
+		w.doc.Hooks = []Webhook{}
+	}
+	w.doc.NextID = max(w.doc.NextID, 1)
+	return w, nil
+}
+
+// For returns the list's webhooks without their secrets
+func (w *webhookRegistry) For(listID int) []Webhook {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hooks := []Webhook{}
+	for _, hook := range w.doc.Hooks {
+		if hook.ListID == listID {
+			hook.Secret = ""
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// Add registers a webhook with a new secret and returns it, secret included
+func (w *webhookRegistry) Add(hook Webhook) (Webhook, error) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	hook.Secret = hex.EncodeToString(secret)
+	hook.CreatedAt = time.Now().UTC()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hook.ID = w.doc.NextID
+	doc := w.doc
+	doc.NextID++
+	doc.Hooks = append(slices.Clone(w.doc.Hooks), hook)
+	err := w.save(doc)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+// Delete removes the list's webhook with the given ID or returns ErrNotFound, deliveries already in flight still finish
+func (w *webhookRegistry) Delete(listID int, id int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := slices.IndexFunc(w.doc.Hooks, func(hook Webhook) bool { return hook.ID == id && hook.ListID == listID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	doc := w.doc
+	doc.Hooks = slices.Delete(slices.Clone(w.doc.Hooks), i, i+1)
+	return w.save(doc)
+}
+
+// Saves doc and only then makes it the current state, like userRegistry.save, so a failed write doesn't leave a webhook
+// delivering that storage doesn't have or one gone that it does
+// callers must hold w.mu
+func (w *webhookRegistry) save(doc webhooksDoc) error {
+	err := w.store.SaveDoc(webhooksDocName, doc)
+	if err != nil {
+		return err
+	}
+	w.doc = doc
+	return nil
+}
+
+// Returns the webhooks that want the event
+func (w *webhookRegistry) matching(e Event) []Webhook {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hooks []Webhook
+	for _, hook := range w.doc.Hooks {
+		if hook.ListID == e.ListID && hook.wants(e.Type) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// Listens to every list's events and delivers them to the matching webhooks until the hub shuts down
+// a delivery runs on its own goroutine so a slow or failing receiver doesn't hold up anyone else's
+func (w *webhookRegistry) run(ctx context.Context) {
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		// a large buffer so a burst of changes doesn't get the dispatcher dropped by the hub
+		sub := hub.SubscribeAll(eventHistorySize * 4)
+		for e := range sub.send {
+			if e.Type == EventReset {
+				continue
+			}
+			for _, hook := range w.matching(e) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					slots <- struct{}{}
+					defer func() { <-slots }()
+					w.deliver(ctx, hook, e)
+				}()
+			}
+		}
+		// the hub closes send when it drops a subscriber that fell behind or when it shuts down
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Webhook dispatcher fell behind and missed events")
+	}
+}
+
+// webhookPayload is the body POSTed to a webhook
+type webhookPayload struct {
+	WebhookID int   `json:"webhook_id"`
+	Event     Event `json:"event"`
+}
+
+// Posts the event to the webhook, retrying with exponential backoff on network errors and 5xx or 429 responses
+// the body is signed with HMAC-SHA256 using the webhook's secret, sent as "X-Shoppinglist-Signature: sha256=<hex>"
+func (w *webhookRegistry) deliver(ctx context.Context, hook Webhook, e Event) {
+	body, err := json.Marshal(webhookPayload{WebhookID: hook.ID, Event: e})
+	if err != nil {
+		slog.Error("Error marshalling webhook payload", "err", err)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		retry, err := w.post(ctx, hook, e, body, signature, attempt)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			slog.Error("Giving up on webhook delivery", "webhook", hook.ID, "url", hook.URL, "event", e.Seq, "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("Webhook delivery failed, retrying", "webhook", hook.ID, "url", hook.URL, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// Makes one delivery attempt, returning whether a failure is worth retrying
+func (w *webhookRegistry) post(ctx context.Context, hook Webhook, e Event, body []byte, signature string, attempt int) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shoppinglist-webhook")
+	req.Header.Set("X-Shoppinglist-Event", e.Type)
+	req.Header.Set("X-Shoppinglist-Delivery", strconv.FormatUint(e.Seq, 10)+"-"+strconv.Itoa(hook.ID))
+	req.Header.Set("X-Shoppinglist-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Shoppinglist-Signature", signature)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// webhookRequest is the body of POST /webhooks
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Handle Get request for the list's webhooks, their secrets aren't included
+func handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, webhooks.For(requestIdentity(r).ListID))
+}
+
+// Handle Post request to register a webhook on the list
+// the response is the only time the secret is sent, the receiver needs it to check the signature
+func handlePostWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	err := decodeStrict(r.Body, &req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+		writeError(w, http.StatusBadRequest, "invalid_webhook", "url has to be an http or https URL")
+		return
+	}
+	events := []string{}
+	for _, eventType := range req.Events {
+		switch eventType {
+		case EventCreated, EventUpdated, EventCompleted, EventDeleted:
+		default:
+			writeError(w, http.StatusBadRequest, "invalid_webhook", "events can be created, updated, completed and deleted")
+			return
+		}
+		if !slices.Contains(events, eventType) {
+			events = append(events, eventType)
+		}
+	}
+
+	hook, err := webhooks.Add(Webhook{ListID: requestIdentity(r).ListID, URL: req.URL, Events: events})
+	if err != nil {
+		slog.Error("Error saving webhook", "err", err)
+		writeInternalError(w)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// Handle Delete request to remove a webhook from the list
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "webhook ID has to be a number")
+		return
+	}
+	err = webhooks.Delete(requestIdentity(r).ListID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not_found", "webhook not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Error deleting webhook", "err", err)
+		writeInternalError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
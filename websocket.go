@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a small WebSocket server (RFC 6455), just enough to push events to clients
+// it only sends text messages and ignores anything the client sends apart from the control frames
+
+// websocketGUID is fixed by the RFC and is mixed into the handshake so the client knows it is talking to a WebSocket server
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+const (
+	// How often the server pings so dead connections are noticed and proxies don't time out an idle connection
+	wsPingInterval = 30 * time.Second
+	// A client that has sent nothing, not even a pong, for this long is gone
+	wsReadTimeout  = 2 * wsPingInterval
+	wsWriteTimeout = 10 * time.Second
+	// Clients have nothing big to say so frames over this size close the connection
+	wsMaxFrameSize = 64 * 1024
+)
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// writeMu stops the ping loop and the event loop from interleaving frames
+	writeMu sync.Mutex
+}
+
+// Checks the handshake headers and takes over the connection from net/http
+// a bad handshake gets a 400 response here, after the connection has been taken over an error just means it has been closed
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, rejectWebSocket(w, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, rejectWebSocket(w, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, rejectWebSocket(w, "missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeInternalError(w)
+		return nil, errors.New("connection can't be taken over")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		writeInternalError(w)
+		return nil, err
+	}
+	// the server's read and write timeouts are still set on the connection and would cut it off, so they are cleared
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// Answers a bad handshake with 400 and returns the reason as an error
+func rejectWebSocket(w http.ResponseWriter, reason string) error {
+	writeError(w, http.StatusBadRequest, "invalid_handshake", reason)
+	return errors.New(reason)
+}
+
+// Reports whether a comma separated header like "Connection: keep-alive, Upgrade" has token in it
+func headerContains(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Writes one unfragmented frame, server frames are never masked
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// FIN bit set because messages are never split over frames
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func (c *wsConn) WriteJSON(v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, payload)
+}
+
+// Sends a close frame with a status code, 1000 is a normal close and 1001 means the server is going away
+func (c *wsConn) WriteClose(code uint16) error {
+	return c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// Reads frames until the client closes the connection or it fails, answering pings along the way
+// data frames are read and thrown away because clients only listen on this endpoint
+func (c *wsConn) readLoop() error {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return io.EOF
+		case wsOpPing:
+			err = c.writeFrame(wsOpPong, payload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.br, head[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	// the RFC says every frame from a client has to be masked
+	if !masked {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	if length > wsMaxFrameSize {
+		return 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.br, mask[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Handle Get request that upgrades to a WebSocket and streams the list's change events as JSON text messages
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		slog.Debug("Rejected websocket", "err", err)
+		return
+	}
+	defer ws.Close()
+
+	sub := hub.Subscribe(requestIdentity(r).ListID)
+	defer hub.Unsubscribe(sub)
+
+	// the read loop runs separately and closes done when the client goes away
+	done := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(done)
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-sub.send:
+			if !ok {
+				// the hub dropped us, either because we fell behind or because the server is shutting down
+				ws.WriteClose(1001)
+				return
+			}
+			err = ws.WriteJSON(e)
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			err = ws.writeFrame(wsOpPing, nil)
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}